@@ -41,3 +41,17 @@ func addressStreet(address *org.Address) string {
 	}
 	return address.Street
 }
+
+func goblAddress(ad *address) *org.Address {
+	addr := &org.Address{
+		Street:   ad.Indirizzo,
+		Number:   ad.NumeroCivico,
+		Locality: ad.Comune,
+		Region:   ad.Provincia,
+		Country:  l10n.CountryCode(ad.Nazione),
+	}
+	if addr.Country == l10n.IT || ad.CAP != foreignCAP {
+		addr.Code = ad.CAP
+	}
+	return addr
+}
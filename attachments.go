@@ -0,0 +1,108 @@
+package fatturapa
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+const algoritmoCompressioneZIP = "ZIP"
+
+// maxFileSize is the maximum size of a file accepted by the SDI (5MB).
+const maxFileSize = 5 * 1024 * 1024
+
+// Attachment contains a file to be embedded in the XML document, such as a
+// PDF copy of the invoice or a delivery note.
+type Attachment struct {
+	// Name of the file including its extension, e.g. "invoice.pdf"
+	Name string
+	// Format of the file, e.g. "PDF". Determined from the name's extension
+	// when empty.
+	Format string
+	// Description of the contents of the file
+	Description string
+	// Data contains the raw contents of the file
+	Data []byte
+}
+
+// allegati contains an attachment as stored in the XML document
+type allegati struct {
+	NomeAttachment        string
+	AlgoritmoCompressione string `xml:",omitempty"`
+	FormatoAttachment     string `xml:",omitempty"`
+	DescrizioneAttachment string `xml:",omitempty"`
+	Attachment            string // base64 encoded
+}
+
+func newAllegati(config *Config) ([]*allegati, error) {
+	var al []*allegati
+
+	for _, a := range config.Attachments {
+		if a.Name == "" {
+			return nil, errors.New("attachment name is required")
+		}
+
+		na := &allegati{
+			NomeAttachment:        a.Name,
+			FormatoAttachment:     a.Format,
+			DescrizioneAttachment: a.Description,
+		}
+		if na.FormatoAttachment == "" {
+			na.FormatoAttachment = strings.ToUpper(strings.TrimPrefix(filepath.Ext(a.Name), "."))
+		}
+
+		data := a.Data
+		if config.CompressAttachments {
+			var err error
+			data, err = compressAttachment(a)
+			if err != nil {
+				return nil, fmt.Errorf("compressing attachment %s: %w", a.Name, err)
+			}
+			na.AlgoritmoCompressione = algoritmoCompressioneZIP
+		}
+		na.Attachment = base64.StdEncoding.EncodeToString(data)
+
+		al = append(al, na)
+	}
+
+	return al, nil
+}
+
+func compressAttachment(a *Attachment) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+
+	w, err := zw.Create(a.Name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(a.Data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// progressivoReserve accounts for the largest ProgressivoInvio element, as
+// the progressive is only assigned once the document passes the checks.
+const progressivoReserve = len("<ProgressivoInvio></ProgressivoInvio>") + 10
+
+// checkFileSize ensures the complete document, including any attachments,
+// can be accepted by the SDI.
+func checkFileSize(doc signable) error {
+	buf, err := doc.buffer("")
+	if err != nil {
+		return err
+	}
+	if size := buf.Len() + progressivoReserve; size > maxFileSize {
+		return fmt.Errorf("document size %d bytes exceeds the SDI maximum of %d bytes", size, maxFileSize)
+	}
+	return nil
+}
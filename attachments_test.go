@@ -0,0 +1,105 @@
+package fatturapa_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"io"
+	"testing"
+
+	fatturapa "github.com/invopop/gobl.fatturapa"
+	"github.com/invopop/gobl.fatturapa/test"
+	"github.com/invopop/gobl/bill"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAllegati(t *testing.T) {
+	pdf := &fatturapa.Attachment{
+		Name:        "invoice.pdf",
+		Description: "PDF copy of the invoice",
+		Data:        []byte("%PDF-1.4 sample data"),
+	}
+
+	t.Run("should be empty without attachments", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simple.json")
+		doc, err := test.ConvertFromGOBL(env)
+		require.NoError(t, err)
+
+		assert.Empty(t, doc.FatturaElettronicaBody[0].Allegati)
+	})
+
+	t.Run("should contain the attachments", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simple.json")
+		c := test.NewConverter(fatturapa.WithAttachments(pdf))
+		doc, err := test.ConvertFromGOBL(env, c)
+		require.NoError(t, err)
+
+		al := doc.FatturaElettronicaBody[0].Allegati
+		require.Len(t, al, 1)
+		assert.Equal(t, "invoice.pdf", al[0].NomeAttachment)
+		assert.Equal(t, "PDF", al[0].FormatoAttachment)
+		assert.Equal(t, "PDF copy of the invoice", al[0].DescrizioneAttachment)
+		assert.Empty(t, al[0].AlgoritmoCompressione)
+		assert.Equal(t, base64.StdEncoding.EncodeToString(pdf.Data), al[0].Attachment)
+	})
+
+	t.Run("should only be included in the first invoice of a lot", func(t *testing.T) {
+		env1 := test.LoadTestFile("invoice-simple.json")
+		env2 := test.LoadTestFile("invoice-simple.json")
+		test.ModifyInvoice(env2, func(inv *bill.Invoice) {
+			inv.Code = "002"
+		})
+		c := test.NewConverter(fatturapa.WithAttachments(pdf))
+		doc, err := c.ConvertLotFromGOBL(env1, env2)
+		require.NoError(t, err)
+
+		require.Len(t, doc.FatturaElettronicaBody, 2)
+		assert.Len(t, doc.FatturaElettronicaBody[0].Allegati, 1)
+		assert.Empty(t, doc.FatturaElettronicaBody[1].Allegati)
+	})
+
+	t.Run("should compress attachments", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simple.json")
+		c := test.NewConverter(
+			fatturapa.WithAttachments(pdf),
+			fatturapa.WithAttachmentCompression(),
+		)
+		doc, err := test.ConvertFromGOBL(env, c)
+		require.NoError(t, err)
+
+		al := doc.FatturaElettronicaBody[0].Allegati
+		require.Len(t, al, 1)
+		assert.Equal(t, "ZIP", al[0].AlgoritmoCompressione)
+
+		data, err := base64.StdEncoding.DecodeString(al[0].Attachment)
+		require.NoError(t, err)
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		require.NoError(t, err)
+		require.Len(t, zr.File, 1)
+		assert.Equal(t, "invoice.pdf", zr.File[0].Name)
+
+		f, err := zr.File[0].Open()
+		require.NoError(t, err)
+		content, err := io.ReadAll(f)
+		require.NoError(t, err)
+		assert.Equal(t, pdf.Data, content)
+	})
+
+	t.Run("should fail when the document is too large", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simple.json")
+		large := &fatturapa.Attachment{
+			Name: "timesheet.csv",
+			Data: bytes.Repeat([]byte("a"), 4*1024*1024),
+		}
+		_, err := test.ConvertFromGOBL(env, test.NewConverter(fatturapa.WithAttachments(large)))
+		assert.ErrorContains(t, err, "exceeds the SDI maximum")
+
+		c := test.NewConverter(
+			fatturapa.WithAttachments(large),
+			fatturapa.WithAttachmentCompression(),
+		)
+		_, err = test.ConvertFromGOBL(env, c)
+		assert.NoError(t, err)
+	})
+}
@@ -6,7 +6,12 @@ import (
 
 	"github.com/invopop/gobl/bill"
 	"github.com/invopop/gobl/cbc"
+	"github.com/invopop/gobl/currency"
+	"github.com/invopop/gobl/l10n"
+	"github.com/invopop/gobl/num"
+	"github.com/invopop/gobl/org"
 	"github.com/invopop/gobl/regimes/it"
+	"github.com/invopop/gobl/tax"
 )
 
 const (
@@ -27,13 +32,21 @@ const stampDutyCode = "SI"
 type fatturaElettronicaBody struct {
 	DatiGenerali    *datiGenerali
 	DatiBeniServizi *datiBeniServizi
-	DatiPagamento   *datiPagamento `xml:",omitempty"`
+	DatiPagamento   []*datiPagamento `xml:",omitempty"`
+	Allegati        []*allegati      `xml:",omitempty"`
 }
 
 // datiGenerali contains general data about the invoice such as retained taxes,
 // invoice number, invoice date, document type, etc.
 type datiGenerali struct {
 	DatiGeneraliDocumento *datiGeneraliDocumento
+	DatiOrdineAcquisto    []*datiDocumentiCorrelati `xml:",omitempty"`
+	DatiContratto         []*datiDocumentiCorrelati `xml:",omitempty"`
+	DatiConvenzione       []*datiDocumentiCorrelati `xml:",omitempty"`
+	DatiRicezione         []*datiDocumentiCorrelati `xml:",omitempty"`
+	DatiFattureCollegate  []*datiDocumentiCorrelati `xml:",omitempty"`
+	DatiDDT               []*datiDDT                `xml:",omitempty"`
+	DatiTrasporto         *datiTrasporto            `xml:",omitempty"`
 }
 
 type datiGeneraliDocumento struct {
@@ -42,7 +55,8 @@ type datiGeneraliDocumento struct {
 	Data                   string
 	Numero                 string
 	DatiRitenuta           []*datiRitenuta
-	DatiBollo              *datiBollo `xml:",omitempty"`
+	DatiBollo              *datiBollo                `xml:",omitempty"`
+	DatiCassaPrevidenziale []*datiCassaPrevidenziale `xml:",omitempty"`
 	ScontoMaggiorazione    []*scontoMaggiorazione
 	ImportoTotaleDocumento string `xml:",omitempty"`
 	Causale                []string
@@ -63,7 +77,10 @@ type scontoMaggiorazione struct {
 }
 
 func newFatturaElettronicaBody(inv *bill.Invoice) (*fatturaElettronicaBody, error) {
-	dbs := newDatiBeniServizi(inv)
+	dbs, err := newDatiBeniServizi(inv)
+	if err != nil {
+		return nil, err
+	}
 
 	dp, err := newDatiPagamento(inv)
 	if err != nil {
@@ -95,7 +112,11 @@ func newDatiGenerali(inv *bill.Invoice) (*datiGenerali, error) {
 
 	switch codeTipoDocumento {
 	case "TD07", "TD08", "TD09":
-		return nil, errors.New("simplified invoices are not currently supported")
+		return nil, errors.New("simplified invoices must be converted with ConvertSimplifiedFromGOBL")
+	case "TD04", "TD05":
+		if len(inv.Preceding) == 0 {
+			return nil, fmt.Errorf("%s documents require a preceding invoice reference", codeTipoDocumento)
+		}
 	}
 
 	code := inv.Code
@@ -103,7 +124,7 @@ func newDatiGenerali(inv *bill.Invoice) (*datiGenerali, error) {
 		code = fmt.Sprintf("%s-%s", inv.Series, inv.Code)
 	}
 
-	return &datiGenerali{
+	dg := &datiGenerali{
 		DatiGeneraliDocumento: &datiGeneraliDocumento{
 			TipoDocumento:          codeTipoDocumento,
 			Divisa:                 string(inv.Currency),
@@ -115,10 +136,45 @@ func newDatiGenerali(inv *bill.Invoice) (*datiGenerali, error) {
 			ScontoMaggiorazione:    extractPriceAdjustments(inv),
 			Causale:                extractInvoiceReasons(inv),
 		},
-	}, nil
+		DatiFattureCollegate: newDatiFattureCollegate(inv),
+	}
+
+	dgd := dg.DatiGeneraliDocumento
+	if dgd.DatiCassaPrevidenziale, err = newDatiCassaPrevidenziale(inv); err != nil {
+		return nil, err
+	}
+
+	if err := addOrderingDocuments(dg, inv); err != nil {
+		return nil, err
+	}
+
+	if dg.DatiDDT, err = newDatiDDT(inv); err != nil {
+		return nil, err
+	}
+
+	if dg.DatiTrasporto, err = newDatiTrasporto(inv); err != nil {
+		return nil, err
+	}
+
+	return dg, nil
 }
 
 func findCodeTipoDocumento(inv *bill.Invoice) (string, error) {
+	code, err := lookupCodeTipoDocumento(inv)
+	if err != nil {
+		return "", err
+	}
+
+	if err := checkDeferredInvoice(inv, code); err != nil {
+		return "", err
+	}
+
+	return code, nil
+}
+
+// lookupCodeTipoDocumento determines the TipoDocumento code from the
+// invoice's type and tags only.
+func lookupCodeTipoDocumento(inv *bill.Invoice) (string, error) {
 	ss := inv.ScenarioSummary()
 
 	code := ss.Codes[it.KeyFatturaPATipoDocumento]
@@ -154,6 +210,10 @@ func extractPriceAdjustments(inv *bill.Invoice) []*scontoMaggiorazione {
 	}
 
 	for _, charge := range inv.Charges {
+		// Pension fund contributions are reported in DatiCassaPrevidenziale
+		if charge.Key == ChargeKeyPensionFund {
+			continue
+		}
 		scontiMaggiorazioni = append(scontiMaggiorazioni, &scontoMaggiorazione{
 			Tipo:        scontoMaggiorazioneTypeCharge,
 			Percentuale: formatPercentage(charge.Percent),
@@ -176,3 +236,195 @@ func extractInvoiceReasons(inv *bill.Invoice) []string {
 
 	return reasons
 }
+
+func goblInvoice(d *Document, body *fatturaElettronicaBody) (*bill.Invoice, error) {
+	header := d.FatturaElettronicaHeader
+	if header.CedentePrestatore == nil {
+		return nil, errors.New("missing CedentePrestatore")
+	}
+	if body.DatiGenerali == nil || body.DatiGenerali.DatiGeneraliDocumento == nil {
+		return nil, errors.New("missing DatiGeneraliDocumento")
+	}
+	dgd := body.DatiGenerali.DatiGeneraliDocumento
+
+	scenario, err := findTipoDocumentoScenario(dgd.TipoDocumento)
+	if err != nil {
+		return nil, err
+	}
+
+	issueDate, err := parseDate(dgd.Data)
+	if err != nil {
+		return nil, fmt.Errorf("Data: %w", err)
+	}
+
+	inv := &bill.Invoice{
+		Type:      scenario.Types[0],
+		Code:      dgd.Numero,
+		IssueDate: issueDate,
+		Currency:  currency.Code(dgd.Divisa),
+	}
+	if len(scenario.Tags) > 0 {
+		inv.Tax = &bill.Tax{
+			Tags: scenario.Tags,
+		}
+	}
+
+	if inv.Supplier, err = goblSupplier(header.CedentePrestatore); err != nil {
+		return nil, err
+	}
+
+	formato := ""
+	if header.DatiTrasmissione != nil {
+		formato = header.DatiTrasmissione.FormatoTrasmissione
+	}
+	if inv.Customer = goblCustomer(header.CessionarioCommittente, formato); inv.Customer != nil {
+		inv.Customer.Inboxes = goblInboxes(header.DatiTrasmissione)
+	}
+
+	if inv.Preceding, err = goblPreceding(body.DatiGenerali.DatiFattureCollegate); err != nil {
+		return nil, err
+	}
+
+	retained, err := newRetainedTaxAllocator(dgd.DatiRitenuta)
+	if err != nil {
+		return nil, err
+	}
+
+	if inv.Lines, err = goblLines(body.DatiBeniServizi, retained); err != nil {
+		return nil, err
+	}
+
+	if inv.Ordering, err = goblOrdering(body.DatiGenerali, inv); err != nil {
+		return nil, err
+	}
+	if ids := goblPrecedingIdentities(body.DatiGenerali.DatiFattureCollegate); len(ids) > 0 {
+		if inv.Ordering == nil {
+			inv.Ordering = new(bill.Ordering)
+		}
+		inv.Ordering.Identities = append(inv.Ordering.Identities, ids...)
+	}
+
+	if inv.Delivery, err = goblDelivery(body.DatiGenerali.DatiTrasporto); err != nil {
+		return nil, err
+	}
+
+	if err = goblDespatch(inv, body.DatiGenerali.DatiDDT); err != nil {
+		return nil, err
+	}
+
+	if inv.Discounts, inv.Charges, err = goblPriceAdjustments(dgd); err != nil {
+		return nil, err
+	}
+
+	pensionFund, err := goblPensionFundCharges(dgd.DatiCassaPrevidenziale, retained)
+	if err != nil {
+		return nil, err
+	}
+	inv.Charges = append(inv.Charges, pensionFund...)
+
+	if inv.Payment, err = goblPayment(body.DatiPagamento, inv.IssueDate); err != nil {
+		return nil, err
+	}
+
+	for _, reason := range dgd.Causale {
+		inv.Notes = append(inv.Notes, &cbc.Note{
+			Key:  cbc.NoteKeyReason,
+			Text: reason,
+		})
+	}
+
+	return inv, nil
+}
+
+// findTipoDocumentoScenario provides the invoice scenario whose TipoDocumento
+// code matches the one provided, so that the invoice type and tags can be
+// determined. Candidates are checked with lookupCodeTipoDocumento to ensure
+// that converting the invoice back would result in the same code.
+func findTipoDocumentoScenario(code string) (*tax.Scenario, error) {
+	ss := regime.ScenarioSet(bill.ShortSchemaInvoice)
+	for _, s := range ss.List {
+		if s.Codes[it.KeyFatturaPATipoDocumento].String() != code || len(s.Types) == 0 {
+			continue
+		}
+		inv := &bill.Invoice{
+			Type:     s.Types[0],
+			Tax:      &bill.Tax{Tags: s.Tags},
+			Supplier: &org.Party{TaxID: &tax.Identity{Country: l10n.IT}},
+		}
+		if c, err := lookupCodeTipoDocumento(inv); err == nil && c == code {
+			return s, nil
+		}
+	}
+
+	return nil, fmt.Errorf("TipoDocumento '%s' not supported", code)
+}
+
+func goblPriceAdjustments(dgd *datiGeneraliDocumento) ([]*bill.Discount, []*bill.Charge, error) {
+	var discounts []*bill.Discount
+	var charges []*bill.Charge
+
+	var stampDuty *num.Amount
+	if db := dgd.DatiBollo; db != nil && db.ImportoBollo != "" {
+		amount, err := parseAmount(db.ImportoBollo)
+		if err != nil {
+			return nil, nil, fmt.Errorf("ImportoBollo: %w", err)
+		}
+		stampDuty = &amount
+		charges = append(charges, &bill.Charge{
+			Key:    it.ChargeKeyStampDuty,
+			Amount: amount,
+		})
+	}
+
+	for _, sm := range dgd.ScontoMaggiorazione {
+		percent, amount, err := parseScontoMaggiorazione(sm)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		switch sm.Tipo {
+		case scontoMaggiorazioneTypeDiscount:
+			discounts = append(discounts, &bill.Discount{
+				Percent: percent,
+				Amount:  amount,
+			})
+		case scontoMaggiorazioneTypeCharge:
+			// Stamp duty charges are also included as price adjustments
+			if stampDuty != nil && percent == nil && amount.Equals(*stampDuty) {
+				stampDuty = nil
+				continue
+			}
+			charges = append(charges, &bill.Charge{
+				Percent: percent,
+				Amount:  amount,
+			})
+		default:
+			return nil, nil, fmt.Errorf("ScontoMaggiorazione Tipo '%s' not supported", sm.Tipo)
+		}
+	}
+
+	return discounts, charges, nil
+}
+
+func parseScontoMaggiorazione(sm *scontoMaggiorazione) (*num.Percentage, num.Amount, error) {
+	var percent *num.Percentage
+	var amount num.Amount
+	var err error
+
+	if sm.Percentuale != "" {
+		if percent, err = parsePercentage(sm.Percentuale); err != nil {
+			return nil, amount, fmt.Errorf("ScontoMaggiorazione: %w", err)
+		}
+		// Adjustments without percentage are exported with a zero value
+		if percent.IsZero() {
+			percent = nil
+		}
+	}
+	if sm.Importo != "" {
+		if amount, err = parseAmount(sm.Importo); err != nil {
+			return nil, amount, fmt.Errorf("ScontoMaggiorazione: %w", err)
+		}
+	}
+
+	return percent, amount, nil
+}
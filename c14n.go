@@ -0,0 +1,361 @@
+package fatturapa
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Canonicalization algorithms supported when verifying signatures
+const (
+	c14nInclusive             = "http://www.w3.org/TR/2001/REC-xml-c14n-20010315"
+	c14nInclusiveComments     = "http://www.w3.org/TR/2001/REC-xml-c14n-20010315#WithComments"
+	c14nExclusive             = "http://www.w3.org/2001/10/xml-exc-c14n#"
+	c14nExclusiveWithComments = "http://www.w3.org/2001/10/xml-exc-c14n#WithComments"
+)
+
+// c14nElement is an element of a parsed XML document that keeps the
+// prefixes and namespace declarations needed for canonicalization.
+type c14nElement struct {
+	prefix string
+	local  string
+	space  string
+	attrs  []*c14nAttr
+	// namespaces in scope, including those declared by ancestors
+	scope    map[string]string
+	children []any // *c14nElement, c14nText or c14nComment
+	parent   *c14nElement
+}
+
+type c14nAttr struct {
+	prefix string
+	local  string
+	space  string
+	value  string
+}
+
+type c14nText string
+
+type c14nComment string
+
+// c14nOptions determines how an element is canonicalized
+type c14nOptions struct {
+	exclusive bool
+	comments  bool
+	// prefixes always treated as used with exclusive canonicalization
+	inclusive map[string]bool
+	// exclude determines which elements are left out, such as signatures
+	exclude func(*c14nElement) bool
+}
+
+// parseC14N parses the XML document provided into a tree of elements
+func parseC14N(data []byte) (*c14nElement, error) {
+	dec := xml.NewDecoder(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+	var root, cur *c14nElement
+	for {
+		tok, err := dec.RawToken()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parsing xml: %w", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			el := &c14nElement{
+				prefix: t.Name.Space,
+				local:  t.Name.Local,
+				scope:  map[string]string{"xml": "http://www.w3.org/XML/1998/namespace"},
+				parent: cur,
+			}
+			if cur != nil {
+				for k, v := range cur.scope {
+					el.scope[k] = v
+				}
+			}
+			for _, a := range t.Attr {
+				switch {
+				case a.Name.Space == "" && a.Name.Local == "xmlns":
+					el.scope[""] = a.Value
+				case a.Name.Space == "xmlns":
+					el.scope[a.Name.Local] = a.Value
+				default:
+					el.attrs = append(el.attrs, &c14nAttr{prefix: a.Name.Space, local: a.Name.Local, value: a.Value})
+				}
+			}
+			el.space = el.scope[el.prefix]
+			for _, a := range el.attrs {
+				if a.prefix != "" {
+					a.space = el.scope[a.prefix]
+				}
+			}
+			if cur == nil {
+				if root != nil {
+					return nil, errors.New("parsing xml: multiple root elements")
+				}
+				root = el
+			} else {
+				cur.children = append(cur.children, el)
+			}
+			cur = el
+		case xml.EndElement:
+			if cur == nil {
+				return nil, errors.New("parsing xml: unexpected end element")
+			}
+			cur = cur.parent
+		case xml.CharData:
+			if cur != nil {
+				cur.children = append(cur.children, c14nText(t))
+			}
+		case xml.Comment:
+			if cur != nil {
+				cur.children = append(cur.children, c14nComment(t))
+			}
+		}
+	}
+	if root == nil {
+		return nil, errors.New("parsing xml: missing root element")
+	}
+	return root, nil
+}
+
+// is determines if the element has the namespace and local name provided
+func (e *c14nElement) is(space, local string) bool {
+	return e.space == space && e.local == local
+}
+
+// attr provides the value of an attribute without namespace
+func (e *c14nElement) attr(local string) string {
+	for _, a := range e.attrs {
+		if a.space == "" && a.local == local {
+			return a.value
+		}
+	}
+	return ""
+}
+
+// child provides the first child element with the name given
+func (e *c14nElement) child(space, local string) *c14nElement {
+	for _, c := range e.childElements() {
+		if c.is(space, local) {
+			return c
+		}
+	}
+	return nil
+}
+
+// find provides the first descendant element matching the path of names
+// given, all in the same namespace.
+func (e *c14nElement) find(space string, path ...string) *c14nElement {
+	el := e
+	for _, p := range path {
+		if el = el.child(space, p); el == nil {
+			return nil
+		}
+	}
+	return el
+}
+
+func (e *c14nElement) childElements() []*c14nElement {
+	var out []*c14nElement
+	for _, c := range e.children {
+		if el, ok := c.(*c14nElement); ok {
+			out = append(out, el)
+		}
+	}
+	return out
+}
+
+// text provides the text content of the element
+func (e *c14nElement) text() string {
+	var sb strings.Builder
+	for _, c := range e.children {
+		switch t := c.(type) {
+		case c14nText:
+			sb.WriteString(string(t))
+		case *c14nElement:
+			sb.WriteString(t.text())
+		}
+	}
+	return sb.String()
+}
+
+// walk visits the element and all its descendants in document order,
+// stopping when the function returns false.
+func (e *c14nElement) walk(fn func(*c14nElement) bool) bool {
+	if !fn(e) {
+		return false
+	}
+	for _, c := range e.childElements() {
+		if !c.walk(fn) {
+			return false
+		}
+	}
+	return true
+}
+
+// idAttributes are the names of the attributes used as identifiers
+var idAttributes = []string{"Id", "ID", "id"}
+
+// byID finds the element with an Id attribute matching the value given
+func (e *c14nElement) byID(id string) *c14nElement {
+	var found *c14nElement
+	e.walk(func(el *c14nElement) bool {
+		for _, name := range idAttributes {
+			if el.attr(name) == id {
+				found = el
+				return false
+			}
+		}
+		return true
+	})
+	return found
+}
+
+// duplicateID provides the first identifier used by more than one element,
+// if any, as references to it would be ambiguous.
+func (e *c14nElement) duplicateID() string {
+	ids := make(map[string]bool)
+	var dup string
+	e.walk(func(el *c14nElement) bool {
+		for _, name := range idAttributes {
+			id := el.attr(name)
+			if id == "" {
+				continue
+			}
+			if ids[id] {
+				dup = id
+				return false
+			}
+			ids[id] = true
+		}
+		return true
+	})
+	return dup
+}
+
+// canonicalize provides the canonical form of the element and its
+// descendants, as defined by Canonical XML 1.0 or Exclusive XML
+// Canonicalization 1.0, depending on the options.
+func (e *c14nElement) canonicalize(opts *c14nOptions) []byte {
+	buf := new(bytes.Buffer)
+	e.writeC14N(buf, make(map[string]string), opts)
+	return buf.Bytes()
+}
+
+func (e *c14nElement) writeC14N(buf *bytes.Buffer, rendered map[string]string, opts *c14nOptions) {
+	if opts.exclude != nil && opts.exclude(e) {
+		return
+	}
+
+	// Namespace declarations that must be output in this element
+	var prefixes []string
+	used := e.usedPrefixes(opts)
+	for p, uri := range e.scope {
+		if p == "xml" || (opts.exclusive && !used[p]) {
+			continue
+		}
+		prev, ok := rendered[p]
+		if (ok && prev == uri) || (!ok && p == "" && uri == "") {
+			continue
+		}
+		prefixes = append(prefixes, p)
+	}
+	sort.Strings(prefixes)
+	current := rendered
+	if len(prefixes) > 0 {
+		current = make(map[string]string, len(rendered)+len(prefixes))
+		for k, v := range rendered {
+			current[k] = v
+		}
+	}
+
+	buf.WriteByte('<')
+	buf.WriteString(qualifiedName(e.prefix, e.local))
+	for _, p := range prefixes {
+		current[p] = e.scope[p]
+		buf.WriteString(" xmlns")
+		if p != "" {
+			buf.WriteByte(':')
+			buf.WriteString(p)
+		}
+		buf.WriteString(`="`)
+		buf.WriteString(escapeC14NAttr(e.scope[p]))
+		buf.WriteByte('"')
+	}
+
+	attrs := make([]*c14nAttr, len(e.attrs))
+	copy(attrs, e.attrs)
+	sort.SliceStable(attrs, func(i, j int) bool {
+		if attrs[i].space != attrs[j].space {
+			return attrs[i].space < attrs[j].space
+		}
+		return attrs[i].local < attrs[j].local
+	})
+	for _, a := range attrs {
+		buf.WriteByte(' ')
+		buf.WriteString(qualifiedName(a.prefix, a.local))
+		buf.WriteString(`="`)
+		buf.WriteString(escapeC14NAttr(a.value))
+		buf.WriteByte('"')
+	}
+	buf.WriteByte('>')
+
+	for _, c := range e.children {
+		switch t := c.(type) {
+		case *c14nElement:
+			t.writeC14N(buf, current, opts)
+		case c14nText:
+			buf.WriteString(escapeC14NText(string(t)))
+		case c14nComment:
+			if opts.comments {
+				buf.WriteString("<!--")
+				buf.WriteString(string(t))
+				buf.WriteString("-->")
+			}
+		}
+	}
+
+	buf.WriteString("</")
+	buf.WriteString(qualifiedName(e.prefix, e.local))
+	buf.WriteByte('>')
+}
+
+// usedPrefixes provides the prefixes visibly used by the element and its
+// attributes, as considered by exclusive canonicalization.
+func (e *c14nElement) usedPrefixes(opts *c14nOptions) map[string]bool {
+	used := map[string]bool{e.prefix: true}
+	for _, a := range e.attrs {
+		if a.prefix != "" {
+			used[a.prefix] = true
+		}
+	}
+	for p := range opts.inclusive {
+		used[p] = true
+	}
+	return used
+}
+
+func qualifiedName(prefix, local string) string {
+	if prefix == "" {
+		return local
+	}
+	return prefix + ":" + local
+}
+
+var (
+	c14nTextReplacer = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", "&#xD;")
+	c14nAttrReplacer = strings.NewReplacer("&", "&amp;", "<", "&lt;", `"`, "&quot;", "\t", "&#x9;", "\n", "&#xA;", "\r", "&#xD;")
+)
+
+func escapeC14NText(s string) string {
+	return c14nTextReplacer.Replace(s)
+}
+
+func escapeC14NAttr(s string) string {
+	return c14nAttrReplacer.Replace(s)
+}
@@ -0,0 +1,693 @@
+package fatturapa
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+)
+
+// SignatureFormat determines how documents are signed
+type SignatureFormat string
+
+// Signature formats accepted by the SDI
+const (
+	// XAdES signatures are enveloped in the XML document (.xml)
+	XAdES SignatureFormat = "xades"
+	// CAdES signatures envelope the XML document in a PKCS#7 container (.xml.p7m)
+	CAdES SignatureFormat = "cades"
+)
+
+// Object identifiers used in CAdES signatures
+var (
+	oidData                 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData           = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidAttributeContentType = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidAttributeDigest      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidAttributeSigningTime = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+	oidAttributeSigningCert = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 47}
+	oidSHA256               = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidRSAEncryption        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+
+	oidAttributeSigningCertV1 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 12}
+	oidAttributeTimestamp     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 14}
+	oidTSTInfo                = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}
+)
+
+// Digest algorithms supported in CAdES signatures and timestamps
+var cmsDigestAlgorithms = map[crypto.Hash]asn1.ObjectIdentifier{
+	crypto.SHA1:   {1, 3, 14, 3, 2, 26},
+	crypto.SHA224: {2, 16, 840, 1, 101, 3, 4, 2, 4},
+	crypto.SHA256: oidSHA256,
+	crypto.SHA384: {2, 16, 840, 1, 101, 3, 4, 2, 2},
+	crypto.SHA512: {2, 16, 840, 1, 101, 3, 4, 2, 3},
+}
+
+// cmsDigestHash provides the hash function of a digest algorithm
+func cmsDigestHash(oid asn1.ObjectIdentifier) (crypto.Hash, bool) {
+	for h, o := range cmsDigestAlgorithms {
+		if o.Equal(oid) {
+			return h, true
+		}
+	}
+	return 0, false
+}
+
+// ASN.1 structures of a CMS SignedData (RFC 5652), with the attributes
+// required by CAdES-BES (ETSI EN 319 122-1).
+
+type cmsContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"optional,tag:0"`
+}
+
+type cmsSignedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	EncapContentInfo cmsContentInfo
+	Certificates     asn1.RawValue   `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue   `asn1:"optional,tag:1"`
+	SignerInfos      []cmsSignerInfo `asn1:"set"`
+}
+
+type cmsSignerInfo struct {
+	Version int
+	// SID is either an IssuerAndSerialNumber or a SubjectKeyIdentifier
+	SID                asn1.RawValue
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttrs        asn1.RawValue `asn1:"optional,tag:0"`
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+	UnsignedAttrs      asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type cmsIssuerAndSerial struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type cmsAttribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue
+}
+
+type essSigningCertificateV2 struct {
+	Certs []essCertIDv2
+}
+
+// essCertIDv2 also parses the ESSCertID of the SigningCertificate
+// attribute. The hash algorithm is omitted when it is SHA-256.
+type essCertIDv2 struct {
+	HashAlgorithm pkix.AlgorithmIdentifier `asn1:"optional"`
+	CertHash      []byte
+	IssuerSerial  essIssuerSerial `asn1:"optional"`
+}
+
+type essIssuerSerial struct {
+	Issuer       []asn1.RawValue
+	SerialNumber *big.Int
+}
+
+// tstInfo is the content of a timestamp token (RFC 3161), ignoring the
+// optional fields that follow the nonce.
+type tstInfo struct {
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint tsMessageImprint
+	SerialNumber   *big.Int
+	GenTime        time.Time  `asn1:"generalized"`
+	Accuracy       tsAccuracy `asn1:"optional"`
+	Ordering       bool       `asn1:"optional"`
+	Nonce          *big.Int   `asn1:"optional"`
+}
+
+type tsAccuracy struct {
+	Seconds int `asn1:"optional"`
+	Millis  int `asn1:"optional,tag:0"`
+	Micros  int `asn1:"optional,tag:1"`
+}
+
+// P7M provides the signed document enveloped in a CAdES signature, as
+// generated when using the CAdES signature format.
+func (d *Document) P7M() ([]byte, error) {
+	if d.p7m == nil {
+		return nil, errors.New("document not signed with CAdES")
+	}
+	return d.p7m, nil
+}
+
+// P7M provides the signed document enveloped in a CAdES signature, as
+// generated when using the CAdES signature format.
+func (d *SimplifiedDocument) P7M() ([]byte, error) {
+	if d.p7m == nil {
+		return nil, errors.New("document not signed with CAdES")
+	}
+	return d.p7m, nil
+}
+
+// signCAdES envelopes the data in a CAdES-BES signature made by the signer
+// provided, returning the DER encoded PKCS#7 SignedData. When a provider is
+// given, a timestamp of the signature is added (CAdES-T).
+func signCAdES(ctx context.Context, data []byte, signer Signer, signingTime time.Time, timestamps TimestampProvider) ([]byte, error) {
+	x509Cert, err := signingCertificate(signer)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs, err := cadesSignedAttributes(data, x509Cert, signingTime)
+	if err != nil {
+		return nil, err
+	}
+
+	// The signature is calculated over the DER encoding of the attributes
+	// as a SET, while they are included in the SignerInfo with an implicit tag.
+	set, err := asn1.Marshal(asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: attrs})
+	if err != nil {
+		return nil, err
+	}
+	sigValue, err := signData(ctx, signer, set)
+	if err != nil {
+		return nil, err
+	}
+
+	var unsigned asn1.RawValue
+	if timestamps != nil {
+		token, err := requestTimestamp(ctx, timestamps, sigValue)
+		if err != nil {
+			return nil, err
+		}
+		attr, err := asn1.Marshal(cmsAttribute{
+			Type:   oidAttributeTimestamp,
+			Values: asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: token},
+		})
+		if err != nil {
+			return nil, err
+		}
+		unsigned = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 1, IsCompound: true, Bytes: attr}
+	}
+
+	var certs [][]byte
+	for _, c := range signer.Certificates() {
+		certs = append(certs, c.Raw)
+	}
+
+	content, err := asn1.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	sid, err := asn1.Marshal(cmsIssuerAndSerial{
+		Issuer:       asn1.RawValue{FullBytes: x509Cert.RawIssuer},
+		SerialNumber: x509Cert.SerialNumber,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sd := cmsSignedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{{Algorithm: oidSHA256}},
+		EncapContentInfo: cmsContentInfo{
+			ContentType: oidData,
+			Content:     explicitTag(0, content),
+		},
+		Certificates: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: bytes.Join(certs, nil)},
+		SignerInfos: []cmsSignerInfo{
+			{
+				Version:            1,
+				SID:                asn1.RawValue{FullBytes: sid},
+				DigestAlgorithm:    pkix.AlgorithmIdentifier{Algorithm: oidSHA256},
+				SignedAttrs:        asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: attrs},
+				SignatureAlgorithm: cmsSignatureAlgorithm(x509Cert),
+				Signature:          sigValue,
+				UnsignedAttrs:      unsigned,
+			},
+		},
+	}
+	inner, err := asn1.Marshal(sd)
+	if err != nil {
+		return nil, fmt.Errorf("encoding signed data: %w", err)
+	}
+
+	return asn1.Marshal(cmsContentInfo{
+		ContentType: oidSignedData,
+		Content:     explicitTag(0, inner),
+	})
+}
+
+// cadesSignedAttributes provides the DER encoding of the signed attributes,
+// sorted as required for a SET OF.
+func cadesSignedAttributes(data []byte, cert *x509.Certificate, signingTime time.Time) ([]byte, error) {
+	digest := sha256.Sum256(data)
+	certHash := sha256.Sum256(cert.Raw)
+
+	values := []struct {
+		oid   asn1.ObjectIdentifier
+		value any
+	}{
+		{oidAttributeContentType, oidData},
+		{oidAttributeSigningTime, signingTime.UTC()},
+		{oidAttributeDigest, digest[:]},
+		{oidAttributeSigningCert, essSigningCertificateV2{
+			Certs: []essCertIDv2{{
+				CertHash: certHash[:],
+				IssuerSerial: essIssuerSerial{
+					// GeneralName directoryName [4]
+					Issuer:       []asn1.RawValue{explicitTag(4, cert.RawIssuer)},
+					SerialNumber: cert.SerialNumber,
+				},
+			}},
+		}},
+	}
+
+	attrs := make([][]byte, len(values))
+	for i, v := range values {
+		value, err := asn1.Marshal(v.value)
+		if err != nil {
+			return nil, err
+		}
+		attrs[i], err = asn1.Marshal(cmsAttribute{
+			Type:   v.oid,
+			Values: asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: value},
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(attrs, func(i, j int) bool {
+		return bytes.Compare(attrs[i], attrs[j]) < 0
+	})
+
+	return bytes.Join(attrs, nil), nil
+}
+
+// explicitTag wraps the DER encoded value in an explicit context specific tag
+func explicitTag(tag int, value []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: tag, IsCompound: true, Bytes: value}
+}
+
+// verifyCAdES checks the signatures of a CAdES envelope and extracts the
+// document it contains.
+func verifyCAdES(data []byte) (*Verification, error) {
+	content, sd, certs, err := parseSignedData(data)
+	if err != nil {
+		return nil, err
+	}
+	if !sd.EncapContentInfo.ContentType.Equal(oidData) {
+		return nil, errors.New("unexpected content type")
+	}
+
+	v := &Verification{Format: CAdES, Data: content}
+	for i := range sd.SignerInfos {
+		si := &sd.SignerInfos[i]
+		cert, signingTime, err := verifySignerInfo(si, content, oidData, certs)
+		if err != nil {
+			return nil, err
+		}
+		if v.Certificate != nil {
+			// Only the details of the first signer are reported
+			continue
+		}
+		v.Certificate = cert
+		v.SigningTime = signingTime
+
+		v.Certificates = []*x509.Certificate{cert}
+		for _, c := range certs {
+			if c != cert {
+				v.Certificates = append(v.Certificates, c)
+			}
+		}
+
+		token, err := unsignedAttribute(si, oidAttributeTimestamp)
+		if err != nil {
+			return nil, err
+		}
+		if token != nil {
+			if v.Timestamp, err = verifyTimestampToken(token, si.Signature); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return v, nil
+}
+
+// verifyTimestampToken checks the signature of a timestamp token, and that
+// it was issued for the data given.
+func verifyTimestampToken(token, data []byte) (*TimestampToken, error) {
+	content, sd, certs, err := parseSignedData(token)
+	if err != nil {
+		return nil, fmt.Errorf("timestamp: %w", err)
+	}
+	if !sd.EncapContentInfo.ContentType.Equal(oidTSTInfo) || len(sd.SignerInfos) != 1 {
+		return nil, errors.New("timestamp: invalid token")
+	}
+	cert, _, err := verifySignerInfo(&sd.SignerInfos[0], content, oidTSTInfo, certs)
+	if err != nil {
+		return nil, fmt.Errorf("timestamp: %w", err)
+	}
+
+	info, err := parseTSTInfo(token)
+	if err != nil {
+		return nil, err
+	}
+	hash, ok := cmsDigestHash(info.MessageImprint.HashAlgorithm.Algorithm)
+	if !ok {
+		return nil, errors.New("timestamp: unsupported digest algorithm")
+	}
+	h := hash.New()
+	h.Write(data) // nolint:errcheck
+	if !bytes.Equal(h.Sum(nil), info.MessageImprint.HashedMessage) {
+		return nil, errors.New("timestamp: message imprint mismatch")
+	}
+
+	return &TimestampToken{
+		Time:         info.GenTime,
+		Certificate:  cert,
+		Token:        token,
+		certificates: certs,
+	}, nil
+}
+
+// parseTSTInfo extracts the details of a timestamp token
+func parseTSTInfo(token []byte) (*tstInfo, error) {
+	content, sd, _, err := parseSignedData(token)
+	if err != nil {
+		return nil, fmt.Errorf("timestamp: %w", err)
+	}
+	if !sd.EncapContentInfo.ContentType.Equal(oidTSTInfo) {
+		return nil, errors.New("timestamp: invalid token")
+	}
+	info := new(tstInfo)
+	if _, err := asn1.Unmarshal(content, info); err != nil {
+		return nil, fmt.Errorf("timestamp: parsing info: %w", err)
+	}
+	return info, nil
+}
+
+// parseSignedData parses a CMS SignedData, which may be BER encoded,
+// providing the encapsulated content and the certificates included.
+func parseSignedData(data []byte) ([]byte, *cmsSignedData, []*x509.Certificate, error) {
+	der, err := berToDER(data)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("parsing envelope: %w", err)
+	}
+	ci := new(cmsContentInfo)
+	if _, err := asn1.Unmarshal(der, ci); err != nil {
+		return nil, nil, nil, fmt.Errorf("parsing envelope: %w", err)
+	}
+	if !ci.ContentType.Equal(oidSignedData) {
+		return nil, nil, nil, errors.New("envelope does not contain signed data")
+	}
+	sd := new(cmsSignedData)
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, sd); err != nil {
+		return nil, nil, nil, fmt.Errorf("parsing signed data: %w", err)
+	}
+
+	var content []byte
+	if len(sd.EncapContentInfo.Content.Bytes) == 0 {
+		return nil, nil, nil, errors.New("envelope does not contain the signed document")
+	}
+	if _, err := asn1.Unmarshal(sd.EncapContentInfo.Content.Bytes, &content); err != nil {
+		return nil, nil, nil, fmt.Errorf("parsing content: %w", err)
+	}
+
+	certs, err := x509.ParseCertificates(sd.Certificates.Bytes)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("parsing certificates: %w", err)
+	}
+	if len(sd.SignerInfos) == 0 {
+		return nil, nil, nil, errors.New("envelope is not signed")
+	}
+
+	return content, sd, certs, nil
+}
+
+// verifySignerInfo checks the signature and signed attributes of a signer,
+// providing its certificate and the signing time, if present.
+func verifySignerInfo(si *cmsSignerInfo, content []byte, contentType asn1.ObjectIdentifier, certs []*x509.Certificate) (*x509.Certificate, time.Time, error) {
+	var signingTime time.Time
+	cert := signerCertificate(si, certs)
+	if cert == nil {
+		return nil, signingTime, errors.New("missing signer certificate")
+	}
+	hash, ok := cmsDigestHash(si.DigestAlgorithm.Algorithm)
+	if !ok {
+		return nil, signingTime, errors.New("unsupported digest algorithm")
+	}
+	if len(si.SignedAttrs.Bytes) == 0 {
+		return nil, signingTime, errors.New("missing signed attributes")
+	}
+
+	var attrs []cmsAttribute
+	if _, err := asn1.UnmarshalWithParams(si.SignedAttrs.FullBytes, &attrs, "set,tag:0"); err != nil {
+		return nil, signingTime, fmt.Errorf("parsing signed attributes: %w", err)
+	}
+
+	var digest []byte
+	var certIDs []essCertIDv2
+	var ct asn1.ObjectIdentifier
+	for _, a := range attrs {
+		var err error
+		switch {
+		case a.Type.Equal(oidAttributeContentType):
+			_, err = asn1.Unmarshal(a.Values.Bytes, &ct)
+		case a.Type.Equal(oidAttributeDigest):
+			_, err = asn1.Unmarshal(a.Values.Bytes, &digest)
+		case a.Type.Equal(oidAttributeSigningTime):
+			_, err = asn1.Unmarshal(a.Values.Bytes, &signingTime)
+		case a.Type.Equal(oidAttributeSigningCert), a.Type.Equal(oidAttributeSigningCertV1):
+			sc := new(essSigningCertificateV2)
+			_, err = asn1.Unmarshal(a.Values.Bytes, sc)
+			for _, id := range sc.Certs {
+				if a.Type.Equal(oidAttributeSigningCertV1) {
+					id.HashAlgorithm.Algorithm = cmsDigestAlgorithms[crypto.SHA1]
+				}
+				certIDs = append(certIDs, id)
+			}
+		}
+		if err != nil {
+			return nil, signingTime, fmt.Errorf("parsing attribute %s: %w", a.Type, err)
+		}
+	}
+
+	if !ct.Equal(contentType) {
+		return nil, signingTime, errors.New("content type mismatch")
+	}
+	h := hash.New()
+	h.Write(content) // nolint:errcheck
+	if !bytes.Equal(h.Sum(nil), digest) {
+		return nil, signingTime, errors.New("message digest mismatch")
+	}
+	if len(certIDs) == 0 {
+		return nil, signingTime, errors.New("missing signing certificate attribute")
+	}
+	if !matchesCertID(cert, certIDs[0]) {
+		return nil, signingTime, errors.New("signing certificate does not match")
+	}
+
+	// The signature is calculated over the attributes encoded as a SET
+	signed := append([]byte{0x31}, si.SignedAttrs.FullBytes[1:]...)
+	if err := checkSignature(cert, hash, signed, si.Signature, false); err != nil {
+		return nil, signingTime, err
+	}
+
+	return cert, signingTime, nil
+}
+
+// signerCertificate finds the certificate identified by the signer
+func signerCertificate(si *cmsSignerInfo, certs []*x509.Certificate) *x509.Certificate {
+	if si.SID.Class == asn1.ClassContextSpecific {
+		for _, c := range certs {
+			if bytes.Equal(c.SubjectKeyId, si.SID.Bytes) {
+				return c
+			}
+		}
+		return nil
+	}
+	ias := new(cmsIssuerAndSerial)
+	if _, err := asn1.Unmarshal(si.SID.FullBytes, ias); err != nil {
+		return nil
+	}
+	for _, c := range certs {
+		if bytes.Equal(c.RawIssuer, ias.Issuer.FullBytes) && c.SerialNumber.Cmp(ias.SerialNumber) == 0 {
+			return c
+		}
+	}
+	return nil
+}
+
+// matchesCertID determines if the certificate has the hash of the ESSCertID
+func matchesCertID(cert *x509.Certificate, id essCertIDv2) bool {
+	hash := crypto.SHA256
+	if len(id.HashAlgorithm.Algorithm) > 0 {
+		var ok bool
+		if hash, ok = cmsDigestHash(id.HashAlgorithm.Algorithm); !ok {
+			return false
+		}
+	}
+	h := hash.New()
+	h.Write(cert.Raw) // nolint:errcheck
+	return bytes.Equal(h.Sum(nil), id.CertHash)
+}
+
+// unsignedAttribute provides the DER encoding of the first value of an
+// unsigned attribute, or nil if not present.
+func unsignedAttribute(si *cmsSignerInfo, oid asn1.ObjectIdentifier) ([]byte, error) {
+	if len(si.UnsignedAttrs.Bytes) == 0 {
+		return nil, nil
+	}
+	var attrs []cmsAttribute
+	if _, err := asn1.UnmarshalWithParams(si.UnsignedAttrs.FullBytes, &attrs, "set,tag:1"); err != nil {
+		return nil, fmt.Errorf("parsing unsigned attributes: %w", err)
+	}
+	for _, a := range attrs {
+		if a.Type.Equal(oid) {
+			var value asn1.RawValue
+			if _, err := asn1.Unmarshal(a.Values.Bytes, &value); err != nil {
+				return nil, err
+			}
+			return value.FullBytes, nil
+		}
+	}
+	return nil, nil
+}
+
+// maxBERDepth limits the nesting of the values in BER encoded data, which
+// comes from untrusted sources.
+const maxBERDepth = 64
+
+// berToDER converts BER encoded data, as produced by some signing tools, to
+// DER by using definite lengths and joining constructed strings.
+func berToDER(data []byte) ([]byte, error) {
+	out, rest, err := berValueToDER(data, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, errors.New("trailing data")
+	}
+	return out, nil
+}
+
+func berValueToDER(data []byte, depth int) ([]byte, []byte, error) {
+	if depth > maxBERDepth {
+		return nil, nil, errors.New("too deeply nested")
+	}
+	if len(data) < 2 {
+		return nil, nil, errors.New("truncated data")
+	}
+
+	// Identifier octets, including high tag numbers
+	n := 1
+	if data[0]&0x1f == 0x1f {
+		for n < len(data) && data[n]&0x80 != 0 {
+			n++
+		}
+		n++
+	}
+	if n >= len(data) {
+		return nil, nil, errors.New("truncated data")
+	}
+	tag := data[:n]
+	constructed := data[0]&0x20 != 0
+
+	// Length octets
+	l := int(data[n])
+	n++
+	indefinite := l == 0x80
+	if l > 0x80 {
+		size := l & 0x7f
+		if size > 4 || n+size > len(data) {
+			return nil, nil, errors.New("invalid length")
+		}
+		l = 0
+		for _, b := range data[n : n+size] {
+			l = l<<8 | int(b)
+		}
+		n += size
+	}
+
+	if !constructed {
+		if indefinite || n+l > len(data) {
+			return nil, nil, errors.New("invalid length")
+		}
+		return derTLV(tag, data[n:n+l]), data[n+l:], nil
+	}
+
+	var body []byte
+	var rest []byte
+	if indefinite {
+		rest = data[n:]
+	} else {
+		if n+l > len(data) {
+			return nil, nil, errors.New("invalid length")
+		}
+		body, rest = data[n:n+l], data[n+l:]
+	}
+
+	// Constructed strings are joined into a primitive value
+	str := len(tag) == 1 && tag[0]&0xc0 == 0 && tag[0]&0x1f != asn1.TagSequence && tag[0]&0x1f != asn1.TagSet
+	var children [][]byte
+	for {
+		if indefinite {
+			if len(rest) >= 2 && rest[0] == 0 && rest[1] == 0 {
+				rest = rest[2:]
+				break
+			}
+			if len(rest) == 0 {
+				return nil, nil, errors.New("missing end of contents")
+			}
+		} else if len(body) == 0 {
+			break
+		}
+		var child []byte
+		var err error
+		if indefinite {
+			child, rest, err = berValueToDER(rest, depth+1)
+		} else {
+			child, body, err = berValueToDER(body, depth+1)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if str {
+			var v asn1.RawValue
+			if _, err := asn1.Unmarshal(child, &v); err != nil {
+				return nil, nil, err
+			}
+			child = v.Bytes
+		}
+		children = append(children, child)
+	}
+
+	if str {
+		return derTLV([]byte{tag[0] &^ 0x20}, bytes.Join(children, nil)), rest, nil
+	}
+	return derTLV(tag, bytes.Join(children, nil)), rest, nil
+}
+
+// derTLV encodes a value with a definite length
+func derTLV(tag, value []byte) []byte {
+	out := append([]byte{}, tag...)
+	l := len(value)
+	switch {
+	case l < 0x80:
+		out = append(out, byte(l))
+	default:
+		var size []byte
+		for ; l > 0; l >>= 8 {
+			size = append([]byte{byte(l)}, size...)
+		}
+		out = append(out, 0x80|byte(len(size)))
+		out = append(out, size...)
+	}
+	return append(out, value...)
+}
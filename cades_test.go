@@ -0,0 +1,169 @@
+package fatturapa_test
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"math/big"
+	"testing"
+	"time"
+
+	fatturapa "github.com/invopop/gobl.fatturapa"
+	"github.com/invopop/gobl.fatturapa/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Minimal structures used to inspect the generated envelopes
+type p7mContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,tag:0"`
+}
+
+type p7mSignedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	EncapContentInfo struct {
+		ContentType asn1.ObjectIdentifier
+		Content     []byte `asn1:"explicit,tag:0"`
+	}
+	Certificates asn1.RawValue   `asn1:"optional,tag:0"`
+	SignerInfos  []p7mSignerInfo `asn1:"set"`
+}
+
+type p7mSignerInfo struct {
+	Version int
+	SID     struct {
+		Issuer       asn1.RawValue
+		SerialNumber *big.Int
+	}
+	DigestAlgorithm    asn1.RawValue
+	SignedAttrs        asn1.RawValue `asn1:"optional,tag:0"`
+	SignatureAlgorithm asn1.RawValue
+	Signature          []byte
+}
+
+type p7mAttribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue `asn1:"set"`
+}
+
+func parseP7M(t *testing.T, data []byte) *p7mSignedData {
+	t.Helper()
+	ci := new(p7mContentInfo)
+	_, err := asn1.Unmarshal(data, ci)
+	require.NoError(t, err)
+	assert.Equal(t, asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}, ci.ContentType)
+
+	sd := new(p7mSignedData)
+	_, err = asn1.Unmarshal(ci.Content.Bytes, sd)
+	require.NoError(t, err)
+	return sd
+}
+
+func TestCAdES(t *testing.T) {
+	env := test.LoadTestFile("invoice-simple.json")
+	converter := test.NewConverter(fatturapa.WithSignatureFormat(fatturapa.CAdES))
+
+	t.Run("should envelope the document", func(t *testing.T) {
+		doc, err := test.ConvertFromGOBL(env, converter)
+		require.NoError(t, err)
+		assert.Nil(t, doc.Signature)
+		assert.Regexp(t, `^IT01234567890_[0-9a-f]{5}\.xml\.p7m$`, doc.FileName())
+
+		data, err := doc.P7M()
+		require.NoError(t, err)
+		sd := parseP7M(t, data)
+
+		xml, err := doc.Bytes()
+		require.NoError(t, err)
+		assert.Equal(t, asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}, sd.EncapContentInfo.ContentType)
+		assert.Equal(t, xml, sd.EncapContentInfo.Content)
+	})
+
+	t.Run("should sign the attributes", func(t *testing.T) {
+		doc, err := test.ConvertFromGOBL(env, converter)
+		require.NoError(t, err)
+		data, err := doc.P7M()
+		require.NoError(t, err)
+		sd := parseP7M(t, data)
+
+		certs, err := x509.ParseCertificates(sd.Certificates.Bytes)
+		require.NoError(t, err)
+		require.NotEmpty(t, certs)
+		require.Len(t, sd.SignerInfos, 1)
+		si := sd.SignerInfos[0]
+		assert.Equal(t, 0, certs[0].SerialNumber.Cmp(si.SID.SerialNumber))
+
+		// Signatures are calculated over the attributes encoded as a SET
+		signed := append([]byte{0x31}, si.SignedAttrs.FullBytes[1:]...)
+		hash := sha256.Sum256(signed)
+		pub := certs[0].PublicKey.(*rsa.PublicKey)
+		assert.NoError(t, rsa.VerifyPKCS1v15(pub, crypto.SHA256, hash[:], si.Signature))
+
+		var attrs []p7mAttribute
+		_, err = asn1.UnmarshalWithParams(signed, &attrs, "set")
+		require.NoError(t, err)
+		found := make(map[string][]byte)
+		for _, a := range attrs {
+			found[a.Type.String()] = a.Values.Bytes
+		}
+		require.Len(t, found, 4)
+		assert.Contains(t, found, "1.2.840.113549.1.9.3")
+		assert.Contains(t, found, "1.2.840.113549.1.9.5")
+
+		var digest []byte
+		_, err = asn1.Unmarshal(found["1.2.840.113549.1.9.4"], &digest)
+		require.NoError(t, err)
+		xml, err := doc.Bytes()
+		require.NoError(t, err)
+		expected := sha256.Sum256(xml)
+		assert.Equal(t, expected[:], digest)
+
+		// ESSCertIDv2 hash of the signing certificate
+		certHash := sha256.Sum256(certs[0].Raw)
+		assert.Contains(t, string(found["1.2.840.113549.1.9.16.2.47"]), string(certHash[:]))
+	})
+
+	t.Run("should support simplified documents", func(t *testing.T) {
+		doc, err := test.ConvertSimplifiedFromGOBL(test.LoadTestFile("invoice-simplified.json"), converter)
+		require.NoError(t, err)
+		assert.Nil(t, doc.Signature)
+		data, err := doc.P7M()
+		require.NoError(t, err)
+		sd := parseP7M(t, data)
+
+		xml, err := doc.Bytes()
+		require.NoError(t, err)
+		assert.Equal(t, xml, sd.EncapContentInfo.Content)
+	})
+
+	t.Run("should require the CAdES format", func(t *testing.T) {
+		doc, err := test.ConvertFromGOBL(env)
+		require.NoError(t, err)
+		assert.NotNil(t, doc.Signature)
+		assert.Regexp(t, `\.xml$`, doc.FileName())
+
+		_, err = doc.P7M()
+		assert.EqualError(t, err, "document not signed with CAdES")
+	})
+
+	t.Run("should add timestamps", func(t *testing.T) {
+		tsa := test.NewTSAServer()
+		defer tsa.Close()
+
+		converter := test.NewConverter(fatturapa.WithSignatureFormat(fatturapa.CAdES), fatturapa.WithTSA(tsa.URL, "", ""))
+		doc, err := test.ConvertFromGOBL(env, converter)
+		require.NoError(t, err)
+		data, err := doc.P7M()
+		require.NoError(t, err)
+		assert.Equal(t, 1, tsa.Requests())
+
+		v, err := fatturapa.Verify(data)
+		require.NoError(t, err)
+		require.NotNil(t, v.Timestamp)
+		assert.WithinDuration(t, time.Now(), v.Timestamp.Time, time.Minute)
+	})
+}
@@ -0,0 +1,287 @@
+package fatturapa
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/invopop/gobl/i18n"
+	"github.com/invopop/gobl/num"
+)
+
+// Codes of the SDI rejections (codici di scarto) anticipated by the checks
+const (
+	CheckCodeNaturaMissing          = "00400"
+	CheckCodeNaturaNotAllowed       = "00401"
+	CheckCodeDuplicate              = "00404"
+	CheckCodeRitenutaWithoutDati    = "00411"
+	CheckCodeRiepilogoMissing       = "00419"
+	CheckCodeImpostaInvalid         = "00421"
+	CheckCodeImponibileInvalid      = "00422"
+	CheckCodePrezzoTotaleInvalid    = "00423"
+	CheckCodeCodiceDestinatarioSize = "00427"
+)
+
+// Messages used for each check, in Italian as provided by the SDI
+// specifications, along with their English translation.
+var checkMessages = map[string]i18n.String{
+	CheckCodeNaturaMissing: {
+		i18n.IT: "2.2.1.14 <Natura> non presente a fronte di 2.2.1.12 <AliquotaIVA> pari a zero",
+		i18n.EN: "2.2.1.14 <Natura> missing for a 2.2.1.12 <AliquotaIVA> of zero",
+	},
+	CheckCodeNaturaNotAllowed: {
+		i18n.IT: "2.2.1.14 <Natura> presente a fronte di 2.2.1.12 <AliquotaIVA> diversa da zero",
+		i18n.EN: "2.2.1.14 <Natura> present for a 2.2.1.12 <AliquotaIVA> other than zero",
+	},
+	CheckCodeDuplicate: {
+		i18n.IT: "Fattura duplicata",
+		i18n.EN: "Duplicate invoice",
+	},
+	CheckCodeRitenutaWithoutDati: {
+		i18n.IT: "2.2.1.13 <Ritenuta> presente a fronte di 2.1.1.5 <DatiRitenuta> assente",
+		i18n.EN: "2.2.1.13 <Ritenuta> present while 2.1.1.5 <DatiRitenuta> is missing",
+	},
+	CheckCodeRiepilogoMissing: {
+		i18n.IT: "2.2.2 <DatiRiepilogo> non presente in corrispondenza di almeno un valore di 2.1.1.7.5 <AliquotaIVA> o 2.2.1.12 <AliquotaIVA>",
+		i18n.EN: "2.2.2 <DatiRiepilogo> missing for at least one value of 2.1.1.7.5 <AliquotaIVA> or 2.2.1.12 <AliquotaIVA>",
+	},
+	CheckCodeImpostaInvalid: {
+		i18n.IT: "2.2.2.6 <Imposta> non calcolato secondo le regole definite nelle specifiche tecniche",
+		i18n.EN: "2.2.2.6 <Imposta> not calculated according to the rules of the technical specifications",
+	},
+	CheckCodeImponibileInvalid: {
+		i18n.IT: "2.2.2.5 <ImponibileImporto> non calcolato secondo le regole definite nelle specifiche tecniche",
+		i18n.EN: "2.2.2.5 <ImponibileImporto> not calculated according to the rules of the technical specifications",
+	},
+	CheckCodePrezzoTotaleInvalid: {
+		i18n.IT: "2.2.1.11 <PrezzoTotale> non calcolato secondo le regole definite nelle specifiche tecniche",
+		i18n.EN: "2.2.1.11 <PrezzoTotale> not calculated according to the rules of the technical specifications",
+	},
+	CheckCodeCodiceDestinatarioSize: {
+		i18n.IT: "1.1.4 <CodiceDestinatario> di 7 caratteri a fronte di 1.1.3 <FormatoTrasmissione> con valore FPA12 o 1.1.4 <CodiceDestinatario> di 6 caratteri a fronte di 1.1.3 <FormatoTrasmissione> con valore FPR12",
+		i18n.EN: "1.1.4 <CodiceDestinatario> of 7 characters with 1.1.3 <FormatoTrasmissione> FPA12 or 1.1.4 <CodiceDestinatario> of 6 characters with 1.1.3 <FormatoTrasmissione> FPR12",
+	},
+}
+
+// Tolerances accepted by the SDI when checking the amounts calculated
+var (
+	checkToleranceLine    = num.MakeAmount(1, 2)
+	checkToleranceSummary = num.MakeAmount(1, 0)
+)
+
+// Number of decimal places used for the calculations
+const checkPrecision = 8
+
+// Finding describes a problem in the document that would lead the SDI to
+// reject it, along with the path in the GOBL invoice that caused it.
+type Finding struct {
+	// Code of the SDI rejection
+	Code string
+	// Message in Italian and English
+	Message i18n.String
+	// Path of the offending field in the GOBL invoice
+	Path string
+	// Invoice is the position of the invoice in a lot, starting at 0
+	Invoice int
+}
+
+// Error provides the code, English message and path of the finding
+func (f *Finding) Error() string {
+	return fmt.Sprintf("%s: %s (%s)", f.Code, f.Message[i18n.EN], f.Path)
+}
+
+// Findings contains all the problems found in a document
+type Findings []*Finding
+
+// Error joins the messages of all the findings
+func (f Findings) Error() string {
+	msgs := make([]string, len(f))
+	for i, fi := range f {
+		msgs[i] = fi.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// InvoiceRegistry keeps track of the invoices already sent to the SDI, so
+// that duplicates may be detected before sending them again.
+type InvoiceRegistry interface {
+	// Exists determines if an invoice with the same supplier tax ID
+	// (country code and code), document type, number and year has been sent.
+	Exists(supplier, tipoDocumento, numero string, year int) bool
+}
+
+// Check runs the semantic checks applied by the SDI on the document,
+// returning the findings that would cause it to be rejected.
+func (d *Document) Check() Findings {
+	return d.check(nil)
+}
+
+func (d *Document) check(registry InvoiceRegistry) Findings {
+	c := &checker{registry: registry}
+	c.checkCodiceDestinatario(d)
+	seen := make(map[string]bool)
+	for i, body := range d.FatturaElettronicaBody {
+		c.invoice = i
+		c.checkDuplicate(d, body, seen)
+		c.checkLines(body)
+		c.checkRiepilogo(body)
+	}
+	return c.findings
+}
+
+type checker struct {
+	registry InvoiceRegistry
+	invoice  int
+	findings Findings
+}
+
+func (c *checker) add(code, path string) {
+	c.findings = append(c.findings, &Finding{
+		Code:    code,
+		Message: checkMessages[code],
+		Path:    path,
+		Invoice: c.invoice,
+	})
+}
+
+func (c *checker) checkCodiceDestinatario(d *Document) {
+	dt := d.FatturaElettronicaHeader.DatiTrasmissione
+	if dt == nil {
+		return
+	}
+	size := len(dt.CodiceDestinatario)
+	if (d.Versione == formatoTrasmissioneFPA12 && size != 6) ||
+		(d.Versione == formatoTrasmissioneFPR12 && size != 7) {
+		c.add(CheckCodeCodiceDestinatarioSize, "customer.inboxes")
+	}
+}
+
+func (c *checker) checkDuplicate(d *Document, body *fatturaElettronicaBody, seen map[string]bool) {
+	dgd := body.DatiGenerali.DatiGeneraliDocumento
+	id := d.FatturaElettronicaHeader.CedentePrestatore.DatiAnagrafici.IdFiscaleIVA
+	year, _ := strconv.Atoi(strings.SplitN(dgd.Data, "-", 2)[0])
+	supplier := id.IdPaese + id.IdCodice
+
+	key := fmt.Sprintf("%s/%s/%s/%d", supplier, dgd.TipoDocumento, dgd.Numero, year)
+	if seen[key] || (c.registry != nil && c.registry.Exists(supplier, dgd.TipoDocumento, dgd.Numero, year)) {
+		c.add(CheckCodeDuplicate, "code")
+	}
+	seen[key] = true
+}
+
+func (c *checker) checkLines(body *fatturaElettronicaBody) {
+	hasRitenuta := len(body.DatiGenerali.DatiGeneraliDocumento.DatiRitenuta) > 0
+	for i, dl := range body.DatiBeniServizi.DettaglioLinee {
+		path := fmt.Sprintf("lines[%d]", i)
+		if n, err := strconv.Atoi(dl.NumeroLinea); err == nil {
+			path = fmt.Sprintf("lines[%d]", n-1)
+		}
+
+		zero := parseCheckAmount(dl.AliquotaIVA).IsZero()
+		if zero && dl.Natura == "" {
+			c.add(CheckCodeNaturaMissing, path+".taxes")
+		}
+		if !zero && dl.Natura != "" {
+			c.add(CheckCodeNaturaNotAllowed, path+".taxes")
+		}
+
+		if dl.Ritenuta == ritenutaYes && !hasRitenuta {
+			c.add(CheckCodeRitenutaWithoutDati, path+".taxes")
+		}
+
+		if !checkAmountMatches(dl.PrezzoTotale, lineTotal(dl), checkToleranceLine) {
+			c.add(CheckCodePrezzoTotaleInvalid, path+".sum")
+		}
+	}
+}
+
+// lineTotal calculates the line's total price from the unit price adjusted by
+// any discounts or charges, which are applied in order, and the quantity.
+func lineTotal(dl *dettaglioLinee) num.Amount {
+	price := parseCheckAmount(dl.PrezzoUnitario)
+	for _, sm := range dl.ScontoMaggiorazione {
+		var adj num.Amount
+		if sm.Importo != "" {
+			adj = parseCheckAmount(sm.Importo)
+		} else {
+			adj = price.Multiply(parseCheckAmount(sm.Percentuale)).Divide(num.MakeAmount(100, 0))
+		}
+		if sm.Tipo == scontoMaggiorazioneTypeDiscount {
+			price = price.Subtract(adj)
+		} else {
+			price = price.Add(adj)
+		}
+	}
+
+	quantity := num.MakeAmount(1, 0)
+	if dl.Quantita != "" {
+		quantity = parseCheckAmount(dl.Quantita)
+	}
+	return price.Multiply(quantity)
+}
+
+// riepilogoKey identifies the summary of a rate and nature
+func riepilogoKey(aliquota, natura string) string {
+	return parseCheckAmount(aliquota).Rescale(2).String() + "/" + natura
+}
+
+func (c *checker) checkRiepilogo(body *fatturaElettronicaBody) {
+	dbs := body.DatiBeniServizi
+
+	// Taxable amounts expected for each rate and nature
+	bases := make(map[string]num.Amount)
+	var keys []string
+	add := func(aliquota, natura string, amount num.Amount) {
+		k := riepilogoKey(aliquota, natura)
+		if b, ok := bases[k]; ok {
+			bases[k] = b.Add(amount)
+			return
+		}
+		bases[k] = amount
+		keys = append(keys, k)
+	}
+	for _, dl := range dbs.DettaglioLinee {
+		add(dl.AliquotaIVA, dl.Natura, parseCheckAmount(dl.PrezzoTotale))
+	}
+	for _, dcp := range body.DatiGenerali.DatiGeneraliDocumento.DatiCassaPrevidenziale {
+		add(dcp.AliquotaIVA, dcp.Natura, parseCheckAmount(dcp.ImportoContributoCassa))
+	}
+
+	// Summaries are identified by their rate when checking they exist
+	rates := make(map[string]bool)
+	for _, dr := range dbs.DatiRiepilogo {
+		rates[riepilogoKey(dr.AliquotaIVA, "")] = true
+
+		imponibile := parseCheckAmount(dr.ImponibileImporto)
+		imposta := imponibile.Multiply(parseCheckAmount(dr.AliquotaIVA)).Divide(num.MakeAmount(100, 0))
+		if !checkAmountMatches(dr.Imposta, imposta, checkToleranceSummary) {
+			c.add(CheckCodeImpostaInvalid, "totals.taxes")
+		}
+		b, ok := bases[riepilogoKey(dr.AliquotaIVA, dr.Natura)]
+		if ok && !checkAmountMatches(dr.ImponibileImporto, b, checkToleranceSummary) {
+			c.add(CheckCodeImponibileInvalid, "totals.taxes")
+		}
+	}
+
+	for _, k := range keys {
+		if !rates[strings.SplitN(k, "/", 2)[0]+"/"] {
+			c.add(CheckCodeRiepilogoMissing, "totals.taxes")
+			break
+		}
+	}
+}
+
+func checkAmountMatches(value string, expected, tolerance num.Amount) bool {
+	diff := parseCheckAmount(value).Subtract(expected).Abs()
+	return diff.Compare(tolerance) <= 0
+}
+
+// parseCheckAmount parses the amounts of the document, already formatted
+// correctly, with enough precision for the calculations.
+func parseCheckAmount(s string) num.Amount {
+	a, err := num.AmountFromString(s)
+	if err != nil {
+		return num.MakeAmount(0, checkPrecision)
+	}
+	return a.Rescale(checkPrecision)
+}
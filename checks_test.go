@@ -0,0 +1,157 @@
+package fatturapa_test
+
+import (
+	"encoding/xml"
+	"testing"
+
+	fatturapa "github.com/invopop/gobl.fatturapa"
+	"github.com/invopop/gobl.fatturapa/test"
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/i18n"
+	"github.com/invopop/gobl/org"
+	"github.com/invopop/gobl/regimes/it"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type staticRegistry bool
+
+func (r staticRegistry) Exists(_, _, _ string, _ int) bool {
+	return bool(r)
+}
+
+func checkExample(t *testing.T, name string, replacements ...string) fatturapa.Findings {
+	t.Helper()
+	doc := new(fatturapa.Document)
+	require.NoError(t, xml.Unmarshal(modifyExample(t, name, replacements...), doc))
+	return doc.Check()
+}
+
+func findingCodes(findings fatturapa.Findings) []string {
+	codes := make([]string, len(findings))
+	for i, f := range findings {
+		codes[i] = f.Code
+	}
+	return codes
+}
+
+func TestCheck(t *testing.T) {
+	t.Run("should accept valid documents", func(t *testing.T) {
+		doc, err := test.ConvertFromGOBL(test.LoadTestFile("invoice-hotel.json"))
+		require.NoError(t, err)
+		assert.Empty(t, doc.Check())
+
+		assert.Empty(t, checkExample(t, "bare-minimum.xml"))
+	})
+
+	t.Run("should check the nature of zero rates", func(t *testing.T) {
+		findings := checkExample(t, "bare-minimum.xml",
+			"<AliquotaIVA>22.00</AliquotaIVA>", "<AliquotaIVA>0.00</AliquotaIVA>",
+			"<AliquotaIVA>22.00</AliquotaIVA>", "<AliquotaIVA>0.00</AliquotaIVA><Natura>N2.2</Natura>",
+			"<Imposta>1.10</Imposta>", "<Imposta>0.00</Imposta>",
+		)
+		require.Len(t, findings, 1)
+		f := findings[0]
+		assert.Equal(t, fatturapa.CheckCodeNaturaMissing, f.Code)
+		assert.Equal(t, "lines[0].taxes", f.Path)
+		assert.Equal(t, "2.2.1.14 <Natura> non presente a fronte di 2.2.1.12 <AliquotaIVA> pari a zero", f.Message[i18n.IT])
+		assert.Equal(t, "00400: 2.2.1.14 <Natura> missing for a 2.2.1.12 <AliquotaIVA> of zero (lines[0].taxes)", f.Error())
+	})
+
+	t.Run("should check the nature is only used for zero rates", func(t *testing.T) {
+		findings := checkExample(t, "bare-minimum.xml",
+			"<AliquotaIVA>22.00</AliquotaIVA>", "<AliquotaIVA>22.00</AliquotaIVA><Natura>N1</Natura>",
+		)
+		assert.Equal(t, []string{fatturapa.CheckCodeNaturaNotAllowed}, findingCodes(findings))
+	})
+
+	t.Run("should check retained taxes", func(t *testing.T) {
+		findings := checkExample(t, "bare-minimum.xml",
+			"<AliquotaIVA>22.00</AliquotaIVA>", "<AliquotaIVA>22.00</AliquotaIVA><Ritenuta>SI</Ritenuta>",
+		)
+		assert.Equal(t, []string{fatturapa.CheckCodeRitenutaWithoutDati}, findingCodes(findings))
+	})
+
+	t.Run("should check the summaries", func(t *testing.T) {
+		findings := checkExample(t, "bare-minimum.xml",
+			"<AliquotaIVA>22.00</AliquotaIVA>", "<AliquotaIVA>10.00</AliquotaIVA>",
+		)
+		assert.Equal(t, []string{fatturapa.CheckCodeRiepilogoMissing}, findingCodes(findings))
+		assert.Equal(t, "totals.taxes", findings[0].Path)
+
+		findings = checkExample(t, "bare-minimum.xml",
+			"<Imposta>1.10</Imposta>", "<Imposta>2.20</Imposta>",
+		)
+		assert.Equal(t, []string{fatturapa.CheckCodeImpostaInvalid}, findingCodes(findings))
+
+		findings = checkExample(t, "bare-minimum.xml",
+			"<ImponibileImporto>5.00</ImponibileImporto>", "<ImponibileImporto>7.00</ImponibileImporto>",
+			"<Imposta>1.10</Imposta>", "<Imposta>1.54</Imposta>",
+		)
+		assert.Equal(t, []string{fatturapa.CheckCodeImponibileInvalid}, findingCodes(findings))
+	})
+
+	t.Run("should accept differences within the tolerance", func(t *testing.T) {
+		findings := checkExample(t, "bare-minimum.xml",
+			"<ImponibileImporto>5.00</ImponibileImporto>", "<ImponibileImporto>5.90</ImponibileImporto>",
+			"<Imposta>1.10</Imposta>", "<Imposta>2.00</Imposta>",
+			"<PrezzoTotale>5.00</PrezzoTotale>", "<PrezzoTotale>5.01</PrezzoTotale>",
+		)
+		assert.Empty(t, findings)
+	})
+
+	t.Run("should check the line totals", func(t *testing.T) {
+		doc, err := test.ConvertFromGOBL(test.LoadTestFile("invoice-simple.json"))
+		require.NoError(t, err)
+
+		findings := doc.Check()
+		assert.Equal(t, []string{fatturapa.CheckCodePrezzoTotaleInvalid, fatturapa.CheckCodeImponibileInvalid}, findingCodes(findings))
+		assert.Equal(t, "lines[0].sum", findings[0].Path)
+
+		findings = checkExample(t, "bare-minimum.xml",
+			"<PrezzoUnitario>1.00</PrezzoUnitario>", "<PrezzoUnitario>1.25</PrezzoUnitario><ScontoMaggiorazione><Tipo>SC</Tipo><Percentuale>20.00</Percentuale></ScontoMaggiorazione>",
+		)
+		assert.Empty(t, findings)
+	})
+
+	t.Run("should check the length of the codice destinatario", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-hotel.json")
+		test.ModifyInvoice(env, func(inv *bill.Invoice) {
+			inv.Customer.Inboxes = []*org.Inbox{{Key: it.KeyInboxSDICode, Code: "ABCDEF"}}
+		})
+		doc, err := test.ConvertFromGOBL(env)
+		require.NoError(t, err)
+
+		findings := doc.Check()
+		assert.Equal(t, []string{fatturapa.CheckCodeCodiceDestinatarioSize}, findingCodes(findings))
+		assert.Equal(t, "customer.inboxes", findings[0].Path)
+	})
+
+	t.Run("should check duplicates in lots", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-hotel.json")
+		doc, err := test.NewConverter().ConvertLotFromGOBL(env, env)
+		require.NoError(t, err)
+
+		findings := doc.Check()
+		require.Len(t, findings, 1)
+		assert.Equal(t, fatturapa.CheckCodeDuplicate, findings[0].Code)
+		assert.Equal(t, "code", findings[0].Path)
+		assert.Equal(t, 1, findings[0].Invoice)
+	})
+
+	t.Run("should run the checks before signing", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-hotel.json")
+
+		converter := test.NewConverter(fatturapa.WithPreflightChecks())
+		doc, err := test.ConvertFromGOBL(env, converter)
+		require.NoError(t, err)
+		assert.NotNil(t, doc.Signature)
+
+		converter = test.NewConverter(fatturapa.WithPreflightChecks(), fatturapa.WithInvoiceRegistry(staticRegistry(true)))
+		_, err = test.ConvertFromGOBL(env, converter)
+		require.Error(t, err)
+		findings, ok := err.(fatturapa.Findings)
+		require.True(t, ok)
+		assert.Equal(t, []string{fatturapa.CheckCodeDuplicate}, findingCodes(findings))
+	})
+}
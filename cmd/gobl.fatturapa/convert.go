@@ -2,7 +2,10 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"os"
+	"path/filepath"
 
 	fatturapa "github.com/invopop/gobl.fatturapa"
 	"github.com/invopop/gobl/l10n"
@@ -16,6 +19,14 @@ type convertOpts struct {
 	password      string
 	transmitter   string
 	withTimestamp bool
+	tsa           string
+	tsaUser       string
+	tsaPassword   string
+	cades         bool
+	attachments   []string
+	compress      bool
+	sequence      string
+	rules         string
 }
 
 func convert(o *rootOpts) *convertOpts {
@@ -26,6 +37,7 @@ func (c *convertOpts) cmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "convert [infile] [outfile]",
 		Short: "Convert a GOBL JSON into a FatturaPA XML",
+		Long:  "Convert a GOBL JSON into a FatturaPA XML. When outfile is a directory, the file is named according to the SDI rules.",
 		RunE:  c.runE,
 	}
 	f := cmd.Flags()
@@ -33,6 +45,14 @@ func (c *convertOpts) cmd() *cobra.Command {
 	f.StringVarP(&c.password, "password", "p", "", "Password of the certificate")
 	f.StringVarP(&c.transmitter, "transmitter", "T", "", "Tax ID of the transmitter. Must be prefixed by the country code")
 	f.BoolVarP(&c.withTimestamp, "with-timestamp", "t", false, "Add timestamp to the output file")
+	f.StringVar(&c.tsa, "tsa", "", "URL of the timestamping authority (RFC 3161) to add timestamps from")
+	f.StringVar(&c.tsaUser, "tsa-user", "", "Username for the timestamping authority")
+	f.StringVar(&c.tsaPassword, "tsa-password", "", "Password for the timestamping authority")
+	f.BoolVar(&c.cades, "cades", false, "Sign using CAdES, producing a .p7m file instead of an XAdES signed XML")
+	f.StringSliceVarP(&c.attachments, "attach", "a", nil, "File to embed in the output as an attachment. May be repeated")
+	f.BoolVarP(&c.compress, "compress", "z", false, "Compress attachments using ZIP")
+	f.StringVarP(&c.sequence, "sequence", "s", "", "File used to keep the progressives of the files sent to the SDI")
+	f.StringVar(&c.rules, "management-data", "", "JSON file with the rules used to prepare the AltriDatiGestionali of the lines")
 
 	return cmd
 }
@@ -44,12 +64,6 @@ func (c *convertOpts) runE(cmd *cobra.Command, args []string) error {
 	}
 	defer input.Close() // nolint:errcheck
 
-	out, err := c.openOutput(cmd, args)
-	if err != nil {
-		return err
-	}
-	defer out.Close() // nolint:errcheck
-
 	converter, err := loadConverterFromConfig(c)
 	if err != nil {
 		return err
@@ -60,15 +74,22 @@ func (c *convertOpts) runE(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	doc, err := converter.ConvertFromGOBL(env)
+	data, name, err := converter.ConvertEnvelopeContext(cmd.Context(), env)
 	if err != nil {
 		return err
 	}
 
-	data, err := doc.Bytes()
+	if dir := c.outputFilename(args); dir != "" {
+		if fi, err := os.Stat(dir); err == nil && fi.IsDir() {
+			args = []string{args[0], filepath.Join(dir, name)}
+		}
+	}
+
+	out, err := c.openOutput(cmd, args)
 	if err != nil {
-		return fmt.Errorf("generating fatturapa xml: %w", err)
+		return err
 	}
+	defer out.Close() // nolint:errcheck
 
 	if _, err = out.Write(data); err != nil {
 		return fmt.Errorf("writing fatturapa xml: %w", err)
@@ -107,10 +128,53 @@ func loadConverterFromConfig(c *convertOpts) (*fatturapa.Converter, error) {
 		opts = append(opts, fatturapa.WithCertificate(cert))
 	}
 
+	if c.cades {
+		if c.cert == "" {
+			return nil, fmt.Errorf("CAdES signatures require a certificate")
+		}
+		opts = append(opts, fatturapa.WithSignatureFormat(fatturapa.CAdES))
+	}
+
 	if c.withTimestamp {
 		opts = append(opts, fatturapa.WithTimestamp())
 	}
 
+	if c.tsa != "" {
+		opts = append(opts, fatturapa.WithTSA(c.tsa, c.tsaUser, c.tsaPassword))
+	}
+
+	for _, name := range c.attachments {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return nil, fmt.Errorf("loading attachment %s: %w", name, err)
+		}
+
+		opts = append(opts, fatturapa.WithAttachments(&fatturapa.Attachment{
+			Name: filepath.Base(name),
+			Data: data,
+		}))
+	}
+
+	if c.compress {
+		opts = append(opts, fatturapa.WithAttachmentCompression())
+	}
+
+	if c.sequence != "" {
+		opts = append(opts, fatturapa.WithSequenceProvider(fatturapa.NewFileSequence(c.sequence)))
+	}
+
+	if c.rules != "" {
+		data, err := os.ReadFile(c.rules)
+		if err != nil {
+			return nil, fmt.Errorf("loading management data rules %s: %w", c.rules, err)
+		}
+		var rules []*fatturapa.ManagementDataRule
+		if err := json.Unmarshal(data, &rules); err != nil {
+			return nil, fmt.Errorf("parsing management data rules %s: %w", c.rules, err)
+		}
+		opts = append(opts, fatturapa.WithManagementData(rules...))
+	}
+
 	return fatturapa.NewConverter(
 		opts...,
 	), nil
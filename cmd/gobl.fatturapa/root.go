@@ -22,6 +22,8 @@ func (o *rootOpts) cmd() *cobra.Command {
 
 	cmd.AddCommand(versionCmd())
 	cmd.AddCommand(convert(o).cmd())
+	cmd.AddCommand(validate(o).cmd())
+	cmd.AddCommand(verify(o).cmd())
 
 	return cmd
 }
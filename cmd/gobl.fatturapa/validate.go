@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	fatturapa "github.com/invopop/gobl.fatturapa"
+	"github.com/spf13/cobra"
+)
+
+type validateOpts struct {
+	*rootOpts
+}
+
+func validate(o *rootOpts) *validateOpts {
+	return &validateOpts{rootOpts: o}
+}
+
+func (v *validateOpts) cmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "validate [infile]",
+		Short: "Validate a FatturaPA XML against the schema",
+		RunE:  v.runE,
+	}
+
+	return cmd
+}
+
+func (v *validateOpts) runE(cmd *cobra.Command, args []string) error {
+	input, err := openInput(cmd, args)
+	if err != nil {
+		return err
+	}
+	defer input.Close() // nolint:errcheck
+
+	data, err := io.ReadAll(input)
+	if err != nil {
+		return fmt.Errorf("reading input: %w", err)
+	}
+
+	err = fatturapa.ValidateXML(data)
+	var verrs fatturapa.ValidationErrors
+	if errors.As(err, &verrs) {
+		for _, e := range verrs {
+			fmt.Fprintln(cmd.OutOrStdout(), e.Error())
+		}
+		return fmt.Errorf("found %d validation errors", len(verrs))
+	}
+
+	return err
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	fatturapa "github.com/invopop/gobl.fatturapa"
+	"github.com/spf13/cobra"
+)
+
+type verifyOpts struct {
+	*rootOpts
+	trust   string
+	extract string
+}
+
+func verify(o *rootOpts) *verifyOpts {
+	return &verifyOpts{rootOpts: o}
+}
+
+func (v *verifyOpts) cmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "verify [infile]",
+		Short: "Verify the signature of a FatturaPA XML or p7m",
+		RunE:  v.runE,
+	}
+	f := cmd.Flags()
+	f.StringVarP(&v.trust, "trust", "t", "", "File or directory with the trusted certificates in PEM or DER format")
+	f.StringVarP(&v.extract, "extract", "x", "", "File to write the signed XML document to")
+
+	return cmd
+}
+
+func (v *verifyOpts) runE(cmd *cobra.Command, args []string) error {
+	input, err := openInput(cmd, args)
+	if err != nil {
+		return err
+	}
+	defer input.Close() // nolint:errcheck
+
+	data, err := io.ReadAll(input)
+	if err != nil {
+		return fmt.Errorf("reading input: %w", err)
+	}
+
+	var opts []fatturapa.VerifyOption
+	if v.trust != "" {
+		pool, err := fatturapa.LoadTrustStore(v.trust)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, fatturapa.WithTrustStore(pool))
+	}
+
+	res, err := fatturapa.Verify(data, opts...)
+	if err != nil {
+		return fmt.Errorf("invalid signature: %w", err)
+	}
+
+	out := cmd.OutOrStdout()
+	cert := res.Certificate
+	fmt.Fprintf(out, "Format:       %s\n", res.Format)
+	fmt.Fprintf(out, "Signer:       %s\n", cert.Subject)
+	fmt.Fprintf(out, "Issuer:       %s\n", cert.Issuer)
+	fmt.Fprintf(out, "Serial:       %s\n", cert.SerialNumber)
+	fmt.Fprintf(out, "Validity:     %s - %s\n", cert.NotBefore.Format(time.RFC3339), cert.NotAfter.Format(time.RFC3339))
+	if !res.SigningTime.IsZero() {
+		fmt.Fprintf(out, "Signing time: %s\n", res.SigningTime.Format(time.RFC3339))
+	}
+	if ts := res.Timestamp; ts != nil {
+		fmt.Fprintf(out, "Timestamp:    %s (%s)\n", ts.Time.Format(time.RFC3339), ts.Certificate.Subject)
+	}
+	if res.Trusted {
+		fmt.Fprintln(out, "Trusted:      yes")
+	} else {
+		fmt.Fprintf(out, "Trusted:      no (%s)\n", res.TrustError)
+	}
+
+	if v.extract != "" {
+		if err := os.WriteFile(v.extract, res.Data, 0644); err != nil {
+			return fmt.Errorf("writing signed document: %w", err)
+		}
+	}
+
+	if v.trust != "" && !res.Trusted {
+		return fmt.Errorf("certificate not trusted: %w", res.TrustError)
+	}
+
+	return nil
+}
@@ -24,9 +24,18 @@ type Transmitter struct {
 
 // Config contains the configuration for the Converter
 type Config struct {
-	Certificate   *xmldsig.Certificate
-	WithTimestamp bool
-	Transmitter   *Transmitter
+	Certificate         *xmldsig.Certificate
+	Signer              Signer
+	WithTimestamp       bool
+	Timestamps          TimestampProvider
+	SignatureFormat     SignatureFormat
+	Transmitter         *Transmitter
+	Attachments         []*Attachment
+	CompressAttachments bool
+	Sequence            SequenceProvider
+	Checks              bool
+	Registry            InvoiceRegistry
+	ManagementData      []*ManagementDataRule
 }
 
 // Option is a function that can be passed to NewConverter to configure it
@@ -46,13 +55,98 @@ func WithCertificate(cert *xmldsig.Certificate) Option {
 	}
 }
 
-// WithTimestamp will ensure the XML document is timestamped
+// WithSigner will ensure the XML document is signed by the given signer, for
+// keys that are not available to the application, instead of a certificate
+func WithSigner(s Signer) Option {
+	return func(c *Converter) {
+		c.Config.Signer = s
+	}
+}
+
+// WithTimestamp will ensure the XML document is timestamped. Unless another
+// provider is configured, the free FreeTSA service is used, which is only
+// suitable for testing.
 func WithTimestamp() Option {
 	return func(c *Converter) {
 		c.Config.WithTimestamp = true
 	}
 }
 
+// WithTimestampProvider will ensure the XML document is timestamped using
+// the given provider
+func WithTimestampProvider(p TimestampProvider) Option {
+	return func(c *Converter) {
+		c.Config.WithTimestamp = true
+		c.Config.Timestamps = p
+	}
+}
+
+// WithTSA will ensure the XML document is timestamped by the timestamping
+// authority at the given URL, authenticating with the credentials if provided
+func WithTSA(url, username, password string) Option {
+	return WithTimestampProvider(&TSAClient{
+		URL:      url,
+		Username: username,
+		Password: password,
+	})
+}
+
+// WithSignatureFormat will sign the document using the given format, either
+// an enveloped XAdES signature (default) or a CAdES .p7m envelope
+func WithSignatureFormat(f SignatureFormat) Option {
+	return func(c *Converter) {
+		c.Config.SignatureFormat = f
+	}
+}
+
+// WithAttachments will embed the given files in the XML document. Documents
+// containing a lot of invoices only include them in the first invoice.
+func WithAttachments(attachments ...*Attachment) Option {
+	return func(c *Converter) {
+		c.Config.Attachments = append(c.Config.Attachments, attachments...)
+	}
+}
+
+// WithAttachmentCompression will ensure attachments are compressed using ZIP
+// to reduce the size of the XML document
+func WithAttachmentCompression() Option {
+	return func(c *Converter) {
+		c.Config.CompressAttachments = true
+	}
+}
+
+// WithSequenceProvider will use the given provider to assign the progressive
+// of each file sent to the SDI, instead of deriving it from the envelope
+func WithSequenceProvider(p SequenceProvider) Option {
+	return func(c *Converter) {
+		c.Config.Sequence = p
+	}
+}
+
+// WithPreflightChecks will run the semantic checks applied by the SDI on each
+// document before it is signed, returning the Findings as an error
+func WithPreflightChecks() Option {
+	return func(c *Converter) {
+		c.Config.Checks = true
+	}
+}
+
+// WithInvoiceRegistry will use the given registry to detect invoices already
+// sent to the SDI when running the preflight checks
+func WithInvoiceRegistry(r InvoiceRegistry) Option {
+	return func(c *Converter) {
+		c.Config.Registry = r
+	}
+}
+
+// WithManagementData will add the AltriDatiGestionali blocks defined by the
+// given rules to the lines of the XML document, and read them back into GOBL
+func WithManagementData(rules ...*ManagementDataRule) Option {
+	return func(c *Converter) {
+		c.Config.ManagementData = append(c.Config.ManagementData, rules...)
+	}
+}
+
 // NewConverter returns a new GOBL to XML Converter with the given options
 func NewConverter(opts ...Option) *Converter {
 	c := new(Converter)
@@ -1,14 +1,17 @@
-// Package fatturapa implements the conversion from GOBL to FatturaPA XML.
+// Package fatturapa implements the conversion between GOBL and FatturaPA XML.
 package fatturapa
 
 import (
 	"bytes"
+	"context"
 	"encoding/xml"
 	"errors"
 	"fmt"
+	"io"
 
 	"github.com/invopop/gobl"
 	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/tax"
 	"github.com/invopop/xmldsig"
 )
 
@@ -26,7 +29,9 @@ const (
 
 // Document is a pseudo-model for containing the XML document being created.
 type Document struct {
-	env *gobl.Envelope `xml:"-"` // Envelope to convert.
+	env      *gobl.Envelope `xml:"-"` // Envelope to convert.
+	fileName string         `xml:"-"` // Name of the file without extension.
+	p7m      []byte         `xml:"-"` // CAdES envelope, when signed in that format.
 
 	XMLName        xml.Name `xml:"p:FatturaElettronica"`
 	FPANamespace   string   `xml:"xmlns:p,attr"`
@@ -44,48 +49,226 @@ type Document struct {
 // ConvertFromGOBL expects the base envelope and provides a new Document
 // containing the XML version.
 func (c *Converter) ConvertFromGOBL(env *gobl.Envelope) (*Document, error) {
-	invoice, ok := env.Extract().(*bill.Invoice)
-	if !ok {
-		return nil, errors.New("expected an invoice")
+	return c.ConvertLotFromGOBLContext(context.Background(), env)
+}
+
+// ConvertFromGOBLContext is like ConvertFromGOBL, using the context provided
+// for the requests made while signing, such as timestamps.
+func (c *Converter) ConvertFromGOBLContext(ctx context.Context, env *gobl.Envelope) (*Document, error) {
+	return c.ConvertLotFromGOBLContext(ctx, env)
+}
+
+// ConvertLotFromGOBL expects one or more envelopes and provides a new
+// Document containing a lot of invoices (lotto di fatture), with a body for
+// each envelope. All the invoices must share the same supplier, customer and
+// transmission details, which are taken from the first envelope. The
+// resulting document is signed only once.
+func (c *Converter) ConvertLotFromGOBL(envs ...*gobl.Envelope) (*Document, error) {
+	return c.ConvertLotFromGOBLContext(context.Background(), envs...)
+}
+
+// ConvertLotFromGOBLContext is like ConvertLotFromGOBL, using the context
+// provided for the requests made while signing, such as timestamps.
+func (c *Converter) ConvertLotFromGOBLContext(ctx context.Context, envs ...*gobl.Envelope) (*Document, error) {
+	if len(envs) == 0 {
+		return nil, errors.New("expected at least one envelope")
 	}
 
-	// Make sure we're dealing with raw data
+	var d *Document
+	for i, env := range envs {
+		invoice, ok := env.Extract().(*bill.Invoice)
+		if !ok {
+			return nil, errors.New("expected an invoice")
+		}
+
+		// Make sure we're dealing with raw data
+		var err error
+		invoice, err = invoice.RemoveIncludedTaxes()
+		if err != nil {
+			return nil, err
+		}
+
+		datiTrasmissione := c.newDatiTrasmissione(invoice)
+
+		header := newFatturaElettronicaHeader(invoice, datiTrasmissione)
+
+		body, err := newFatturaElettronicaBody(invoice)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := addAltriDatiGestionali(body, invoice, c.Config.ManagementData); err != nil {
+			return nil, err
+		}
+
+		// Attachments are only included once, in the first invoice
+		if i == 0 {
+			if body.Allegati, err = newAllegati(c.Config); err != nil {
+				return nil, err
+			}
+		}
+
+		if d == nil {
+			// Basic document headers
+			d = &Document{
+				env:                      env,
+				FPANamespace:             namespaceFatturaPA,
+				DSigNamespace:            namespaceDSig,
+				XSINamespace:             namespaceXSI,
+				Versione:                 formatoTransmissione(invoice.Customer),
+				SchemaLocation:           schemaLocation,
+				FatturaElettronicaHeader: header,
+			}
+		} else if err := checkLotHeader(d, header, formatoTransmissione(invoice.Customer)); err != nil {
+			return nil, fmt.Errorf("invoice %d: %w", i+1, err)
+		}
+
+		d.FatturaElettronicaBody = append(d.FatturaElettronicaBody, body)
+	}
+
+	if len(c.Config.Attachments) > 0 {
+		if err := checkFileSize(d); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.Config.Checks {
+		if findings := d.check(c.Config.Registry); len(findings) > 0 {
+			return nil, findings
+		}
+	}
+
+	// Progressives are only issued for documents passing the checks, so
+	// that no gaps are left in the sequence.
+	h := d.FatturaElettronicaHeader
 	var err error
-	invoice, err = invoice.RemoveIncludedTaxes()
+	d.fileName, err = c.progressivo(d.env.Head.UUID.String(), h.DatiTrasmissione, h.CedentePrestatore.DatiAnagrafici.IdFiscaleIVA)
 	if err != nil {
 		return nil, err
 	}
 
-	datiTrasmissione := c.newDatiTrasmissione(invoice, env)
+	if c.Config.signs() {
+		if err := d.sign(ctx, c.Config); err != nil {
+			return nil, err
+		}
+	}
 
-	header := newFatturaElettronicaHeader(invoice, datiTrasmissione)
+	return d, nil
+}
+
+// output is implemented by the documents provided by the Converter
+type output interface {
+	Bytes() ([]byte, error)
+	P7M() ([]byte, error)
+	FileName() string
+}
+
+// ConvertEnvelope converts the invoice of the envelope to the format it
+// requires, simplified (FSM10) for invoices with the simplified tag or
+// ordinary otherwise, and provides the data of the document, as a .p7m when
+// using the CAdES signature format, along with the name of its file.
+func (c *Converter) ConvertEnvelope(env *gobl.Envelope) ([]byte, string, error) {
+	return c.ConvertEnvelopeContext(context.Background(), env)
+}
+
+// ConvertEnvelopeContext is like ConvertEnvelope, using the context provided
+// for the requests made while signing, such as timestamps.
+func (c *Converter) ConvertEnvelopeContext(ctx context.Context, env *gobl.Envelope) ([]byte, string, error) {
+	inv, ok := env.Extract().(*bill.Invoice)
+	if !ok {
+		return nil, "", errors.New("expected an invoice")
+	}
+
+	var doc output
+	var err error
+	if inv.Tax != nil && inv.Tax.ContainsTag(tax.TagSimplified) {
+		doc, err = c.ConvertSimplifiedFromGOBLContext(ctx, env)
+	} else {
+		doc, err = c.ConvertFromGOBLContext(ctx, env)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	var data []byte
+	if c.Config.SignatureFormat == CAdES {
+		data, err = doc.P7M()
+	} else {
+		data, err = doc.Bytes()
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("generating fatturapa xml: %w", err)
+	}
+
+	return data, doc.FileName(), nil
+}
 
-	body, err := newFatturaElettronicaBody(invoice)
+// ConvertToGOBL expects a FatturaPA XML document and provides a new GOBL
+// envelope containing the invoice it describes. Only documents containing
+// a single invoice body are supported.
+func (c *Converter) ConvertToGOBL(r io.Reader) (*gobl.Envelope, error) {
+	d := new(Document)
+	if err := xml.NewDecoder(r).Decode(d); err != nil {
+		return nil, fmt.Errorf("parsing xml: %w", err)
+	}
+
+	if d.FatturaElettronicaHeader == nil {
+		return nil, errors.New("missing FatturaElettronicaHeader")
+	}
+	if len(d.FatturaElettronicaBody) != 1 {
+		return nil, fmt.Errorf("expected a single FatturaElettronicaBody, found %d", len(d.FatturaElettronicaBody))
+	}
+
+	inv, err := goblInvoice(d, d.FatturaElettronicaBody[0])
 	if err != nil {
 		return nil, err
 	}
+	if err := goblManagementData(inv, d.FatturaElettronicaBody[0].DatiBeniServizi, c.Config.ManagementData); err != nil {
+		return nil, err
+	}
 
-	// Basic document headers
-	d := &Document{
-		env:                      env,
-		FPANamespace:             namespaceFatturaPA,
-		DSigNamespace:            namespaceDSig,
-		XSINamespace:             namespaceXSI,
-		Versione:                 formatoTransmissione(invoice.Customer),
-		SchemaLocation:           schemaLocation,
-		FatturaElettronicaHeader: header,
-		FatturaElettronicaBody:   []*fatturaElettronicaBody{body},
+	env, err := gobl.Envelop(inv)
+	if err != nil {
+		return nil, fmt.Errorf("building envelope: %w", err)
 	}
 
-	if c.Config.Certificate != nil {
-		err = d.sign(c.Config)
+	return env, nil
+}
 
-		if err != nil {
-			return nil, err
-		}
+// UnmarshalXML allows a FatturaPA XML document to be parsed into the Document
+// structure. Namespaces are reset to those used when generating documents and
+// any signature present is ignored.
+func (d *Document) UnmarshalXML(dec *xml.Decoder, start xml.StartElement) error {
+	in := new(struct {
+		Versione                 string `xml:"versione,attr"`
+		FatturaElettronicaHeader *fatturaElettronicaHeader
+		FatturaElettronicaBody   []*fatturaElettronicaBody
+	})
+	if err := dec.DecodeElement(in, &start); err != nil {
+		return err
 	}
 
-	return d, nil
+	d.FPANamespace = namespaceFatturaPA
+	d.DSigNamespace = namespaceDSig
+	d.XSINamespace = namespaceXSI
+	d.SchemaLocation = schemaLocation
+	d.Versione = in.Versione
+	d.FatturaElettronicaHeader = in.FatturaElettronicaHeader
+	d.FatturaElettronicaBody = in.FatturaElettronicaBody
+
+	return nil
+}
+
+// FileName provides the name the file must have when sent to the SDI, made up
+// of the sender's tax ID and the progressive of the file, e.g.
+// "IT01234567890_0000A.xml", or "IT01234567890_0000A.xml.p7m" when signed
+// with CAdES. Names are only guaranteed to be unique when a SequenceProvider
+// is used.
+func (d *Document) FileName() string {
+	if d.p7m != nil {
+		return d.fileName + ".xml.p7m"
+	}
+	return d.fileName + ".xml"
 }
 
 // Buffer returns a byte buffer representation of the complete XML document.
@@ -112,9 +295,13 @@ func (d *Document) Bytes() ([]byte, error) {
 }
 
 func (d *Document) buffer(base string) (*bytes.Buffer, error) {
+	return marshalDocument(d, base)
+}
+
+func marshalDocument(doc any, base string) (*bytes.Buffer, error) {
 	buf := bytes.NewBufferString(base)
 	// data, err := xml.MarshalIndent(d, "", "  ") // not compatible with certificates
-	data, err := xml.Marshal(d)
+	data, err := xml.Marshal(doc)
 	if err != nil {
 		return nil, fmt.Errorf("marshal document: %w", err)
 	}
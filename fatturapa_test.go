@@ -0,0 +1,169 @@
+package fatturapa_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/invopop/gobl"
+	fatturapa "github.com/invopop/gobl.fatturapa"
+	"github.com/invopop/gobl.fatturapa/test"
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cbc"
+	"github.com/invopop/gobl/pay"
+	"github.com/invopop/gobl/regimes/it"
+	"github.com/invopop/gobl/tax"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConvertToGOBL(t *testing.T) {
+	t.Run("should round trip a simple invoice", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simple.json")
+		orig := env.Extract().(*bill.Invoice)
+
+		inv := convertToGOBL(t, test.NewConverter(), env)
+
+		assert.Equal(t, bill.InvoiceTypeStandard, inv.Type)
+		assert.Equal(t, orig.IssueDate, inv.IssueDate)
+		assert.Equal(t, orig.Currency, inv.Currency)
+		assert.Equal(t, orig.Supplier.Name, inv.Supplier.Name)
+		assert.Equal(t, orig.Supplier.TaxID.Code, inv.Supplier.TaxID.Code)
+		assert.Equal(t, orig.Customer.TaxID.Code, inv.Customer.TaxID.Code)
+		require.Len(t, inv.Lines, len(orig.Lines))
+		assert.Equal(t, orig.Lines[0].Item.Name, inv.Lines[0].Item.Name)
+		assert.Equal(t, orig.Totals.Payable.String(), inv.Totals.Payable.String())
+		assert.Equal(t, orig.Totals.Tax.String(), inv.Totals.Tax.String())
+	})
+
+	t.Run("should round trip retained taxes", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-irpef.json")
+		orig := env.Extract().(*bill.Invoice)
+
+		inv := convertToGOBL(t, test.NewConverter(), env)
+
+		require.Len(t, inv.Lines, 2)
+		irpef := inv.Lines[0].Taxes.Get(it.TaxCategoryIRPEF)
+		require.NotNil(t, irpef)
+		assert.Equal(t, "20.0%", irpef.Percent.String())
+		assert.Equal(t, tax.ExtValue("A"), irpef.Ext[it.ExtKeySDIRetainedTax])
+
+		irpef = inv.Lines[1].Taxes.Get(it.TaxCategoryIRPEF)
+		require.NotNil(t, irpef)
+		assert.Equal(t, "50.0%", irpef.Percent.String())
+		assert.Equal(t, tax.ExtValue("J"), irpef.Ext[it.ExtKeySDIRetainedTax])
+
+		vat := inv.Lines[1].Taxes.Get(tax.CategoryVAT)
+		require.NotNil(t, vat)
+		assert.Equal(t, tax.RateExempt, vat.Rate)
+		assert.Equal(t, tax.ExtValue("N2.2"), vat.Ext[it.ExtKeySDINature])
+
+		assert.Equal(t, orig.Totals.Payable.String(), inv.Totals.Payable.String())
+	})
+
+	t.Run("should parse an external document", func(t *testing.T) {
+		data := test.LoadExampleFile("bare-minimum.xml")
+
+		env, err := test.NewConverter().ConvertToGOBL(bytes.NewReader(data))
+		require.NoError(t, err)
+
+		inv, ok := env.Extract().(*bill.Invoice)
+		require.True(t, ok)
+
+		assert.Equal(t, "123", inv.Code)
+		assert.Equal(t, "2017-01-18", inv.IssueDate.String())
+		assert.Equal(t, "ALPHA SRL", inv.Supplier.Name)
+		assert.Equal(t, tax.ExtValue("RF19"), inv.Supplier.Ext[it.ExtKeySDIFiscalRegime])
+		assert.Equal(t, it.TaxIdentityTypeGovernment, inv.Customer.TaxID.Type)
+		assert.Equal(t, "AAAAAA", inv.Customer.Inboxes[0].Code)
+		require.Len(t, inv.Notes, 2)
+		assert.Equal(t, cbc.NoteKeyReason, inv.Notes[0].Key)
+		assert.Equal(t, "6.10", inv.Totals.Payable.String())
+
+		require.NotNil(t, inv.Payment)
+		assert.Equal(t, pay.MeansKeyCash, inv.Payment.Instructions.Key)
+		assert.Equal(t, pay.TermKeyDueDate, inv.Payment.Terms.Key)
+		require.Len(t, inv.Payment.Terms.DueDates, 1)
+		assert.Equal(t, "2017-02-18", inv.Payment.Terms.DueDates[0].Date.String())
+	})
+
+	t.Run("should reject documents with unsupported TipoDocumento", func(t *testing.T) {
+		data := bytes.Replace(test.LoadExampleFile("bare-minimum.xml"), []byte("TD01"), []byte("TD99"), 1)
+
+		_, err := test.NewConverter().ConvertToGOBL(bytes.NewReader(data))
+		assert.ErrorContains(t, err, "TipoDocumento 'TD99' not supported")
+	})
+}
+
+func convertToGOBL(t *testing.T, c *fatturapa.Converter, env *gobl.Envelope) *bill.Invoice {
+	t.Helper()
+
+	doc, err := test.ConvertFromGOBL(env, c)
+	require.NoError(t, err)
+
+	data, err := doc.Bytes()
+	require.NoError(t, err)
+
+	out, err := c.ConvertToGOBL(bytes.NewReader(data))
+	require.NoError(t, err)
+
+	inv, ok := out.Extract().(*bill.Invoice)
+	require.True(t, ok)
+
+	return inv
+}
+
+func TestConvertLotFromGOBL(t *testing.T) {
+	t.Run("should include a body for each invoice", func(t *testing.T) {
+		env1 := test.LoadTestFile("invoice-simple.json")
+		env2 := test.LoadTestFile("invoice-simple.json")
+		test.ModifyInvoice(env2, func(inv *bill.Invoice) {
+			inv.Code = "002"
+		})
+
+		doc, err := test.NewConverter().ConvertLotFromGOBL(env1, env2)
+		require.NoError(t, err)
+
+		require.Len(t, doc.FatturaElettronicaBody, 2)
+		assert.Equal(t, "SAMPLE-001", doc.FatturaElettronicaBody[0].DatiGenerali.DatiGeneraliDocumento.Numero)
+		assert.Equal(t, "SAMPLE-002", doc.FatturaElettronicaBody[1].DatiGenerali.DatiGeneraliDocumento.Numero)
+		assert.NotNil(t, doc.Signature)
+	})
+
+	t.Run("should fail when customers differ", func(t *testing.T) {
+		env1 := test.LoadTestFile("invoice-simple.json")
+		env2 := test.LoadTestFile("invoice-simple.json")
+		test.ModifyInvoice(env2, func(inv *bill.Invoice) {
+			inv.Customer.Name = "Another Customer"
+		})
+
+		_, err := test.NewConverter().ConvertLotFromGOBL(env1, env2)
+		assert.EqualError(t, err, "invoice 2: customer does not match")
+	})
+
+	t.Run("should fail when suppliers differ", func(t *testing.T) {
+		env1 := test.LoadTestFile("invoice-simple.json")
+		env2 := test.LoadTestFile("invoice-simple.json")
+		test.ModifyInvoice(env2, func(inv *bill.Invoice) {
+			inv.Supplier.Name = "Another Supplier"
+		})
+
+		_, err := test.NewConverter().ConvertLotFromGOBL(env1, env2)
+		assert.EqualError(t, err, "invoice 2: supplier does not match")
+	})
+
+	t.Run("should fail when transmission formats differ", func(t *testing.T) {
+		env1 := test.LoadTestFile("invoice-simple.json")
+		env2 := test.LoadTestFile("invoice-simple.json")
+		test.ModifyInvoice(env2, func(inv *bill.Invoice) {
+			inv.Customer.TaxID.Type = it.TaxIdentityTypeGovernment
+		})
+
+		_, err := test.NewConverter().ConvertLotFromGOBL(env1, env2)
+		assert.EqualError(t, err, "invoice 2: transmission format FPA12 does not match FPR12")
+	})
+
+	t.Run("should fail without envelopes", func(t *testing.T) {
+		_, err := test.NewConverter().ConvertLotFromGOBL()
+		assert.EqualError(t, err, "expected at least one envelope")
+	})
+}
@@ -1,6 +1,10 @@
 package fatturapa
 
 import (
+	"errors"
+	"fmt"
+	"reflect"
+
 	"github.com/invopop/gobl/bill"
 )
 
@@ -22,3 +26,30 @@ func newFatturaElettronicaHeader(inv *bill.Invoice, datiTrasmissione *datiTrasmi
 		CessionarioCommittente: customer,
 	}
 }
+
+// checkLotHeader ensures that the header of an additional invoice is
+// compatible with the document's, as a lot of invoices may only contain
+// invoices between the same parties and sent in the same way.
+func checkLotHeader(d *Document, header *fatturaElettronicaHeader, versione string) error {
+	if d.Versione != versione {
+		return fmt.Errorf("transmission format %s does not match %s", versione, d.Versione)
+	}
+
+	h := d.FatturaElettronicaHeader
+	if !reflect.DeepEqual(h.CedentePrestatore, header.CedentePrestatore) {
+		return errors.New("supplier does not match")
+	}
+	if !reflect.DeepEqual(h.CessionarioCommittente, header.CessionarioCommittente) {
+		return errors.New("customer does not match")
+	}
+
+	dt, ndt := h.DatiTrasmissione, header.DatiTrasmissione
+	if dt.FormatoTrasmissione != ndt.FormatoTrasmissione {
+		return fmt.Errorf("transmission format %s does not match %s", ndt.FormatoTrasmissione, dt.FormatoTrasmissione)
+	}
+	if dt.CodiceDestinatario != ndt.CodiceDestinatario || dt.PECDestinatario != ndt.PECDestinatario {
+		return errors.New("recipient code or PEC address does not match")
+	}
+
+	return nil
+}
@@ -1,6 +1,14 @@
 package fatturapa
 
-import "github.com/invopop/gobl/num"
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/invopop/gobl/cal"
+	"github.com/invopop/gobl/cbc"
+	"github.com/invopop/gobl/num"
+)
 
 func formatPercentage(p *num.Percentage) string {
 	if p == nil {
@@ -16,3 +24,60 @@ func formatAmount(a *num.Amount) string {
 	}
 	return a.RescaleUp(2).String()
 }
+
+func parseAmount(s string) (num.Amount, error) {
+	a, err := num.AmountFromString(s)
+	if err != nil {
+		return num.Amount{}, fmt.Errorf("parsing amount '%s': %w", s, err)
+	}
+	return a, nil
+}
+
+// parsePercentage expects a percentage without symbol as used in FatturaPA,
+// i.e. "22.00" for 22%. Trailing zeros are removed so that the result matches
+// the precision usually found in GOBL documents, i.e. "22.0%".
+func parsePercentage(s string) (*num.Percentage, error) {
+	v := s
+	if strings.Contains(v, ".") {
+		v = strings.TrimRight(v, "0")
+		if strings.HasSuffix(v, ".") {
+			v += "0"
+		}
+	}
+	p, err := num.PercentageFromString(v + "%")
+	if err != nil {
+		return nil, fmt.Errorf("parsing percentage '%s': %w", s, err)
+	}
+	return &p, nil
+}
+
+// formatMetaDate provides the date in the meta data, if any, ensuring it is
+// valid.
+func formatMetaDate(meta cbc.Meta, key cbc.Key) (string, error) {
+	date, err := parseMetaDate(meta, key)
+	if err != nil || date == nil {
+		return "", err
+	}
+	return date.String(), nil
+}
+
+// parseMetaDate provides the date of the meta data key, if any
+func parseMetaDate(meta cbc.Meta, key cbc.Key) (*cal.Date, error) {
+	v := strings.TrimSpace(meta[key])
+	if v == "" {
+		return nil, nil
+	}
+	date, err := parseDate(v)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", key, err)
+	}
+	return &date, nil
+}
+
+func parseDate(s string) (cal.Date, error) {
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return cal.Date{}, fmt.Errorf("parsing date '%s': %w", s, err)
+	}
+	return cal.DateOf(t), nil
+}
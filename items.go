@@ -1,14 +1,66 @@
 package fatturapa
 
 import (
+	"errors"
+	"fmt"
 	"strconv"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cbc"
 	"github.com/invopop/gobl/i18n"
+	"github.com/invopop/gobl/num"
+	"github.com/invopop/gobl/org"
 	"github.com/invopop/gobl/regimes/it"
 	"github.com/invopop/gobl/tax"
 )
 
+// ritenutaYes is used to flag lines subject to retained taxes
+const ritenutaYes = "SI"
+
+// Keys of the item meta data with the period the line refers to, and the
+// units of measure not supported by GOBL, i.e. "ore".
+const (
+	MetaKeyPeriodStart cbc.Key = "period-start"
+	MetaKeyPeriodEnd   cbc.Key = "period-end"
+	MetaKeyUnit        cbc.Key = "unit"
+)
+
+// Formats of the item meta data keys with the values and types of the codes
+// that are not valid identity codes, numbered from 1, i.e. "code-1" and
+// "code-1-type".
+const (
+	MetaKeyCodeFormat     = "code-%d"
+	MetaKeyCodeTypeFormat = "code-%d-type"
+)
+
+// Maximum lengths of the fields of the lines
+const (
+	maxDescrizioneLength    = 1000
+	maxUnitaMisuraLength    = 10
+	maxCodiceArticoloLength = 35 // of both CodiceTipo and CodiceValore
+)
+
+// Keys of the items used for lines that are not sales of goods or services,
+// which are reported with their TipoCessionePrestazione.
+const (
+	ItemKeyDiscount  cbc.Key = "discount"  // SC - sconto
+	ItemKeyPremium   cbc.Key = "premium"   // PR - premio
+	ItemKeyRebate    cbc.Key = "rebate"    // AB - abbuono
+	ItemKeyAncillary cbc.Key = "ancillary" // AC - spesa accessoria
+)
+
+var tipoCessionePrestazioneKeys = map[string]cbc.Key{
+	"SC": ItemKeyDiscount,
+	"PR": ItemKeyPremium,
+	"AB": ItemKeyRebate,
+	"AC": ItemKeyAncillary,
+}
+
+// codiceTipoRef is the CodiceTipo of the item's own reference
+const codiceTipoRef = "INTERNO"
+
 // datiBeniServizi contains all data related to the goods and services sold.
 type datiBeniServizi struct {
 	DettaglioLinee []*dettaglioLinee
@@ -17,14 +69,27 @@ type datiBeniServizi struct {
 
 // dettaglioLinee contains line data such as description, quantity, price, etc.
 type dettaglioLinee struct {
-	NumeroLinea         string
-	Descrizione         string
-	Quantita            string
-	PrezzoUnitario      string
-	ScontoMaggiorazione []*scontoMaggiorazione `xml:",omitempty"`
-	PrezzoTotale        string
-	AliquotaIVA         string
-	Natura              string `xml:",omitempty"`
+	NumeroLinea             string
+	TipoCessionePrestazione string            `xml:",omitempty"`
+	CodiceArticolo          []*codiceArticolo `xml:",omitempty"`
+	Descrizione             string
+	Quantita                string
+	UnitaMisura             string `xml:",omitempty"`
+	DataInizioPeriodo       string `xml:",omitempty"`
+	DataFinePeriodo         string `xml:",omitempty"`
+	PrezzoUnitario          string
+	ScontoMaggiorazione     []*scontoMaggiorazione `xml:",omitempty"`
+	PrezzoTotale            string
+	AliquotaIVA             string
+	Ritenuta                string                 `xml:",omitempty"`
+	Natura                  string                 `xml:",omitempty"`
+	AltriDatiGestionali     []*altriDatiGestionali `xml:",omitempty"`
+}
+
+// codiceArticolo contains a code identifying the item, i.e. EAN, TARIC, etc.
+type codiceArticolo struct {
+	CodiceTipo   string
+	CodiceValore string
 }
 
 // datiRiepilogo contains tax summary data such as tax rate, tax amount, etc.
@@ -36,24 +101,41 @@ type datiRiepilogo struct {
 	RiferimentoNormativo string `xml:",omitempty"`
 }
 
-func newDatiBeniServizi(inv *bill.Invoice) *datiBeniServizi {
+func newDatiBeniServizi(inv *bill.Invoice) (*datiBeniServizi, error) {
+	dl, err := generateLineDetails(inv)
+	if err != nil {
+		return nil, err
+	}
 	return &datiBeniServizi{
-		DettaglioLinee: generateLineDetails(inv),
+		DettaglioLinee: dl,
 		DatiRiepilogo:  generateTaxSummary(inv),
-	}
+	}, nil
 }
 
-func generateLineDetails(inv *bill.Invoice) []*dettaglioLinee {
+func generateLineDetails(inv *bill.Invoice) ([]*dettaglioLinee, error) {
 	var dl []*dettaglioLinee
 
 	for _, line := range inv.Lines {
 		d := &dettaglioLinee{
-			NumeroLinea:         strconv.Itoa(line.Index),
-			Descrizione:         line.Item.Name,
-			Quantita:            formatAmount(&line.Quantity),
-			PrezzoUnitario:      formatAmount(&line.Item.Price),
-			PrezzoTotale:        formatAmount(&line.Sum),
-			ScontoMaggiorazione: extractLinePriceAdjustments(line),
+			NumeroLinea:             strconv.Itoa(line.Index),
+			TipoCessionePrestazione: findTipoCessionePrestazione(line.Item.Key),
+			Quantita:                formatAmount(&line.Quantity),
+			PrezzoUnitario:          formatAmount(&line.Item.Price),
+			PrezzoTotale:            formatAmount(&line.Sum),
+			ScontoMaggiorazione:     extractLinePriceAdjustments(line),
+		}
+		var err error
+		if d.CodiceArticolo, err = newCodiceArticolo(line.Item); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line.Index, err)
+		}
+		if d.Descrizione, err = lineDescription(line.Item); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line.Index, err)
+		}
+		if d.UnitaMisura, err = lineUnit(line.Item); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line.Index, err)
+		}
+		if err = d.setPeriod(line.Item.Meta); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line.Index, err)
 		}
 		if line.Taxes != nil && len(line.Taxes) > 0 {
 			vatTax := line.Taxes.Get(tax.CategoryVAT)
@@ -61,12 +143,117 @@ func generateLineDetails(inv *bill.Invoice) []*dettaglioLinee {
 				d.AliquotaIVA = formatPercentage(vatTax.Percent)
 				d.Natura = vatTax.Ext[it.ExtKeySDINature].String()
 			}
+			if hasRetainedTaxes(line.Taxes) {
+				d.Ritenuta = ritenutaYes
+			}
 		}
 
 		dl = append(dl, d)
 	}
 
-	return dl
+	return dl, nil
+}
+
+// findTipoCessionePrestazione provides the type of line of items with the
+// keys of discounts, premiums, rebates and ancillary charges.
+func findTipoCessionePrestazione(key cbc.Key) string {
+	for code, k := range tipoCessionePrestazioneKeys {
+		if k == key {
+			return code
+		}
+	}
+	return ""
+}
+
+// newCodiceArticolo prepares the codes of the item, starting with its own
+// reference, followed by its identities, which use their type, label or key
+// as the CodiceTipo, and the codes kept in the meta data.
+func newCodiceArticolo(item *org.Item) ([]*codiceArticolo, error) {
+	var ca []*codiceArticolo
+	if item.Ref != "" {
+		ca = append(ca, &codiceArticolo{
+			CodiceTipo:   codiceTipoRef,
+			CodiceValore: item.Ref,
+		})
+	}
+	for _, id := range item.Identities {
+		tipo := id.Type.String()
+		if tipo == "" {
+			tipo = id.Label
+		}
+		if tipo == "" {
+			tipo = id.Key.String()
+		}
+		if tipo == "" {
+			tipo = codiceTipoRef
+		}
+		ca = append(ca, &codiceArticolo{
+			CodiceTipo:   tipo,
+			CodiceValore: id.Code.String(),
+		})
+	}
+	for n := 1; ; n++ {
+		v := item.Meta[cbc.Key(fmt.Sprintf(MetaKeyCodeFormat, n))]
+		if v == "" {
+			break
+		}
+		tipo := item.Meta[cbc.Key(fmt.Sprintf(MetaKeyCodeTypeFormat, n))]
+		if tipo == "" {
+			tipo = codiceTipoRef
+		}
+		ca = append(ca, &codiceArticolo{
+			CodiceTipo:   tipo,
+			CodiceValore: v,
+		})
+	}
+	for _, c := range ca {
+		if utf8.RuneCountInString(c.CodiceTipo) > maxCodiceArticoloLength {
+			return nil, fmt.Errorf("code type '%s' exceeds %d characters", c.CodiceTipo, maxCodiceArticoloLength)
+		}
+		if utf8.RuneCountInString(c.CodiceValore) > maxCodiceArticoloLength {
+			return nil, fmt.Errorf("code '%s' exceeds %d characters", c.CodiceValore, maxCodiceArticoloLength)
+		}
+	}
+	return ca, nil
+}
+
+// lineDescription provides the name of the item followed by its
+// description, if any, which must fit in Descrizione. Names that are too long
+// on their own are left to the validation of the document.
+func lineDescription(item *org.Item) (string, error) {
+	if item.Description == "" {
+		return item.Name, nil
+	}
+	d := item.Name + " - " + item.Description
+	if utf8.RuneCountInString(d) > maxDescrizioneLength {
+		return "", fmt.Errorf("name and description exceed %d characters", maxDescrizioneLength)
+	}
+	return d, nil
+}
+
+// lineUnit provides the unit of measure of the item, which must fit in
+// UnitaMisura.
+func lineUnit(item *org.Item) (string, error) {
+	u := item.Meta[MetaKeyUnit]
+	if item.Unit != org.UnitEmpty {
+		u = string(item.Unit)
+	}
+	if utf8.RuneCountInString(u) > maxUnitaMisuraLength {
+		return "", fmt.Errorf("unit '%s' exceeds %d characters", u, maxUnitaMisuraLength)
+	}
+	return u, nil
+}
+
+// setPeriod sets the period the line refers to from the item's meta data
+func (d *dettaglioLinee) setPeriod(meta cbc.Meta) error {
+	var err error
+	if d.DataInizioPeriodo, err = formatMetaDate(meta, MetaKeyPeriodStart); err != nil {
+		return err
+	}
+	if d.DataFinePeriodo, err = formatMetaDate(meta, MetaKeyPeriodEnd); err != nil {
+		return err
+	}
+	return nil
 }
 
 func generateTaxSummary(inv *bill.Invoice) []*datiRiepilogo {
@@ -127,3 +314,198 @@ func findRiferimentoNormativo(rateTotal *tax.RateTotal) string {
 
 	return ""
 }
+
+func hasRetainedTaxes(taxes tax.Set) bool {
+	for _, combo := range taxes {
+		if cat := regime.Category(combo.Category); cat != nil && cat.Retained {
+			return true
+		}
+	}
+	return false
+}
+
+func goblLines(dbs *datiBeniServizi, retained *retainedTaxAllocator) ([]*bill.Line, error) {
+	if dbs == nil {
+		return nil, errors.New("missing DatiBeniServizi")
+	}
+
+	var lines []*bill.Line
+	for _, dl := range dbs.DettaglioLinee {
+		line, err := goblLine(dl, retained)
+		if err != nil {
+			return nil, fmt.Errorf("line %s: %w", dl.NumeroLinea, err)
+		}
+		lines = append(lines, line)
+	}
+
+	return lines, nil
+}
+
+func goblLine(dl *dettaglioLinee, retained *retainedTaxAllocator) (*bill.Line, error) {
+	quantity := num.MakeAmount(1, 0)
+	if dl.Quantita != "" {
+		q, err := parseAmount(dl.Quantita)
+		if err != nil {
+			return nil, fmt.Errorf("Quantita: %w", err)
+		}
+		quantity = q
+	}
+
+	price, err := parseAmount(dl.PrezzoUnitario)
+	if err != nil {
+		return nil, fmt.Errorf("PrezzoUnitario: %w", err)
+	}
+
+	line := &bill.Line{
+		Quantity: quantity,
+		Item: &org.Item{
+			Name:  dl.Descrizione,
+			Price: price,
+		},
+	}
+	if dl.TipoCessionePrestazione != "" {
+		key, ok := tipoCessionePrestazioneKeys[dl.TipoCessionePrestazione]
+		if !ok {
+			return nil, fmt.Errorf("TipoCessionePrestazione '%s' not supported", dl.TipoCessionePrestazione)
+		}
+		line.Item.Key = key
+	}
+	goblItemCodes(line.Item, dl.CodiceArticolo)
+	goblItemUnit(line.Item, dl.UnitaMisura)
+	if err := goblItemPeriod(line.Item, dl); err != nil {
+		return nil, err
+	}
+
+	for _, sm := range dl.ScontoMaggiorazione {
+		percent, amount, err := parseScontoMaggiorazione(sm)
+		if err != nil {
+			return nil, err
+		}
+		switch sm.Tipo {
+		case scontoMaggiorazioneTypeDiscount:
+			line.Discounts = append(line.Discounts, &bill.LineDiscount{
+				Percent: percent,
+				Amount:  amount,
+			})
+		case scontoMaggiorazioneTypeCharge:
+			line.Charges = append(line.Charges, &bill.LineCharge{
+				Percent: percent,
+				Amount:  amount,
+			})
+		default:
+			return nil, fmt.Errorf("ScontoMaggiorazione Tipo '%s' not supported", sm.Tipo)
+		}
+	}
+
+	vat, err := goblVAT(dl.AliquotaIVA, dl.Natura)
+	if err != nil {
+		return nil, err
+	}
+	line.Taxes = tax.Set{vat}
+
+	if dl.Ritenuta == ritenutaYes {
+		total := quantity.Multiply(price)
+		for _, d := range line.Discounts {
+			total = total.Subtract(d.Amount)
+		}
+		for _, c := range line.Charges {
+			total = total.Add(c.Amount)
+		}
+		line.Taxes = append(line.Taxes, retained.combos(total)...)
+	}
+
+	return line, nil
+}
+
+// goblItemCodes reads back the item's reference and identities, with the
+// CodiceTipo as their type, key or label, whichever is valid. The first
+// INTERNO code is used as the reference, and codes that are not valid for
+// identities are kept in the meta data.
+func goblItemCodes(item *org.Item, codes []*codiceArticolo) {
+	n := 0
+	for _, ca := range codes {
+		if ca.CodiceTipo == codiceTipoRef && item.Ref == "" {
+			item.Ref = ca.CodiceValore
+			continue
+		}
+		code := cbc.Code(ca.CodiceValore)
+		if code.Validate() != nil {
+			n++
+			setItemMeta(item, cbc.Key(fmt.Sprintf(MetaKeyCodeFormat, n)), ca.CodiceValore)
+			setItemMeta(item, cbc.Key(fmt.Sprintf(MetaKeyCodeTypeFormat, n)), ca.CodiceTipo)
+			continue
+		}
+		id := &org.Identity{Code: code}
+		if tipo := cbc.Code(ca.CodiceTipo); tipo.Validate() == nil {
+			id.Type = tipo
+		} else if key := cbc.Key(ca.CodiceTipo); key.Validate() == nil {
+			id.Key = key
+		} else {
+			id.Label = ca.CodiceTipo
+		}
+		item.Identities = append(item.Identities, id)
+	}
+}
+
+// goblItemUnit reads back the unit of measure, which is kept in the item's
+// meta data when not supported by GOBL.
+func goblItemUnit(item *org.Item, unit string) {
+	if unit == "" {
+		return
+	}
+	for _, u := range []org.Unit{org.Unit(unit), org.Unit(strings.ToLower(unit))} {
+		if u.Validate() == nil {
+			item.Unit = u
+			return
+		}
+	}
+	setItemMeta(item, MetaKeyUnit, unit)
+}
+
+// goblItemPeriod reads back the period the line refers to
+func goblItemPeriod(item *org.Item, dl *dettaglioLinee) error {
+	if dl.DataInizioPeriodo != "" {
+		date, err := parseDate(dl.DataInizioPeriodo)
+		if err != nil {
+			return fmt.Errorf("DataInizioPeriodo: %w", err)
+		}
+		setItemMeta(item, MetaKeyPeriodStart, date.String())
+	}
+	if dl.DataFinePeriodo != "" {
+		date, err := parseDate(dl.DataFinePeriodo)
+		if err != nil {
+			return fmt.Errorf("DataFinePeriodo: %w", err)
+		}
+		setItemMeta(item, MetaKeyPeriodEnd, date.String())
+	}
+	return nil
+}
+
+func setItemMeta(item *org.Item, key cbc.Key, value string) {
+	if item.Meta == nil {
+		item.Meta = make(cbc.Meta)
+	}
+	item.Meta[key] = value
+}
+
+func goblVAT(aliquota, natura string) (*tax.Combo, error) {
+	combo := &tax.Combo{
+		Category: tax.CategoryVAT,
+	}
+
+	if natura != "" {
+		combo.Rate = tax.RateExempt
+		combo.Ext = tax.Extensions{
+			it.ExtKeySDINature: tax.ExtValue(natura),
+		}
+		return combo, nil
+	}
+
+	percent, err := parsePercentage(aliquota)
+	if err != nil {
+		return nil, fmt.Errorf("AliquotaIVA: %w", err)
+	}
+	combo.Percent = percent
+
+	return combo, nil
+}
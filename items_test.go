@@ -1,9 +1,15 @@
 package fatturapa_test
 
 import (
+	"bytes"
+	"strings"
 	"testing"
 
+	fatturapa "github.com/invopop/gobl.fatturapa"
 	"github.com/invopop/gobl.fatturapa/test"
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cbc"
+	"github.com/invopop/gobl/org"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -37,6 +43,210 @@ func TestDettaglioLinee(t *testing.T) {
 	})
 }
 
+func TestDettaglioLineeDetails(t *testing.T) {
+	details := func(inv *bill.Invoice) {
+		item := inv.Lines[0].Item
+		item.Ref = "DEV-001"
+		item.Identities = []*org.Identity{
+			{Type: "EAN", Code: "8001234567890"},
+			{Label: "Codice cliente", Code: "C-42"},
+		}
+		item.Description = "Backend development"
+		item.Meta = cbc.Meta{
+			fatturapa.MetaKeyPeriodStart: "2023-02-01",
+			fatturapa.MetaKeyPeriodEnd:   "2023-02-28",
+		}
+		inv.Lines[1].Item.Key = fatturapa.ItemKeyAncillary
+	}
+
+	t.Run("should contain the item details", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simple.json")
+		test.ModifyInvoice(env, details)
+		doc, err := test.ConvertFromGOBL(env)
+		require.NoError(t, err)
+
+		dl := doc.FatturaElettronicaBody[0].DatiBeniServizi.DettaglioLinee[0]
+		assert.Empty(t, dl.TipoCessionePrestazione)
+		require.Len(t, dl.CodiceArticolo, 3)
+		assert.Equal(t, "INTERNO", dl.CodiceArticolo[0].CodiceTipo)
+		assert.Equal(t, "DEV-001", dl.CodiceArticolo[0].CodiceValore)
+		assert.Equal(t, "EAN", dl.CodiceArticolo[1].CodiceTipo)
+		assert.Equal(t, "8001234567890", dl.CodiceArticolo[1].CodiceValore)
+		assert.Equal(t, "Codice cliente", dl.CodiceArticolo[2].CodiceTipo)
+		assert.Equal(t, "C-42", dl.CodiceArticolo[2].CodiceValore)
+		assert.Equal(t, "Development services - Backend development", dl.Descrizione)
+		assert.Equal(t, "h", dl.UnitaMisura)
+		assert.Equal(t, "2023-02-01", dl.DataInizioPeriodo)
+		assert.Equal(t, "2023-02-28", dl.DataFinePeriodo)
+
+		dl = doc.FatturaElettronicaBody[0].DatiBeniServizi.DettaglioLinee[1]
+		assert.Equal(t, "AC", dl.TipoCessionePrestazione)
+		assert.Empty(t, dl.CodiceArticolo)
+		assert.Empty(t, dl.DataInizioPeriodo)
+
+		assert.NoError(t, doc.Validate())
+	})
+
+	t.Run("should map every line type", func(t *testing.T) {
+		for code, key := range map[string]cbc.Key{
+			"SC": fatturapa.ItemKeyDiscount,
+			"PR": fatturapa.ItemKeyPremium,
+			"AB": fatturapa.ItemKeyRebate,
+			"AC": fatturapa.ItemKeyAncillary,
+		} {
+			env := test.LoadTestFile("invoice-simple.json")
+			test.ModifyInvoice(env, func(inv *bill.Invoice) {
+				inv.Lines[1].Item.Key = key
+			})
+			doc, err := test.ConvertFromGOBL(env)
+			require.NoError(t, err)
+			assert.Equal(t, code, doc.FatturaElettronicaBody[0].DatiBeniServizi.DettaglioLinee[1].TipoCessionePrestazione)
+		}
+	})
+
+	t.Run("should reject invalid periods", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simple.json")
+		test.ModifyInvoice(env, func(inv *bill.Invoice) {
+			inv.Lines[0].Item.Meta = cbc.Meta{fatturapa.MetaKeyPeriodStart: "February"}
+		})
+		_, err := test.ConvertFromGOBL(env)
+		assert.ErrorContains(t, err, "line 1: period-start: parsing date 'February'")
+	})
+
+	t.Run("should read back the item details", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simple.json")
+		test.ModifyInvoice(env, details)
+
+		c := test.NewConverter()
+		doc, err := test.ConvertFromGOBL(env, c)
+		require.NoError(t, err)
+		data, err := doc.Bytes()
+		require.NoError(t, err)
+		out, err := c.ConvertToGOBL(bytes.NewReader(data))
+		require.NoError(t, err)
+		inv := out.Extract().(*bill.Invoice)
+
+		item := inv.Lines[0].Item
+		assert.Equal(t, "DEV-001", item.Ref)
+		require.Len(t, item.Identities, 2)
+		assert.Equal(t, cbc.Code("EAN"), item.Identities[0].Type)
+		assert.Equal(t, cbc.Code("8001234567890"), item.Identities[0].Code)
+		assert.Equal(t, "Codice cliente", item.Identities[1].Label)
+		assert.Equal(t, cbc.Code("C-42"), item.Identities[1].Code)
+		assert.Equal(t, org.UnitHour, item.Unit)
+		assert.Equal(t, "2023-02-01", item.Meta[fatturapa.MetaKeyPeriodStart])
+		assert.Equal(t, "2023-02-28", item.Meta[fatturapa.MetaKeyPeriodEnd])
+		assert.Equal(t, fatturapa.ItemKeyAncillary, inv.Lines[1].Item.Key)
+	})
+
+	t.Run("should read back identity keys", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simple.json")
+		test.ModifyInvoice(env, func(inv *bill.Invoice) {
+			inv.Lines[0].Item.Identities = []*org.Identity{{Key: "gtin", Code: "8001234567890"}}
+		})
+
+		c := test.NewConverter()
+		doc, err := test.ConvertFromGOBL(env, c)
+		require.NoError(t, err)
+		data, err := doc.Bytes()
+		require.NoError(t, err)
+		out, err := c.ConvertToGOBL(bytes.NewReader(data))
+		require.NoError(t, err)
+
+		item := out.Extract().(*bill.Invoice).Lines[0].Item
+		require.Len(t, item.Identities, 1)
+		assert.Equal(t, cbc.Key("gtin"), item.Identities[0].Key)
+		assert.Empty(t, item.Identities[0].Label)
+	})
+
+	t.Run("should keep every code", func(t *testing.T) {
+		data := bytes.Replace(
+			test.LoadExampleFile("bare-minimum.xml"),
+			[]byte("<Descrizione>DESCRIZIONE DELLA FORNITURA</Descrizione>"),
+			[]byte("<CodiceArticolo><CodiceTipo>INTERNO</CodiceTipo><CodiceValore>A1</CodiceValore></CodiceArticolo>"+
+				"<CodiceArticolo><CodiceTipo>INTERNO</CodiceTipo><CodiceValore>B2</CodiceValore></CodiceArticolo>"+
+				"<CodiceArticolo><CodiceTipo>Cod. fornitore</CodiceTipo><CodiceValore>ab/12 3</CodiceValore></CodiceArticolo>"+
+				"<Descrizione>DESCRIZIONE DELLA FORNITURA</Descrizione>"),
+			1,
+		)
+		c := test.NewConverter()
+		env, err := c.ConvertToGOBL(bytes.NewReader(data))
+		require.NoError(t, err)
+
+		item := env.Extract().(*bill.Invoice).Lines[0].Item
+		assert.Equal(t, "A1", item.Ref)
+		require.Len(t, item.Identities, 1)
+		assert.Equal(t, cbc.Code("INTERNO"), item.Identities[0].Type)
+		assert.Equal(t, cbc.Code("B2"), item.Identities[0].Code)
+		assert.Equal(t, "ab/12 3", item.Meta["code-1"])
+		assert.Equal(t, "Cod. fornitore", item.Meta["code-1-type"])
+
+		doc, err := c.ConvertFromGOBL(env)
+		require.NoError(t, err)
+		dl := doc.FatturaElettronicaBody[0].DatiBeniServizi.DettaglioLinee[0]
+		require.Len(t, dl.CodiceArticolo, 3)
+		assert.Equal(t, "INTERNO", dl.CodiceArticolo[1].CodiceTipo)
+		assert.Equal(t, "B2", dl.CodiceArticolo[1].CodiceValore)
+		assert.Equal(t, "Cod. fornitore", dl.CodiceArticolo[2].CodiceTipo)
+		assert.Equal(t, "ab/12 3", dl.CodiceArticolo[2].CodiceValore)
+	})
+
+	t.Run("should reject descriptions that do not fit", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simple.json")
+		test.ModifyInvoice(env, func(inv *bill.Invoice) {
+			inv.Lines[0].Item.Description = strings.Repeat("x", 1000)
+		})
+		_, err := test.ConvertFromGOBL(env)
+		assert.EqualError(t, err, "line 1: name and description exceed 1000 characters")
+	})
+
+	t.Run("should reject codes that do not fit", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simple.json")
+		test.ModifyInvoice(env, func(inv *bill.Invoice) {
+			inv.Lines[0].Item.Meta = cbc.Meta{"code-1": strings.Repeat("1", 36)}
+		})
+		_, err := test.ConvertFromGOBL(env)
+		assert.EqualError(t, err, "line 1: code '"+strings.Repeat("1", 36)+"' exceeds 35 characters")
+
+		test.ModifyInvoice(env, func(inv *bill.Invoice) {
+			inv.Lines[0].Item.Meta = cbc.Meta{"code-1": "A1", "code-1-type": strings.Repeat("x", 36)}
+		})
+		_, err = test.ConvertFromGOBL(env)
+		assert.EqualError(t, err, "line 1: code type '"+strings.Repeat("x", 36)+"' exceeds 35 characters")
+	})
+
+	t.Run("should reject units that do not fit", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simple.json")
+		test.ModifyInvoice(env, func(inv *bill.Invoice) {
+			inv.Lines[0].Item.Unit = org.UnitEmpty
+			inv.Lines[0].Item.Meta = cbc.Meta{fatturapa.MetaKeyUnit: "ore lavorate"}
+		})
+		_, err := test.ConvertFromGOBL(env)
+		assert.EqualError(t, err, "line 1: unit 'ore lavorate' exceeds 10 characters")
+	})
+
+	t.Run("should keep units not supported by GOBL", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simple.json")
+		test.ModifyInvoice(env, func(inv *bill.Invoice) {
+			inv.Lines[0].Item.Unit = org.UnitEmpty
+			inv.Lines[0].Item.Meta = cbc.Meta{fatturapa.MetaKeyUnit: "ore"}
+		})
+
+		c := test.NewConverter()
+		doc, err := test.ConvertFromGOBL(env, c)
+		require.NoError(t, err)
+		assert.Equal(t, "ore", doc.FatturaElettronicaBody[0].DatiBeniServizi.DettaglioLinee[0].UnitaMisura)
+
+		data, err := doc.Bytes()
+		require.NoError(t, err)
+		out, err := c.ConvertToGOBL(bytes.NewReader(data))
+		require.NoError(t, err)
+		item := out.Extract().(*bill.Invoice).Lines[0].Item
+		assert.Equal(t, org.UnitEmpty, item.Unit)
+		assert.Equal(t, "ore", item.Meta[fatturapa.MetaKeyUnit])
+	})
+}
+
 func TestDatiRiepilogo(t *testing.T) {
 	t.Run("should contain the tax summary info", func(t *testing.T) {
 		env := test.LoadTestFile("invoice-simple.json")
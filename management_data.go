@@ -0,0 +1,247 @@
+package fatturapa
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cbc"
+	"github.com/invopop/gobl/org"
+	"github.com/invopop/gobl/tax"
+)
+
+// ManagementDataRule declares how to prepare an AltriDatiGestionali block
+// from the meta data or extensions of each line's item, or of the invoice,
+// in which case the block is included in every line. The same rules are
+// used to read back the values when converting to GOBL.
+type ManagementDataRule struct {
+	// Type is used for TipoDato, i.e. "INTENTO" for letters of intent
+	Type string `json:"type"`
+	// Invoice takes the values from the invoice instead of the line items
+	Invoice bool `json:"invoice,omitempty"`
+	// Ext takes the values from the extensions instead of the meta data
+	Ext bool `json:"ext,omitempty"`
+	// Text is the key providing RiferimentoTesto
+	Text cbc.Key `json:"text,omitempty"`
+	// Number is the key providing RiferimentoNumero
+	Number cbc.Key `json:"number,omitempty"`
+	// Date is the key providing RiferimentoData
+	Date cbc.Key `json:"date,omitempty"`
+}
+
+// Maximum lengths of TipoDato and RiferimentoTesto
+const (
+	maxTipoDatoLength         = 10
+	maxRiferimentoTestoLength = 60
+)
+
+// RiferimentoNumero requires between 2 and 8 decimals, so numbers given with
+// less are completed with zeros.
+var riferimentoNumeroRegexp = regexp.MustCompile(`^-?[0-9]{1,11}(\.[0-9]{0,8})?$`)
+
+// altriDatiGestionali contains other data used to manage the line
+type altriDatiGestionali struct {
+	TipoDato          string
+	RiferimentoTesto  string `xml:",omitempty"`
+	RiferimentoNumero string `xml:",omitempty"`
+	RiferimentoData   string `xml:",omitempty"`
+}
+
+// addAltriDatiGestionali adds the blocks defined by the rules to the lines
+// of the body, which correspond to those of the invoice.
+func addAltriDatiGestionali(body *fatturaElettronicaBody, inv *bill.Invoice, rules []*ManagementDataRule) error {
+	types := make(map[string]bool)
+	for _, r := range rules {
+		if r.Type == "" {
+			return errors.New("management data rule without type")
+		}
+		if utf8.RuneCountInString(r.Type) > maxTipoDatoLength {
+			return fmt.Errorf("management data rule type '%s' exceeds %d characters", r.Type, maxTipoDatoLength)
+		}
+		if types[r.Type] {
+			return fmt.Errorf("duplicate management data rule type '%s'", r.Type)
+		}
+		types[r.Type] = true
+	}
+
+	for i, line := range inv.Lines {
+		dl := body.DatiBeniServizi.DettaglioLinee[i]
+		for _, r := range rules {
+			adg, err := r.newAltriDatiGestionali(r.values(inv, line.Item))
+			if err != nil {
+				return fmt.Errorf("line %d: %s: %w", line.Index, r.Type, err)
+			}
+			if adg != nil {
+				dl.AltriDatiGestionali = append(dl.AltriDatiGestionali, adg)
+			}
+		}
+	}
+
+	return nil
+}
+
+// newAltriDatiGestionali prepares the block with the values given, if any
+func (r *ManagementDataRule) newAltriDatiGestionali(values map[cbc.Key]string) (*altriDatiGestionali, error) {
+	adg := &altriDatiGestionali{TipoDato: r.Type}
+	if r.Text != cbc.KeyEmpty {
+		adg.RiferimentoTesto = values[r.Text]
+		if utf8.RuneCountInString(adg.RiferimentoTesto) > maxRiferimentoTestoLength {
+			return nil, fmt.Errorf("%s: exceeds %d characters", r.Text, maxRiferimentoTestoLength)
+		}
+	}
+	if v := values[r.Number]; r.Number != cbc.KeyEmpty && v != "" {
+		n, err := formatRiferimentoNumero(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", r.Number, err)
+		}
+		adg.RiferimentoNumero = n
+	}
+	if r.Date != cbc.KeyEmpty {
+		date, err := formatMetaDate(values, r.Date)
+		if err != nil {
+			return nil, err
+		}
+		adg.RiferimentoData = date
+	}
+	if adg.RiferimentoTesto == "" && adg.RiferimentoNumero == "" && adg.RiferimentoData == "" {
+		return nil, nil
+	}
+	return adg, nil
+}
+
+// formatRiferimentoNumero checks the number is valid for RiferimentoNumero,
+// which is written as given, completing it with the decimals required.
+func formatRiferimentoNumero(v string) (string, error) {
+	if !riferimentoNumeroRegexp.MatchString(v) {
+		return "", fmt.Errorf("invalid number '%s'", v)
+	}
+	i := strings.IndexByte(v, '.')
+	if i < 0 {
+		return v + ".00", nil
+	}
+	if decimals := len(v) - i - 1; decimals < 2 {
+		v += strings.Repeat("0", 2-decimals)
+	}
+	return v, nil
+}
+
+// values provides the meta data or extensions the rule takes the values from
+func (r *ManagementDataRule) values(inv *bill.Invoice, item *org.Item) map[cbc.Key]string {
+	switch {
+	case r.Invoice && r.Ext:
+		if inv.Tax == nil {
+			return nil
+		}
+		return extValues(inv.Tax.Ext)
+	case r.Invoice:
+		return inv.Meta
+	case r.Ext:
+		return extValues(item.Ext)
+	default:
+		return item.Meta
+	}
+}
+
+func extValues(ext tax.Extensions) map[cbc.Key]string {
+	values := make(map[cbc.Key]string, len(ext))
+	for k, v := range ext {
+		values[k] = v.String()
+	}
+	return values
+}
+
+// goblManagementData reads back the values of the AltriDatiGestionali blocks
+// into the invoice and its lines according to the rules. Blocks without a
+// rule are ignored, while invoice values are taken from the first line
+// including them.
+func goblManagementData(inv *bill.Invoice, dbs *datiBeniServizi, rules []*ManagementDataRule) error {
+	for i, dl := range dbs.DettaglioLinee {
+		for _, adg := range dl.AltriDatiGestionali {
+			r := findManagementDataRule(rules, adg.TipoDato)
+			if r == nil {
+				continue
+			}
+			values, err := r.goblValues(adg)
+			if err != nil {
+				return fmt.Errorf("line %s: AltriDatiGestionali %s: %w", dl.NumeroLinea, adg.TipoDato, err)
+			}
+			if r.Invoice {
+				r.setInvoiceValues(inv, values)
+			} else {
+				r.setItemValues(inv.Lines[i].Item, values)
+			}
+		}
+	}
+	return nil
+}
+
+func findManagementDataRule(rules []*ManagementDataRule, tipo string) *ManagementDataRule {
+	for _, r := range rules {
+		if r.Type == tipo {
+			return r
+		}
+	}
+	return nil
+}
+
+// goblValues provides the values of the block according to the rule
+func (r *ManagementDataRule) goblValues(adg *altriDatiGestionali) (map[cbc.Key]string, error) {
+	values := make(map[cbc.Key]string)
+	if r.Text != cbc.KeyEmpty && adg.RiferimentoTesto != "" {
+		values[r.Text] = adg.RiferimentoTesto
+	}
+	if r.Number != cbc.KeyEmpty && adg.RiferimentoNumero != "" {
+		// The decimals added to whole numbers are removed
+		if _, err := parseAmount(adg.RiferimentoNumero); err != nil {
+			return nil, fmt.Errorf("RiferimentoNumero: %w", err)
+		}
+		values[r.Number] = strings.TrimSuffix(adg.RiferimentoNumero, ".00")
+	}
+	if r.Date != cbc.KeyEmpty && adg.RiferimentoData != "" {
+		date, err := parseDate(adg.RiferimentoData)
+		if err != nil {
+			return nil, fmt.Errorf("RiferimentoData: %w", err)
+		}
+		values[r.Date] = date.String()
+	}
+	return values, nil
+}
+
+func (r *ManagementDataRule) setItemValues(item *org.Item, values map[cbc.Key]string) {
+	for k, v := range values {
+		if r.Ext {
+			if item.Ext == nil {
+				item.Ext = make(tax.Extensions)
+			}
+			item.Ext[k] = tax.ExtValue(v)
+		} else {
+			setItemMeta(item, k, v)
+		}
+	}
+}
+
+func (r *ManagementDataRule) setInvoiceValues(inv *bill.Invoice, values map[cbc.Key]string) {
+	for k, v := range values {
+		if r.Ext {
+			if inv.Tax == nil {
+				inv.Tax = new(bill.Tax)
+			}
+			if inv.Tax.Ext == nil {
+				inv.Tax.Ext = make(tax.Extensions)
+			}
+			if _, ok := inv.Tax.Ext[k]; !ok {
+				inv.Tax.Ext[k] = tax.ExtValue(v)
+			}
+			continue
+		}
+		if inv.Meta == nil {
+			inv.Meta = make(cbc.Meta)
+		}
+		if _, ok := inv.Meta[k]; !ok {
+			inv.Meta[k] = v
+		}
+	}
+}
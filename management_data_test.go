@@ -0,0 +1,201 @@
+package fatturapa_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	fatturapa "github.com/invopop/gobl.fatturapa"
+	"github.com/invopop/gobl.fatturapa/test"
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cbc"
+	"github.com/invopop/gobl/regimes/it"
+	"github.com/invopop/gobl/tax"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAltriDatiGestionali(t *testing.T) {
+	rules := []*fatturapa.ManagementDataRule{
+		{
+			Type:    "INTENTO",
+			Invoice: true,
+			Text:    "intent-protocol",
+			Date:    "intent-date",
+		},
+		{
+			Type:   "ORDINE",
+			Text:   "order-ref",
+			Number: "order-line",
+			Date:   "order-date",
+		},
+	}
+	managementData := func(inv *bill.Invoice) {
+		inv.Meta = cbc.Meta{
+			"intent-protocol": "21012345678901-000001",
+			"intent-date":     "2023-01-15",
+		}
+		inv.Lines[0].Item.Meta = cbc.Meta{
+			"order-ref":  "PO-2023-001",
+			"order-line": "10",
+			"order-date": "2023-02-20",
+		}
+	}
+
+	t.Run("should not include blocks without rules", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simple.json")
+		test.ModifyInvoice(env, managementData)
+		doc, err := test.ConvertFromGOBL(env)
+		require.NoError(t, err)
+
+		for _, dl := range doc.FatturaElettronicaBody[0].DatiBeniServizi.DettaglioLinee {
+			assert.Empty(t, dl.AltriDatiGestionali)
+		}
+	})
+
+	t.Run("should include the blocks of the rules", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simple.json")
+		test.ModifyInvoice(env, managementData)
+		doc, err := test.ConvertFromGOBL(env, test.NewConverter(fatturapa.WithManagementData(rules...)))
+		require.NoError(t, err)
+
+		dl := doc.FatturaElettronicaBody[0].DatiBeniServizi.DettaglioLinee
+		require.Len(t, dl[0].AltriDatiGestionali, 2)
+		adg := dl[0].AltriDatiGestionali[0]
+		assert.Equal(t, "INTENTO", adg.TipoDato)
+		assert.Equal(t, "21012345678901-000001", adg.RiferimentoTesto)
+		assert.Empty(t, adg.RiferimentoNumero)
+		assert.Equal(t, "2023-01-15", adg.RiferimentoData)
+		adg = dl[0].AltriDatiGestionali[1]
+		assert.Equal(t, "ORDINE", adg.TipoDato)
+		assert.Equal(t, "PO-2023-001", adg.RiferimentoTesto)
+		assert.Equal(t, "10.00", adg.RiferimentoNumero)
+		assert.Equal(t, "2023-02-20", adg.RiferimentoData)
+
+		// Invoice values are included in every line
+		require.Len(t, dl[1].AltriDatiGestionali, 1)
+		assert.Equal(t, "INTENTO", dl[1].AltriDatiGestionali[0].TipoDato)
+
+		assert.NoError(t, doc.Validate())
+	})
+
+	t.Run("should use extensions", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simple.json")
+		test.ModifyInvoice(env, func(inv *bill.Invoice) {
+			inv.Tax.Ext = tax.Extensions{it.ExtKeySDIFiscalRegime: "RF02"}
+		})
+		rule := &fatturapa.ManagementDataRule{
+			Type:    "REGIME",
+			Invoice: true,
+			Ext:     true,
+			Text:    it.ExtKeySDIFiscalRegime,
+		}
+		doc, err := test.ConvertFromGOBL(env, test.NewConverter(fatturapa.WithManagementData(rule)))
+		require.NoError(t, err)
+
+		adg := doc.FatturaElettronicaBody[0].DatiBeniServizi.DettaglioLinee[0].AltriDatiGestionali
+		require.Len(t, adg, 1)
+		assert.Equal(t, "RF02", adg[0].RiferimentoTesto)
+	})
+
+	t.Run("should reject invalid values", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simple.json")
+		test.ModifyInvoice(env, func(inv *bill.Invoice) {
+			managementData(inv)
+			inv.Lines[0].Item.Meta["order-line"] = "ten"
+		})
+		_, err := test.ConvertFromGOBL(env, test.NewConverter(fatturapa.WithManagementData(rules...)))
+		assert.EqualError(t, err, "line 1: ORDINE: order-line: invalid number 'ten'")
+
+		test.ModifyInvoice(env, func(inv *bill.Invoice) {
+			managementData(inv)
+			inv.Lines[0].Item.Meta["order-line"] = "1.123456789"
+		})
+		_, err = test.ConvertFromGOBL(env, test.NewConverter(fatturapa.WithManagementData(rules...)))
+		assert.EqualError(t, err, "line 1: ORDINE: order-line: invalid number '1.123456789'")
+
+		test.ModifyInvoice(env, func(inv *bill.Invoice) {
+			managementData(inv)
+			inv.Lines[0].Item.Meta["order-ref"] = strings.Repeat("x", 61)
+		})
+		_, err = test.ConvertFromGOBL(env, test.NewConverter(fatturapa.WithManagementData(rules...)))
+		assert.EqualError(t, err, "line 1: ORDINE: order-ref: exceeds 60 characters")
+	})
+
+	t.Run("should write numbers as given", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simple.json")
+		test.ModifyInvoice(env, func(inv *bill.Invoice) {
+			managementData(inv)
+			inv.Lines[0].Item.Meta["order-line"] = "-1.12345678"
+		})
+		doc, err := test.ConvertFromGOBL(env, test.NewConverter(fatturapa.WithManagementData(rules...)))
+		require.NoError(t, err)
+
+		adg := doc.FatturaElettronicaBody[0].DatiBeniServizi.DettaglioLinee[0].AltriDatiGestionali
+		require.Len(t, adg, 2)
+		assert.Equal(t, "-1.12345678", adg[1].RiferimentoNumero)
+		assert.NoError(t, doc.Validate())
+	})
+
+	t.Run("should require the type", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simple.json")
+		converter := test.NewConverter(fatturapa.WithManagementData(&fatturapa.ManagementDataRule{Text: "order-ref"}))
+		_, err := test.ConvertFromGOBL(env, converter)
+		assert.EqualError(t, err, "management data rule without type")
+	})
+
+	t.Run("should check the type", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simple.json")
+		converter := test.NewConverter(fatturapa.WithManagementData(&fatturapa.ManagementDataRule{Type: "RIFERIMENTO", Text: "ref"}))
+		_, err := test.ConvertFromGOBL(env, converter)
+		assert.EqualError(t, err, "management data rule type 'RIFERIMENTO' exceeds 10 characters")
+
+		converter = test.NewConverter(fatturapa.WithManagementData(append(rules, &fatturapa.ManagementDataRule{Type: "ORDINE", Text: "ref"})...))
+		_, err = test.ConvertFromGOBL(env, converter)
+		assert.EqualError(t, err, "duplicate management data rule type 'ORDINE'")
+	})
+
+	t.Run("should read back the values", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simple.json")
+		test.ModifyInvoice(env, managementData)
+		orig := env.Extract().(*bill.Invoice)
+
+		c := test.NewConverter(fatturapa.WithManagementData(rules...))
+		doc, err := test.ConvertFromGOBL(env, c)
+		require.NoError(t, err)
+		data, err := doc.Bytes()
+		require.NoError(t, err)
+		out, err := c.ConvertToGOBL(bytes.NewReader(data))
+		require.NoError(t, err)
+		inv := out.Extract().(*bill.Invoice)
+
+		assert.Equal(t, orig.Meta, inv.Meta)
+		assert.Equal(t, orig.Lines[0].Item.Meta, inv.Lines[0].Item.Meta)
+		assert.Empty(t, inv.Lines[1].Item.Meta)
+	})
+
+	t.Run("should read back extensions", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simple.json")
+		test.ModifyInvoice(env, func(inv *bill.Invoice) {
+			inv.Lines[0].Item.Ext = tax.Extensions{it.ExtKeySDINature: "N2.2"}
+		})
+		rule := &fatturapa.ManagementDataRule{
+			Type: "NATURA",
+			Ext:  true,
+			Text: it.ExtKeySDINature,
+		}
+
+		c := test.NewConverter(fatturapa.WithManagementData(rule))
+		doc, err := test.ConvertFromGOBL(env, c)
+		require.NoError(t, err)
+		data, err := doc.Bytes()
+		require.NoError(t, err)
+		out, err := c.ConvertToGOBL(bytes.NewReader(data))
+		require.NoError(t, err)
+		inv := out.Extract().(*bill.Invoice)
+
+		assert.Equal(t, tax.Extensions{it.ExtKeySDINature: "N2.2"}, inv.Lines[0].Item.Ext)
+		assert.Empty(t, inv.Lines[1].Item.Ext)
+		assert.Empty(t, inv.Lines[0].Item.Meta)
+	})
+}
@@ -0,0 +1,370 @@
+package fatturapa
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cbc"
+	"github.com/invopop/gobl/org"
+)
+
+// Identity types that may be used in the invoice ordering identities to
+// provide the public administration tender (CIG) and project (CUP) codes.
+// Identities labelled with the code of a preceding invoice are used for
+// that invoice only.
+const (
+	IdentityTypeCIG cbc.Code = "CIG" // Codice Identificativo Gara
+	IdentityTypeCUP cbc.Code = "CUP" // Codice Unitario Progetto
+)
+
+// Item meta keys used to link invoice lines to the related documents. The
+// value must match the code, including any series, of the referenced
+// document. Lines may also reference documents not included in the invoice
+// ordering section.
+const (
+	MetaKeyPurchase  cbc.Key = "purchase"
+	MetaKeyContract  cbc.Key = "contract"
+	MetaKeyTender    cbc.Key = "tender"
+	MetaKeyReceiving cbc.Key = "receiving"
+)
+
+// Meta keys used to provide the date of the related documents and the
+// number of the item or line referenced in them. When set in the invoice
+// meta they apply to the document of the ordering section, otherwise they
+// apply to the document referenced by the line's item.
+const (
+	MetaKeyPurchaseDate  cbc.Key = "purchase-date"
+	MetaKeyPurchaseItem  cbc.Key = "purchase-item"
+	MetaKeyContractDate  cbc.Key = "contract-date"
+	MetaKeyContractItem  cbc.Key = "contract-item"
+	MetaKeyTenderDate    cbc.Key = "tender-date"
+	MetaKeyTenderItem    cbc.Key = "tender-item"
+	MetaKeyReceivingDate cbc.Key = "receiving-date"
+	MetaKeyReceivingItem cbc.Key = "receiving-item"
+)
+
+// orderingKeys groups the meta keys used for each type of related document
+type orderingKeys struct {
+	code cbc.Key
+	date cbc.Key
+	item cbc.Key
+}
+
+var (
+	purchaseKeys  = orderingKeys{MetaKeyPurchase, MetaKeyPurchaseDate, MetaKeyPurchaseItem}
+	contractKeys  = orderingKeys{MetaKeyContract, MetaKeyContractDate, MetaKeyContractItem}
+	tenderKeys    = orderingKeys{MetaKeyTender, MetaKeyTenderDate, MetaKeyTenderItem}
+	receivingKeys = orderingKeys{MetaKeyReceiving, MetaKeyReceivingDate, MetaKeyReceivingItem}
+)
+
+// addOrderingDocuments adds the references to documents related to the
+// invoice's ordering process to the general data.
+func addOrderingDocuments(dg *datiGenerali, inv *bill.Invoice) error {
+	o := inv.Ordering
+	if o == nil {
+		o = new(bill.Ordering)
+	}
+
+	var err error
+	if dg.DatiOrdineAcquisto, err = newDatiDocumentiCorrelati(inv, o.Purchase, purchaseKeys); err != nil {
+		return err
+	}
+	if dg.DatiContratto, err = newDatiDocumentiCorrelati(inv, o.Contract, contractKeys); err != nil {
+		return err
+	}
+	if dg.DatiConvenzione, err = newDatiDocumentiCorrelati(inv, o.Tender, tenderKeys); err != nil {
+		return err
+	}
+	if dg.DatiRicezione, err = newDatiDocumentiCorrelati(inv, o.Receiving, receivingKeys); err != nil {
+		return err
+	}
+
+	var commessa string
+	if o.Project != nil {
+		commessa = documentReferenceCode(o.Project)
+	}
+	if commessa != "" || findOrderingIdentity(o, IdentityTypeCIG) != "" || findOrderingIdentity(o, IdentityTypeCUP) != "" {
+		if len(orderingDocuments(dg)) == 0 {
+			if o.Code == "" {
+				return errors.New("CIG, CUP or project codes require an ordering code or document reference")
+			}
+			dg.DatiOrdineAcquisto = append(dg.DatiOrdineAcquisto, &datiDocumentiCorrelati{IdDocumento: o.Code})
+		}
+	}
+
+	// Public administration codes are added to every document they belong to
+	docs := orderingDocuments(dg)
+	for _, d := range docs {
+		d.CodiceCIG = findDocumentIdentity(o, IdentityTypeCIG, d.IdDocumento)
+		if d.CodiceCIG == "" {
+			d.CodiceCIG = findOrderingIdentity(o, IdentityTypeCIG)
+		}
+		d.CodiceCUP = findDocumentIdentity(o, IdentityTypeCUP, d.IdDocumento)
+		if d.CodiceCUP == "" {
+			d.CodiceCUP = findOrderingIdentity(o, IdentityTypeCUP)
+		}
+		d.CodiceCommessaConvenzione = commessa
+	}
+
+	return checkIdentityLabels(o, append(docs, dg.DatiFattureCollegate...))
+}
+
+// checkIdentityLabels ensures CIG and CUP identities labelled with a document
+// code match one of the related documents, so they are not lost.
+func checkIdentityLabels(o *bill.Ordering, docs []*datiDocumentiCorrelati) error {
+	for _, id := range o.Identities {
+		if id.Label == "" || (id.Type != IdentityTypeCIG && id.Type != IdentityTypeCUP) {
+			continue
+		}
+		found := false
+		for _, d := range docs {
+			if d.IdDocumento == id.Label {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s identity labelled '%s' does not match any related document", id.Type, id.Label)
+		}
+	}
+	return nil
+}
+
+// orderingDocuments provides all the ordering documents in the general data
+func orderingDocuments(dg *datiGenerali) []*datiDocumentiCorrelati {
+	var docs []*datiDocumentiCorrelati
+	for _, list := range [][]*datiDocumentiCorrelati{
+		dg.DatiOrdineAcquisto,
+		dg.DatiContratto,
+		dg.DatiConvenzione,
+		dg.DatiRicezione,
+	} {
+		docs = append(docs, list...)
+	}
+	return docs
+}
+
+// newDatiDocumentiCorrelati prepares the references for the provided document
+// along with any other documents of the same type referenced by the lines.
+// Lines referencing different items of the same document are grouped in
+// separate blocks.
+func newDatiDocumentiCorrelati(inv *bill.Invoice, ref *bill.DocumentReference, keys orderingKeys) ([]*datiDocumentiCorrelati, error) {
+	var dd []*datiDocumentiCorrelati
+
+	find := func(code, item string) *datiDocumentiCorrelati {
+		for _, d := range dd {
+			if d.IdDocumento == code && d.NumItem == item {
+				return d
+			}
+		}
+		return nil
+	}
+
+	var refDate string
+	if ref != nil {
+		d := &datiDocumentiCorrelati{
+			IdDocumento: documentReferenceCode(ref),
+			NumItem:     inv.Meta[keys.item],
+		}
+		date, err := formatMetaDate(inv.Meta, keys.date)
+		if err != nil {
+			return nil, err
+		}
+		d.Data = date
+		refDate = date
+		dd = append(dd, d)
+	}
+
+	for _, line := range inv.Lines {
+		if line.Item == nil {
+			continue
+		}
+		code := line.Item.Meta[keys.code]
+		if code == "" {
+			continue
+		}
+		item := line.Item.Meta[keys.item]
+		d := find(code, item)
+		if d == nil {
+			date, err := formatMetaDate(line.Item.Meta, keys.date)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line.Index, err)
+			}
+			if date == "" && ref != nil && code == dd[0].IdDocumento {
+				date = refDate
+			}
+			d = &datiDocumentiCorrelati{
+				IdDocumento: code,
+				Data:        date,
+				NumItem:     item,
+			}
+			dd = append(dd, d)
+		}
+		d.RiferimentoNumeroLinea = append(d.RiferimentoNumeroLinea, line.Index)
+	}
+
+	for _, d := range dd {
+		sort.Ints(d.RiferimentoNumeroLinea)
+	}
+
+	return dd, nil
+}
+
+func documentReferenceCode(ref *bill.DocumentReference) string {
+	if ref.Series != "" {
+		return fmt.Sprintf("%s-%s", ref.Series, ref.Code)
+	}
+	return ref.Code
+}
+
+func findOrderingIdentity(o *bill.Ordering, typ cbc.Code) string {
+	return findDocumentIdentity(o, typ, "")
+}
+
+// findDocumentIdentity provides the code of the first ordering identity of
+// the type given whose label matches the document code.
+func findDocumentIdentity(o *bill.Ordering, typ cbc.Code, code string) string {
+	if o == nil {
+		return ""
+	}
+	for _, id := range o.Identities {
+		if id.Type == typ && id.Label == code {
+			return id.Code.String()
+		}
+	}
+	return ""
+}
+
+// goblOrdering prepares the ordering section from the related documents,
+// linking the lines to them and setting the date and item of the main
+// documents in the invoice meta.
+func goblOrdering(dg *datiGenerali, inv *bill.Invoice) (*bill.Ordering, error) {
+	o := new(bill.Ordering)
+
+	var err error
+	if o.Purchase, err = goblDocumentReference(dg.DatiOrdineAcquisto, purchaseKeys, inv); err != nil {
+		return nil, fmt.Errorf("DatiOrdineAcquisto: %w", err)
+	}
+	if o.Contract, err = goblDocumentReference(dg.DatiContratto, contractKeys, inv); err != nil {
+		return nil, fmt.Errorf("DatiContratto: %w", err)
+	}
+	if o.Tender, err = goblDocumentReference(dg.DatiConvenzione, tenderKeys, inv); err != nil {
+		return nil, fmt.Errorf("DatiConvenzione: %w", err)
+	}
+	if o.Receiving, err = goblDocumentReference(dg.DatiRicezione, receivingKeys, inv); err != nil {
+		return nil, fmt.Errorf("DatiRicezione: %w", err)
+	}
+
+	docs := orderingDocuments(dg)
+	o.Identities = append(o.Identities, goblOrderingIdentities(docs, IdentityTypeCIG, func(d *datiDocumentiCorrelati) string { return d.CodiceCIG })...)
+	o.Identities = append(o.Identities, goblOrderingIdentities(docs, IdentityTypeCUP, func(d *datiDocumentiCorrelati) string { return d.CodiceCUP })...)
+	for _, d := range docs {
+		if d.CodiceCommessaConvenzione != "" {
+			o.Project = &bill.DocumentReference{Code: d.CodiceCommessaConvenzione}
+			break
+		}
+	}
+
+	if o.Purchase == nil && o.Contract == nil && o.Tender == nil && o.Receiving == nil &&
+		o.Project == nil && len(o.Identities) == 0 {
+		return nil, nil
+	}
+
+	return o, nil
+}
+
+// goblOrderingIdentities provides a single identity when all the documents
+// share the same code, or otherwise identities labelled with the code of
+// each document.
+func goblOrderingIdentities(docs []*datiDocumentiCorrelati, typ cbc.Code, value func(*datiDocumentiCorrelati) string) []*org.Identity {
+	shared := len(docs) > 0
+	for _, d := range docs {
+		if value(d) == "" || value(d) != value(docs[0]) {
+			shared = false
+			break
+		}
+	}
+	if shared {
+		return []*org.Identity{{Type: typ, Code: cbc.Code(value(docs[0]))}}
+	}
+
+	var ids []*org.Identity
+	for _, d := range docs {
+		v := value(d)
+		if v == "" {
+			continue
+		}
+		dup := false
+		for _, id := range ids {
+			if id.Label == d.IdDocumento && id.Code.String() == v {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			ids = append(ids, &org.Identity{Label: d.IdDocumento, Type: typ, Code: cbc.Code(v)})
+		}
+	}
+	return ids
+}
+
+// goblDocumentReference provides the first document of the list as the
+// invoice's reference, with its date and item in the invoice meta, while
+// the lines are linked to their documents using the item meta keys.
+func goblDocumentReference(list []*datiDocumentiCorrelati, keys orderingKeys, inv *bill.Invoice) (*bill.DocumentReference, error) {
+	if len(list) == 0 {
+		return nil, nil
+	}
+
+	for i, d := range list {
+		var date string
+		if d.Data != "" {
+			dt, err := parseDate(d.Data)
+			if err != nil {
+				return nil, err
+			}
+			date = dt.String()
+		}
+		if i == 0 {
+			if date != "" || d.NumItem != "" {
+				if inv.Meta == nil {
+					inv.Meta = make(cbc.Meta)
+				}
+				setMeta(inv.Meta, keys.date, date)
+				setMeta(inv.Meta, keys.item, d.NumItem)
+			}
+		}
+		for _, n := range d.RiferimentoNumeroLinea {
+			if n < 1 || n > len(inv.Lines) {
+				continue
+			}
+			item := inv.Lines[n-1].Item
+			setItemMeta(item, keys.code, d.IdDocumento)
+			if i > 0 {
+				setMeta(item.Meta, keys.date, date)
+				setMeta(item.Meta, keys.item, d.NumItem)
+			}
+		}
+	}
+
+	return &bill.DocumentReference{
+		Code: list[0].IdDocumento,
+	}, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func setMeta(meta cbc.Meta, key cbc.Key, value string) {
+	if value != "" {
+		meta[key] = value
+	}
+}
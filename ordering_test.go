@@ -0,0 +1,276 @@
+package fatturapa_test
+
+import (
+	"bytes"
+	"testing"
+
+	fatturapa "github.com/invopop/gobl.fatturapa"
+	"github.com/invopop/gobl.fatturapa/test"
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cbc"
+	"github.com/invopop/gobl/org"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrderingDocuments(t *testing.T) {
+	t.Run("should be empty without ordering data", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simple.json")
+		doc, err := test.ConvertFromGOBL(env)
+		require.NoError(t, err)
+
+		dg := doc.FatturaElettronicaBody[0].DatiGenerali
+		assert.Empty(t, dg.DatiOrdineAcquisto)
+		assert.Empty(t, dg.DatiContratto)
+		assert.Empty(t, dg.DatiConvenzione)
+		assert.Empty(t, dg.DatiRicezione)
+	})
+
+	t.Run("should contain the ordering documents", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simple.json")
+		test.ModifyInvoice(env, func(inv *bill.Invoice) {
+			inv.Ordering = &bill.Ordering{
+				Identities: []*org.Identity{
+					{Type: fatturapa.IdentityTypeCIG, Code: "1234567890"},
+					{Type: fatturapa.IdentityTypeCUP, Code: "J12B34000560001"},
+				},
+				Project:   &bill.DocumentReference{Code: "PRJ-1"},
+				Purchase:  &bill.DocumentReference{Code: "PO-1"},
+				Contract:  &bill.DocumentReference{Series: "CT", Code: "22"},
+				Tender:    &bill.DocumentReference{Code: "CONV-3"},
+				Receiving: &bill.DocumentReference{Code: "RCV-4"},
+			}
+		})
+
+		doc, err := test.ConvertFromGOBL(env)
+		require.NoError(t, err)
+
+		dg := doc.FatturaElettronicaBody[0].DatiGenerali
+		require.Len(t, dg.DatiOrdineAcquisto, 1)
+		assert.Equal(t, "PO-1", dg.DatiOrdineAcquisto[0].IdDocumento)
+		assert.Empty(t, dg.DatiOrdineAcquisto[0].RiferimentoNumeroLinea)
+		assert.Equal(t, "1234567890", dg.DatiOrdineAcquisto[0].CodiceCIG)
+		assert.Equal(t, "J12B34000560001", dg.DatiOrdineAcquisto[0].CodiceCUP)
+		assert.Equal(t, "PRJ-1", dg.DatiOrdineAcquisto[0].CodiceCommessaConvenzione)
+
+		require.Len(t, dg.DatiContratto, 1)
+		assert.Equal(t, "CT-22", dg.DatiContratto[0].IdDocumento)
+		assert.Equal(t, "1234567890", dg.DatiContratto[0].CodiceCIG)
+		assert.Equal(t, "PRJ-1", dg.DatiContratto[0].CodiceCommessaConvenzione)
+		require.Len(t, dg.DatiConvenzione, 1)
+		assert.Equal(t, "CONV-3", dg.DatiConvenzione[0].IdDocumento)
+		require.Len(t, dg.DatiRicezione, 1)
+		assert.Equal(t, "RCV-4", dg.DatiRicezione[0].IdDocumento)
+	})
+
+	t.Run("should link lines to their documents", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-irpef.json")
+		test.ModifyInvoice(env, func(inv *bill.Invoice) {
+			inv.Ordering = &bill.Ordering{
+				Purchase: &bill.DocumentReference{Code: "PO-1"},
+			}
+			inv.Lines[0].Item.Meta = cbc.Meta{fatturapa.MetaKeyPurchase: "PO-1"}
+			inv.Lines[1].Item.Meta = cbc.Meta{fatturapa.MetaKeyPurchase: "PO-2"}
+		})
+
+		doc, err := test.ConvertFromGOBL(env)
+		require.NoError(t, err)
+
+		doa := doc.FatturaElettronicaBody[0].DatiGenerali.DatiOrdineAcquisto
+		require.Len(t, doa, 2)
+		assert.Equal(t, "PO-1", doa[0].IdDocumento)
+		assert.Equal(t, []int{1}, doa[0].RiferimentoNumeroLinea)
+		assert.Equal(t, "PO-2", doa[1].IdDocumento)
+		assert.Equal(t, []int{2}, doa[1].RiferimentoNumeroLinea)
+	})
+
+	t.Run("should include the document dates and items", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-irpef.json")
+		test.ModifyInvoice(env, func(inv *bill.Invoice) {
+			inv.Meta = cbc.Meta{fatturapa.MetaKeyPurchaseDate: "2022-06-01"}
+			inv.Ordering = &bill.Ordering{
+				Purchase: &bill.DocumentReference{Code: "PO-1"},
+			}
+			inv.Lines[0].Item.Meta = cbc.Meta{
+				fatturapa.MetaKeyPurchase:     "PO-1",
+				fatturapa.MetaKeyPurchaseItem: "3",
+			}
+			inv.Lines[1].Item.Meta = cbc.Meta{
+				fatturapa.MetaKeyPurchase:     "PO-2",
+				fatturapa.MetaKeyPurchaseDate: "2022-06-10",
+			}
+		})
+
+		doc, err := test.ConvertFromGOBL(env)
+		require.NoError(t, err)
+
+		doa := doc.FatturaElettronicaBody[0].DatiGenerali.DatiOrdineAcquisto
+		require.Len(t, doa, 3)
+		assert.Equal(t, "PO-1", doa[0].IdDocumento)
+		assert.Equal(t, "2022-06-01", doa[0].Data)
+		assert.Empty(t, doa[0].NumItem)
+		assert.Empty(t, doa[0].RiferimentoNumeroLinea)
+		assert.Equal(t, "PO-1", doa[1].IdDocumento)
+		assert.Equal(t, "2022-06-01", doa[1].Data)
+		assert.Equal(t, "3", doa[1].NumItem)
+		assert.Equal(t, []int{1}, doa[1].RiferimentoNumeroLinea)
+		assert.Equal(t, "PO-2", doa[2].IdDocumento)
+		assert.Equal(t, "2022-06-10", doa[2].Data)
+		assert.Equal(t, []int{2}, doa[2].RiferimentoNumeroLinea)
+	})
+
+	t.Run("should fail with invalid document dates", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-irpef.json")
+		test.ModifyInvoice(env, func(inv *bill.Invoice) {
+			inv.Lines[0].Item.Meta = cbc.Meta{
+				fatturapa.MetaKeyContract:     "CT-1",
+				fatturapa.MetaKeyContractDate: "01/06/2022",
+			}
+		})
+
+		_, err := test.ConvertFromGOBL(env)
+		assert.ErrorContains(t, err, "line 1: contract-date: parsing date")
+	})
+
+	t.Run("should apply labelled codes to their documents", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simple.json")
+		test.ModifyInvoice(env, func(inv *bill.Invoice) {
+			inv.Ordering = &bill.Ordering{
+				Identities: []*org.Identity{
+					{Type: fatturapa.IdentityTypeCUP, Code: "J12B34000560001"},
+					{Label: "PO-1", Type: fatturapa.IdentityTypeCIG, Code: "1234567890"},
+					{Label: "CT-22", Type: fatturapa.IdentityTypeCIG, Code: "0987654321"},
+				},
+				Purchase: &bill.DocumentReference{Code: "PO-1"},
+				Contract: &bill.DocumentReference{Series: "CT", Code: "22"},
+			}
+		})
+
+		doc, err := test.ConvertFromGOBL(env)
+		require.NoError(t, err)
+
+		dg := doc.FatturaElettronicaBody[0].DatiGenerali
+		require.Len(t, dg.DatiOrdineAcquisto, 1)
+		assert.Equal(t, "1234567890", dg.DatiOrdineAcquisto[0].CodiceCIG)
+		assert.Equal(t, "J12B34000560001", dg.DatiOrdineAcquisto[0].CodiceCUP)
+		require.Len(t, dg.DatiContratto, 1)
+		assert.Equal(t, "0987654321", dg.DatiContratto[0].CodiceCIG)
+		assert.Equal(t, "J12B34000560001", dg.DatiContratto[0].CodiceCUP)
+	})
+
+	t.Run("should fail with labelled codes without document", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simple.json")
+		test.ModifyInvoice(env, func(inv *bill.Invoice) {
+			inv.Ordering = &bill.Ordering{
+				Identities: []*org.Identity{
+					{Label: "PO-9", Type: fatturapa.IdentityTypeCIG, Code: "1234567890"},
+				},
+				Purchase: &bill.DocumentReference{Code: "PO-1"},
+			}
+		})
+
+		_, err := test.ConvertFromGOBL(env)
+		assert.EqualError(t, err, "CIG identity labelled 'PO-9' does not match any related document")
+	})
+
+	t.Run("should use the ordering code for public administration codes", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simple.json")
+		test.ModifyInvoice(env, func(inv *bill.Invoice) {
+			inv.Ordering = &bill.Ordering{
+				Code: "ORD-9",
+				Identities: []*org.Identity{
+					{Type: fatturapa.IdentityTypeCIG, Code: "1234567890"},
+				},
+			}
+		})
+
+		doc, err := test.ConvertFromGOBL(env)
+		require.NoError(t, err)
+
+		doa := doc.FatturaElettronicaBody[0].DatiGenerali.DatiOrdineAcquisto
+		require.Len(t, doa, 1)
+		assert.Equal(t, "ORD-9", doa[0].IdDocumento)
+		assert.Equal(t, "1234567890", doa[0].CodiceCIG)
+	})
+
+	t.Run("should fail with public administration codes but no document", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simple.json")
+		test.ModifyInvoice(env, func(inv *bill.Invoice) {
+			inv.Ordering = &bill.Ordering{
+				Identities: []*org.Identity{
+					{Type: fatturapa.IdentityTypeCUP, Code: "J12B34000560001"},
+				},
+			}
+		})
+
+		_, err := test.ConvertFromGOBL(env)
+		assert.ErrorContains(t, err, "require an ordering code or document reference")
+	})
+
+	t.Run("should be imported into the ordering section", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-irpef.json")
+		test.ModifyInvoice(env, func(inv *bill.Invoice) {
+			inv.Ordering = &bill.Ordering{
+				Identities: []*org.Identity{
+					{Type: fatturapa.IdentityTypeCIG, Code: "1234567890"},
+				},
+				Purchase: &bill.DocumentReference{Code: "PO-1"},
+			}
+			inv.Lines[1].Item.Meta = cbc.Meta{fatturapa.MetaKeyPurchase: "PO-2"}
+		})
+
+		c := test.NewConverter()
+		doc, err := test.ConvertFromGOBL(env, c)
+		require.NoError(t, err)
+		data, err := doc.Bytes()
+		require.NoError(t, err)
+
+		out, err := c.ConvertToGOBL(bytes.NewReader(data))
+		require.NoError(t, err)
+		inv := out.Extract().(*bill.Invoice)
+
+		require.NotNil(t, inv.Ordering)
+		assert.Equal(t, "PO-1", inv.Ordering.Purchase.Code)
+		require.Len(t, inv.Ordering.Identities, 1)
+		assert.Equal(t, cbc.Code("1234567890"), inv.Ordering.Identities[0].Code)
+		assert.Equal(t, "PO-2", inv.Lines[1].Item.Meta[fatturapa.MetaKeyPurchase])
+	})
+
+	t.Run("should import dates, items and labelled codes", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-irpef.json")
+		test.ModifyInvoice(env, func(inv *bill.Invoice) {
+			inv.Meta = cbc.Meta{fatturapa.MetaKeyPurchaseDate: "2022-06-01"}
+			inv.Ordering = &bill.Ordering{
+				Identities: []*org.Identity{
+					{Label: "PO-1", Type: fatturapa.IdentityTypeCIG, Code: "1234567890"},
+				},
+				Purchase: &bill.DocumentReference{Code: "PO-1"},
+			}
+			inv.Lines[1].Item.Meta = cbc.Meta{
+				fatturapa.MetaKeyPurchase:     "PO-2",
+				fatturapa.MetaKeyPurchaseDate: "2022-06-10",
+				fatturapa.MetaKeyPurchaseItem: "7",
+			}
+		})
+
+		c := test.NewConverter()
+		doc, err := test.ConvertFromGOBL(env, c)
+		require.NoError(t, err)
+		data, err := doc.Bytes()
+		require.NoError(t, err)
+
+		out, err := c.ConvertToGOBL(bytes.NewReader(data))
+		require.NoError(t, err)
+		inv := out.Extract().(*bill.Invoice)
+
+		require.NotNil(t, inv.Ordering)
+		assert.Equal(t, "2022-06-01", inv.Meta[fatturapa.MetaKeyPurchaseDate])
+		require.Len(t, inv.Ordering.Identities, 1)
+		assert.Equal(t, "PO-1", inv.Ordering.Identities[0].Label)
+		assert.Equal(t, cbc.Code("1234567890"), inv.Ordering.Identities[0].Code)
+		meta := inv.Lines[1].Item.Meta
+		assert.Equal(t, "PO-2", meta[fatturapa.MetaKeyPurchase])
+		assert.Equal(t, "2022-06-10", meta[fatturapa.MetaKeyPurchaseDate])
+		assert.Equal(t, "7", meta[fatturapa.MetaKeyPurchaseItem])
+	})
+}
@@ -0,0 +1,150 @@
+package fatturapa
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/invopop/gobl.fatturapa/sdi"
+	"github.com/invopop/xmldsig"
+)
+
+// Namespace used for the messages exchanged with the SDI
+const namespaceMessaggi = "http://www.fatturapa.gov.it/sdi/messaggi/v1.0"
+
+const (
+	versioneMessaggi    = "1.0"
+	maxDescrizioneEsito = 255
+)
+
+// progressivoEsitoRegexp matches the progressive of the outcome's file name,
+// which is padded with zeros up to 3 alphanumeric characters.
+var progressivoEsitoRegexp = regexp.MustCompile(`^[a-zA-Z0-9]{1,3}$`)
+
+// EsitoCommittenteDocument contains the NotificaEsitoCommittente message used
+// by the recipient of an invoice to accept (EC01) or refuse (EC02) it.
+type EsitoCommittenteDocument struct {
+	fileName string `xml:"-"`
+
+	XMLName        xml.Name `xml:"types:NotificaEsitoCommittente"`
+	TypesNamespace string   `xml:"xmlns:types,attr"`
+	DSigNamespace  string   `xml:"xmlns:ds,attr"`
+	Versione       string   `xml:"versione,attr"`
+
+	sdi.EsitoCommittente
+
+	Signature *xmldsig.Signature `xml:"ds:Signature,omitempty"`
+}
+
+// ConvertEsitoCommittente prepares the recipient's outcome for the file
+// described by the MetadatiInvioFile received along with the invoice.
+// Outcomes may only be sent for invoices to public administrations (FPA12).
+// The esito must be sdi.EsitoAccettazione or sdi.EsitoRifiuto, the latter
+// requiring a description of the reason. The progressive, of up to 3
+// alphanumeric characters, must be unique for each outcome of the same file,
+// i.e. "1" for the first one. The document is signed when a certificate or
+// signer is available.
+func (c *Converter) ConvertEsitoCommittente(md *sdi.MetadatiInvioFile, esito, descrizione, progressivo string) (*EsitoCommittenteDocument, error) {
+	return c.ConvertEsitoCommittenteContext(context.Background(), md, esito, descrizione, progressivo)
+}
+
+// ConvertEsitoCommittenteContext is like ConvertEsitoCommittente, using the
+// context provided for the requests made while signing, such as timestamps.
+func (c *Converter) ConvertEsitoCommittenteContext(ctx context.Context, md *sdi.MetadatiInvioFile, esito, descrizione, progressivo string) (*EsitoCommittenteDocument, error) {
+	if md == nil {
+		return nil, errors.New("outcomes require the MetadatiInvioFile of the invoice")
+	}
+	if md.Formato != formatoTrasmissioneFPA12 {
+		return nil, fmt.Errorf("outcomes are only accepted for %s invoices, not '%s'", formatoTrasmissioneFPA12, md.Formato)
+	}
+	id, name := md.IdentificativoSdI, md.NomeFile
+	if id == "" || name == "" {
+		return nil, errors.New("outcomes require the IdentificativoSdI and NomeFile of the invoice")
+	}
+
+	switch esito {
+	case sdi.EsitoAccettazione:
+	case sdi.EsitoRifiuto:
+		if descrizione == "" {
+			return nil, errors.New("refusals require a description of the reason")
+		}
+	default:
+		return nil, fmt.Errorf("invalid outcome '%s'", esito)
+	}
+	if utf8.RuneCountInString(descrizione) > maxDescrizioneEsito {
+		return nil, fmt.Errorf("description exceeds %d characters", maxDescrizioneEsito)
+	}
+	if !progressivoEsitoRegexp.MatchString(progressivo) {
+		return nil, fmt.Errorf("invalid progressive '%s'", progressivo)
+	}
+
+	d := &EsitoCommittenteDocument{
+		fileName:       esitoFileName(name, progressivo),
+		TypesNamespace: namespaceMessaggi,
+		DSigNamespace:  namespaceDSig,
+		Versione:       versioneMessaggi,
+		EsitoCommittente: sdi.EsitoCommittente{
+			IdentificativoSdI: id,
+			Esito:             esito,
+			Descrizione:       descrizione,
+		},
+	}
+
+	if c.Config.signs() {
+		if err := signDocument(ctx, d, "EC-"+id, c.Config); err != nil {
+			return nil, err
+		}
+	}
+
+	return d, nil
+}
+
+// esitoFileName provides the name of the outcome's file, based on the name of
+// the invoice file without extensions, e.g. "IT01234567890_11111_EC_001.xml".
+func esitoFileName(name, progressivo string) string {
+	name = strings.TrimSuffix(name, ".p7m")
+	name = strings.TrimSuffix(name, ".xml")
+	progressivo = strings.Repeat("0", 3-len(progressivo)) + progressivo
+	return fmt.Sprintf("%s_%s_%s.xml", name, sdi.NotificationTypeEC, progressivo)
+}
+
+// FileName provides the name the file must have when sent to the SDI
+func (d *EsitoCommittenteDocument) FileName() string {
+	return d.fileName
+}
+
+// Buffer returns a byte buffer representation of the complete XML document.
+func (d *EsitoCommittenteDocument) Buffer() (*bytes.Buffer, error) {
+	return d.buffer(xml.Header)
+}
+
+// String converts a struct representation to string.
+func (d *EsitoCommittenteDocument) String() (string, error) {
+	buf, err := d.Buffer()
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// Bytes returns the XML document bytes
+func (d *EsitoCommittenteDocument) Bytes() ([]byte, error) {
+	buf, err := d.Buffer()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (d *EsitoCommittenteDocument) buffer(base string) (*bytes.Buffer, error) {
+	return marshalDocument(d, base)
+}
+
+func (d *EsitoCommittenteDocument) setSignature(sig *xmldsig.Signature) {
+	d.Signature = sig
+}
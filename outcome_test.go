@@ -0,0 +1,113 @@
+package fatturapa_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/invopop/gobl.fatturapa/sdi"
+	"github.com/invopop/gobl.fatturapa/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func loadMetadati(t *testing.T, name string) *sdi.MetadatiInvioFile {
+	t.Helper()
+	data, err := os.ReadFile(test.GetDataPath() + "messages/" + name)
+	require.NoError(t, err)
+	m, err := sdi.ParseMessage(data)
+	require.NoError(t, err)
+	md, ok := m.(*sdi.MetadatiInvioFile)
+	require.True(t, ok)
+	return md
+}
+
+func TestConvertEsitoCommittente(t *testing.T) {
+	t.Run("should accept invoices", func(t *testing.T) {
+		md := loadMetadati(t, "IT12345678903_00002_MT_001.xml")
+
+		doc, err := test.NewConverter().ConvertEsitoCommittente(md, sdi.EsitoAccettazione, "", "1")
+		require.NoError(t, err)
+
+		assert.Equal(t, "IT12345678903_00002_EC_001.xml", doc.FileName())
+		assert.Equal(t, "112", doc.IdentificativoSdI)
+		assert.Equal(t, "EC01", doc.Esito)
+		assert.NotNil(t, doc.Signature)
+
+		data, err := doc.String()
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(data, `<?xml version="1.0" encoding="UTF-8"?>`+"\n"+`<types:NotificaEsitoCommittente xmlns:types="http://www.fatturapa.gov.it/sdi/messaggi/v1.0"`))
+		assert.Contains(t, data, "<IdentificativoSdI>112</IdentificativoSdI><Esito>EC01</Esito>")
+		assert.Contains(t, data, "<ds:Signature")
+		assert.NotContains(t, data, "<Descrizione>")
+	})
+
+	t.Run("should refuse invoices with a reason", func(t *testing.T) {
+		md := loadMetadati(t, "IT12345678903_00002_MT_001.xml")
+
+		doc, err := test.NewConverter().ConvertEsitoCommittente(md, sdi.EsitoRifiuto, "Importo errato", "1")
+		require.NoError(t, err)
+
+		data, err := doc.Bytes()
+		require.NoError(t, err)
+
+		m, err := sdi.ParseMessage(data)
+		require.NoError(t, err)
+		ec, ok := m.(*sdi.NotificaEsitoCommittente)
+		require.True(t, ok)
+		assert.Equal(t, "1.0", ec.Versione)
+		assert.Equal(t, "112", ec.IdentificativoSdI)
+		assert.Equal(t, sdi.EsitoRifiuto, ec.Esito)
+		assert.Equal(t, "Importo errato", ec.Descrizione)
+	})
+
+	t.Run("should name outcomes of signed files", func(t *testing.T) {
+		md := &sdi.MetadatiInvioFile{IdentificativoSdI: "222", NomeFile: "IT12345678903_0000A.xml.p7m", Formato: "FPA12"}
+
+		doc, err := test.NewConverter().ConvertEsitoCommittente(md, sdi.EsitoAccettazione, "", "1")
+		require.NoError(t, err)
+		assert.Equal(t, "IT12345678903_0000A_EC_001.xml", doc.FileName())
+	})
+
+	t.Run("should use the progressive provided", func(t *testing.T) {
+		md := loadMetadati(t, "IT12345678903_00002_MT_001.xml")
+
+		doc, err := test.NewConverter().ConvertEsitoCommittente(md, sdi.EsitoAccettazione, "", "A2")
+		require.NoError(t, err)
+		assert.Equal(t, "IT12345678903_00002_EC_0A2.xml", doc.FileName())
+
+		_, err = test.NewConverter().ConvertEsitoCommittente(md, sdi.EsitoAccettazione, "", "")
+		assert.EqualError(t, err, "invalid progressive ''")
+		_, err = test.NewConverter().ConvertEsitoCommittente(md, sdi.EsitoAccettazione, "", "0001")
+		assert.EqualError(t, err, "invalid progressive '0001'")
+	})
+
+	t.Run("should only accept invoices to public administrations", func(t *testing.T) {
+		md := loadMetadati(t, "IT12345678903_00001_MT_001.xml")
+
+		_, err := test.NewConverter().ConvertEsitoCommittente(md, sdi.EsitoAccettazione, "", "1")
+		assert.EqualError(t, err, "outcomes are only accepted for FPA12 invoices, not 'FPR12'")
+	})
+
+	t.Run("should require a reason for refusals", func(t *testing.T) {
+		md := loadMetadati(t, "IT12345678903_00002_MT_001.xml")
+
+		_, err := test.NewConverter().ConvertEsitoCommittente(md, sdi.EsitoRifiuto, "", "1")
+		assert.EqualError(t, err, "refusals require a description of the reason")
+
+		_, err = test.NewConverter().ConvertEsitoCommittente(md, sdi.EsitoRifiuto, strings.Repeat("x", 256), "1")
+		assert.EqualError(t, err, "description exceeds 255 characters")
+	})
+
+	t.Run("should validate the outcome", func(t *testing.T) {
+		md := loadMetadati(t, "IT12345678903_00002_MT_001.xml")
+
+		_, err := test.NewConverter().ConvertEsitoCommittente(md, "EC03", "", "1")
+		assert.EqualError(t, err, "invalid outcome 'EC03'")
+	})
+
+	t.Run("should require the metadata of the invoice file", func(t *testing.T) {
+		_, err := test.NewConverter().ConvertEsitoCommittente(nil, sdi.EsitoAccettazione, "", "1")
+		assert.EqualError(t, err, "outcomes require the MetadatiInvioFile of the invoice")
+	})
+}
@@ -1,6 +1,11 @@
 package fatturapa
 
 import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/invopop/gobl/cbc"
 	"github.com/invopop/gobl/l10n"
 	"github.com/invopop/gobl/org"
 	"github.com/invopop/gobl/regimes/it"
@@ -199,3 +204,123 @@ func isCodiceFiscale(taxID *tax.Identity) bool {
 
 	return len(taxID.Code.String()) == 16
 }
+
+func goblSupplier(s *supplier) (*org.Party, error) {
+	if s.DatiAnagrafici == nil {
+		return nil, errors.New("missing supplier DatiAnagrafici")
+	}
+
+	p := goblParty(s.DatiAnagrafici)
+
+	if v := s.DatiAnagrafici.RegimeFiscale; v != "" {
+		p.Ext = tax.Extensions{
+			it.ExtKeySDIFiscalRegime: tax.ExtValue(v),
+		}
+	}
+
+	if s.Sede != nil {
+		p.Addresses = []*org.Address{goblAddress(s.Sede)}
+	}
+
+	reg, err := goblRegistration(s.IscrizioneREA)
+	if err != nil {
+		return nil, err
+	}
+	p.Registration = reg
+
+	if c := s.Contatti; c != nil {
+		if c.Email != "" {
+			p.Emails = []*org.Email{{Address: c.Email}}
+		}
+		if c.Telefono != "" {
+			p.Telephones = []*org.Telephone{{Number: c.Telefono}}
+		}
+	}
+
+	return p, nil
+}
+
+func goblCustomer(c *customer, formato string) *org.Party {
+	if c == nil || c.DatiAnagrafici == nil {
+		return nil
+	}
+
+	p := goblParty(c.DatiAnagrafici)
+
+	if p.TaxID != nil && p.TaxID.Country == l10n.IT {
+		switch {
+		case formato == formatoTrasmissioneFPA12:
+			p.TaxID.Type = it.TaxIdentityTypeGovernment
+		case len(p.People) > 0 || isCodiceFiscale(p.TaxID):
+			p.TaxID.Type = it.TaxIdentityTypeIndividual
+		}
+	}
+
+	if c.Sede != nil {
+		p.Addresses = []*org.Address{goblAddress(c.Sede)}
+	}
+
+	return p
+}
+
+func goblParty(da *datiAnagrafici) *org.Party {
+	p := new(org.Party)
+
+	switch {
+	case da.IdFiscaleIVA != nil:
+		p.TaxID = &tax.Identity{
+			Country: l10n.CountryCode(da.IdFiscaleIVA.IdPaese),
+			Code:    cbc.Code(da.IdFiscaleIVA.IdCodice),
+		}
+		switch da.IdFiscaleIVA.IdCodice {
+		case nonITCitizenTaxCodeDefault, nonEUBusinessTaxCodeDefault:
+			p.TaxID.Code = ""
+		}
+	case da.CodiceFiscale != "":
+		p.TaxID = &tax.Identity{
+			Country: l10n.IT,
+			Code:    cbc.Code(da.CodiceFiscale),
+		}
+	}
+
+	if a := da.Anagrafica; a != nil {
+		if a.Denominazione != "" {
+			p.Name = a.Denominazione
+		} else {
+			p.Name = strings.TrimSpace(a.Nome + " " + a.Cognome)
+			p.People = []*org.Person{
+				{
+					Name: org.Name{
+						Prefix:  a.Titolo,
+						Given:   a.Nome,
+						Surname: a.Cognome,
+					},
+				},
+			}
+		}
+	}
+
+	return p
+}
+
+func goblRegistration(rea *iscrizioneREA) (*org.Registration, error) {
+	if rea == nil {
+		return nil, nil
+	}
+
+	reg := &org.Registration{
+		Office: rea.Ufficio,
+		Entry:  rea.NumeroREA,
+	}
+
+	if rea.CapitaleSociale != "" {
+		capital, err := parseAmount(rea.CapitaleSociale)
+		if err != nil {
+			return nil, fmt.Errorf("CapitaleSociale: %w", err)
+		}
+		reg.Capital = &capital
+		reg.Currency = regime.Currency
+	}
+
+	return reg, nil
+}
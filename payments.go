@@ -2,13 +2,34 @@ package fatturapa
 
 import (
 	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
 
 	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cal"
 	"github.com/invopop/gobl/cbc"
+	"github.com/invopop/gobl/num"
+	"github.com/invopop/gobl/org"
 	"github.com/invopop/gobl/pay"
 	"github.com/invopop/gobl/regimes/it"
 )
 
+// Keys of the payment instructions meta data with the conditions for early
+// and late payments, which the payment terms do not cover. Discounts and
+// penalties may be given either as amounts or as percentages of each
+// payment, i.e. "2%", and only apply to the payments due after the early
+// payment date, or before the penalty date.
+const (
+	MetaKeyEarlyPaymentDiscount cbc.Key = "early-payment-discount"
+	MetaKeyEarlyPaymentDate     cbc.Key = "early-payment-date"
+	MetaKeyLatePaymentPenalty   cbc.Key = "late-payment-penalty"
+	MetaKeyLatePaymentDate      cbc.Key = "late-payment-date"
+)
+
+// Maximum value of GiorniTerminiPagamento
+const maxGiorniTerminiPagamento = 999
+
 // datiPagamento contains all data related to the payment of the document.
 type datiPagamento struct {
 	CondizioniPagamento string
@@ -17,64 +38,323 @@ type datiPagamento struct {
 
 // dettaglioPagamento contains data related to a single payment.
 type dettaglioPagamento struct {
-	ModalitaPagamento     string
-	DataScadenzaPagamento string `xml:",omitempty"`
-	ImportoPagamento      string
+	Beneficiario                    string `xml:",omitempty"`
+	ModalitaPagamento               string
+	DataRiferimentoTerminiPagamento string `xml:",omitempty"`
+	GiorniTerminiPagamento          string `xml:",omitempty"`
+	DataScadenzaPagamento           string `xml:",omitempty"`
+	ImportoPagamento                string
+	IstitutoFinanziario             string `xml:",omitempty"`
+	IBAN                            string `xml:",omitempty"`
+	ABI                             string `xml:",omitempty"`
+	CAB                             string `xml:",omitempty"`
+	BIC                             string `xml:",omitempty"`
+	ScontoPagamentoAnticipato       string `xml:",omitempty"`
+	DataLimitePagamentoAnticipato   string `xml:",omitempty"`
+	PenalitaPagamentiRitardati      string `xml:",omitempty"`
+	DataDecorrenzaPenale            string `xml:",omitempty"`
+	CodicePagamento                 string `xml:",omitempty"`
 }
 
-func newDatiPagamento(inv *bill.Invoice) (*datiPagamento, error) {
-	if inv.Payment == nil || inv.Payment.Instructions == nil {
+// Italian IBANs contain the ABI and CAB codes of the bank, i.e.
+// IT60X0542811101000000123456 has ABI 05428 and CAB 11101.
+var ibanPatternIT = regexp.MustCompile(`^IT[0-9]{2}[A-Z]([0-9]{5})([0-9]{5})[0-9A-Z]{12}$`)
+
+var ibanPattern = regexp.MustCompile(`^[A-Z]{2}[0-9]{2}[A-Z0-9]{11,30}$`)
+
+// Description of the advances read from FatturaPA documents, which are not
+// described.
+const advanceDescription = "Anticipo"
+
+// newDatiPagamento prepares a DatiPagamento block with the advances already
+// paid, and another with the balance to be paid according to the payment
+// instructions, so that together they add up to the payable amount.
+func newDatiPagamento(inv *bill.Invoice) ([]*datiPagamento, error) {
+	payment := inv.Payment
+	if payment == nil {
 		return nil, nil
 	}
 
-	dp, err := newDettalgioPagamento(inv)
-	if err != nil {
-		return nil, err
+	var blocks []*datiPagamento
+	if len(payment.Advances) > 0 {
+		dp, err := newDettaglioPagamentoAdvances(payment)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, &datiPagamento{
+			CondizioniPagamento: condizioniPagamentoAdvance,
+			DettaglioPagamento:  dp,
+		})
+	}
+
+	balance := inv.Totals.Payable
+	if inv.Totals.Due != nil {
+		balance = *inv.Totals.Due
+	}
+	if payment.Instructions != nil && (len(payment.Advances) == 0 || !balance.IsZero()) {
+		dp, err := newDettaglioPagamento(payment, inv.IssueDate, balance)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, &datiPagamento{
+			CondizioniPagamento: determinePaymentConditions(payment),
+			DettaglioPagamento:  dp,
+		})
 	}
 
-	return &datiPagamento{
-		CondizioniPagamento: determinePaymentConditions(inv.Payment),
-		DettaglioPagamento:  dp,
-	}, nil
+	return blocks, nil
 }
 
-func newDettalgioPagamento(inv *bill.Invoice) ([]*dettaglioPagamento, error) {
+// newDettaglioPagamentoAdvances prepares a DettaglioPagamento for each
+// advance, using the payment means of the instructions for those that do not
+// define their own.
+func newDettaglioPagamentoAdvances(payment *bill.Payment) ([]*dettaglioPagamento, error) {
+	var dp []*dettaglioPagamento
+	for _, a := range payment.Advances {
+		key := a.Key
+		if key == cbc.KeyEmpty && payment.Instructions != nil {
+			key = payment.Instructions.Key
+		}
+		if key == cbc.KeyEmpty {
+			return nil, fmt.Errorf("advance '%s' without payment means key", a.Description)
+		}
+		code, err := findCodeModalitaPagamento(key)
+		if err != nil {
+			return nil, err
+		}
+
+		d := &dettaglioPagamento{
+			ModalitaPagamento: code,
+			ImportoPagamento:  formatAmount(&a.Amount),
+			CodicePagamento:   a.Ref,
+		}
+		if payment.Payee != nil {
+			d.Beneficiario = payment.Payee.Name
+		}
+		if a.Date != nil {
+			d.DataScadenzaPagamento = a.Date.String()
+		}
+		if ct := a.CreditTransfer; ct != nil {
+			d.setCreditTransfer(ct)
+		}
+		dp = append(dp, d)
+	}
+	return dp, nil
+}
+
+// newDettaglioPagamento prepares the payments of the balance according to
+// the instructions, with one for each due date. The number of days to pay
+// is given from the date of reference of the terms, together with the
+// conditions for early and late payments.
+func newDettaglioPagamento(payment *bill.Payment, issueDate cal.Date, balance num.Amount) ([]*dettaglioPagamento, error) {
 	var dp []*dettaglioPagamento
-	payment := inv.Payment
 
 	codeModalitaPagamento, err := findCodeModalitaPagamento(payment.Instructions.Key)
 	if err != nil {
 		return nil, err
 	}
+	details := newDettaglioPagamentoDetails(payment)
+	details.ModalitaPagamento = codeModalitaPagamento
+	conditions, err := newPaymentConditions(payment.Instructions.Meta)
+	if err != nil {
+		return nil, err
+	}
 
 	// First check if there are multiple due dates, and if so, create a
 	// DettaglioPagamento for each one.
-	if terms := payment.Terms; terms != nil {
-		for _, dueDate := range payment.Terms.DueDates {
-			dp = append(dp, &dettaglioPagamento{
-				ModalitaPagamento:     codeModalitaPagamento,
-				DataScadenzaPagamento: dueDate.Date.String(), // ISO 8601 YYYY-MM-DD format
-				ImportoPagamento:      formatAmount(&dueDate.Amount),
-			})
+	terms := payment.Terms
+	if terms != nil && len(terms.DueDates) > 0 {
+		ref := termsReferenceDate(terms.Key, issueDate)
+		for _, dueDate := range terms.DueDates {
+			d := *details
+			d.DataScadenzaPagamento = dueDate.Date.String() // ISO 8601 YYYY-MM-DD format
+			d.ImportoPagamento = formatAmount(&dueDate.Amount)
+			if days := dueDate.Date.DaysSince(ref.Date); days >= 0 && days <= maxGiorniTerminiPagamento {
+				d.DataRiferimentoTerminiPagamento = ref.String()
+				d.GiorniTerminiPagamento = strconv.Itoa(days)
+			}
+			if err := d.setConditions(conditions, dueDate.Amount, dueDate.Date); err != nil {
+				return nil, err
+			}
+			dp = append(dp, &d)
 		}
+		return dp, conditions.check()
 	}
 
 	// If there are no due dates, then a single DettaglioPagamento is created
-	// with the total payable amount.
-	if len(dp) == 0 {
-		dp = append(dp, &dettaglioPagamento{
-			ModalitaPagamento: codeModalitaPagamento,
-			ImportoPagamento:  formatAmount(&inv.Totals.Payable),
-		})
+	// with the amount due, payable immediately with instant terms.
+	details.ImportoPagamento = formatAmount(&balance)
+	if terms != nil && terms.Key == pay.TermKeyInstant {
+		details.DataRiferimentoTerminiPagamento = issueDate.String()
+		details.GiorniTerminiPagamento = "0"
 	}
+	if err := details.setConditions(conditions, balance, nil); err != nil {
+		return nil, err
+	}
+	return append(dp, details), conditions.check()
+}
 
-	return dp, nil
+// termsReferenceDate provides the date from which the days to pay are
+// counted: the end of the month of issue for end of month terms, or the
+// issue date otherwise.
+func termsReferenceDate(key cbc.Key, issueDate cal.Date) cal.Date {
+	if key == pay.TermKeyEndOfMonth {
+		return endOfMonth(issueDate)
+	}
+	return issueDate
+}
+
+// endOfMonth provides the last day of the month of the date
+func endOfMonth(date cal.Date) cal.Date {
+	return cal.MakeDate(date.Year, date.Month, 1).Add(0, 1, -1)
+}
+
+// paymentConditions contains the conditions for early and late payments
+// defined in the meta data of the payment instructions, and whether they
+// were applied to any of the payments.
+type paymentConditions struct {
+	meta                cbc.Meta
+	earlyDate, lateDate *cal.Date
+	earlyUsed, lateUsed bool
+}
+
+// newPaymentConditions reads the dates of the conditions for early and late
+// payments from the meta data.
+func newPaymentConditions(meta cbc.Meta) (*paymentConditions, error) {
+	pc := &paymentConditions{meta: meta}
+	var err error
+	if pc.earlyDate, err = parseMetaDate(meta, MetaKeyEarlyPaymentDate); err != nil {
+		return nil, err
+	}
+	if pc.lateDate, err = parseMetaDate(meta, MetaKeyLatePaymentDate); err != nil {
+		return nil, err
+	}
+	return pc, nil
+}
+
+// check ensures the conditions limited by date applied to some payment
+func (pc *paymentConditions) check() error {
+	if pc.earlyDate != nil && !pc.earlyUsed {
+		return fmt.Errorf("%s: not before any due date", MetaKeyEarlyPaymentDate)
+	}
+	if pc.lateDate != nil && !pc.lateUsed {
+		return fmt.Errorf("%s: before every due date", MetaKeyLatePaymentDate)
+	}
+	return nil
+}
+
+// setConditions sets the discount for early payments and the penalty for
+// late ones, calculating percentages over the amount of the payment. When
+// the payment has a due date, the discount only applies if the limit to pay
+// early is before it, and the penalty only if it starts after it.
+func (d *dettaglioPagamento) setConditions(pc *paymentConditions, amount num.Amount, due *cal.Date) error {
+	var err error
+	if pc.earlyDate == nil || due == nil || pc.earlyDate.Before(due.Date) {
+		if d.ScontoPagamentoAnticipato, err = paymentCondition(pc.meta, MetaKeyEarlyPaymentDiscount, amount); err != nil {
+			return err
+		}
+		if pc.earlyDate != nil {
+			d.DataLimitePagamentoAnticipato = pc.earlyDate.String()
+			pc.earlyUsed = true
+		}
+	}
+	if pc.lateDate == nil || due == nil || pc.lateDate.After(due.Date) {
+		if d.PenalitaPagamentiRitardati, err = paymentCondition(pc.meta, MetaKeyLatePaymentPenalty, amount); err != nil {
+			return err
+		}
+		if pc.lateDate != nil {
+			d.DataDecorrenzaPenale = pc.lateDate.String()
+			pc.lateUsed = true
+		}
+	}
+	return nil
+}
+
+// paymentCondition provides the amount of the condition in the meta data,
+// which may be a percentage of the amount given.
+func paymentCondition(meta cbc.Meta, key cbc.Key, amount num.Amount) (string, error) {
+	v := strings.TrimSpace(meta[key])
+	if v == "" {
+		return "", nil
+	}
+	if strings.HasSuffix(v, "%") {
+		p, err := num.PercentageFromString(v)
+		if err != nil {
+			return "", fmt.Errorf("%s: invalid percentage '%s'", key, v)
+		}
+		a := p.Of(amount).Rescale(2)
+		return formatAmount(&a), nil
+	}
+	a, err := num.AmountFromString(v)
+	if err != nil {
+		return "", fmt.Errorf("%s: invalid amount '%s'", key, v)
+	}
+	return formatAmount(&a), nil
+}
+
+// newDettaglioPagamentoDetails prepares the details shared by all the
+// payments: the payee and how to pay them. Only the first credit transfer
+// account can be included. FatturaPA has no fields for card details, the
+// address of online payments, nor the creditor ID of direct debits.
+func newDettaglioPagamentoDetails(payment *bill.Payment) *dettaglioPagamento {
+	d := new(dettaglioPagamento)
+	if payment.Payee != nil {
+		d.Beneficiario = payment.Payee.Name
+	}
+
+	instr := payment.Instructions
+	d.CodicePagamento = instr.Ref
+	switch {
+	case len(instr.CreditTransfer) > 0:
+		d.setCreditTransfer(instr.CreditTransfer[0])
+	case instr.DirectDebit != nil:
+		// The account debited, when provided as an IBAN, and the mandate
+		// reference unless another payment code is given.
+		if iban := normalizeIBAN(instr.DirectDebit.Account); ibanPattern.MatchString(iban) {
+			d.IBAN = iban
+		}
+		if d.CodicePagamento == "" {
+			d.CodicePagamento = instr.DirectDebit.Ref
+		}
+	case len(instr.Online) > 0:
+		d.IstitutoFinanziario = instr.Online[0].Name
+	}
+	d.setBankCodes()
+
+	return d
 }
 
+// setCreditTransfer sets the details of the bank account to pay to
+func (d *dettaglioPagamento) setCreditTransfer(ct *pay.CreditTransfer) {
+	d.IstitutoFinanziario = ct.Name
+	d.IBAN = normalizeIBAN(ct.IBAN)
+	d.BIC = ct.BIC
+	d.setBankCodes()
+}
+
+// setBankCodes sets the ABI and CAB codes contained in Italian IBANs
+func (d *dettaglioPagamento) setBankCodes() {
+	if m := ibanPatternIT.FindStringSubmatch(d.IBAN); m != nil {
+		d.ABI = m[1]
+		d.CAB = m[2]
+	}
+}
+
+// normalizeIBAN removes the spaces used to group the characters of IBANs
+func normalizeIBAN(iban string) string {
+	return strings.ToUpper(strings.Join(strings.Fields(iban), ""))
+}
+
+// findCodeModalitaPagamento provides the ModalitaPagamento of the payment
+// means key. Keys with extensions not defined by the regime, such as
+// "credit-transfer+sepa", use the code of the key they extend.
 func findCodeModalitaPagamento(key cbc.Key) (string, error) {
 	keyDef := findPaymentKeyDefinition(key)
 
 	if keyDef == nil {
+		if i := strings.LastIndex(key.String(), cbc.KeySeparator); i > 0 {
+			return findCodeModalitaPagamento(key[:i])
+		}
 		return "", fmt.Errorf("ModalitaPagamento Code not found for payment method key '%s'", key)
 	}
 
@@ -107,3 +387,261 @@ func determinePaymentConditions(payment *bill.Payment) string {
 		return condizioniPagamentoFull
 	}
 }
+
+func findPaymentMeansKey(code string) (cbc.Key, error) {
+	for _, keyDef := range regime.PaymentMeansKeys {
+		if c, err := findCodeModalitaPagamento(keyDef.Key); err == nil && c == code {
+			return keyDef.Key, nil
+		}
+	}
+
+	return cbc.KeyEmpty, fmt.Errorf("payment method key not found for ModalitaPagamento '%s'", code)
+}
+
+// goblPayment reads back the payment details. TP03 blocks contain the
+// advances already paid when followed by others, or when on their own, i.e.
+// invoices fully paid in advance, while a TP03 block after others contains
+// the balance to be paid in advance. The rest contain the balance, using the
+// instructions of the first one.
+func goblPayment(blocks []*datiPagamento, issueDate cal.Date) (*bill.Payment, error) {
+	payment := new(bill.Payment)
+	var dueDates []*pay.DueDate
+	var advanced bool
+	var termKey cbc.Key
+	for i, dp := range blocks {
+		if len(dp.DettaglioPagamento) == 0 {
+			continue
+		}
+		if dp.CondizioniPagamento == condizioniPagamentoAdvance && (len(blocks) == 1 || i < len(blocks)-1) {
+			advances, err := goblPaymentAdvances(dp)
+			if err != nil {
+				return nil, err
+			}
+			payment.Advances = append(payment.Advances, advances...)
+			continue
+		}
+
+		if payment.Instructions == nil {
+			key, err := findPaymentMeansKey(dp.DettaglioPagamento[0].ModalitaPagamento)
+			if err != nil {
+				return nil, err
+			}
+			payment.Instructions = goblPaymentInstructions(key, dp.DettaglioPagamento[0])
+			if payment.Instructions.Meta, err = goblPaymentConditions(dp.DettaglioPagamento); err != nil {
+				return nil, err
+			}
+			if name := dp.DettaglioPagamento[0].Beneficiario; name != "" {
+				payment.Payee = &org.Party{Name: name}
+			}
+			advanced = dp.CondizioniPagamento == condizioniPagamentoAdvance
+			termKey = goblPaymentTermKey(dp.DettaglioPagamento, issueDate)
+		}
+
+		for _, d := range dp.DettaglioPagamento {
+			date, err := d.dueDate()
+			if err != nil {
+				return nil, err
+			}
+			if date == nil {
+				continue
+			}
+			amount, err := parseAmount(d.ImportoPagamento)
+			if err != nil {
+				return nil, fmt.Errorf("ImportoPagamento: %w", err)
+			}
+			dueDates = append(dueDates, &pay.DueDate{
+				Date:   date,
+				Amount: amount,
+			})
+		}
+	}
+
+	if payment.Instructions == nil && len(payment.Advances) == 0 {
+		return nil, nil
+	}
+
+	switch {
+	case advanced:
+		payment.Terms = &pay.Terms{
+			Key:      pay.TermKeyAdvanced,
+			DueDates: dueDates,
+		}
+	case termKey == pay.TermKeyInstant:
+		payment.Terms = &pay.Terms{
+			Key: pay.TermKeyInstant,
+		}
+	case termKey != cbc.KeyEmpty && len(dueDates) > 0:
+		payment.Terms = &pay.Terms{
+			Key:      termKey,
+			DueDates: dueDates,
+		}
+	case len(dueDates) > 0:
+		payment.Terms = &pay.Terms{
+			Key:      pay.TermKeyDueDate,
+			DueDates: dueDates,
+		}
+	}
+
+	return payment, nil
+}
+
+// goblPaymentTermKey provides the key of the payment terms given in days:
+// instant when they are all due in 0 days from the issue date, without other
+// due date, or end of month when the days are counted from the end of the
+// month of issue.
+func goblPaymentTermKey(details []*dettaglioPagamento, issueDate cal.Date) cbc.Key {
+	instant, eom := true, true
+	for _, d := range details {
+		if d.DataScadenzaPagamento != "" || d.GiorniTerminiPagamento != "0" || d.DataRiferimentoTerminiPagamento != issueDate.String() {
+			instant = false
+		}
+		if d.GiorniTerminiPagamento == "" || d.DataRiferimentoTerminiPagamento != endOfMonth(issueDate).String() {
+			eom = false
+		}
+	}
+	switch {
+	case instant:
+		return pay.TermKeyInstant
+	case eom && endOfMonth(issueDate) != issueDate:
+		return pay.TermKeyEndOfMonth
+	}
+	return cbc.KeyEmpty
+}
+
+// dueDate provides the date the payment is due, which when missing is
+// calculated from the payment terms in days, if any.
+func (d *dettaglioPagamento) dueDate() (*cal.Date, error) {
+	if d.DataScadenzaPagamento != "" {
+		date, err := parseDate(d.DataScadenzaPagamento)
+		if err != nil {
+			return nil, fmt.Errorf("DataScadenzaPagamento: %w", err)
+		}
+		return &date, nil
+	}
+	if d.DataRiferimentoTerminiPagamento == "" || d.GiorniTerminiPagamento == "" {
+		return nil, nil
+	}
+	date, err := parseDate(d.DataRiferimentoTerminiPagamento)
+	if err != nil {
+		return nil, fmt.Errorf("DataRiferimentoTerminiPagamento: %w", err)
+	}
+	days, err := strconv.Atoi(d.GiorniTerminiPagamento)
+	if err != nil {
+		return nil, fmt.Errorf("GiorniTerminiPagamento: parsing days '%s': %w", d.GiorniTerminiPagamento, err)
+	}
+	date = date.Add(0, 0, days)
+	return &date, nil
+}
+
+// goblPaymentConditions reads back the conditions for early and late
+// payments as meta data, from the first payment that has each of them.
+// Discounts and penalties are read back as the amounts of that payment, as
+// the percentages they may have been calculated from are not kept.
+func goblPaymentConditions(details []*dettaglioPagamento) (cbc.Meta, error) {
+	meta := make(cbc.Meta)
+	for _, d := range details {
+		if d.ScontoPagamentoAnticipato != "" && meta[MetaKeyEarlyPaymentDiscount] == "" {
+			a, err := parseAmount(d.ScontoPagamentoAnticipato)
+			if err != nil {
+				return nil, fmt.Errorf("ScontoPagamentoAnticipato: %w", err)
+			}
+			meta[MetaKeyEarlyPaymentDiscount] = a.String()
+		}
+		if d.DataLimitePagamentoAnticipato != "" && meta[MetaKeyEarlyPaymentDate] == "" {
+			date, err := parseDate(d.DataLimitePagamentoAnticipato)
+			if err != nil {
+				return nil, fmt.Errorf("DataLimitePagamentoAnticipato: %w", err)
+			}
+			meta[MetaKeyEarlyPaymentDate] = date.String()
+		}
+		if d.PenalitaPagamentiRitardati != "" && meta[MetaKeyLatePaymentPenalty] == "" {
+			a, err := parseAmount(d.PenalitaPagamentiRitardati)
+			if err != nil {
+				return nil, fmt.Errorf("PenalitaPagamentiRitardati: %w", err)
+			}
+			meta[MetaKeyLatePaymentPenalty] = a.String()
+		}
+		if d.DataDecorrenzaPenale != "" && meta[MetaKeyLatePaymentDate] == "" {
+			date, err := parseDate(d.DataDecorrenzaPenale)
+			if err != nil {
+				return nil, fmt.Errorf("DataDecorrenzaPenale: %w", err)
+			}
+			meta[MetaKeyLatePaymentDate] = date.String()
+		}
+	}
+	if len(meta) == 0 {
+		return nil, nil
+	}
+	return meta, nil
+}
+
+// goblPaymentAdvances reads back the advances already paid
+func goblPaymentAdvances(dp *datiPagamento) ([]*pay.Advance, error) {
+	var advances []*pay.Advance
+	for _, d := range dp.DettaglioPagamento {
+		key, err := findPaymentMeansKey(d.ModalitaPagamento)
+		if err != nil {
+			return nil, err
+		}
+		amount, err := parseAmount(d.ImportoPagamento)
+		if err != nil {
+			return nil, fmt.Errorf("ImportoPagamento: %w", err)
+		}
+		a := &pay.Advance{
+			Key:         key,
+			Ref:         d.CodicePagamento,
+			Description: advanceDescription,
+			Amount:      amount,
+		}
+		if d.DataScadenzaPagamento != "" {
+			date, err := parseDate(d.DataScadenzaPagamento)
+			if err != nil {
+				return nil, fmt.Errorf("DataScadenzaPagamento: %w", err)
+			}
+			a.Date = &date
+		}
+		if d.IBAN != "" || d.BIC != "" {
+			a.CreditTransfer = &pay.CreditTransfer{
+				IBAN: d.IBAN,
+				BIC:  d.BIC,
+				Name: d.IstitutoFinanziario,
+			}
+		}
+		advances = append(advances, a)
+	}
+	return advances, nil
+}
+
+// goblPaymentInstructions reads back the details of how to pay
+func goblPaymentInstructions(key cbc.Key, d *dettaglioPagamento) *pay.Instructions {
+	instr := &pay.Instructions{
+		Key: key,
+	}
+
+	if key.HasPrefix(pay.MeansKeyDirectDebit) {
+		if d.IBAN != "" || d.CodicePagamento != "" {
+			instr.DirectDebit = &pay.DirectDebit{
+				Ref:     d.CodicePagamento,
+				Account: d.IBAN,
+			}
+		}
+		return instr
+	}
+
+	instr.Ref = d.CodicePagamento
+	if d.IBAN != "" || d.BIC != "" || d.IstitutoFinanziario != "" {
+		if key.HasPrefix(pay.MeansKeyOnline) && d.IBAN == "" && d.BIC == "" {
+			// Online payments require an address that is not available
+			return instr
+		}
+		instr.CreditTransfer = []*pay.CreditTransfer{
+			{
+				IBAN: d.IBAN,
+				BIC:  d.BIC,
+				Name: d.IstitutoFinanziario,
+			},
+		}
+	}
+
+	return instr
+}
@@ -1,9 +1,21 @@
 package fatturapa_test
 
 import (
+	"bytes"
+	"fmt"
 	"testing"
 
+	fatturapa "github.com/invopop/gobl.fatturapa"
 	"github.com/invopop/gobl.fatturapa/test"
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cal"
+	"github.com/invopop/gobl/cbc"
+	"github.com/invopop/gobl/l10n"
+	"github.com/invopop/gobl/num"
+	"github.com/invopop/gobl/org"
+	"github.com/invopop/gobl/pay"
+	"github.com/invopop/gobl/regimes/it"
+	"github.com/invopop/gobl/tax"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -14,7 +26,7 @@ func TestPaymentsSimple(t *testing.T) {
 		doc, err := test.ConvertFromGOBL(env)
 		require.NoError(t, err)
 
-		dp := doc.FatturaElettronicaBody[0].DatiPagamento
+		dp := doc.FatturaElettronicaBody[0].DatiPagamento[0]
 
 		require.NotNil(t, dp)
 		assert.Equal(t, "TP02", dp.CondizioniPagamento)
@@ -30,7 +42,7 @@ func TestPaymentsMultipleDueDates(t *testing.T) {
 		doc, err := test.ConvertFromGOBL(env)
 		require.NoError(t, err)
 
-		dp := doc.FatturaElettronicaBody[0].DatiPagamento
+		dp := doc.FatturaElettronicaBody[0].DatiPagamento[0]
 
 		require.NotNil(t, dp)
 		assert.Equal(t, "TP01", dp.CondizioniPagamento)
@@ -43,3 +55,506 @@ func TestPaymentsMultipleDueDates(t *testing.T) {
 		assert.Equal(t, "544.40", dp.DettaglioPagamento[1].ImportoPagamento)
 	})
 }
+
+func TestPaymentsBankDetails(t *testing.T) {
+	t.Run("should include the credit transfer details", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-irpef.json")
+		doc, err := test.ConvertFromGOBL(env)
+		require.NoError(t, err)
+
+		dp := doc.FatturaElettronicaBody[0].DatiPagamento[0]
+		require.Len(t, dp.DettaglioPagamento, 2)
+		for _, d := range dp.DettaglioPagamento {
+			assert.Equal(t, "BANCA POPOLARE DI MILANO", d.IstitutoFinanziario)
+			assert.Equal(t, "IT60X0542811101000000123456", d.IBAN)
+			assert.Equal(t, "05428", d.ABI)
+			assert.Equal(t, "11101", d.CAB)
+			assert.Equal(t, "BCITITMM", d.BIC)
+		}
+	})
+
+	t.Run("should include the payee and payment code", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-irpef.json")
+		test.ModifyInvoice(env, func(inv *bill.Invoice) {
+			inv.Payment.Payee = &org.Party{Name: "Factor S.p.A."}
+			inv.Payment.Instructions.Ref = "RF18539007547034"
+			inv.Payment.Instructions.CreditTransfer[0].IBAN = "DE89 3704 0044 0532 0130 00"
+		})
+		doc, err := test.ConvertFromGOBL(env)
+		require.NoError(t, err)
+
+		d := doc.FatturaElettronicaBody[0].DatiPagamento[0].DettaglioPagamento[0]
+		assert.Equal(t, "Factor S.p.A.", d.Beneficiario)
+		assert.Equal(t, "RF18539007547034", d.CodicePagamento)
+		assert.Equal(t, "DE89370400440532013000", d.IBAN)
+		assert.Empty(t, d.ABI)
+		assert.Empty(t, d.CAB)
+	})
+
+	t.Run("should include the direct debit mandate", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simple.json")
+		test.ModifyInvoice(env, func(inv *bill.Invoice) {
+			inv.Payment.Instructions = &pay.Instructions{
+				Key: pay.MeansKeyDirectDebit.With(it.MeansKeySEPACore),
+				DirectDebit: &pay.DirectDebit{
+					Ref:      "MANDATE-001",
+					Creditor: "IT98ZZZ0000012345678901",
+					Account:  "IT60X0542811101000000123456",
+				},
+			}
+		})
+		doc, err := test.ConvertFromGOBL(env)
+		require.NoError(t, err)
+
+		d := doc.FatturaElettronicaBody[0].DatiPagamento[0].DettaglioPagamento[0]
+		assert.Equal(t, "MP20", d.ModalitaPagamento)
+		assert.Equal(t, "MANDATE-001", d.CodicePagamento)
+		assert.Equal(t, "IT60X0542811101000000123456", d.IBAN)
+		assert.Equal(t, "05428", d.ABI)
+	})
+
+	t.Run("should include the online payment provider", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simple.json")
+		test.ModifyInvoice(env, func(inv *bill.Invoice) {
+			inv.Payment.Instructions = &pay.Instructions{
+				Key: pay.MeansKeyOnline.With(it.MeansKeyPagoPA),
+				Ref: "301000000012345678",
+				Online: []*pay.Online{
+					{Name: "PagoPA", Address: "https://checkout.pagopa.it"},
+				},
+			}
+		})
+		doc, err := test.ConvertFromGOBL(env)
+		require.NoError(t, err)
+
+		d := doc.FatturaElettronicaBody[0].DatiPagamento[0].DettaglioPagamento[0]
+		assert.Equal(t, "MP23", d.ModalitaPagamento)
+		assert.Equal(t, "PagoPA", d.IstitutoFinanziario)
+		assert.Equal(t, "301000000012345678", d.CodicePagamento)
+	})
+
+	t.Run("should read back the bank details", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-irpef.json")
+		test.ModifyInvoice(env, func(inv *bill.Invoice) {
+			inv.Payment.Payee = &org.Party{Name: "Factor S.p.A."}
+			inv.Payment.Instructions.Ref = "RF18539007547034"
+		})
+		orig := env.Extract().(*bill.Invoice)
+
+		c := test.NewConverter()
+		doc, err := test.ConvertFromGOBL(env, c)
+		require.NoError(t, err)
+		data, err := doc.Bytes()
+		require.NoError(t, err)
+		out, err := c.ConvertToGOBL(bytes.NewReader(data))
+		require.NoError(t, err)
+		inv := out.Extract().(*bill.Invoice)
+
+		require.NotNil(t, inv.Payment.Payee)
+		assert.Equal(t, "Factor S.p.A.", inv.Payment.Payee.Name)
+		assert.Equal(t, "RF18539007547034", inv.Payment.Instructions.Ref)
+		assert.Equal(t, orig.Payment.Instructions.CreditTransfer, inv.Payment.Instructions.CreditTransfer)
+	})
+}
+
+func TestPaymentsAdvances(t *testing.T) {
+	advance := func(inv *bill.Invoice) {
+		date := cal.MakeDate(2023, 3, 1)
+		inv.Payment.Advances = []*pay.Advance{
+			{
+				Date:        &date,
+				Key:         pay.MeansKeyCard,
+				Ref:         "AUTH-001",
+				Description: "Deposit",
+				Amount:      num.MakeAmount(38840, 2),
+			},
+		}
+		inv.Payment.Instructions = &pay.Instructions{
+			Key: pay.MeansKeyCreditTransfer,
+			CreditTransfer: []*pay.CreditTransfer{
+				{IBAN: "IT60X0542811101000000123456", Name: "Banca Popolare"},
+			},
+		}
+	}
+
+	t.Run("should report advances and the balance separately", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simple.json")
+		test.ModifyInvoice(env, advance)
+		require.NoError(t, env.Calculate())
+
+		doc, err := test.ConvertFromGOBL(env)
+		require.NoError(t, err)
+
+		dp := doc.FatturaElettronicaBody[0].DatiPagamento
+		require.Len(t, dp, 2)
+
+		assert.Equal(t, "TP03", dp[0].CondizioniPagamento)
+		require.Len(t, dp[0].DettaglioPagamento, 1)
+		d := dp[0].DettaglioPagamento[0]
+		assert.Equal(t, "MP08", d.ModalitaPagamento)
+		assert.Equal(t, "2023-03-01", d.DataScadenzaPagamento)
+		assert.Equal(t, "388.40", d.ImportoPagamento)
+		assert.Equal(t, "AUTH-001", d.CodicePagamento)
+		assert.Empty(t, d.IBAN)
+
+		assert.Equal(t, "TP02", dp[1].CondizioniPagamento)
+		require.Len(t, dp[1].DettaglioPagamento, 1)
+		d = dp[1].DettaglioPagamento[0]
+		assert.Equal(t, "MP05", d.ModalitaPagamento)
+		assert.Equal(t, "1000.00", d.ImportoPagamento)
+		assert.Equal(t, "IT60X0542811101000000123456", d.IBAN)
+	})
+
+	t.Run("should skip the balance when fully paid", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simple.json")
+		test.ModifyInvoice(env, func(inv *bill.Invoice) {
+			advance(inv)
+			inv.Payment.Advances[0].Amount = num.MakeAmount(138840, 2)
+		})
+		require.NoError(t, env.Calculate())
+
+		doc, err := test.ConvertFromGOBL(env)
+		require.NoError(t, err)
+
+		dp := doc.FatturaElettronicaBody[0].DatiPagamento
+		require.Len(t, dp, 1)
+		assert.Equal(t, "TP03", dp[0].CondizioniPagamento)
+		assert.Equal(t, "1388.40", dp[0].DettaglioPagamento[0].ImportoPagamento)
+
+		data, err := doc.Bytes()
+		require.NoError(t, err)
+		out, err := test.NewConverter().ConvertToGOBL(bytes.NewReader(data))
+		require.NoError(t, err)
+		inv := out.Extract().(*bill.Invoice)
+
+		assert.Nil(t, inv.Payment.Instructions)
+		assert.Nil(t, inv.Payment.Terms)
+		require.Len(t, inv.Payment.Advances, 1)
+		assert.Equal(t, "1388.40", inv.Payment.Advances[0].Amount.String())
+		assert.Equal(t, "2023-03-01", inv.Payment.Advances[0].Date.String())
+		require.NotNil(t, inv.Totals.Due)
+		assert.Equal(t, "0.00", inv.Totals.Due.String())
+	})
+
+	t.Run("should leave advances without a date undated", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simple.json")
+		test.ModifyInvoice(env, func(inv *bill.Invoice) {
+			advance(inv)
+			inv.Payment.Advances[0].Date = nil
+		})
+		require.NoError(t, env.Calculate())
+
+		c := test.NewConverter()
+		doc, err := test.ConvertFromGOBL(env, c)
+		require.NoError(t, err)
+		d := doc.FatturaElettronicaBody[0].DatiPagamento[0].DettaglioPagamento[0]
+		assert.Empty(t, d.DataScadenzaPagamento)
+
+		data, err := doc.Bytes()
+		require.NoError(t, err)
+		out, err := c.ConvertToGOBL(bytes.NewReader(data))
+		require.NoError(t, err)
+		inv := out.Extract().(*bill.Invoice)
+
+		require.Len(t, inv.Payment.Advances, 1)
+		assert.Nil(t, inv.Payment.Advances[0].Date)
+		assert.Equal(t, "388.40", inv.Payment.Advances[0].Amount.String())
+	})
+
+	t.Run("should use the instructions for advances without key", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simple.json")
+		test.ModifyInvoice(env, func(inv *bill.Invoice) {
+			advance(inv)
+			inv.Payment.Advances[0].Key = ""
+		})
+		require.NoError(t, env.Calculate())
+
+		doc, err := test.ConvertFromGOBL(env)
+		require.NoError(t, err)
+		assert.Equal(t, "MP05", doc.FatturaElettronicaBody[0].DatiPagamento[0].DettaglioPagamento[0].ModalitaPagamento)
+	})
+
+	t.Run("should read back advances", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simple.json")
+		test.ModifyInvoice(env, advance)
+		require.NoError(t, env.Calculate())
+
+		c := test.NewConverter()
+		doc, err := test.ConvertFromGOBL(env, c)
+		require.NoError(t, err)
+		data, err := doc.Bytes()
+		require.NoError(t, err)
+		out, err := c.ConvertToGOBL(bytes.NewReader(data))
+		require.NoError(t, err)
+		inv := out.Extract().(*bill.Invoice)
+
+		require.Len(t, inv.Payment.Advances, 1)
+		a := inv.Payment.Advances[0]
+		assert.Equal(t, pay.MeansKeyCard, a.Key)
+		assert.Equal(t, "AUTH-001", a.Ref)
+		assert.Equal(t, "388.40", a.Amount.String())
+		assert.Equal(t, "2023-03-01", a.Date.String())
+		assert.Equal(t, pay.MeansKeyCreditTransfer, inv.Payment.Instructions.Key)
+		require.NotNil(t, inv.Totals.Due)
+		assert.Equal(t, "1000.00", inv.Totals.Due.String())
+	})
+}
+
+func TestPaymentsConditions(t *testing.T) {
+	conditions := func(inv *bill.Invoice) {
+		inv.Payment.Instructions.Meta = cbc.Meta{
+			fatturapa.MetaKeyEarlyPaymentDiscount: "2%",
+			fatturapa.MetaKeyEarlyPaymentDate:     "2023-03-12",
+			fatturapa.MetaKeyLatePaymentPenalty:   "15.00",
+			fatturapa.MetaKeyLatePaymentDate:      "2023-05-02",
+		}
+	}
+
+	t.Run("should include the days of the payment terms", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-irpef.json")
+		doc, err := test.ConvertFromGOBL(env)
+		require.NoError(t, err)
+
+		dp := doc.FatturaElettronicaBody[0].DatiPagamento[0].DettaglioPagamento
+		require.Len(t, dp, 2)
+		assert.Equal(t, "2023-03-02", dp[0].DataRiferimentoTerminiPagamento)
+		assert.Equal(t, "0", dp[0].GiorniTerminiPagamento)
+		assert.Equal(t, "2023-03-02", dp[1].DataRiferimentoTerminiPagamento)
+		assert.Equal(t, "31", dp[1].GiorniTerminiPagamento)
+	})
+
+	t.Run("should include early and late payment conditions", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-irpef.json")
+		test.ModifyInvoice(env, conditions)
+		doc, err := test.ConvertFromGOBL(env)
+		require.NoError(t, err)
+
+		dp := doc.FatturaElettronicaBody[0].DatiPagamento[0].DettaglioPagamento
+		require.Len(t, dp, 2)
+		for _, d := range dp {
+			assert.Equal(t, "15.00", d.PenalitaPagamentiRitardati)
+			assert.Equal(t, "2023-05-02", d.DataDecorrenzaPenale)
+		}
+	})
+
+	t.Run("should only offer discounts before the due date", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-irpef.json")
+		test.ModifyInvoice(env, conditions)
+		doc, err := test.ConvertFromGOBL(env)
+		require.NoError(t, err)
+
+		dp := doc.FatturaElettronicaBody[0].DatiPagamento[0].DettaglioPagamento
+		require.Len(t, dp, 2)
+		assert.Empty(t, dp[0].ScontoPagamentoAnticipato)
+		assert.Empty(t, dp[0].DataLimitePagamentoAnticipato)
+		assert.Equal(t, "10.89", dp[1].ScontoPagamentoAnticipato)
+		assert.Equal(t, "2023-03-12", dp[1].DataLimitePagamentoAnticipato)
+	})
+
+	t.Run("should only apply penalties after the due date", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-irpef.json")
+		test.ModifyInvoice(env, func(inv *bill.Invoice) {
+			conditions(inv)
+			inv.Payment.Instructions.Meta[fatturapa.MetaKeyLatePaymentDate] = "2023-03-15"
+		})
+		doc, err := test.ConvertFromGOBL(env)
+		require.NoError(t, err)
+
+		dp := doc.FatturaElettronicaBody[0].DatiPagamento[0].DettaglioPagamento
+		require.Len(t, dp, 2)
+		assert.Equal(t, "2023-03-15", dp[0].DataDecorrenzaPenale)
+		assert.Empty(t, dp[1].PenalitaPagamentiRitardati)
+		assert.Empty(t, dp[1].DataDecorrenzaPenale)
+	})
+
+	t.Run("should reject conditions that apply to no payment", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-irpef.json")
+		test.ModifyInvoice(env, func(inv *bill.Invoice) {
+			conditions(inv)
+			inv.Payment.Instructions.Meta[fatturapa.MetaKeyEarlyPaymentDate] = "2023-04-02"
+		})
+		_, err := test.ConvertFromGOBL(env)
+		assert.EqualError(t, err, "early-payment-date: not before any due date")
+
+		test.ModifyInvoice(env, func(inv *bill.Invoice) {
+			conditions(inv)
+			inv.Payment.Instructions.Meta[fatturapa.MetaKeyLatePaymentDate] = "2023-03-01"
+		})
+		_, err = test.ConvertFromGOBL(env)
+		assert.EqualError(t, err, "late-payment-date: before every due date")
+	})
+
+	t.Run("should count the days from the end of the month", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-irpef.json")
+		test.ModifyInvoice(env, func(inv *bill.Invoice) {
+			inv.Payment.Terms.Key = pay.TermKeyEndOfMonth
+			inv.Payment.Terms.DueDates[0].Date = cal.NewDate(2023, 3, 31)
+		})
+
+		c := test.NewConverter()
+		doc, err := test.ConvertFromGOBL(env, c)
+		require.NoError(t, err)
+
+		dp := doc.FatturaElettronicaBody[0].DatiPagamento[0].DettaglioPagamento
+		require.Len(t, dp, 2)
+		assert.Equal(t, "2023-03-31", dp[0].DataRiferimentoTerminiPagamento)
+		assert.Equal(t, "0", dp[0].GiorniTerminiPagamento)
+		assert.Equal(t, "2023-03-31", dp[1].DataRiferimentoTerminiPagamento)
+		assert.Equal(t, "2", dp[1].GiorniTerminiPagamento)
+
+		data, err := doc.Bytes()
+		require.NoError(t, err)
+		out, err := c.ConvertToGOBL(bytes.NewReader(data))
+		require.NoError(t, err)
+		inv := out.Extract().(*bill.Invoice)
+		assert.Equal(t, pay.TermKeyEndOfMonth, inv.Payment.Terms.Key)
+		assert.Len(t, inv.Payment.Terms.DueDates, 2)
+	})
+
+	t.Run("should pay instant terms in 0 days", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simple.json")
+		test.ModifyInvoice(env, func(inv *bill.Invoice) {
+			inv.Payment.Terms = &pay.Terms{Key: pay.TermKeyInstant}
+		})
+
+		c := test.NewConverter()
+		doc, err := test.ConvertFromGOBL(env, c)
+		require.NoError(t, err)
+
+		d := doc.FatturaElettronicaBody[0].DatiPagamento[0].DettaglioPagamento[0]
+		assert.Equal(t, "2023-03-02", d.DataRiferimentoTerminiPagamento)
+		assert.Equal(t, "0", d.GiorniTerminiPagamento)
+		assert.Empty(t, d.DataScadenzaPagamento)
+
+		data, err := doc.Bytes()
+		require.NoError(t, err)
+		out, err := c.ConvertToGOBL(bytes.NewReader(data))
+		require.NoError(t, err)
+		inv := out.Extract().(*bill.Invoice)
+		assert.Equal(t, &pay.Terms{Key: pay.TermKeyInstant}, inv.Payment.Terms)
+	})
+
+	t.Run("should apply the conditions to the amount due", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simple.json")
+		test.ModifyInvoice(env, conditions)
+		doc, err := test.ConvertFromGOBL(env)
+		require.NoError(t, err)
+
+		d := doc.FatturaElettronicaBody[0].DatiPagamento[0].DettaglioPagamento[0]
+		assert.Equal(t, "27.77", d.ScontoPagamentoAnticipato)
+		assert.Empty(t, d.GiorniTerminiPagamento)
+		assert.NoError(t, doc.Validate())
+	})
+
+	t.Run("should reject invalid conditions", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simple.json")
+		test.ModifyInvoice(env, func(inv *bill.Invoice) {
+			inv.Payment.Instructions.Meta = cbc.Meta{fatturapa.MetaKeyEarlyPaymentDiscount: "two"}
+		})
+		_, err := test.ConvertFromGOBL(env)
+		assert.EqualError(t, err, "early-payment-discount: invalid amount 'two'")
+
+		test.ModifyInvoice(env, func(inv *bill.Invoice) {
+			inv.Payment.Instructions.Meta = cbc.Meta{fatturapa.MetaKeyLatePaymentDate: "May 2nd"}
+		})
+		_, err = test.ConvertFromGOBL(env)
+		assert.ErrorContains(t, err, "late-payment-date: parsing date 'May 2nd'")
+	})
+
+	t.Run("should read back the conditions", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-irpef.json")
+		test.ModifyInvoice(env, conditions)
+
+		c := test.NewConverter()
+		doc, err := test.ConvertFromGOBL(env, c)
+		require.NoError(t, err)
+		data, err := doc.Bytes()
+		require.NoError(t, err)
+		out, err := c.ConvertToGOBL(bytes.NewReader(data))
+		require.NoError(t, err)
+		inv := out.Extract().(*bill.Invoice)
+
+		// Percentages are read back as the amount of the first payment
+		assert.Equal(t, cbc.Meta{
+			fatturapa.MetaKeyEarlyPaymentDiscount: "10.89",
+			fatturapa.MetaKeyEarlyPaymentDate:     "2023-03-12",
+			fatturapa.MetaKeyLatePaymentPenalty:   "15.00",
+			fatturapa.MetaKeyLatePaymentDate:      "2023-05-02",
+		}, inv.Payment.Instructions.Meta)
+	})
+
+	t.Run("should calculate due dates from the days", func(t *testing.T) {
+		data := bytes.Replace(
+			test.LoadExampleFile("bare-minimum.xml"),
+			[]byte("<DataScadenzaPagamento>2017-02-18</DataScadenzaPagamento>"),
+			[]byte("<DataRiferimentoTerminiPagamento>2023-01-31</DataRiferimentoTerminiPagamento><GiorniTerminiPagamento>30</GiorniTerminiPagamento>"),
+			1,
+		)
+		env, err := test.NewConverter().ConvertToGOBL(bytes.NewReader(data))
+		require.NoError(t, err)
+		inv := env.Extract().(*bill.Invoice)
+
+		require.NotNil(t, inv.Payment.Terms)
+		require.Len(t, inv.Payment.Terms.DueDates, 1)
+		assert.Equal(t, "2023-03-02", inv.Payment.Terms.DueDates[0].Date.String())
+	})
+}
+
+func TestPaymentsModalitaPagamento(t *testing.T) {
+	t.Run("should map every payment means key", func(t *testing.T) {
+		codes := make(map[string]bool)
+		for _, kd := range tax.RegimeFor(l10n.IT).PaymentMeansKeys {
+			env := test.LoadTestFile("invoice-simple.json")
+			test.ModifyInvoice(env, func(inv *bill.Invoice) {
+				inv.Payment.Instructions = &pay.Instructions{Key: kd.Key}
+			})
+			doc, err := test.ConvertFromGOBL(env)
+			require.NoError(t, err, kd.Key)
+
+			code := doc.FatturaElettronicaBody[0].DatiPagamento[0].DettaglioPagamento[0].ModalitaPagamento
+			assert.Equal(t, kd.Map[it.KeyFatturaPAModalitaPagamento].String(), code, kd.Key)
+			codes[code] = true
+		}
+		for i := 1; i <= 23; i++ {
+			assert.True(t, codes[fmt.Sprintf("MP%02d", i)], "MP%02d", i)
+		}
+	})
+
+	t.Run("should read back every code", func(t *testing.T) {
+		for i := 1; i <= 23; i++ {
+			code := fmt.Sprintf("MP%02d", i)
+			data := bytes.Replace(test.LoadExampleFile("bare-minimum.xml"), []byte("<ModalitaPagamento>MP01<"), []byte("<ModalitaPagamento>"+code+"<"), 1)
+
+			env, err := test.NewConverter().ConvertToGOBL(bytes.NewReader(data))
+			require.NoError(t, err, code)
+			inv := env.Extract().(*bill.Invoice)
+
+			env = test.LoadTestFile("invoice-simple.json")
+			test.ModifyInvoice(env, func(orig *bill.Invoice) {
+				orig.Payment.Instructions = inv.Payment.Instructions
+			})
+			doc, err := test.ConvertFromGOBL(env)
+			require.NoError(t, err, code)
+			assert.Equal(t, code, doc.FatturaElettronicaBody[0].DatiPagamento[0].DettaglioPagamento[0].ModalitaPagamento)
+		}
+	})
+
+	t.Run("should use the code of extended keys", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simple.json")
+		test.ModifyInvoice(env, func(inv *bill.Invoice) {
+			inv.Payment.Instructions.Key = pay.MeansKeyCreditTransfer.With("sepa")
+		})
+		doc, err := test.ConvertFromGOBL(env)
+		require.NoError(t, err)
+		assert.Equal(t, "MP05", doc.FatturaElettronicaBody[0].DatiPagamento[0].DettaglioPagamento[0].ModalitaPagamento)
+	})
+
+	t.Run("should reject unknown keys", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simple.json")
+		test.ModifyInvoice(env, func(inv *bill.Invoice) {
+			inv.Payment.Instructions.Key = pay.MeansKeyAny
+		})
+		_, err := test.ConvertFromGOBL(env)
+		assert.ErrorContains(t, err, "ModalitaPagamento Code not found for payment method key 'any'")
+	})
+}
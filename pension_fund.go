@@ -0,0 +1,117 @@
+package fatturapa
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cbc"
+	"github.com/invopop/gobl/regimes/it"
+	"github.com/invopop/gobl/tax"
+)
+
+// ChargeKeyPensionFund is used to identify the invoice charges that represent
+// the contribution to a professional pension fund (cassa previdenziale). The
+// charge code must contain the TipoCassa code of the fund, from TC01 to TC22.
+const ChargeKeyPensionFund cbc.Key = "pension-fund"
+
+var tipoCassaRegexp = regexp.MustCompile(`^TC(0[1-9]|1[0-9]|2[0-2])$`)
+
+// datiCassaPrevidenziale contains data about the contribution to a
+// professional pension fund.
+type datiCassaPrevidenziale struct {
+	TipoCassa              string
+	AlCassa                string
+	ImportoContributoCassa string
+	ImponibileCassa        string `xml:",omitempty"`
+	AliquotaIVA            string
+	Ritenuta               string `xml:",omitempty"`
+	Natura                 string `xml:",omitempty"`
+}
+
+func newDatiCassaPrevidenziale(inv *bill.Invoice) ([]*datiCassaPrevidenziale, error) {
+	var dcp []*datiCassaPrevidenziale
+
+	for _, charge := range inv.Charges {
+		if charge.Key != ChargeKeyPensionFund {
+			continue
+		}
+		if !tipoCassaRegexp.MatchString(charge.Code) {
+			return nil, fmt.Errorf("charge %d: invalid pension fund code '%s', expected TC01 to TC22", charge.Index, charge.Code)
+		}
+		if charge.Percent == nil {
+			return nil, fmt.Errorf("charge %d: pension fund requires a percentage", charge.Index)
+		}
+
+		base := inv.Totals.Sum
+		if charge.Base != nil {
+			base = *charge.Base
+		}
+		base = base.Rescale(2)
+		amount := charge.Amount.Rescale(2)
+
+		d := &datiCassaPrevidenziale{
+			TipoCassa:              charge.Code,
+			AlCassa:                formatPercentage(charge.Percent),
+			ImportoContributoCassa: formatAmount(&amount),
+			ImponibileCassa:        formatAmount(&base),
+		}
+		if vat := charge.Taxes.Get(tax.CategoryVAT); vat != nil {
+			d.AliquotaIVA = formatPercentage(vat.Percent)
+			d.Natura = vat.Ext[it.ExtKeySDINature].String()
+		} else {
+			d.AliquotaIVA = formatPercentage(nil)
+		}
+		if hasRetainedTaxes(charge.Taxes) {
+			d.Ritenuta = ritenutaYes
+		}
+
+		dcp = append(dcp, d)
+	}
+
+	return dcp, nil
+}
+
+func goblPensionFundCharges(dcp []*datiCassaPrevidenziale, retained *retainedTaxAllocator) ([]*bill.Charge, error) {
+	var charges []*bill.Charge
+
+	for _, d := range dcp {
+		percent, err := parsePercentage(d.AlCassa)
+		if err != nil {
+			return nil, fmt.Errorf("AlCassa: %w", err)
+		}
+
+		amount, err := parseAmount(d.ImportoContributoCassa)
+		if err != nil {
+			return nil, fmt.Errorf("ImportoContributoCassa: %w", err)
+		}
+
+		charge := &bill.Charge{
+			Key:     ChargeKeyPensionFund,
+			Code:    d.TipoCassa,
+			Percent: percent,
+			Amount:  amount,
+		}
+		if d.ImponibileCassa != "" {
+			base, err := parseAmount(d.ImponibileCassa)
+			if err != nil {
+				return nil, fmt.Errorf("ImponibileCassa: %w", err)
+			}
+			charge.Base = &base
+		}
+
+		vat, err := goblVAT(d.AliquotaIVA, d.Natura)
+		if err != nil {
+			return nil, err
+		}
+		charge.Taxes = tax.Set{vat}
+
+		if d.Ritenuta == ritenutaYes {
+			charge.Taxes = append(charge.Taxes, retained.combos(amount)...)
+		}
+
+		charges = append(charges, charge)
+	}
+
+	return charges, nil
+}
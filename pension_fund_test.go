@@ -0,0 +1,152 @@
+package fatturapa_test
+
+import (
+	"bytes"
+	"testing"
+
+	fatturapa "github.com/invopop/gobl.fatturapa"
+	"github.com/invopop/gobl.fatturapa/test"
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/num"
+	"github.com/invopop/gobl/regimes/it"
+	"github.com/invopop/gobl/tax"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDatiCassaPrevidenziale(t *testing.T) {
+	pensionFund := func(inv *bill.Invoice) {
+		inv.Charges = append(inv.Charges, &bill.Charge{
+			Key:     fatturapa.ChargeKeyPensionFund,
+			Code:    "TC22",
+			Percent: num.NewPercentage(4, 2),
+			Taxes: tax.Set{
+				{
+					Category: tax.CategoryVAT,
+					Rate:     tax.RateStandard,
+				},
+				{
+					Category: it.TaxCategoryIRPEF,
+					Percent:  num.NewPercentage(20, 2),
+					Ext: tax.Extensions{
+						it.ExtKeySDIRetainedTax: "A",
+					},
+				},
+			},
+		})
+	}
+
+	t.Run("should be empty without pension fund charges", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-irpef.json")
+		doc, err := test.ConvertFromGOBL(env)
+		require.NoError(t, err)
+
+		assert.Empty(t, doc.FatturaElettronicaBody[0].DatiGenerali.DatiGeneraliDocumento.DatiCassaPrevidenziale)
+	})
+
+	t.Run("should contain the pension fund contribution", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-irpef.json")
+		test.ModifyInvoice(env, pensionFund)
+		require.NoError(t, env.Calculate())
+
+		doc, err := test.ConvertFromGOBL(env)
+		require.NoError(t, err)
+
+		dgd := doc.FatturaElettronicaBody[0].DatiGenerali.DatiGeneraliDocumento
+		require.Len(t, dgd.DatiCassaPrevidenziale, 1)
+		dcp := dgd.DatiCassaPrevidenziale[0]
+		assert.Equal(t, "TC22", dcp.TipoCassa)
+		assert.Equal(t, "4.00", dcp.AlCassa)
+		assert.Equal(t, "68.80", dcp.ImportoContributoCassa)
+		assert.Equal(t, "1720.00", dcp.ImponibileCassa)
+		assert.Equal(t, "22.00", dcp.AliquotaIVA)
+		assert.Equal(t, "SI", dcp.Ritenuta)
+		assert.Empty(t, dcp.Natura)
+
+		for _, sm := range dgd.ScontoMaggiorazione {
+			assert.NotEqual(t, "68.80", sm.Importo)
+		}
+	})
+
+	t.Run("should use the charge base", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-irpef.json")
+		test.ModifyInvoice(env, func(inv *bill.Invoice) {
+			pensionFund(inv)
+			base := num.MakeAmount(100000, 2)
+			inv.Charges[len(inv.Charges)-1].Base = &base
+		})
+		require.NoError(t, env.Calculate())
+
+		doc, err := test.ConvertFromGOBL(env)
+		require.NoError(t, err)
+
+		dcp := doc.FatturaElettronicaBody[0].DatiGenerali.DatiGeneraliDocumento.DatiCassaPrevidenziale[0]
+		assert.Equal(t, "1000.00", dcp.ImponibileCassa)
+		assert.Equal(t, "40.00", dcp.ImportoContributoCassa)
+	})
+
+	t.Run("should include the VAT nature", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-irpef.json")
+		test.ModifyInvoice(env, func(inv *bill.Invoice) {
+			pensionFund(inv)
+			inv.Charges[len(inv.Charges)-1].Taxes = tax.Set{
+				{
+					Category: tax.CategoryVAT,
+					Rate:     tax.RateExempt,
+					Ext: tax.Extensions{
+						it.ExtKeySDINature: "N4",
+					},
+				},
+			}
+		})
+		require.NoError(t, env.Calculate())
+
+		doc, err := test.ConvertFromGOBL(env)
+		require.NoError(t, err)
+
+		dcp := doc.FatturaElettronicaBody[0].DatiGenerali.DatiGeneraliDocumento.DatiCassaPrevidenziale[0]
+		assert.Equal(t, "0.00", dcp.AliquotaIVA)
+		assert.Equal(t, "N4", dcp.Natura)
+		assert.Empty(t, dcp.Ritenuta)
+	})
+
+	t.Run("should require a valid fund code", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-irpef.json")
+		test.ModifyInvoice(env, func(inv *bill.Invoice) {
+			pensionFund(inv)
+			inv.Charges[len(inv.Charges)-1].Code = "TC23"
+		})
+
+		_, err := test.ConvertFromGOBL(env)
+		assert.ErrorContains(t, err, "invalid pension fund code 'TC23'")
+	})
+
+	t.Run("should be imported as a charge", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-irpef.json")
+		test.ModifyInvoice(env, pensionFund)
+		require.NoError(t, env.Calculate())
+
+		c := test.NewConverter()
+		doc, err := test.ConvertFromGOBL(env, c)
+		require.NoError(t, err)
+		data, err := doc.Bytes()
+		require.NoError(t, err)
+
+		out, err := c.ConvertToGOBL(bytes.NewReader(data))
+		require.NoError(t, err)
+		inv := out.Extract().(*bill.Invoice)
+
+		var charge *bill.Charge
+		for _, ch := range inv.Charges {
+			if ch.Key == fatturapa.ChargeKeyPensionFund {
+				charge = ch
+			}
+		}
+		require.NotNil(t, charge)
+		assert.Equal(t, "TC22", charge.Code)
+		assert.Equal(t, "4.0%", charge.Percent.String())
+		assert.Equal(t, "68.80", charge.Amount.String())
+		assert.Equal(t, "22.0%", charge.Taxes.Get(tax.CategoryVAT).Percent.String())
+		assert.NotNil(t, charge.Taxes.Get(it.TaxCategoryIRPEF))
+	})
+}
@@ -0,0 +1,83 @@
+package fatturapa
+
+import (
+	"fmt"
+
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cbc"
+	"github.com/invopop/gobl/org"
+)
+
+// datiDocumentiCorrelati contains references to other documents related to
+// the invoice, such as purchase orders, contracts or preceding invoices.
+type datiDocumentiCorrelati struct {
+	RiferimentoNumeroLinea    []int  `xml:",omitempty"`
+	IdDocumento               string // nolint:revive
+	Data                      string `xml:",omitempty"`
+	NumItem                   string `xml:",omitempty"`
+	CodiceCommessaConvenzione string `xml:",omitempty"`
+	CodiceCUP                 string `xml:",omitempty"`
+	CodiceCIG                 string `xml:",omitempty"`
+}
+
+// newDatiFattureCollegate prepares the references to preceding invoices. CIG
+// and CUP codes are taken from the ordering identities labelled with the code
+// of the preceding invoice.
+func newDatiFattureCollegate(inv *bill.Invoice) []*datiDocumentiCorrelati {
+	var dfc []*datiDocumentiCorrelati
+
+	for _, p := range inv.Preceding {
+		code := p.Code
+		if p.Series != "" {
+			code = fmt.Sprintf("%s-%s", p.Series, p.Code)
+		}
+
+		d := &datiDocumentiCorrelati{
+			IdDocumento: code,
+			CodiceCUP:   findDocumentIdentity(inv.Ordering, IdentityTypeCUP, code),
+			CodiceCIG:   findDocumentIdentity(inv.Ordering, IdentityTypeCIG, code),
+		}
+		if p.IssueDate != nil {
+			d.Data = p.IssueDate.String()
+		}
+
+		dfc = append(dfc, d)
+	}
+
+	return dfc
+}
+
+func goblPreceding(dfc []*datiDocumentiCorrelati) ([]*bill.Preceding, error) {
+	var preceding []*bill.Preceding
+
+	for _, d := range dfc {
+		p := &bill.Preceding{
+			Code: d.IdDocumento,
+		}
+		if d.Data != "" {
+			date, err := parseDate(d.Data)
+			if err != nil {
+				return nil, fmt.Errorf("DatiFattureCollegate: %w", err)
+			}
+			p.IssueDate = &date
+		}
+		preceding = append(preceding, p)
+	}
+
+	return preceding, nil
+}
+
+// goblPrecedingIdentities provides the CIG and CUP codes of the preceding
+// invoices as ordering identities labelled with the code of each invoice.
+func goblPrecedingIdentities(dfc []*datiDocumentiCorrelati) []*org.Identity {
+	var ids []*org.Identity
+	for _, d := range dfc {
+		if d.CodiceCIG != "" {
+			ids = append(ids, &org.Identity{Label: d.IdDocumento, Type: IdentityTypeCIG, Code: cbc.Code(d.CodiceCIG)})
+		}
+		if d.CodiceCUP != "" {
+			ids = append(ids, &org.Identity{Label: d.IdDocumento, Type: IdentityTypeCUP, Code: cbc.Code(d.CodiceCUP)})
+		}
+	}
+	return ids
+}
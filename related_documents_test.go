@@ -0,0 +1,94 @@
+package fatturapa_test
+
+import (
+	"bytes"
+	"testing"
+
+	fatturapa "github.com/invopop/gobl.fatturapa"
+	"github.com/invopop/gobl.fatturapa/test"
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cal"
+	"github.com/invopop/gobl/cbc"
+	"github.com/invopop/gobl/org"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDatiFattureCollegate(t *testing.T) {
+	creditNote := func(inv *bill.Invoice) {
+		inv.Type = bill.InvoiceTypeCreditNote
+		inv.Preceding = []*bill.Preceding{
+			{
+				Series:    "SAMPLE",
+				Code:      "001",
+				IssueDate: cal.NewDate(2023, 1, 15),
+			},
+		}
+		inv.Ordering = &bill.Ordering{
+			Identities: []*org.Identity{
+				{Label: "SAMPLE-001", Type: fatturapa.IdentityTypeCIG, Code: "1234567890"},
+				{Label: "SAMPLE-001", Type: fatturapa.IdentityTypeCUP, Code: "J12B34000560001"},
+			},
+		}
+	}
+
+	t.Run("should be empty without preceding invoices", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simple.json")
+		doc, err := test.ConvertFromGOBL(env)
+		require.NoError(t, err)
+
+		assert.Empty(t, doc.FatturaElettronicaBody[0].DatiGenerali.DatiFattureCollegate)
+	})
+
+	t.Run("should contain the preceding invoice references", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simple.json")
+		test.ModifyInvoice(env, creditNote)
+
+		doc, err := test.ConvertFromGOBL(env)
+		require.NoError(t, err)
+
+		dg := doc.FatturaElettronicaBody[0].DatiGenerali
+		assert.Equal(t, "TD04", dg.DatiGeneraliDocumento.TipoDocumento)
+		dfc := dg.DatiFattureCollegate
+		require.Len(t, dfc, 1)
+		assert.Equal(t, "SAMPLE-001", dfc[0].IdDocumento)
+		assert.Equal(t, "2023-01-15", dfc[0].Data)
+		assert.Equal(t, "1234567890", dfc[0].CodiceCIG)
+		assert.Equal(t, "J12B34000560001", dfc[0].CodiceCUP)
+	})
+
+	t.Run("should fail for credit notes without preceding invoices", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simple.json")
+		test.ModifyInvoice(env, func(inv *bill.Invoice) {
+			inv.Type = bill.InvoiceTypeCreditNote
+		})
+
+		_, err := test.ConvertFromGOBL(env)
+		assert.EqualError(t, err, "TD04 documents require a preceding invoice reference")
+	})
+
+	t.Run("should be imported as preceding invoices", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simple.json")
+		test.ModifyInvoice(env, creditNote)
+
+		c := test.NewConverter()
+		doc, err := test.ConvertFromGOBL(env, c)
+		require.NoError(t, err)
+		data, err := doc.Bytes()
+		require.NoError(t, err)
+
+		out, err := c.ConvertToGOBL(bytes.NewReader(data))
+		require.NoError(t, err)
+		inv := out.Extract().(*bill.Invoice)
+
+		assert.Equal(t, bill.InvoiceTypeCreditNote, inv.Type)
+		require.Len(t, inv.Preceding, 1)
+		assert.Equal(t, "SAMPLE-001", inv.Preceding[0].Code)
+		assert.Equal(t, "2023-01-15", inv.Preceding[0].IssueDate.String())
+		require.NotNil(t, inv.Ordering)
+		require.Len(t, inv.Ordering.Identities, 2)
+		assert.Equal(t, "SAMPLE-001", inv.Ordering.Identities[0].Label)
+		assert.Equal(t, fatturapa.IdentityTypeCIG, inv.Ordering.Identities[0].Type)
+		assert.Equal(t, cbc.Code("1234567890"), inv.Ordering.Identities[0].Code)
+	})
+}
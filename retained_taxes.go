@@ -5,6 +5,7 @@ import (
 
 	"github.com/invopop/gobl/bill"
 	"github.com/invopop/gobl/cbc"
+	"github.com/invopop/gobl/num"
 	"github.com/invopop/gobl/regimes/it"
 	"github.com/invopop/gobl/tax"
 )
@@ -73,3 +74,96 @@ func findCodeTipoRitenuta(cat cbc.Code) (string, error) {
 
 	return code.String(), nil
 }
+
+func findRetainedCategory(code string) (cbc.Code, error) {
+	for _, cat := range regime.Categories {
+		if !cat.Retained {
+			continue
+		}
+		if c, err := findCodeTipoRitenuta(cat.Code); err == nil && c == code {
+			return cat.Code, nil
+		}
+	}
+
+	return "", fmt.Errorf("could not find tax category for TipoRitenuta %s", code)
+}
+
+// retainedTaxAllocator is used when importing documents to assign the
+// retained taxes defined in the general document data to the lines flagged
+// with Ritenuta. FatturaPA does not state which rate applies to each line,
+// so when several rates exist for the same category, each line is given the
+// first rate whose remaining amount can still cover it.
+type retainedTaxAllocator struct {
+	categories []cbc.Code
+	rates      map[cbc.Code][]*retainedTaxRate
+}
+
+type retainedTaxRate struct {
+	percent   *num.Percentage
+	ext       tax.Extensions
+	remaining num.Amount
+}
+
+func newRetainedTaxAllocator(dr []*datiRitenuta) (*retainedTaxAllocator, error) {
+	a := &retainedTaxAllocator{
+		rates: make(map[cbc.Code][]*retainedTaxRate),
+	}
+
+	for _, r := range dr {
+		cat, err := findRetainedCategory(r.TipoRitenuta)
+		if err != nil {
+			return nil, err
+		}
+
+		percent, err := parsePercentage(r.AliquotaRitenuta)
+		if err != nil {
+			return nil, fmt.Errorf("AliquotaRitenuta: %w", err)
+		}
+
+		amount, err := parseAmount(r.ImportoRitenuta)
+		if err != nil {
+			return nil, fmt.Errorf("ImportoRitenuta: %w", err)
+		}
+
+		rate := &retainedTaxRate{
+			percent:   percent,
+			remaining: amount,
+		}
+		if r.CausalePagamento != "" {
+			rate.ext = tax.Extensions{
+				it.ExtKeySDIRetainedTax: tax.ExtValue(r.CausalePagamento),
+			}
+		}
+
+		if _, ok := a.rates[cat]; !ok {
+			a.categories = append(a.categories, cat)
+		}
+		a.rates[cat] = append(a.rates[cat], rate)
+	}
+
+	return a, nil
+}
+
+func (a *retainedTaxAllocator) combos(total num.Amount) []*tax.Combo {
+	var combos []*tax.Combo
+
+	for _, cat := range a.categories {
+		rates := a.rates[cat]
+		rate := rates[0]
+		for _, r := range rates {
+			if r.percent.Of(total).Rescale(2).Compare(r.remaining) <= 0 {
+				rate = r
+				break
+			}
+		}
+		rate.remaining = rate.remaining.Subtract(rate.percent.Of(total).Rescale(2))
+
+		combos = append(combos, &tax.Combo{
+			Category: cat,
+			Percent:  rate.percent,
+			Ext:      rate.ext,
+		})
+	}
+
+	return combos
+}
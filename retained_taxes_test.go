@@ -41,5 +41,16 @@ func TestDatiRitenuta(t *testing.T) {
 			assert.Equal(t, "50.00", dr[1].AliquotaRitenuta)
 			assert.Equal(t, "J", dr[1].CausalePagamento)
 		})
+
+		t.Run("should flag the lines subject to retained taxes", func(t *testing.T) {
+			env := test.LoadTestFile("invoice-irpef.json")
+			doc, err := test.ConvertFromGOBL(env)
+			require.NoError(t, err)
+
+			dl := doc.FatturaElettronicaBody[0].DatiBeniServizi.DettaglioLinee
+
+			assert.Equal(t, "SI", dl[0].Ritenuta)
+			assert.Equal(t, "SI", dl[1].Ritenuta)
+		})
 	})
 }
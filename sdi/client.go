@@ -0,0 +1,209 @@
+package sdi
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Client is used to send files to the SDI using the SDICoop SdIRiceviFile
+// web service (TrasmissioneFatture).
+type Client struct {
+	url  string
+	http *http.Client
+}
+
+// Option is a function that can be passed to NewClient to configure it
+type Option func(*Client)
+
+// Receipt contains the SDI's response to a file sent for processing
+type Receipt struct {
+	// ID assigned by the SDI to the file (IdentificativoSdI)
+	ID string
+	// ReceivedAt contains the date and time the file was received
+	// (DataOraRicezione)
+	ReceivedAt time.Time
+	// Error contains the code of the error when the file was not accepted
+	// (Errore)
+	Error string
+}
+
+// fileSdIAccoglienza is the request of the SdIRiceviFile service
+type fileSdIAccoglienza struct {
+	XMLName   xml.Name `xml:"types:fileSdIAccoglienza"`
+	Namespace string   `xml:"xmlns:types,attr"`
+	NomeFile  string
+	File      *xopData
+}
+
+// incomingFileSdIAccoglienza is used to read the SdIRiceviFile request
+type incomingFileSdIAccoglienza struct {
+	XMLName  xml.Name `xml:"fileSdIAccoglienza"`
+	NomeFile string
+	File     *incomingXOPData
+}
+
+// rispostaSdIRiceviFile is the response of the SdIRiceviFile service
+type rispostaSdIRiceviFile struct {
+	XMLName           xml.Name `xml:"types:rispostaSdIRiceviFile"`
+	Namespace         string   `xml:"xmlns:types,attr"`
+	IdentificativoSdI string   `xml:",omitempty"`
+	DataOraRicezione  string   `xml:",omitempty"`
+	Errore            string   `xml:",omitempty"`
+}
+
+// incomingRispostaSdIRiceviFile is used to read the SdIRiceviFile response
+type incomingRispostaSdIRiceviFile struct {
+	XMLName           xml.Name `xml:"rispostaSdIRiceviFile"`
+	IdentificativoSdI string
+	DataOraRicezione  string
+	Errore            string
+}
+
+// WithHTTPClient will use the given HTTP client to connect to the SDI
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.http = hc
+	}
+}
+
+// WithTLSConfig will use the given TLS configuration to connect to the SDI,
+// which must include the client certificate issued for mutual TLS.
+func WithTLSConfig(config *tls.Config) Option {
+	return func(c *Client) {
+		c.http = &http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: config,
+			},
+		}
+	}
+}
+
+// WithClientCertificate will authenticate with the SDI using the given client
+// certificate. When provided, the root certificates will be used to verify
+// the SDI's server certificate instead of those of the system.
+func WithClientCertificate(cert tls.Certificate, roots *x509.CertPool) Option {
+	return WithTLSConfig(&tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      roots,
+		MinVersion:   tls.VersionTLS12,
+	})
+}
+
+// NewClient returns a new client for the SdIRiceviFile service at the given
+// URL with the given options
+func NewClient(url string, opts ...Option) *Client {
+	c := &Client{
+		url:  url,
+		http: http.DefaultClient,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// SendFile sends the file with the given name to the SDI. The data is expected
+// to contain a signed FatturaPA document, as provided by Document.Bytes. An
+// *Error is returned along with the receipt when the SDI does not accept the
+// file.
+func (c *Client) SendFile(ctx context.Context, name string, data []byte) (*Receipt, error) {
+	if name == "" {
+		return nil, errors.New("file name is required")
+	}
+	if len(data) == 0 {
+		return nil, errors.New("file is empty")
+	}
+
+	cid := newContentID()
+	req := &fileSdIAccoglienza{
+		Namespace: namespaceTrasmissione,
+		NomeFile:  name,
+		File:      newXOPData(cid),
+	}
+
+	res := new(incomingRispostaSdIRiceviFile)
+	m := &message{parts: map[string][]byte{cid: data}}
+	if err := c.call(ctx, soapActionRiceviFile, req, m, res); err != nil {
+		return nil, err
+	}
+
+	receipt := &Receipt{
+		ID:    res.IdentificativoSdI,
+		Error: res.Errore,
+	}
+	if res.DataOraRicezione != "" {
+		t, err := parseDateTime(res.DataOraRicezione)
+		if err != nil {
+			return nil, fmt.Errorf("DataOraRicezione: %w", err)
+		}
+		receipt.ReceivedAt = t
+	}
+	if receipt.Error != "" {
+		return receipt, &Error{Code: receipt.Error}
+	}
+
+	return receipt, nil
+}
+
+// call sends the request in a SOAP message, along with any binary parts of
+// the message provided, and decodes the response.
+func (c *Client) call(ctx context.Context, action string, req any, m *message, res any) error {
+	var err error
+	if m.envelope, err = newEnvelope(req).bytes(); err != nil {
+		return fmt.Errorf("encoding request: %w", err)
+	}
+
+	ct, body, err := m.encode()
+	if err != nil {
+		return fmt.Errorf("encoding request: %w", err)
+	}
+
+	hr, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	hr.Header.Set("Content-Type", ct)
+	hr.Header.Set("SOAPAction", `"`+action+`"`)
+
+	resp, err := c.http.Do(hr)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	// One-way operations don't expect a response
+	ok := resp.StatusCode >= 200 && resp.StatusCode < 300
+	if res == nil && ok {
+		return nil
+	}
+
+	rm, err := readMessage(resp.Header.Get("Content-Type"), resp.Body)
+	if err != nil {
+		return err
+	}
+	if res == nil {
+		res = new(struct{})
+	}
+
+	// SOAP faults are sent with an error status code
+	if err := decodeEnvelope(rm.envelope, res); err != nil {
+		if !ok {
+			return fmt.Errorf("unexpected status %d: %w", resp.StatusCode, err)
+		}
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	return nil
+}
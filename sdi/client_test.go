@@ -0,0 +1,190 @@
+package sdi_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/invopop/gobl.fatturapa/sdi"
+	"github.com/invopop/gobl.fatturapa/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func signedDocument(t *testing.T) []byte {
+	t.Helper()
+	env := test.LoadTestFile("invoice-simple.json")
+	doc, err := test.ConvertFromGOBL(env)
+	require.NoError(t, err)
+	data, err := doc.Bytes()
+	require.NoError(t, err)
+	return data
+}
+
+func newClientCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "SDICoop Client"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestSendFile(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("should send the signed document", func(t *testing.T) {
+		srv := sdi.NewStubServer()
+		defer srv.Close()
+
+		data := signedDocument(t)
+		c := sdi.NewClient(srv.URL)
+		receipt, err := c.SendFile(ctx, "IT12345678903_00001.xml", data)
+		require.NoError(t, err)
+
+		assert.Equal(t, "1", receipt.ID)
+		assert.Empty(t, receipt.Error)
+		assert.WithinDuration(t, time.Now(), receipt.ReceivedAt, time.Minute)
+
+		files := srv.Files()
+		require.Len(t, files, 1)
+		assert.Equal(t, "IT12345678903_00001.xml", files[0].Name)
+		assert.Equal(t, data, files[0].Data)
+	})
+
+	t.Run("should assign sequential IDs", func(t *testing.T) {
+		srv := sdi.NewStubServer()
+		defer srv.Close()
+
+		c := sdi.NewClient(srv.URL)
+		_, err := c.SendFile(ctx, "IT12345678903_00001.xml", []byte("<a/>"))
+		require.NoError(t, err)
+		receipt, err := c.SendFile(ctx, "IT12345678903_00002.xml", []byte("<b/>"))
+		require.NoError(t, err)
+
+		assert.Equal(t, "2", receipt.ID)
+		assert.Len(t, srv.Files(), 2)
+	})
+
+	t.Run("should return SDI errors", func(t *testing.T) {
+		srv := sdi.NewStubServer()
+		defer srv.Close()
+		srv.RespondWithError(sdi.ErrorCodeServiceUnavailable)
+
+		c := sdi.NewClient(srv.URL)
+		receipt, err := c.SendFile(ctx, "IT12345678903_00001.xml", signedDocument(t))
+		require.Error(t, err)
+
+		var se *sdi.Error
+		require.True(t, errors.As(err, &se))
+		assert.Equal(t, sdi.ErrorCodeServiceUnavailable, se.Code)
+		assert.EqualError(t, err, "sdi: EI02: service unavailable")
+		require.NotNil(t, receipt)
+		assert.Equal(t, sdi.ErrorCodeServiceUnavailable, receipt.Error)
+		assert.Empty(t, srv.Files())
+	})
+
+	t.Run("should require file contents", func(t *testing.T) {
+		c := sdi.NewClient("http://localhost")
+		_, err := c.SendFile(ctx, "IT12345678903_00001.xml", nil)
+		assert.EqualError(t, err, "file is empty")
+	})
+
+	t.Run("should authenticate with a client certificate", func(t *testing.T) {
+		cert := newClientCertificate(t)
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		require.NoError(t, err)
+		clientCAs := x509.NewCertPool()
+		clientCAs.AddCert(leaf)
+
+		srv := sdi.NewStubTLSServer(clientCAs)
+		defer srv.Close()
+		roots := x509.NewCertPool()
+		roots.AddCert(srv.Certificate())
+
+		c := sdi.NewClient(srv.URL, sdi.WithClientCertificate(cert, roots))
+		receipt, err := c.SendFile(ctx, "IT12345678903_00001.xml", signedDocument(t))
+		require.NoError(t, err)
+		assert.Equal(t, "1", receipt.ID)
+
+		// Without client certificate
+		c = sdi.NewClient(srv.URL, sdi.WithTLSConfig(&tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12}))
+		_, err = c.SendFile(ctx, "IT12345678903_00002.xml", signedDocument(t))
+		assert.Error(t, err)
+		assert.Len(t, srv.Files(), 1)
+	})
+
+	t.Run("should read MTOM responses", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, `"http://www.fatturapa.it/SdIRiceviFile/RiceviFile"`, r.Header.Get("SOAPAction"))
+			assert.True(t, strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/related"))
+			w.Header().Set("Content-Type", `multipart/related; type="application/xop+xml"; boundary="uuid:abc"; start="<root.message@cxf.apache.org>"; start-info="text/xml"`)
+			_, _ = w.Write([]byte("--uuid:abc\r\n" +
+				"Content-Type: application/xop+xml; charset=UTF-8; type=\"text/xml\"\r\n" +
+				"Content-ID: <root.message@cxf.apache.org>\r\n\r\n" +
+				`<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/"><soap:Body>` +
+				`<ns2:rispostaSdIRiceviFile xmlns:ns2="http://www.fatturapa.gov.it/sdi/ws/trasmissione/v1.0/types">` +
+				`<IdentificativoSdI>111</IdentificativoSdI><DataOraRicezione>2014-12-18T16:08:52.000+01:00</DataOraRicezione>` +
+				`</ns2:rispostaSdIRiceviFile></soap:Body></soap:Envelope>` +
+				"\r\n--uuid:abc--\r\n"))
+		}))
+		defer srv.Close()
+
+		c := sdi.NewClient(srv.URL)
+		receipt, err := c.SendFile(ctx, "IT12345678903_00001.xml", signedDocument(t))
+		require.NoError(t, err)
+		assert.Equal(t, "111", receipt.ID)
+		assert.Equal(t, "2014-12-18T15:08:52Z", receipt.ReceivedAt.UTC().Format(time.RFC3339))
+	})
+
+	t.Run("should limit the size of responses", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/xml")
+			_, _ = w.Write(bytes.Repeat([]byte(" "), 10<<20+1))
+		}))
+		defer srv.Close()
+
+		c := sdi.NewClient(srv.URL)
+		_, err := c.SendFile(ctx, "IT12345678903_00001.xml", signedDocument(t))
+		assert.ErrorContains(t, err, "message exceeds the maximum size")
+	})
+
+	t.Run("should return SOAP faults", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/xml")
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(`<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/"><soap:Body>` +
+				`<soap:Fault><faultcode>soap:Server</faultcode><faultstring>boom</faultstring></soap:Fault>` +
+				`</soap:Body></soap:Envelope>`))
+		}))
+		defer srv.Close()
+
+		c := sdi.NewClient(srv.URL)
+		_, err := c.SendFile(ctx, "IT12345678903_00001.xml", signedDocument(t))
+		assert.ErrorContains(t, err, "SOAP fault soap:Server: boom")
+	})
+}
@@ -0,0 +1,315 @@
+package sdi
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Namespace of the RicezioneFatture service
+const namespaceRicezione = "http://www.fatturapa.gov.it/sdi/ws/ricezione/v1.0/types"
+
+// SOAP actions of the TrasmissioneFatture and RicezioneFatture services, called
+// by the SDI to notify senders and to deliver invoices to their recipients.
+const (
+	soapActionRicevutaConsegna                   = "http://www.fatturapa.it/TrasmissioneFatture/RicevutaConsegna"
+	soapActionNotificaMancataConsegna            = "http://www.fatturapa.it/TrasmissioneFatture/NotificaMancataConsegna"
+	soapActionNotificaScarto                     = "http://www.fatturapa.it/TrasmissioneFatture/NotificaScarto"
+	soapActionNotificaEsito                      = "http://www.fatturapa.it/TrasmissioneFatture/NotificaEsito"
+	soapActionNotificaDecorrenzaTermini          = "http://www.fatturapa.it/TrasmissioneFatture/NotificaDecorrenzaTermini"
+	soapActionAttestazioneTrasmissioneFattura    = "http://www.fatturapa.it/TrasmissioneFatture/AttestazioneTrasmissioneFattura"
+	soapActionRiceviFatture                      = "http://www.fatturapa.it/RicezioneFatture/RiceviFatture"
+	soapActionNotificaDecorrenzaTerminiRecipient = "http://www.fatturapa.it/RicezioneFatture/NotificaDecorrenzaTermini"
+)
+
+// esitoRicezione is the response to the RiceviFatture requests when the
+// invoice has been received (ER01).
+const esitoRicezione = "ER01"
+
+// NotificationType identifies the kind of notification sent by the SDI using
+// the codes included in the notification file names.
+type NotificationType string
+
+// Notification types sent by the SDI
+const (
+	NotificationTypeRC NotificationType = "RC" // Ricevuta di consegna
+	NotificationTypeMC NotificationType = "MC" // Notifica di mancata consegna
+	NotificationTypeNS NotificationType = "NS" // Notifica di scarto
+	NotificationTypeNE NotificationType = "NE" // Notifica esito committente
+	NotificationTypeDT NotificationType = "DT" // Notifica di decorrenza termini
+	NotificationTypeAT NotificationType = "AT" // Attestazione di avvenuta trasmissione della fattura con impossibilità di recapito
+)
+
+var notificationActions = map[string]NotificationType{
+	soapActionRicevutaConsegna:                   NotificationTypeRC,
+	soapActionNotificaMancataConsegna:            NotificationTypeMC,
+	soapActionNotificaScarto:                     NotificationTypeNS,
+	soapActionNotificaEsito:                      NotificationTypeNE,
+	soapActionNotificaDecorrenzaTermini:          NotificationTypeDT,
+	soapActionAttestazioneTrasmissioneFattura:    NotificationTypeAT,
+	soapActionNotificaDecorrenzaTerminiRecipient: NotificationTypeDT,
+}
+
+// Notification contains a notification file sent by the SDI about a file
+// previously sent or received.
+type Notification struct {
+	Type NotificationType
+	// ID assigned by the SDI to the file the notification refers to
+	// (IdentificativoSdI)
+	ID string
+	// FileName of the notification file, e.g. "IT01234567890_11111_RC_001.xml"
+	FileName string
+	// Data contains the notification file
+	Data []byte
+	// Recipient is true when the notification was sent to the recipient of
+	// the invoice using the RicezioneFatture service.
+	Recipient bool
+}
+
+// InboundInvoice contains a file with one or more invoices sent to us by a
+// supplier, along with the metadata file prepared by the SDI.
+type InboundInvoice struct {
+	// ID assigned by the SDI to the file (IdentificativoSdI)
+	ID string
+	// FileName of the invoice file, e.g. "IT01234567890_00001.xml.p7m"
+	FileName string
+	// Data contains the invoice file, which may be signed
+	Data []byte
+	// MetadataFileName of the metadata file, e.g.
+	// "IT01234567890_00001_MT_001.xml"
+	MetadataFileName string
+	// Metadata contains the metadata file
+	Metadata []byte
+}
+
+// Receiver is implemented by applications to process the events sent by the
+// SDI. Returning an error will make the SDI send the event again later.
+type Receiver interface {
+	// ReceiveNotification is called with notifications about the files
+	// sent (TrasmissioneFatture) and received (RicezioneFatture).
+	ReceiveNotification(ctx context.Context, n *Notification) error
+	// ReceiveInvoice is called with the invoices sent to us by suppliers
+	// (RicezioneFatture).
+	ReceiveInvoice(ctx context.Context, inv *InboundInvoice) error
+}
+
+// Handler implements the SDICoop TrasmissioneFatture and RicezioneFatture
+// web services, dispatching the events received to a Receiver. Both services
+// may be served by the same handler, as requests are identified using the
+// SOAPAction header.
+type Handler struct {
+	receiver Receiver
+}
+
+// fileSdI is the request of the notification operations
+type fileSdI struct {
+	XMLName           xml.Name `xml:"types:fileSdI"`
+	Namespace         string   `xml:"xmlns:types,attr"`
+	IdentificativoSdI string
+	NomeFile          string
+	File              *xopData
+}
+
+// incomingFileSdI is used to read the notification operations' requests
+type incomingFileSdI struct {
+	XMLName           xml.Name `xml:"fileSdI"`
+	IdentificativoSdI string
+	NomeFile          string
+	File              *incomingXOPData
+}
+
+// fileSdIConMetadati is the request of the RiceviFatture operation
+type fileSdIConMetadati struct {
+	XMLName           xml.Name `xml:"types:fileSdIConMetadati"`
+	Namespace         string   `xml:"xmlns:types,attr"`
+	IdentificativoSdI string
+	NomeFile          string
+	File              *xopData
+	NomeFileMetadati  string
+	Metadati          *xopData
+}
+
+// incomingFileSdIConMetadati is used to read the RiceviFatture requests
+type incomingFileSdIConMetadati struct {
+	XMLName           xml.Name `xml:"fileSdIConMetadati"`
+	IdentificativoSdI string
+	NomeFile          string
+	File              *incomingXOPData
+	NomeFileMetadati  string
+	Metadati          *incomingXOPData
+}
+
+// rispostaRiceviFatture is the response of the RiceviFatture operation
+type rispostaRiceviFatture struct {
+	XMLName   xml.Name `xml:"types:rispostaRiceviFatture"`
+	Namespace string   `xml:"xmlns:types,attr"`
+	Esito     string
+}
+
+// NewHandler returns a new handler dispatching the SDI events to the given
+// receiver
+func NewHandler(r Receiver) *Handler {
+	return &Handler{receiver: r}
+}
+
+// ServeHTTP handles the requests of the SDI
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	action := strings.Trim(r.Header.Get("SOAPAction"), `"`)
+	body := http.MaxBytesReader(w, r.Body, maxMessageSize)
+	m, err := readMessage(r.Header.Get("Content-Type"), body)
+	if err != nil {
+		var mbe *http.MaxBytesError
+		if errors.As(err, &mbe) {
+			err = errMessageTooLarge
+		}
+		writeFault(w, "soap:Client", err.Error())
+		return
+	}
+
+	if action == soapActionRiceviFatture {
+		inv, err := readInboundInvoice(m)
+		if err != nil {
+			writeFault(w, "soap:Client", err.Error())
+			return
+		}
+		if err := h.receiver.ReceiveInvoice(r.Context(), inv); err != nil {
+			writeFault(w, "soap:Server", err.Error())
+			return
+		}
+		writeResponse(w, &rispostaRiceviFatture{
+			Namespace: namespaceRicezione,
+			Esito:     esitoRicezione,
+		})
+		return
+	}
+
+	typ, ok := notificationActions[action]
+	if !ok {
+		writeFault(w, "soap:Client", fmt.Sprintf("unsupported SOAP action '%s'", action))
+		return
+	}
+
+	n, err := readNotification(m, typ)
+	if err != nil {
+		writeFault(w, "soap:Client", err.Error())
+		return
+	}
+	n.Recipient = action == soapActionNotificaDecorrenzaTerminiRecipient
+	if err := h.receiver.ReceiveNotification(r.Context(), n); err != nil {
+		writeFault(w, "soap:Server", err.Error())
+		return
+	}
+
+	// Notifications are one-way operations
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func readNotification(m *message, typ NotificationType) (*Notification, error) {
+	req := new(incomingFileSdI)
+	if err := decodeEnvelope(m.envelope, req); err != nil {
+		return nil, err
+	}
+
+	n := &Notification{
+		Type:     typ,
+		ID:       req.IdentificativoSdI,
+		FileName: req.NomeFile,
+	}
+	if err := checkFile(n.ID, n.FileName, req.File); err != nil {
+		return nil, err
+	}
+	if err := checkParts(m, req.File); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if n.Data, err = req.File.data(m.parts); err != nil {
+		return nil, fmt.Errorf("File: %w", err)
+	}
+	if len(n.Data) == 0 {
+		return nil, errors.New("empty notification file")
+	}
+
+	return n, nil
+}
+
+func readInboundInvoice(m *message) (*InboundInvoice, error) {
+	req := new(incomingFileSdIConMetadati)
+	if err := decodeEnvelope(m.envelope, req); err != nil {
+		return nil, err
+	}
+
+	inv := &InboundInvoice{
+		ID:               req.IdentificativoSdI,
+		FileName:         req.NomeFile,
+		MetadataFileName: req.NomeFileMetadati,
+	}
+	if err := checkFile(inv.ID, inv.FileName, req.File); err != nil {
+		return nil, err
+	}
+	if inv.MetadataFileName == "" || req.Metadati == nil {
+		return nil, errors.New("missing metadata file")
+	}
+	if err := checkParts(m, req.File, req.Metadati); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if inv.Data, err = req.File.data(m.parts); err != nil {
+		return nil, fmt.Errorf("File: %w", err)
+	}
+	if inv.Metadata, err = req.Metadati.data(m.parts); err != nil {
+		return nil, fmt.Errorf("Metadati: %w", err)
+	}
+	if len(inv.Data) == 0 {
+		return nil, errors.New("empty invoice file")
+	}
+
+	return inv, nil
+}
+
+func checkFile(id, name string, file *incomingXOPData) error {
+	if id == "" {
+		return errors.New("missing IdentificativoSdI")
+	}
+	if name == "" {
+		return errors.New("missing NomeFile")
+	}
+	if file == nil {
+		return errors.New("missing File")
+	}
+	return nil
+}
+
+// checkParts ensures the MIME parts received are exactly those referenced by
+// the xop:Include elements of the envelope.
+func checkParts(m *message, files ...*incomingXOPData) error {
+	refs := make(map[string]bool)
+	for _, f := range files {
+		cid, err := f.contentID()
+		if err != nil {
+			return err
+		}
+		if cid == "" {
+			continue
+		}
+		if _, ok := m.parts[cid]; !ok {
+			return fmt.Errorf("missing MIME part %s", cid)
+		}
+		refs[cid] = true
+	}
+	for cid := range m.parts {
+		if !refs[cid] {
+			return fmt.Errorf("unexpected MIME part %s", cid)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,253 @@
+package sdi_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/invopop/gobl.fatturapa/sdi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testReceiver struct {
+	mu            sync.Mutex
+	notifications []*sdi.Notification
+	invoices      []*sdi.InboundInvoice
+	err           error
+}
+
+func (r *testReceiver) ReceiveNotification(_ context.Context, n *sdi.Notification) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	r.notifications = append(r.notifications, n)
+	return nil
+}
+
+func (r *testReceiver) ReceiveInvoice(_ context.Context, inv *sdi.InboundInvoice) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	r.invoices = append(r.invoices, inv)
+	return nil
+}
+
+func TestHandler(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("should dispatch notifications", func(t *testing.T) {
+		r := new(testReceiver)
+		srv := httptest.NewServer(sdi.NewHandler(r))
+		defer srv.Close()
+		sim := sdi.NewSimulator(srv.URL)
+
+		types := []sdi.NotificationType{
+			sdi.NotificationTypeRC,
+			sdi.NotificationTypeMC,
+			sdi.NotificationTypeNS,
+			sdi.NotificationTypeNE,
+			sdi.NotificationTypeDT,
+			sdi.NotificationTypeAT,
+		}
+		for _, typ := range types {
+			err := sim.SendNotification(ctx, &sdi.Notification{
+				Type:     typ,
+				ID:       "111",
+				FileName: "IT12345678903_00001_" + string(typ) + "_001.xml",
+				Data:     []byte("<notifica/>"),
+			})
+			require.NoError(t, err, string(typ))
+		}
+
+		require.Len(t, r.notifications, len(types))
+		for i, typ := range types {
+			n := r.notifications[i]
+			assert.Equal(t, typ, n.Type)
+			assert.Equal(t, "111", n.ID)
+			assert.Equal(t, "IT12345678903_00001_"+string(typ)+"_001.xml", n.FileName)
+			assert.Equal(t, []byte("<notifica/>"), n.Data)
+			assert.False(t, n.Recipient)
+		}
+	})
+
+	t.Run("should dispatch recipient notifications", func(t *testing.T) {
+		r := new(testReceiver)
+		srv := httptest.NewServer(sdi.NewHandler(r))
+		defer srv.Close()
+		sim := sdi.NewSimulator(srv.URL)
+
+		err := sim.SendNotification(ctx, &sdi.Notification{
+			Type:      sdi.NotificationTypeDT,
+			ID:        "222",
+			FileName:  "IT12345678903_00001_DT_001.xml",
+			Data:      []byte("<notifica/>"),
+			Recipient: true,
+		})
+		require.NoError(t, err)
+
+		require.Len(t, r.notifications, 1)
+		assert.Equal(t, sdi.NotificationTypeDT, r.notifications[0].Type)
+		assert.True(t, r.notifications[0].Recipient)
+	})
+
+	t.Run("should dispatch inbound invoices", func(t *testing.T) {
+		r := new(testReceiver)
+		srv := httptest.NewServer(sdi.NewHandler(r))
+		defer srv.Close()
+		sim := sdi.NewSimulator(srv.URL)
+
+		data := signedDocument(t)
+		err := sim.SendInvoice(ctx, &sdi.InboundInvoice{
+			ID:               "333",
+			FileName:         "IT12345678903_00001.xml",
+			Data:             data,
+			MetadataFileName: "IT12345678903_00001_MT_001.xml",
+			Metadata:         []byte("<metadati/>"),
+		})
+		require.NoError(t, err)
+
+		require.Len(t, r.invoices, 1)
+		inv := r.invoices[0]
+		assert.Equal(t, "333", inv.ID)
+		assert.Equal(t, "IT12345678903_00001.xml", inv.FileName)
+		assert.Equal(t, data, inv.Data)
+		assert.Equal(t, "IT12345678903_00001_MT_001.xml", inv.MetadataFileName)
+		assert.Equal(t, []byte("<metadati/>"), inv.Metadata)
+	})
+
+	t.Run("should return receiver errors as faults", func(t *testing.T) {
+		r := &testReceiver{err: errors.New("database unavailable")}
+		srv := httptest.NewServer(sdi.NewHandler(r))
+		defer srv.Close()
+		sim := sdi.NewSimulator(srv.URL)
+
+		err := sim.SendNotification(ctx, &sdi.Notification{
+			Type:     sdi.NotificationTypeRC,
+			ID:       "111",
+			FileName: "IT12345678903_00001_RC_001.xml",
+			Data:     []byte("<notifica/>"),
+		})
+		assert.ErrorContains(t, err, "database unavailable")
+
+		err = sim.SendInvoice(ctx, &sdi.InboundInvoice{
+			ID:               "333",
+			FileName:         "IT12345678903_00001.xml",
+			Data:             []byte("<fattura/>"),
+			MetadataFileName: "IT12345678903_00001_MT_001.xml",
+			Metadata:         []byte("<metadati/>"),
+		})
+		assert.ErrorContains(t, err, "database unavailable")
+	})
+
+	t.Run("should validate requests", func(t *testing.T) {
+		r := new(testReceiver)
+		srv := httptest.NewServer(sdi.NewHandler(r))
+		defer srv.Close()
+		sim := sdi.NewSimulator(srv.URL)
+
+		err := sim.SendNotification(ctx, &sdi.Notification{
+			Type:     sdi.NotificationTypeRC,
+			FileName: "IT12345678903_00001_RC_001.xml",
+			Data:     []byte("<notifica/>"),
+		})
+		assert.ErrorContains(t, err, "missing IdentificativoSdI")
+
+		err = sim.SendInvoice(ctx, &sdi.InboundInvoice{
+			ID:       "333",
+			FileName: "IT12345678903_00001.xml",
+			Data:     []byte("<fattura/>"),
+		})
+		assert.ErrorContains(t, err, "missing metadata file")
+
+		assert.Empty(t, r.notifications)
+		assert.Empty(t, r.invoices)
+	})
+
+	t.Run("should reject unreferenced MIME parts", func(t *testing.T) {
+		r := new(testReceiver)
+		srv := httptest.NewServer(sdi.NewHandler(r))
+		defer srv.Close()
+
+		body := "--b\r\n" +
+			"Content-Type: application/xop+xml; charset=UTF-8; type=\"text/xml\"\r\n" +
+			"Content-ID: <root>\r\n\r\n" +
+			`<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/"><soap:Body>` +
+			`<types:fileSdI xmlns:types="http://www.fatturapa.gov.it/sdi/ws/trasmissione/v1.0/types">` +
+			`<IdentificativoSdI>111</IdentificativoSdI><NomeFile>IT12345678903_00001_RC_001.xml</NomeFile>` +
+			`<File><xop:Include xmlns:xop="http://www.w3.org/2004/08/xop/include" href="cid:file"/></File>` +
+			`</types:fileSdI></soap:Body></soap:Envelope>` +
+			"\r\n--b\r\nContent-ID: <file>\r\n\r\n<notifica/>" +
+			"\r\n--b\r\nContent-ID: <other>\r\n\r\n<altro/>" +
+			"\r\n--b--\r\n"
+		req, err := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", `multipart/related; type="application/xop+xml"; boundary="b"; start="<root>"`)
+		req.Header.Set("SOAPAction", `"http://www.fatturapa.it/TrasmissioneFatture/RicevutaConsegna"`)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close() // nolint:errcheck
+		data, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+		assert.Contains(t, string(data), "unexpected MIME part other")
+		assert.Empty(t, r.notifications)
+	})
+
+	t.Run("should limit the size of requests", func(t *testing.T) {
+		srv := httptest.NewServer(sdi.NewHandler(new(testReceiver)))
+		defer srv.Close()
+
+		req, err := http.NewRequest(http.MethodPost, srv.URL, bytes.NewReader(bytes.Repeat([]byte(" "), 10<<20+1)))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "text/xml")
+		req.Header.Set("SOAPAction", `"http://www.fatturapa.it/TrasmissioneFatture/RicevutaConsegna"`)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close() // nolint:errcheck
+		data, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+		assert.Contains(t, string(data), "message exceeds the maximum size")
+	})
+
+	t.Run("should reject unknown actions", func(t *testing.T) {
+		srv := httptest.NewServer(sdi.NewHandler(new(testReceiver)))
+		defer srv.Close()
+
+		req, err := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader(
+			`<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/"><soap:Body/></soap:Envelope>`,
+		))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "text/xml")
+		req.Header.Set("SOAPAction", `"http://www.fatturapa.it/Unknown"`)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close() // nolint:errcheck
+
+		assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	})
+
+	t.Run("should only accept POST requests", func(t *testing.T) {
+		srv := httptest.NewServer(sdi.NewHandler(new(testReceiver)))
+		defer srv.Close()
+
+		resp, err := http.Get(srv.URL)
+		require.NoError(t, err)
+		defer resp.Body.Close() // nolint:errcheck
+
+		assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	})
+}
@@ -0,0 +1,390 @@
+package sdi
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Types of the other files exchanged with the SDI, using the codes included
+// in their file names.
+const (
+	NotificationTypeEC NotificationType = "EC" // Notifica di esito committente
+	NotificationTypeSE NotificationType = "SE" // Notifica di scarto esito committente
+	NotificationTypeMT NotificationType = "MT" // File dei metadati
+)
+
+// Outcomes of the recipient of an invoice (Esito committente)
+const (
+	EsitoAccettazione = "EC01" // Accettazione
+	EsitoRifiuto      = "EC02" // Rifiuto
+)
+
+// Reasons for which the SDI rejects a recipient's outcome (Scarto esito
+// committente)
+const (
+	ScartoFormato        = "EN00" // Notifica non conforme al formato
+	ScartoNonAmmissibile = "EN01" // Notifica non ammissibile
+)
+
+var outcomeDescriptions = map[string]string{
+	EsitoAccettazione:    "Accettazione",
+	EsitoRifiuto:         "Rifiuto",
+	ScartoFormato:        "Notifica non conforme al formato",
+	ScartoNonAmmissibile: "Notifica non ammissibile",
+}
+
+// Message is implemented by all the messages exchanged with the SDI
+type Message interface {
+	// MessageType provides the code used in the message's file name
+	MessageType() NotificationType
+	// Errors provides the list of errors reported by the message, if any
+	Errors() []*Errore
+}
+
+// DateTime is used for the timestamps of the messages, which may not include
+// a time zone.
+type DateTime struct {
+	time.Time
+}
+
+// UnmarshalText parses the timestamp
+func (d *DateTime) UnmarshalText(data []byte) error {
+	t, err := parseDateTime(string(data))
+	if err != nil {
+		return err
+	}
+	d.Time = t
+	return nil
+}
+
+// MarshalText formats the timestamp
+func (d DateTime) MarshalText() ([]byte, error) {
+	return []byte(d.Format(time.RFC3339)), nil
+}
+
+// Destinatario identifies the recipient of a file
+type Destinatario struct {
+	Codice      string
+	Descrizione string `xml:",omitempty"`
+}
+
+// RiferimentoArchivio references the archive file that contained the file
+// the message refers to.
+type RiferimentoArchivio struct {
+	IdentificativoSdI string
+	NomeFile          string
+}
+
+// RiferimentoFattura references a specific invoice within a file
+type RiferimentoFattura struct {
+	NumeroFattura    string
+	AnnoFattura      string
+	PosizioneFattura string `xml:",omitempty"`
+}
+
+// Errore contains an error reported by the SDI
+type Errore struct {
+	Codice       string
+	Descrizione  string
+	Suggerimento string `xml:",omitempty"`
+}
+
+// ListaErrori contains the list of errors of a rejection
+type ListaErrori struct {
+	Errore []*Errore
+}
+
+// RicevutaConsegna is sent to the sender when the file has been delivered
+// to the recipient (RC).
+type RicevutaConsegna struct {
+	XMLName             xml.Name `xml:"RicevutaConsegna"`
+	Versione            string   `xml:"versione,attr"`
+	IdentificativoSdI   string
+	NomeFile            string
+	Hash                string
+	DataOraRicezione    DateTime
+	DataOraConsegna     DateTime
+	Destinatario        *Destinatario
+	RiferimentoArchivio *RiferimentoArchivio
+	MessageId           string // nolint:revive
+	PecMessageId        string // nolint:revive
+	Note                string
+}
+
+// NotificaMancataConsegna is sent to the sender when the file could not be
+// delivered to the recipient (MC).
+type NotificaMancataConsegna struct {
+	XMLName             xml.Name `xml:"NotificaMancataConsegna"`
+	Versione            string   `xml:"versione,attr"`
+	IdentificativoSdI   string
+	NomeFile            string
+	Hash                string
+	DataOraRicezione    DateTime
+	RiferimentoArchivio *RiferimentoArchivio
+	Descrizione         string
+	MessageId           string // nolint:revive
+	PecMessageId        string // nolint:revive
+	Note                string
+}
+
+// NotificaScarto is sent to the sender when the file has been rejected (NS)
+type NotificaScarto struct {
+	XMLName             xml.Name `xml:"NotificaScarto"`
+	Versione            string   `xml:"versione,attr"`
+	IdentificativoSdI   string
+	NomeFile            string
+	Hash                string
+	DataOraRicezione    DateTime
+	RiferimentoArchivio *RiferimentoArchivio
+	ListaErrori         *ListaErrori
+	MessageId           string // nolint:revive
+	PecMessageId        string // nolint:revive
+	Note                string
+}
+
+// NotificaEsito is sent to the sender with the outcome communicated by the
+// public administration recipient of the invoice (NE).
+type NotificaEsito struct {
+	XMLName           xml.Name `xml:"NotificaEsito"`
+	Versione          string   `xml:"versione,attr"`
+	IdentificativoSdI string
+	NomeFile          string
+	EsitoCommittente  *EsitoCommittente
+	MessageId         string // nolint:revive
+	PecMessageId      string // nolint:revive
+	Note              string
+}
+
+// NotificaDecorrenzaTermini is sent to both the sender and the recipient when
+// the recipient did not communicate an outcome within 15 days (DT).
+type NotificaDecorrenzaTermini struct {
+	XMLName            xml.Name `xml:"NotificaDecorrenzaTermini"`
+	Versione           string   `xml:"versione,attr"`
+	IdentificativoSdI  string
+	RiferimentoFattura *RiferimentoFattura
+	NomeFile           string
+	Descrizione        string
+	MessageId          string // nolint:revive
+	PecMessageId       string // nolint:revive
+	Note               string
+}
+
+// AttestazioneTrasmissioneFattura is sent to the sender when the file could
+// not be delivered to a public administration recipient (AT).
+type AttestazioneTrasmissioneFattura struct {
+	XMLName             xml.Name `xml:"AttestazioneTrasmissioneFattura"`
+	Versione            string   `xml:"versione,attr"`
+	IdentificativoSdI   string
+	NomeFile            string
+	Hash                string
+	DataOraRicezione    DateTime
+	RiferimentoArchivio *RiferimentoArchivio
+	Destinatario        *Destinatario
+	MessageId           string // nolint:revive
+	PecMessageId        string // nolint:revive
+	Note                string
+	HashFileOriginale   string
+}
+
+// EsitoCommittente contains the outcome communicated by the recipient of an
+// invoice.
+type EsitoCommittente struct {
+	IdentificativoSdI    string
+	RiferimentoFattura   *RiferimentoFattura `xml:",omitempty"`
+	Esito                string
+	Descrizione          string `xml:",omitempty"`
+	MessageIdCommittente string `xml:",omitempty"` // nolint:revive
+}
+
+// NotificaEsitoCommittente is sent by the recipient of the invoice to accept
+// or refuse it (EC).
+type NotificaEsitoCommittente struct {
+	XMLName  xml.Name `xml:"NotificaEsitoCommittente"`
+	Versione string   `xml:"versione,attr"`
+	EsitoCommittente
+}
+
+// ScartoEsitoCommittente is sent to the recipient when the outcome
+// communicated has been rejected (SE).
+type ScartoEsitoCommittente struct {
+	XMLName              xml.Name `xml:"ScartoEsitoCommittente"`
+	Versione             string   `xml:"versione,attr"`
+	IdentificativoSdI    string
+	RiferimentoFattura   *RiferimentoFattura
+	Scarto               string
+	MessageIdCommittente string // nolint:revive
+}
+
+// MetadatiInvioFile is sent to the recipient along with the invoice file (MT)
+type MetadatiInvioFile struct {
+	XMLName            xml.Name `xml:"MetadatiInvioFile"`
+	Versione           string   `xml:"versione,attr"`
+	IdentificativoSdI  string
+	NomeFile           string
+	Hash               string
+	CodiceDestinatario string
+	Formato            string
+	TentativiInvio     int
+	MessageId          string // nolint:revive
+	Note               string
+}
+
+// MessageType provides the code used in the message's file name
+func (m *RicevutaConsegna) MessageType() NotificationType { return NotificationTypeRC }
+
+// MessageType provides the code used in the message's file name
+func (m *NotificaMancataConsegna) MessageType() NotificationType { return NotificationTypeMC }
+
+// MessageType provides the code used in the message's file name
+func (m *NotificaScarto) MessageType() NotificationType { return NotificationTypeNS }
+
+// MessageType provides the code used in the message's file name
+func (m *NotificaEsito) MessageType() NotificationType { return NotificationTypeNE }
+
+// MessageType provides the code used in the message's file name
+func (m *NotificaDecorrenzaTermini) MessageType() NotificationType { return NotificationTypeDT }
+
+// MessageType provides the code used in the message's file name
+func (m *AttestazioneTrasmissioneFattura) MessageType() NotificationType { return NotificationTypeAT }
+
+// MessageType provides the code used in the message's file name
+func (m *NotificaEsitoCommittente) MessageType() NotificationType { return NotificationTypeEC }
+
+// MessageType provides the code used in the message's file name
+func (m *ScartoEsitoCommittente) MessageType() NotificationType { return NotificationTypeSE }
+
+// MessageType provides the code used in the message's file name
+func (m *MetadatiInvioFile) MessageType() NotificationType { return NotificationTypeMT }
+
+// Errors provides no errors, as the file was delivered
+func (m *RicevutaConsegna) Errors() []*Errore { return nil }
+
+// Errors provides the reason the file could not be delivered, if available
+func (m *NotificaMancataConsegna) Errors() []*Errore {
+	if m.Descrizione == "" {
+		return nil
+	}
+	return []*Errore{{Descrizione: m.Descrizione}}
+}
+
+// Errors provides the reasons the file was rejected
+func (m *NotificaScarto) Errors() []*Errore {
+	if m.ListaErrori == nil {
+		return nil
+	}
+	return m.ListaErrori.Errore
+}
+
+// Errors provides the refusal of the recipient, if any
+func (m *NotificaEsito) Errors() []*Errore {
+	if m.EsitoCommittente == nil {
+		return nil
+	}
+	return m.EsitoCommittente.Errors()
+}
+
+// Errors provides no errors, as the terms simply expired
+func (m *NotificaDecorrenzaTermini) Errors() []*Errore { return nil }
+
+// Errors provides no errors, as the file was transmitted
+func (m *AttestazioneTrasmissioneFattura) Errors() []*Errore { return nil }
+
+// Errors provides the refusal of the recipient, if any
+func (m *EsitoCommittente) Errors() []*Errore {
+	if m.Esito != EsitoRifiuto {
+		return nil
+	}
+	return []*Errore{outcomeError(m.Esito, m.Descrizione)}
+}
+
+// Errors provides the reason the outcome was rejected
+func (m *ScartoEsitoCommittente) Errors() []*Errore {
+	return []*Errore{outcomeError(m.Scarto, "")}
+}
+
+// Errors provides no errors, as metadata files do not report errors
+func (m *MetadatiInvioFile) Errors() []*Errore { return nil }
+
+func outcomeError(code, desc string) *Errore {
+	e := &Errore{
+		Codice:      code,
+		Descrizione: outcomeDescriptions[code],
+	}
+	if desc != "" {
+		e.Suggerimento = desc
+	}
+	return e
+}
+
+// ParseMessage parses any of the messages exchanged with the SDI, which
+// can then be accessed with a type switch.
+func ParseMessage(data []byte) (Message, error) {
+	name, err := rootElementName(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var m Message
+	switch name {
+	case "RicevutaConsegna":
+		m = new(RicevutaConsegna)
+	case "NotificaMancataConsegna":
+		m = new(NotificaMancataConsegna)
+	case "NotificaScarto":
+		m = new(NotificaScarto)
+	case "NotificaEsito":
+		m = new(NotificaEsito)
+	case "NotificaDecorrenzaTermini":
+		m = new(NotificaDecorrenzaTermini)
+	case "AttestazioneTrasmissioneFattura":
+		m = new(AttestazioneTrasmissioneFattura)
+	case "NotificaEsitoCommittente":
+		m = new(NotificaEsitoCommittente)
+	case "ScartoEsitoCommittente":
+		m = new(ScartoEsitoCommittente)
+	case "MetadatiInvioFile":
+		m = new(MetadatiInvioFile)
+	default:
+		return nil, fmt.Errorf("unsupported message '%s'", name)
+	}
+
+	if err := xml.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", name, err)
+	}
+
+	return m, nil
+}
+
+// Message parses the contents of the notification
+func (n *Notification) Message() (Message, error) {
+	return ParseMessage(n.Data)
+}
+
+// MetadataMessage parses the metadata file sent along with the invoice
+func (inv *InboundInvoice) MetadataMessage() (*MetadatiInvioFile, error) {
+	m := new(MetadatiInvioFile)
+	if err := xml.Unmarshal(inv.Metadata, m); err != nil {
+		return nil, fmt.Errorf("parsing MetadatiInvioFile: %w", err)
+	}
+	return m, nil
+}
+
+func rootElementName(data []byte) (string, error) {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := d.Token()
+		if errors.Is(err, io.EOF) {
+			return "", errors.New("empty message")
+		}
+		if err != nil {
+			return "", fmt.Errorf("parsing message: %w", err)
+		}
+		if se, ok := tok.(xml.StartElement); ok {
+			return se.Name.Local, nil
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package sdi_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/invopop/gobl.fatturapa/sdi"
+	"github.com/invopop/gobl.fatturapa/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func loadMessage(t *testing.T, typ sdi.NotificationType) sdi.Message {
+	t.Helper()
+	data, err := os.ReadFile(test.GetDataPath() + "messages/IT12345678903_00001_" + string(typ) + "_001.xml")
+	require.NoError(t, err)
+	m, err := sdi.ParseMessage(data)
+	require.NoError(t, err)
+	assert.Equal(t, typ, m.MessageType())
+	return m
+}
+
+func TestParseMessage(t *testing.T) {
+	hash := "2c71fc8e7c0c9c1f0a53bdc1c7e1cd0b8e2b4e7b0c5e3c93c0bde8d2ac1e4f55"
+
+	t.Run("should parse delivery receipts", func(t *testing.T) {
+		m := loadMessage(t, sdi.NotificationTypeRC).(*sdi.RicevutaConsegna)
+
+		assert.Equal(t, "1.0", m.Versione)
+		assert.Equal(t, "111", m.IdentificativoSdI)
+		assert.Equal(t, "IT12345678903_00001.xml", m.NomeFile)
+		assert.Equal(t, hash, m.Hash)
+		assert.Equal(t, "2023-03-01T09:15:30Z", m.DataOraRicezione.UTC().Format(time.RFC3339))
+		assert.Equal(t, "2023-03-01T09:20:12Z", m.DataOraConsegna.UTC().Format(time.RFC3339))
+		assert.Equal(t, "ABC1234", m.Destinatario.Codice)
+		assert.Equal(t, "123456", m.MessageId)
+		assert.Empty(t, m.Errors())
+	})
+
+	t.Run("should parse rejections", func(t *testing.T) {
+		m := loadMessage(t, sdi.NotificationTypeNS).(*sdi.NotificaScarto)
+
+		assert.Equal(t, "111", m.IdentificativoSdI)
+		assert.Equal(t, hash, m.Hash)
+		errs := m.Errors()
+		require.Len(t, errs, 2)
+		assert.Equal(t, "00404", errs[0].Codice)
+		assert.Equal(t, "Fattura duplicata", errs[0].Descrizione)
+		assert.Equal(t, "00423", errs[1].Codice)
+		assert.Equal(t, "Verificare il calcolo del prezzo totale", errs[1].Suggerimento)
+	})
+
+	t.Run("should parse failed deliveries", func(t *testing.T) {
+		m := loadMessage(t, sdi.NotificationTypeMC).(*sdi.NotificaMancataConsegna)
+
+		assert.Equal(t, "111", m.IdentificativoSdI)
+		assert.Equal(t, "2023-03-01T10:15:30Z", m.DataOraRicezione.Format(time.RFC3339))
+		require.Len(t, m.Errors(), 1)
+		assert.Equal(t, "Casella PEC piena", m.Errors()[0].Descrizione)
+	})
+
+	t.Run("should parse outcomes", func(t *testing.T) {
+		m := loadMessage(t, sdi.NotificationTypeNE).(*sdi.NotificaEsito)
+
+		require.NotNil(t, m.EsitoCommittente)
+		assert.Equal(t, sdi.EsitoRifiuto, m.EsitoCommittente.Esito)
+		assert.Equal(t, "SAMPLE-001", m.EsitoCommittente.RiferimentoFattura.NumeroFattura)
+		assert.Equal(t, "2023", m.EsitoCommittente.RiferimentoFattura.AnnoFattura)
+		errs := m.Errors()
+		require.Len(t, errs, 1)
+		assert.Equal(t, "EC02", errs[0].Codice)
+		assert.Equal(t, "Rifiuto", errs[0].Descrizione)
+		assert.Equal(t, "Importo errato", errs[0].Suggerimento)
+	})
+
+	t.Run("should parse expiry notifications", func(t *testing.T) {
+		m := loadMessage(t, sdi.NotificationTypeDT).(*sdi.NotificaDecorrenzaTermini)
+
+		assert.Equal(t, "111", m.IdentificativoSdI)
+		assert.Equal(t, "IT12345678903_00001.xml", m.NomeFile)
+		assert.Empty(t, m.Errors())
+	})
+
+	t.Run("should parse transmission certificates", func(t *testing.T) {
+		m := loadMessage(t, sdi.NotificationTypeAT).(*sdi.AttestazioneTrasmissioneFattura)
+
+		assert.Equal(t, "UFABCD", m.Destinatario.Codice)
+		assert.Equal(t, hash, m.HashFileOriginale)
+		assert.False(t, m.DataOraRicezione.IsZero())
+	})
+
+	t.Run("should parse recipient outcomes", func(t *testing.T) {
+		m := loadMessage(t, sdi.NotificationTypeEC).(*sdi.NotificaEsitoCommittente)
+
+		assert.Equal(t, "111", m.IdentificativoSdI)
+		assert.Equal(t, sdi.EsitoAccettazione, m.Esito)
+		assert.Empty(t, m.Errors())
+	})
+
+	t.Run("should parse outcome rejections", func(t *testing.T) {
+		m := loadMessage(t, sdi.NotificationTypeSE).(*sdi.ScartoEsitoCommittente)
+
+		assert.Equal(t, sdi.ScartoNonAmmissibile, m.Scarto)
+		require.Len(t, m.Errors(), 1)
+		assert.Equal(t, "Notifica non ammissibile", m.Errors()[0].Descrizione)
+	})
+
+	t.Run("should parse metadata", func(t *testing.T) {
+		m := loadMessage(t, sdi.NotificationTypeMT).(*sdi.MetadatiInvioFile)
+
+		assert.Equal(t, "ABC1234", m.CodiceDestinatario)
+		assert.Equal(t, "FPR12", m.Formato)
+		assert.Equal(t, 1, m.TentativiInvio)
+		assert.Equal(t, hash, m.Hash)
+	})
+
+	t.Run("should parse the notifications received", func(t *testing.T) {
+		data, err := os.ReadFile(test.GetDataPath() + "messages/IT12345678903_00001_RC_001.xml")
+		require.NoError(t, err)
+		n := &sdi.Notification{Type: sdi.NotificationTypeRC, Data: data}
+
+		m, err := n.Message()
+		require.NoError(t, err)
+		assert.IsType(t, &sdi.RicevutaConsegna{}, m)
+	})
+
+	t.Run("should reject unknown messages", func(t *testing.T) {
+		_, err := sdi.ParseMessage([]byte(`<Unknown/>`))
+		assert.EqualError(t, err, "unsupported message 'Unknown'")
+
+		_, err = sdi.ParseMessage(nil)
+		assert.EqualError(t, err, "empty message")
+	})
+}
@@ -0,0 +1,144 @@
+package sdi
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+)
+
+const (
+	contentTypeXML  = "text/xml"
+	contentTypeXOP  = "application/xop+xml"
+	contentTypeMTOM = "multipart/related"
+	rootContentID   = "root.message@fatturapa"
+)
+
+// maxMessageSize is the maximum size of the SOAP messages read. The SDI
+// accepts files of up to 5 MB, which grow by a third when encoded in base64,
+// so this leaves room for the envelope and any metadata file.
+const maxMessageSize = 10 << 20
+
+// errMessageTooLarge is returned when a message exceeds maxMessageSize
+var errMessageTooLarge = fmt.Errorf("message exceeds the maximum size of %d bytes", maxMessageSize)
+
+// message contains the SOAP envelope and any binary parts of an MTOM
+// (SOAP Message Transmission Optimization Mechanism) message.
+type message struct {
+	envelope []byte
+	parts    map[string][]byte
+}
+
+// newContentID provides a random identifier for a MIME part
+func newContentID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b) + "@fatturapa"
+}
+
+// encode prepares the body of the HTTP request or response along with its
+// content type. Messages without binary parts are sent as plain XML.
+func (m *message) encode() (string, []byte, error) {
+	if len(m.parts) == 0 {
+		return contentTypeXML + "; charset=utf-8", m.envelope, nil
+	}
+
+	buf := new(bytes.Buffer)
+	w := multipart.NewWriter(buf)
+
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Type", contentTypeXOP+`; charset=UTF-8; type="`+contentTypeXML+`"`)
+	h.Set("Content-Transfer-Encoding", "8bit")
+	h.Set("Content-ID", "<"+rootContentID+">")
+	pw, err := w.CreatePart(h)
+	if err != nil {
+		return "", nil, err
+	}
+	if _, err := pw.Write(m.envelope); err != nil {
+		return "", nil, err
+	}
+
+	for cid, data := range m.parts {
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Type", "application/octet-stream")
+		h.Set("Content-Transfer-Encoding", "binary")
+		h.Set("Content-ID", "<"+cid+">")
+		pw, err := w.CreatePart(h)
+		if err != nil {
+			return "", nil, err
+		}
+		if _, err := pw.Write(data); err != nil {
+			return "", nil, err
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return "", nil, err
+	}
+
+	ct := mime.FormatMediaType(contentTypeMTOM, map[string]string{
+		"type":       contentTypeXOP,
+		"start":      "<" + rootContentID + ">",
+		"start-info": contentTypeXML,
+		"boundary":   w.Boundary(),
+	})
+
+	return ct, buf.Bytes(), nil
+}
+
+// readMessage reads a SOAP message, either plain or using MTOM, from the body
+// of an HTTP request or response, up to maxMessageSize bytes.
+func readMessage(contentType string, body io.Reader) (*message, error) {
+	lr := &io.LimitedReader{R: body, N: maxMessageSize + 1}
+	m, err := readMessageParts(contentType, lr)
+	if lr.N == 0 {
+		return nil, errMessageTooLarge
+	}
+	return m, err
+}
+
+func readMessageParts(contentType string, body io.Reader) (*message, error) {
+	m := &message{parts: make(map[string][]byte)}
+
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType != contentTypeMTOM {
+		if m.envelope, err = io.ReadAll(body); err != nil {
+			return nil, err
+		}
+		return m, nil
+	}
+
+	start := strings.Trim(params["start"], "<>")
+	r := multipart.NewReader(body, params["boundary"])
+	for i := 0; ; i++ {
+		p, err := r.NextRawPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading MTOM message: %w", err)
+		}
+		data, err := io.ReadAll(p)
+		if err != nil {
+			return nil, fmt.Errorf("reading MTOM message: %w", err)
+		}
+
+		cid := strings.Trim(p.Header.Get("Content-ID"), "<>")
+		if (start == "" && i == 0) || (start != "" && cid == start) {
+			m.envelope = data
+			continue
+		}
+		m.parts[cid] = data
+	}
+
+	if m.envelope == nil {
+		return nil, fmt.Errorf("reading MTOM message: missing root part")
+	}
+
+	return m, nil
+}
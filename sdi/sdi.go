@@ -0,0 +1,174 @@
+// Package sdi implements the SDICoop web services used to exchange FatturaPA
+// files with the Italian Exchange System (Sistema di Interscambio).
+package sdi
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Namespaces used by the SDICoop services
+const (
+	namespaceSOAP         = "http://schemas.xmlsoap.org/soap/envelope/"
+	namespaceXOP          = "http://www.w3.org/2004/08/xop/include"
+	namespaceTrasmissione = "http://www.fatturapa.gov.it/sdi/ws/trasmissione/v1.0/types"
+)
+
+// SOAP actions of the SDICoop services
+const (
+	soapActionRiceviFile = "http://www.fatturapa.it/SdIRiceviFile/RiceviFile"
+)
+
+// Error codes returned by the SDI when a file cannot be received
+const (
+	ErrorCodeEmptyFile          = "EI01" // File vuoto
+	ErrorCodeServiceUnavailable = "EI02" // Servizio non disponibile
+	ErrorCodeUnauthorized       = "EI03" // Utente non abilitato
+)
+
+var errorDescriptions = map[string]string{
+	ErrorCodeEmptyFile:          "empty file",
+	ErrorCodeServiceUnavailable: "service unavailable",
+	ErrorCodeUnauthorized:       "user not authorized",
+}
+
+// Error is returned when the SDI does not accept a file
+type Error struct {
+	Code string
+}
+
+// Error provides the error code along with its description
+func (e *Error) Error() string {
+	if desc, ok := errorDescriptions[e.Code]; ok {
+		return fmt.Sprintf("sdi: %s: %s", e.Code, desc)
+	}
+	return fmt.Sprintf("sdi: %s", e.Code)
+}
+
+// envelope is used to send SOAP messages
+type envelope struct {
+	XMLName       xml.Name `xml:"soap:Envelope"`
+	SOAPNamespace string   `xml:"xmlns:soap,attr"`
+	Body          struct {
+		Content any
+	} `xml:"soap:Body"`
+}
+
+// incomingEnvelope is used to read SOAP messages
+type incomingEnvelope struct {
+	XMLName xml.Name `xml:"Envelope"`
+	Body    struct {
+		Fault   *fault `xml:"Fault"`
+		Content []byte `xml:",innerxml"`
+	} `xml:"Body"`
+}
+
+// fault contains the details of a SOAP error
+type fault struct {
+	Code   string `xml:"faultcode"`
+	String string `xml:"faultstring"`
+}
+
+// xopData contains binary data that is either provided inline, encoded in
+// base64, or as a reference to a MIME part of an MTOM message.
+type xopData struct {
+	Include *xopInclude `xml:"xop:Include,omitempty"`
+	Value   string      `xml:",chardata"`
+}
+
+type xopInclude struct {
+	XMLName xml.Name `xml:"xop:Include"`
+	XOP     string   `xml:"xmlns:xop,attr"`
+	Href    string   `xml:"href,attr"`
+}
+
+// incomingXOPData is used to read binary data, as namespaces are not kept
+// in element names when decoding.
+type incomingXOPData struct {
+	Include *struct {
+		Href string `xml:"href,attr"`
+	} `xml:"Include"`
+	Value string `xml:",chardata"`
+}
+
+func newEnvelope(content any) *envelope {
+	env := &envelope{SOAPNamespace: namespaceSOAP}
+	env.Body.Content = content
+	return env
+}
+
+func (e *envelope) bytes() ([]byte, error) {
+	buf := bytes.NewBufferString(xml.Header)
+	if err := xml.NewEncoder(buf).Encode(e); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func newXOPData(cid string) *xopData {
+	return &xopData{
+		Include: &xopInclude{
+			XOP:  namespaceXOP,
+			Href: "cid:" + cid,
+		},
+	}
+}
+
+// decodeEnvelope extracts the content of the SOAP message's body into the
+// struct provided, returning an error if the message contains a fault.
+func decodeEnvelope(data []byte, content any) error {
+	env := new(incomingEnvelope)
+	if err := xml.Unmarshal(data, env); err != nil {
+		return fmt.Errorf("decoding SOAP envelope: %w", err)
+	}
+	if f := env.Body.Fault; f != nil {
+		return fmt.Errorf("sdi: SOAP fault %s: %s", f.Code, f.String)
+	}
+	if len(bytes.TrimSpace(env.Body.Content)) == 0 {
+		return errors.New("empty SOAP body")
+	}
+	if err := xml.Unmarshal(env.Body.Content, content); err != nil {
+		return fmt.Errorf("decoding SOAP body: %w", err)
+	}
+	return nil
+}
+
+// data resolves the binary contents using the MIME parts of the message
+func (x *incomingXOPData) data(parts map[string][]byte) ([]byte, error) {
+	if x.Include != nil {
+		cid, err := x.contentID()
+		if err != nil {
+			return nil, err
+		}
+		data, ok := parts[cid]
+		if !ok {
+			return nil, fmt.Errorf("missing MIME part %s", cid)
+		}
+		return data, nil
+	}
+	return base64.StdEncoding.DecodeString(strings.TrimSpace(x.Value))
+}
+
+// contentID provides the identifier of the MIME part referenced, if any
+func (x *incomingXOPData) contentID() (string, error) {
+	if x == nil || x.Include == nil {
+		return "", nil
+	}
+	return url.PathUnescape(strings.TrimPrefix(x.Include.Href, "cid:"))
+}
+
+// parseDateTime parses the xs:dateTime values used by the SDI, which may not
+// include a time zone.
+func parseDateTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02T15:04:05.999999999", s)
+}
@@ -0,0 +1,88 @@
+package sdi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Simulator calls the TrasmissioneFatture and RicezioneFatture services in
+// the same way as the SDI, so that a Handler can be tested locally.
+type Simulator struct {
+	client *Client
+}
+
+// NewSimulator returns a new simulator calling the services at the given URL
+func NewSimulator(url string, opts ...Option) *Simulator {
+	return &Simulator{client: NewClient(url, opts...)}
+}
+
+var notificationSOAPActions = map[NotificationType]string{
+	NotificationTypeRC: soapActionRicevutaConsegna,
+	NotificationTypeMC: soapActionNotificaMancataConsegna,
+	NotificationTypeNS: soapActionNotificaScarto,
+	NotificationTypeNE: soapActionNotificaEsito,
+	NotificationTypeDT: soapActionNotificaDecorrenzaTermini,
+	NotificationTypeAT: soapActionAttestazioneTrasmissioneFattura,
+}
+
+// SendNotification sends the notification as the SDI would
+func (s *Simulator) SendNotification(ctx context.Context, n *Notification) error {
+	action, ok := notificationSOAPActions[n.Type]
+	if !ok {
+		return fmt.Errorf("unsupported notification type '%s'", n.Type)
+	}
+	if n.Recipient {
+		if n.Type != NotificationTypeDT {
+			return fmt.Errorf("notification type '%s' cannot be sent to the recipient", n.Type)
+		}
+		action = soapActionNotificaDecorrenzaTerminiRecipient
+	}
+
+	cid := newContentID()
+	req := &fileSdI{
+		Namespace:         namespaceTrasmissione,
+		IdentificativoSdI: n.ID,
+		NomeFile:          n.FileName,
+		File:              newXOPData(cid),
+	}
+	if n.Recipient {
+		req.Namespace = namespaceRicezione
+	}
+
+	m := &message{parts: map[string][]byte{cid: n.Data}}
+	return s.client.call(ctx, action, req, m, nil)
+}
+
+// SendInvoice sends the invoice file along with its metadata as the SDI would
+func (s *Simulator) SendInvoice(ctx context.Context, inv *InboundInvoice) error {
+	fileCID := newContentID()
+	metaCID := newContentID()
+	req := &fileSdIConMetadati{
+		Namespace:         namespaceRicezione,
+		IdentificativoSdI: inv.ID,
+		NomeFile:          inv.FileName,
+		File:              newXOPData(fileCID),
+		NomeFileMetadati:  inv.MetadataFileName,
+		Metadati:          newXOPData(metaCID),
+	}
+
+	res := new(incomingRispostaRiceviFatture)
+	m := &message{parts: map[string][]byte{
+		fileCID: inv.Data,
+		metaCID: inv.Metadata,
+	}}
+	if err := s.client.call(ctx, soapActionRiceviFatture, req, m, res); err != nil {
+		return err
+	}
+	if res.Esito != esitoRicezione {
+		return errors.New("unexpected response: " + res.Esito)
+	}
+
+	return nil
+}
+
+// incomingRispostaRiceviFatture is used to read the RiceviFatture responses
+type incomingRispostaRiceviFatture struct {
+	Esito string
+}
@@ -0,0 +1,148 @@
+package sdi
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// StubServer is an in-process implementation of the SDI's SdIRiceviFile
+// service, so that integrations can be tested offline. Received files are
+// kept in memory and assigned sequential IDs.
+type StubServer struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	files  []*ReceivedFile
+	lastID int
+	errore string
+}
+
+// ReceivedFile contains a file received by the stub server
+type ReceivedFile struct {
+	ID         string
+	Name       string
+	Data       []byte
+	ReceivedAt time.Time
+}
+
+// NewStubServer starts a new stub server using plain HTTP
+func NewStubServer() *StubServer {
+	s := new(StubServer)
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+// NewStubTLSServer starts a new stub server that requires clients to
+// authenticate with a certificate issued by one of the given authorities,
+// as the SDI does. Clients may trust the server using its Certificate.
+func NewStubTLSServer(clientCAs *x509.CertPool) *StubServer {
+	s := new(StubServer)
+	s.Server = httptest.NewUnstartedServer(s)
+	s.Server.TLS = &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  clientCAs,
+		MinVersion: tls.VersionTLS12,
+	}
+	s.Server.StartTLS()
+	return s
+}
+
+// Files provides the list of files received so far
+func (s *StubServer) Files() []*ReceivedFile {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*ReceivedFile(nil), s.files...)
+}
+
+// RespondWithError makes the server reject the following files with the given
+// error code, e.g. ErrorCodeServiceUnavailable. An empty code restores the
+// normal behaviour.
+func (s *StubServer) RespondWithError(code string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errore = code
+}
+
+// ServeHTTP handles the SdIRiceviFile requests
+func (s *StubServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	m, err := readMessage(r.Header.Get("Content-Type"), r.Body)
+	if err != nil {
+		writeFault(w, "soap:Client", err.Error())
+		return
+	}
+
+	req := new(incomingFileSdIAccoglienza)
+	if err := decodeEnvelope(m.envelope, req); err != nil {
+		writeFault(w, "soap:Client", err.Error())
+		return
+	}
+
+	var data []byte
+	if req.File != nil {
+		if data, err = req.File.data(m.parts); err != nil {
+			writeFault(w, "soap:Client", err.Error())
+			return
+		}
+	}
+
+	now := time.Now()
+	res := &rispostaSdIRiceviFile{
+		Namespace:        namespaceTrasmissione,
+		DataOraRicezione: now.Format(time.RFC3339),
+	}
+
+	s.mu.Lock()
+	switch {
+	case s.errore != "":
+		res.Errore = s.errore
+	case len(data) == 0:
+		res.Errore = ErrorCodeEmptyFile
+	default:
+		s.lastID++
+		res.IdentificativoSdI = strconv.Itoa(s.lastID)
+		s.files = append(s.files, &ReceivedFile{
+			ID:         res.IdentificativoSdI,
+			Name:       req.NomeFile,
+			Data:       data,
+			ReceivedAt: now,
+		})
+	}
+	s.mu.Unlock()
+
+	writeResponse(w, res)
+}
+
+func writeResponse(w http.ResponseWriter, res any) {
+	data, err := newEnvelope(res).bytes()
+	if err != nil {
+		writeFault(w, "soap:Server", err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", contentTypeXML+"; charset=utf-8")
+	_, _ = w.Write(data)
+}
+
+// soapFault is used to send SOAP errors
+type soapFault struct {
+	XMLName xml.Name `xml:"soap:Fault"`
+	Code    string   `xml:"faultcode"`
+	String  string   `xml:"faultstring"`
+}
+
+func writeFault(w http.ResponseWriter, code, msg string) {
+	data, _ := newEnvelope(&soapFault{Code: code, String: msg}).bytes()
+	w.Header().Set("Content-Type", contentTypeXML+"; charset=utf-8")
+	w.WriteHeader(http.StatusInternalServerError)
+	_, _ = w.Write(data)
+}
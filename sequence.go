@@ -0,0 +1,153 @@
+package fatturapa
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Progressives used in file names are limited to 5 alphanumeric characters,
+// which are encoded in base 36 by the FileSequence.
+const (
+	maxProgressivoLength = 5
+	maxProgressivoValue  = 36*36*36*36*36 - 1
+)
+
+var progressivoRegexp = regexp.MustCompile(`^[a-zA-Z0-9]{1,5}$`)
+
+// SequenceProvider provides the progressives used to identify each file sent
+// to the SDI by a transmitter, both in the ProgressivoInvio of the
+// transmission data and in the name of the file. Progressives must be unique
+// per transmitter and contain up to 5 alphanumeric characters.
+type SequenceProvider interface {
+	Next(countryCode, taxID string) (string, error)
+}
+
+// FileSequence is a SequenceProvider that keeps the last progressive issued
+// to each transmitter in a JSON file. The file is locked while issuing each
+// progressive, using a ".lock" file next to it, so the same file may be shared
+// by several processes.
+type FileSequence struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileSequence returns a FileSequence backed by the file at the given path,
+// which is created when the first progressive is issued.
+func NewFileSequence(path string) *FileSequence {
+	return &FileSequence{path: path}
+}
+
+// Next increments the counter of the transmitter and provides it as a base 36
+// progressive, e.g. "0000A".
+func (s *FileSequence) Next(countryCode, taxID string) (p string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	unlock, err := lockFile(s.path + ".lock")
+	if err != nil {
+		return "", fmt.Errorf("locking sequence: %w", err)
+	}
+	defer func() {
+		if uerr := unlock(); uerr != nil && err == nil {
+			err = fmt.Errorf("unlocking sequence: %w", uerr)
+		}
+	}()
+
+	counters := make(map[string]int64)
+	data, err := os.ReadFile(s.path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		// first progressive
+	case err != nil:
+		return "", fmt.Errorf("reading sequence: %w", err)
+	default:
+		if err := json.Unmarshal(data, &counters); err != nil {
+			return "", fmt.Errorf("parsing sequence: %w", err)
+		}
+	}
+
+	key := countryCode + taxID
+	n := counters[key] + 1
+	if n > maxProgressivoValue {
+		return "", fmt.Errorf("sequence exhausted for %s", key)
+	}
+	counters[key] = n
+
+	if data, err = json.MarshalIndent(counters, "", "  "); err != nil {
+		return "", err
+	}
+	if err := writeFileAtomic(s.path, data); err != nil {
+		return "", fmt.Errorf("writing sequence: %w", err)
+	}
+
+	return formatProgressivo(n), nil
+}
+
+func formatProgressivo(n int64) string {
+	p := strings.ToUpper(strconv.FormatInt(n, 36))
+	return strings.Repeat("0", maxProgressivoLength-len(p)) + p
+}
+
+// writeFileAtomic replaces the file's contents by renaming a temporary file,
+// so that a failure never leaves a partially written sequence behind.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // nolint:errcheck
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close() // nolint:errcheck
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// progressivo assigns the progressive of the file to the transmission data
+// and provides the name of the file, which is based on the transmitter's tax
+// ID or, in its absence, the supplier's. Without a SequenceProvider, the
+// progressive is taken from the envelope's UUID.
+func (c *Converter) progressivo(uuid string, dt *datiTrasmissione, supplier *taxID) (string, error) {
+	sender := dt.IdTrasmittente
+	if sender == nil {
+		sender = supplier
+	}
+	if sender == nil {
+		return "", errors.New("missing tax ID to name the file")
+	}
+
+	var p string
+	if c.Config.Sequence != nil {
+		var err error
+		if p, err = c.Config.Sequence.Next(sender.IdPaese, sender.IdCodice); err != nil {
+			return "", err
+		}
+		if !progressivoRegexp.MatchString(p) {
+			return "", fmt.Errorf("invalid progressive '%s'", p)
+		}
+	} else {
+		p = uuid[:8]
+	}
+
+	if dt.IdTrasmittente != nil {
+		dt.ProgressivoInvio = p
+	}
+
+	if len(p) > maxProgressivoLength {
+		p = p[:maxProgressivoLength]
+	}
+
+	return fmt.Sprintf("%s%s_%s", sender.IdPaese, sender.IdCodice, p), nil
+}
@@ -0,0 +1,37 @@
+//go:build !unix
+
+package fatturapa
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// lockTimeout is the maximum time to wait for other processes to release
+// the lock.
+const lockTimeout = 30 * time.Second
+
+// lockFile acquires an exclusive lock by creating the file at the given path,
+// which must not exist, waiting for other processes holding it. The function
+// returned releases the lock by removing the file.
+func lockFile(path string) (func() error, error) {
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			if err := f.Close(); err != nil {
+				return nil, err
+			}
+			return func() error { return os.Remove(path) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timeout waiting for lock %s", path)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,26 @@
+//go:build unix
+
+package fatturapa
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile acquires an exclusive lock on the file at the given path, which is
+// created when needed, waiting for other processes holding it. The function
+// returned releases the lock.
+func lockFile(path string) (func() error, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close() // nolint:errcheck
+		return nil, err
+	}
+	return func() error {
+		defer f.Close() // nolint:errcheck
+		return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+	}, nil
+}
@@ -0,0 +1,169 @@
+package fatturapa_test
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	fatturapa "github.com/invopop/gobl.fatturapa"
+	"github.com/invopop/gobl.fatturapa/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type staticSequence string
+
+func (s staticSequence) Next(_, _ string) (string, error) {
+	return string(s), nil
+}
+
+func TestFileSequence(t *testing.T) {
+	t.Run("should issue alphanumeric progressives per transmitter", func(t *testing.T) {
+		seq := fatturapa.NewFileSequence(filepath.Join(t.TempDir(), "sequence.json"))
+
+		for i := 1; i < 10; i++ {
+			_, err := seq.Next("IT", "01234567890")
+			require.NoError(t, err)
+		}
+
+		p, err := seq.Next("IT", "01234567890")
+		require.NoError(t, err)
+		assert.Equal(t, "0000A", p)
+
+		p, err = seq.Next("IT", "09876543210")
+		require.NoError(t, err)
+		assert.Equal(t, "00001", p)
+	})
+
+	t.Run("should continue from the stored progressives", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "sequence.json")
+		require.NoError(t, os.WriteFile(path, []byte(`{"IT01234567890": 1295}`), 0600))
+
+		p, err := fatturapa.NewFileSequence(path).Next("IT", "01234567890")
+		require.NoError(t, err)
+		assert.Equal(t, "00100", p)
+
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"IT01234567890": 1296}`, string(data))
+	})
+
+	t.Run("should fail when the sequence is exhausted", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "sequence.json")
+		require.NoError(t, os.WriteFile(path, []byte(`{"IT01234567890": 60466175}`), 0600))
+
+		_, err := fatturapa.NewFileSequence(path).Next("IT", "01234567890")
+		assert.EqualError(t, err, "sequence exhausted for IT01234567890")
+	})
+
+	t.Run("should issue unique progressives to concurrent users", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "sequence.json")
+
+		var wg sync.WaitGroup
+		results := make(chan string, 40)
+		for i := 0; i < 4; i++ {
+			// Separate instances behave like separate processes
+			seq := fatturapa.NewFileSequence(path)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 10; j++ {
+					p, err := seq.Next("IT", "01234567890")
+					assert.NoError(t, err)
+					results <- p
+				}
+			}()
+		}
+		wg.Wait()
+		close(results)
+
+		seen := make(map[string]bool)
+		for p := range results {
+			assert.False(t, seen[p], "duplicate progressive %s", p)
+			seen[p] = true
+		}
+		assert.Len(t, seen, 40)
+		assert.True(t, seen["00014"])
+	})
+
+	t.Run("should fail with invalid files", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "sequence.json")
+		require.NoError(t, os.WriteFile(path, []byte(`invalid`), 0600))
+
+		_, err := fatturapa.NewFileSequence(path).Next("IT", "01234567890")
+		assert.ErrorContains(t, err, "parsing sequence")
+	})
+}
+
+func TestFileName(t *testing.T) {
+	t.Run("should use the progressive of the sequence", func(t *testing.T) {
+		seq := fatturapa.NewFileSequence(filepath.Join(t.TempDir(), "sequence.json"))
+		converter := test.NewConverter(fatturapa.WithSequenceProvider(seq))
+		env := test.LoadTestFile("invoice-simple.json")
+
+		doc, err := test.ConvertFromGOBL(env, converter)
+		require.NoError(t, err)
+		assert.Equal(t, "00001", doc.FatturaElettronicaHeader.DatiTrasmissione.ProgressivoInvio)
+		assert.Equal(t, "IT01234567890_00001.xml", doc.FileName())
+
+		doc, err = test.ConvertFromGOBL(env, converter)
+		require.NoError(t, err)
+		assert.Equal(t, "00002", doc.FatturaElettronicaHeader.DatiTrasmissione.ProgressivoInvio)
+		assert.Equal(t, "IT01234567890_00002.xml", doc.FileName())
+	})
+
+	t.Run("should default to the envelope's UUID", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simple.json")
+
+		doc, err := test.ConvertFromGOBL(env)
+		require.NoError(t, err)
+		assert.Equal(t, "679a2f25", doc.FatturaElettronicaHeader.DatiTrasmissione.ProgressivoInvio)
+		assert.Equal(t, "IT01234567890_679a2.xml", doc.FileName())
+	})
+
+	t.Run("should use the supplier's tax ID without transmitter", func(t *testing.T) {
+		converter := test.NewConverter(fatturapa.WithSequenceProvider(staticSequence("0000A")))
+		converter.Config.Transmitter = nil
+		env := test.LoadTestFile("invoice-simple.json")
+
+		doc, err := test.ConvertFromGOBL(env, converter)
+		require.NoError(t, err)
+		assert.Empty(t, doc.FatturaElettronicaHeader.DatiTrasmissione.ProgressivoInvio)
+		assert.Equal(t, "IT12345678903_0000A.xml", doc.FileName())
+	})
+
+	t.Run("should name simplified invoices", func(t *testing.T) {
+		converter := test.NewConverter(fatturapa.WithSequenceProvider(staticSequence("0000B")))
+		env := test.LoadTestFile("invoice-simplified.json")
+
+		doc, err := test.ConvertSimplifiedFromGOBL(env, converter)
+		require.NoError(t, err)
+		assert.Equal(t, "IT01234567890_0000B.xml", doc.FileName())
+	})
+
+	t.Run("should not issue progressives for documents failing the checks", func(t *testing.T) {
+		seq := fatturapa.NewFileSequence(filepath.Join(t.TempDir(), "sequence.json"))
+		env := test.LoadTestFile("invoice-hotel.json")
+
+		converter := test.NewConverter(
+			fatturapa.WithSequenceProvider(seq),
+			fatturapa.WithPreflightChecks(),
+			fatturapa.WithInvoiceRegistry(staticRegistry(true)),
+		)
+		_, err := test.ConvertFromGOBL(env, converter)
+		require.Error(t, err)
+
+		p, err := seq.Next("IT", "01234567890")
+		require.NoError(t, err)
+		assert.Equal(t, "00001", p)
+	})
+
+	t.Run("should reject invalid progressives", func(t *testing.T) {
+		converter := test.NewConverter(fatturapa.WithSequenceProvider(staticSequence("0000_1")))
+		env := test.LoadTestFile("invoice-simple.json")
+
+		_, err := test.ConvertFromGOBL(env, converter)
+		assert.EqualError(t, err, "invalid progressive '0000_1'")
+	})
+}
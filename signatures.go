@@ -1,50 +1,306 @@
 package fatturapa
 
 import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
 	"fmt"
+	"math/big"
+	"time"
 
 	"github.com/invopop/xmldsig"
 )
 
+// Description of the documents included in the XAdES signed properties
+const xadesDescription = "Fattura PA"
+
 var xadesConfig = &xmldsig.XAdESConfig{
-	Description: "Fattura PA",
+	Description: xadesDescription,
+}
+
+// Identifiers of the elements of XAdES signatures
+const (
+	signatureRootIDFormat           = "Signature-%s-Signature"
+	sigValueIDFormat                = "Signature-%s-SignatureValue"
+	sigPropertiesIDFormat           = "Signature-%s-SignedProperties"
+	sigQualifyingPropertiesIDFormat = "Signature-%s-QualifyingProperties"
+	referenceIDFormat               = "Reference-%s"
+	certificateIDFormat             = "Certificate-%s"
+)
+
+// Algorithms used in XAdES signatures
+const (
+	algEnvelopedSignature = "http://www.w3.org/2000/09/xmldsig#enveloped-signature"
+	referenceTypeObject   = "http://www.w3.org/2000/09/xmldsig#Object"
+	referenceTypeXAdES    = "http://uri.etsi.org/01903#SignedProperties"
+)
+
+// signable is implemented by the XML documents that can be signed.
+type signable interface {
+	buffer(base string) (*bytes.Buffer, error)
+	setSignature(sig *xmldsig.Signature)
 }
 
-func (d *Document) sign(config *Config) error {
-	data, err := d.canonical()
+func (d *Document) sign(ctx context.Context, config *Config) error {
+	if config.SignatureFormat == CAdES {
+		p7m, err := signEnvelope(ctx, d, config)
+		if err != nil {
+			return err
+		}
+		d.p7m = p7m
+		return nil
+	}
+
+	return signDocument(ctx, d, d.env.Head.UUID.String(), config)
+}
+
+func (d *Document) setSignature(sig *xmldsig.Signature) {
+	d.Signature = sig
+}
+
+func (d *SimplifiedDocument) sign(ctx context.Context, config *Config) error {
+	if config.SignatureFormat == CAdES {
+		p7m, err := signEnvelope(ctx, d, config)
+		if err != nil {
+			return err
+		}
+		d.p7m = p7m
+		return nil
+	}
+
+	return signDocument(ctx, d, d.env.Head.UUID.String(), config)
+}
+
+func (d *SimplifiedDocument) setSignature(sig *xmldsig.Signature) {
+	d.Signature = sig
+}
+
+// signEnvelope prepares a CAdES envelope containing the complete XML of the
+// document provided.
+func signEnvelope(ctx context.Context, doc signable, config *Config) ([]byte, error) {
+	signer, err := config.signer()
+	if err != nil {
+		return nil, err
+	}
+	buf, err := doc.buffer(xml.Header)
+	if err != nil {
+		return nil, err
+	}
+
+	return signCAdES(ctx, buf.Bytes(), signer, time.Now(), config.timestampProvider())
+}
+
+// signDocument adds an enveloped XAdES signature to the document, using the
+// ID given to identify the signed document, and once signed, timestamps it
+// if required. Documents are signed with the certificate by the xmldsig
+// package, unless an external signer is provided.
+func signDocument(ctx context.Context, doc signable, docID string, config *Config) error {
+	if config.Signer == nil {
+		return signDocumentWithCertificate(ctx, doc, docID, config)
+	}
+	return signDocumentWithSigner(ctx, doc, docID, config)
+}
+
+// signDocumentWithCertificate signs the document with the certificate of
+// the configuration.
+func signDocumentWithCertificate(ctx context.Context, doc signable, docID string, config *Config) error {
+	doc.setSignature(nil)
+	data, err := canonical(doc)
 	if err != nil {
 		return fmt.Errorf("converting to canonincal format: %w", err)
 	}
 
-	dsigOpts := []xmldsig.Option{
-		xmldsig.WithDocID(d.env.Head.UUID.String()),
+	sig, err := xmldsig.Sign(data,
+		xmldsig.WithDocID(docID),
 		xmldsig.WithXAdES(xadesConfig),
+		xmldsig.WithCertificate(config.Certificate),
+	)
+	if err != nil {
+		return err
+	}
+	doc.setSignature(sig)
+
+	return timestampSignature(ctx, doc, sig, config)
+}
+
+// signDocumentWithSigner signs the document with the external signer of the
+// configuration. Digests are calculated over the elements in the context of
+// the complete document, so the signature is added before calculating them.
+func signDocumentWithSigner(ctx context.Context, doc signable, docID string, config *Config) error {
+	signer := config.Signer
+	cert, err := signingCertificate(signer)
+	if err != nil {
+		return err
 	}
 
-	if config.Certificate != nil {
-		dsigOpts = append(dsigOpts, xmldsig.WithCertificate(config.Certificate))
+	doc.setSignature(nil)
+	data, err := canonical(doc)
+	if err != nil {
+		return fmt.Errorf("converting to canonincal format: %w", err)
 	}
+	root, err := parseC14N(data)
+	if err != nil {
+		return err
+	}
+	docDigest := sha256.Sum256(root.canonicalize(new(c14nOptions)))
+
+	sig := newSignature(docID, cert, signer)
+	sig.SignedInfo.Reference[0].DigestValue = base64.StdEncoding.EncodeToString(docDigest[:])
+	doc.setSignature(sig)
 
-	if config.WithTimestamp {
-		dsigOpts = append(dsigOpts, xmldsig.WithTimestamp(xmldsig.TimestampFreeTSA))
+	// Digests of the key info and signed properties
+	el, err := signatureElement(doc)
+	if err != nil {
+		return err
+	}
+	for _, ref := range sig.SignedInfo.Reference[1:] {
+		target := el.byID(ref.URI[1:])
+		if target == nil {
+			return fmt.Errorf("missing element '%s'", ref.URI)
+		}
+		digest := sha256.Sum256(target.canonicalize(new(c14nOptions)))
+		ref.DigestValue = base64.StdEncoding.EncodeToString(digest[:])
 	}
 
-	sig, err := xmldsig.Sign(data, dsigOpts...)
+	el, err = signatureElement(doc)
+	if err != nil {
+		return err
+	}
+	value, err := signData(ctx, signer, el.child(namespaceDSig, "SignedInfo").canonicalize(new(c14nOptions)))
+	if err != nil {
+		return err
+	}
+	value, err = xmlSignatureValue(cert, value)
 	if err != nil {
 		return err
 	}
+	sig.Value.Value = base64.StdEncoding.EncodeToString(value)
 
-	d.Signature = sig
+	return timestampSignature(ctx, doc, sig, config)
+}
+
+// newSignature prepares the XAdES signature of the document, pending the
+// digests of the references and the signature value.
+func newSignature(docID string, cert *x509.Certificate, signer Signer) *xmldsig.Signature {
+	certDigest := sha256.Sum256(cert.Raw)
+	sig := &xmldsig.Signature{
+		DSigNamespace: xmldsig.NamespaceDSig,
+		ID:            fmt.Sprintf(signatureRootIDFormat, docID),
+		Value: &xmldsig.Value{
+			ID: fmt.Sprintf(sigValueIDFormat, docID),
+		},
+		KeyInfo: &xmldsig.KeyInfo{
+			ID:       fmt.Sprintf(certificateIDFormat, docID),
+			X509Data: new(xmldsig.X509Data),
+		},
+		Object: &xmldsig.Object{
+			QualifyingProperties: &xmldsig.QualifyingProperties{
+				XAdESNamespace: xmldsig.NamespaceXAdES,
+				ID:             fmt.Sprintf(sigQualifyingPropertiesIDFormat, docID),
+				Target:         fmt.Sprintf("#"+signatureRootIDFormat, docID),
+				SignedProperties: &xmldsig.SignedProperties{
+					ID: fmt.Sprintf(sigPropertiesIDFormat, docID),
+					SignatureProperties: &xmldsig.SignedSignatureProperties{
+						SigningTime: time.Now().UTC().Format(xmldsig.ISO8601),
+						SigningCertificate: &xmldsig.SigningCertificate{
+							CertDigest: &xmldsig.Digest{
+								Method: &xmldsig.AlgorithmMethod{Algorithm: xmldsig.AlgEncSHA256},
+								Value:  base64.StdEncoding.EncodeToString(certDigest[:]),
+							},
+							IssuerSerial: &xmldsig.IssuerSerial{
+								IssuerName:   cert.Issuer.String(),
+								SerialNumber: cert.SerialNumber.String(),
+							},
+						},
+					},
+					DataObjectProperties: &xmldsig.DataObjectFormat{
+						ObjectReference: "#" + fmt.Sprintf(referenceIDFormat, docID),
+						Description:     xadesDescription,
+						ObjectIdentifier: &xmldsig.ObjectIdentifier{
+							Identifier: &xmldsig.Identifier{
+								Qualifier: "OIDAsURN",
+								Value:     "urn:oid:1.2.840.10003.5.109.10",
+							},
+						},
+						MimeType: "text/xml",
+					},
+				},
+			},
+		},
+	}
+
+	for _, c := range signer.Certificates() {
+		sig.KeyInfo.X509Data.X509Certificate = append(sig.KeyInfo.X509Data.X509Certificate, xmldsig.NakedPEM(c))
+	}
+	// XML-DSig only requires the certificate, and the SDI identifies signers
+	// by it, so the optional key value is limited to the RSA keys the
+	// xmldsig package can describe. ECDSA keys would need the ECKeyValue of
+	// XML-DSig 1.1, which verifiers take from the certificate anyway.
+	if pub, ok := cert.PublicKey.(*rsa.PublicKey); ok {
+		sig.KeyInfo.KeyValue = &xmldsig.KeyValue{
+			Modulus:  base64.StdEncoding.EncodeToString(pub.N.Bytes()),
+			Exponent: base64.StdEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}
+	}
+
+	digest := &xmldsig.AlgorithmMethod{Algorithm: xmldsig.AlgEncSHA256}
+	sig.SignedInfo = &xmldsig.SignedInfo{
+		CanonicalizationMethod: &xmldsig.AlgorithmMethod{Algorithm: c14nInclusive},
+		SignatureMethod:        &xmldsig.AlgorithmMethod{Algorithm: xmlSignatureMethod(cert)},
+		Reference: []*xmldsig.Reference{
+			{
+				ID:   fmt.Sprintf(referenceIDFormat, docID),
+				Type: referenceTypeObject,
+				URI:  "",
+				Transforms: &xmldsig.Transforms{
+					Transform: []*xmldsig.AlgorithmMethod{{Algorithm: algEnvelopedSignature}},
+				},
+				DigestMethod: digest,
+			},
+			{
+				URI:          "#" + sig.KeyInfo.ID,
+				DigestMethod: digest,
+			},
+			{
+				URI:          "#" + sig.Object.QualifyingProperties.SignedProperties.ID,
+				Type:         referenceTypeXAdES,
+				DigestMethod: digest,
+			},
+		},
+	}
 
-	return nil
+	return sig
+}
+
+// signatureElement parses the document to provide the element of its
+// signature, with the namespaces in scope.
+func signatureElement(doc signable) (*c14nElement, error) {
+	buf, err := doc.buffer("")
+	if err != nil {
+		return nil, err
+	}
+	root, err := parseC14N(buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	el := root.child(namespaceDSig, "Signature")
+	if el == nil {
+		return nil, errors.New("missing Signature")
+	}
+	return el, nil
 }
 
-// Canonical converts a struct representation of fatturapa to its
+// canonical converts a struct representation of fatturapa to its
 // canonical representation as defined in https://www.w3.org/TR/2001/REC-xml-c14n-20010315
 // (for a simpler explanation look at https://www.di-mgt.com.au/xmldsig-c14n.html)
 // This is used when we need to create a hash for signing, timestamping, ...
-func (d *Document) canonical() ([]byte, error) {
-	buf, err := d.buffer("")
+func canonical(doc signable) ([]byte, error) {
+	buf, err := doc.buffer("")
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,199 @@
+package fatturapa
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/invopop/xmldsig"
+)
+
+// Signer signs documents with a key that may be kept outside of the
+// application, such as in an HSM or a remote signing service.
+type Signer interface {
+	// Certificates provides the signing certificate followed by the rest
+	// of its chain, if any.
+	Certificates() []*x509.Certificate
+	// Sign signs the digest calculated with the hash function given. As
+	// with crypto.Signer, signatures made with RSA keys must use PKCS #1
+	// v1.5 and those made with ECDSA keys must be ASN.1 encoded.
+	Sign(ctx context.Context, hash crypto.Hash, digest []byte) ([]byte, error)
+}
+
+// KeySigner is a Signer using a private key held in memory
+type KeySigner struct {
+	Key crypto.Signer
+	// Chain contains the signing certificate followed by the rest of its chain
+	Chain []*x509.Certificate
+}
+
+// Object identifiers of the signature algorithms used in CAdES signatures
+var oidECDSAWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+
+const algDSigECDSASHA256 = "http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha256"
+
+// NewKeySigner prepares a signer with the private key and chain of the
+// certificate provided.
+func NewKeySigner(cert *xmldsig.Certificate) (*KeySigner, error) {
+	x509Cert, err := parseCertificate(cert)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ParsePrivateKey(cert.PrivateKey())
+	if err != nil {
+		return nil, err
+	}
+
+	return &KeySigner{
+		Key:   key,
+		Chain: append([]*x509.Certificate{x509Cert}, cert.CaChain...),
+	}, nil
+}
+
+// ParsePrivateKey parses a private key in PEM format to use in a KeySigner.
+// RSA keys may be in PKCS #1 or PKCS #8 format, and ECDSA keys in SEC 1 or
+// PKCS #8 format.
+func ParsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("invalid private key")
+	}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parsing private key: %w", err)
+		}
+		return key, nil
+	case "EC PRIVATE KEY":
+		key, err := x509.ParseECPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parsing private key: %w", err)
+		}
+		return key, nil
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parsing private key: %w", err)
+		}
+		switch k := key.(type) {
+		case *rsa.PrivateKey:
+			return k, nil
+		case *ecdsa.PrivateKey:
+			return k, nil
+		default:
+			return nil, fmt.Errorf("unsupported private key type %T", key)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported private key block '%s'", block.Type)
+	}
+}
+
+// Certificates provides the certificate chain of the signer
+func (s *KeySigner) Certificates() []*x509.Certificate {
+	return s.Chain
+}
+
+// Sign signs the digest with the private key
+func (s *KeySigner) Sign(_ context.Context, hash crypto.Hash, digest []byte) ([]byte, error) {
+	return s.Key.Sign(rand.Reader, digest, hash)
+}
+
+// signer provides the Signer to use according to the configuration, if any
+func (c *Config) signer() (Signer, error) {
+	if c.Signer != nil {
+		return c.Signer, nil
+	}
+	if c.Certificate != nil {
+		return NewKeySigner(c.Certificate)
+	}
+	return nil, nil
+}
+
+// signs determines whether documents must be signed
+func (c *Config) signs() bool {
+	return c.Signer != nil || c.Certificate != nil
+}
+
+// signingCertificate provides the certificate of the signer, ensuring its
+// key is supported.
+func signingCertificate(signer Signer) (*x509.Certificate, error) {
+	certs := signer.Certificates()
+	if len(certs) == 0 {
+		return nil, errors.New("signer without certificates")
+	}
+	switch certs[0].PublicKey.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+		return certs[0], nil
+	default:
+		return nil, errors.New("unsupported public key")
+	}
+}
+
+// signData signs the SHA-256 digest of the data
+func signData(ctx context.Context, signer Signer, data []byte) ([]byte, error) {
+	h := crypto.SHA256.New()
+	h.Write(data) // nolint:errcheck
+	sig, err := signer.Sign(ctx, crypto.SHA256, h.Sum(nil))
+	if err != nil {
+		return nil, fmt.Errorf("signing: %w", err)
+	}
+	return sig, nil
+}
+
+// xmlSignatureMethod provides the XML-DSig algorithm of signatures made with
+// the certificate's key.
+func xmlSignatureMethod(cert *x509.Certificate) string {
+	if _, ok := cert.PublicKey.(*ecdsa.PublicKey); ok {
+		return algDSigECDSASHA256
+	}
+	return xmldsig.AlgDSigRSASHA256
+}
+
+// xmlSignatureValue converts signatures to the format used in XML-DSig, where
+// ECDSA signatures are the concatenation of r and s.
+func xmlSignatureValue(cert *x509.Certificate, sig []byte) ([]byte, error) {
+	pub, ok := cert.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return sig, nil
+	}
+	var rs struct {
+		R, S *big.Int
+	}
+	if _, err := asn1.Unmarshal(sig, &rs); err != nil {
+		return nil, fmt.Errorf("parsing signature: %w", err)
+	}
+	size := (pub.Curve.Params().BitSize + 7) / 8
+	value := make([]byte, 2*size)
+	rs.R.FillBytes(value[:size])
+	rs.S.FillBytes(value[size:])
+	return value, nil
+}
+
+// cmsSignatureAlgorithm provides the algorithm of signatures made with the
+// certificate's key.
+func cmsSignatureAlgorithm(cert *x509.Certificate) pkix.AlgorithmIdentifier {
+	if _, ok := cert.PublicKey.(*ecdsa.PublicKey); ok {
+		return pkix.AlgorithmIdentifier{Algorithm: oidECDSAWithSHA256}
+	}
+	return pkix.AlgorithmIdentifier{Algorithm: oidRSAEncryption, Parameters: asn1.NullRawValue}
+}
+
+// parseCertificate extracts the X.509 certificate used for signing, which is
+// only exposed in PEM format.
+func parseCertificate(cert *xmldsig.Certificate) (*x509.Certificate, error) {
+	block, _ := pem.Decode(cert.PEM())
+	if block == nil {
+		return nil, errors.New("invalid certificate")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
@@ -0,0 +1,237 @@
+package fatturapa_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	fatturapa "github.com/invopop/gobl.fatturapa"
+	"github.com/invopop/gobl.fatturapa/sdi"
+	"github.com/invopop/gobl.fatturapa/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// remoteSigner mocks a remote signing service, which only receives the
+// digests to sign and keeps the key to itself.
+type remoteSigner struct {
+	key     *ecdsa.PrivateKey
+	cert    *x509.Certificate
+	digests [][]byte
+	err     error
+}
+
+func newRemoteSigner(t *testing.T) *remoteSigner {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	return &remoteSigner{key: key, cert: selfSignedCertificate(t, key)}
+}
+
+func (s *remoteSigner) Certificates() []*x509.Certificate {
+	return []*x509.Certificate{s.cert}
+}
+
+func (s *remoteSigner) Sign(ctx context.Context, hash crypto.Hash, digest []byte) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if s.err != nil {
+		return nil, s.err
+	}
+	s.digests = append(s.digests, digest)
+	return s.key.Sign(rand.Reader, digest, hash)
+}
+
+func selfSignedCertificate(t *testing.T, key crypto.Signer) *x509.Certificate {
+	t.Helper()
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "Test Signer", Country: []string{"IT"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageContentCommitment,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert
+}
+
+func TestSigner(t *testing.T) {
+	env := test.LoadTestFile("invoice-simple.json")
+
+	t.Run("should sign with keys in memory", func(t *testing.T) {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+		signer := &fatturapa.KeySigner{
+			Key:   key,
+			Chain: []*x509.Certificate{selfSignedCertificate(t, key)},
+		}
+
+		converter := fatturapa.NewConverter(fatturapa.WithSigner(signer))
+		doc, err := converter.ConvertFromGOBL(env)
+		require.NoError(t, err)
+		assert.NotNil(t, doc.Signature.KeyInfo.KeyValue)
+
+		data, err := doc.Bytes()
+		require.NoError(t, err)
+		v, err := fatturapa.Verify(data)
+		require.NoError(t, err)
+		assert.Equal(t, signer.Chain[0].Raw, v.Certificate.Raw)
+	})
+
+	t.Run("should sign remotely", func(t *testing.T) {
+		signer := newRemoteSigner(t)
+		converter := fatturapa.NewConverter(fatturapa.WithSigner(signer))
+		doc, err := converter.ConvertFromGOBL(env)
+		require.NoError(t, err)
+		assert.Len(t, signer.digests, 1)
+		assert.Equal(t, "http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha256", doc.Signature.SignedInfo.SignatureMethod.Algorithm)
+		assert.Nil(t, doc.Signature.KeyInfo.KeyValue)
+
+		data, err := doc.Bytes()
+		require.NoError(t, err)
+		v, err := fatturapa.Verify(data)
+		require.NoError(t, err)
+		assert.Equal(t, signer.cert.Raw, v.Certificate.Raw)
+	})
+
+	t.Run("should prefer the signer over the certificate", func(t *testing.T) {
+		signer := newRemoteSigner(t)
+		doc, err := test.ConvertFromGOBL(env, test.NewConverter(fatturapa.WithSigner(signer)))
+		require.NoError(t, err)
+
+		data, err := doc.Bytes()
+		require.NoError(t, err)
+		v, err := fatturapa.Verify(data)
+		require.NoError(t, err)
+		assert.Equal(t, signer.cert.Raw, v.Certificate.Raw)
+	})
+
+	t.Run("should sign simplified documents", func(t *testing.T) {
+		signer := newRemoteSigner(t)
+		converter := fatturapa.NewConverter(fatturapa.WithSigner(signer))
+		doc, err := converter.ConvertSimplifiedFromGOBL(test.LoadTestFile("invoice-simplified.json"))
+		require.NoError(t, err)
+
+		data, err := doc.Bytes()
+		require.NoError(t, err)
+		_, err = fatturapa.Verify(data)
+		assert.NoError(t, err)
+	})
+
+	t.Run("should sign outcomes", func(t *testing.T) {
+		signer := newRemoteSigner(t)
+		converter := fatturapa.NewConverter(fatturapa.WithSigner(signer))
+		ref := &sdi.MetadatiInvioFile{IdentificativoSdI: "111", NomeFile: "IT01234567890_00001.xml", Formato: "FPA12"}
+		doc, err := converter.ConvertEsitoCommittente(ref, sdi.EsitoAccettazione, "", "1")
+		require.NoError(t, err)
+
+		data, err := doc.Bytes()
+		require.NoError(t, err)
+		_, err = fatturapa.Verify(data)
+		assert.NoError(t, err)
+	})
+
+	t.Run("should sign CAdES envelopes remotely", func(t *testing.T) {
+		signer := newRemoteSigner(t)
+		converter := fatturapa.NewConverter(
+			fatturapa.WithSigner(signer),
+			fatturapa.WithSignatureFormat(fatturapa.CAdES),
+		)
+		doc, err := converter.ConvertFromGOBL(env)
+		require.NoError(t, err)
+
+		data, err := doc.P7M()
+		require.NoError(t, err)
+		v, err := fatturapa.Verify(data)
+		require.NoError(t, err)
+		assert.Equal(t, signer.cert.Raw, v.Certificate.Raw)
+	})
+
+	t.Run("should report signing errors", func(t *testing.T) {
+		signer := newRemoteSigner(t)
+		signer.err = errors.New("unavailable")
+		converter := fatturapa.NewConverter(fatturapa.WithSigner(signer))
+		_, err := converter.ConvertFromGOBL(env)
+		assert.EqualError(t, err, "signing: unavailable")
+	})
+
+	t.Run("should honour the context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		converter := fatturapa.NewConverter(fatturapa.WithSigner(newRemoteSigner(t)))
+		_, err := converter.ConvertFromGOBLContext(ctx, env)
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+
+	t.Run("should parse private keys", func(t *testing.T) {
+		rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+		ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		pkcs8 := func(key any) []byte {
+			der, err := x509.MarshalPKCS8PrivateKey(key)
+			require.NoError(t, err)
+			return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+		}
+		sec1, err := x509.MarshalECPrivateKey(ecKey)
+		require.NoError(t, err)
+
+		key, err := fatturapa.ParsePrivateKey(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}))
+		require.NoError(t, err)
+		assert.True(t, rsaKey.Equal(key))
+		key, err = fatturapa.ParsePrivateKey(pkcs8(rsaKey))
+		require.NoError(t, err)
+		assert.True(t, rsaKey.Equal(key))
+		key, err = fatturapa.ParsePrivateKey(pkcs8(ecKey))
+		require.NoError(t, err)
+		assert.True(t, ecKey.Equal(key))
+		key, err = fatturapa.ParsePrivateKey(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: sec1}))
+		require.NoError(t, err)
+		assert.True(t, ecKey.Equal(key))
+
+		signer := &fatturapa.KeySigner{Key: key, Chain: []*x509.Certificate{selfSignedCertificate(t, ecKey)}}
+		doc, err := fatturapa.NewConverter(fatturapa.WithSigner(signer)).ConvertFromGOBL(env)
+		require.NoError(t, err)
+		data, err := doc.Bytes()
+		require.NoError(t, err)
+		_, err = fatturapa.Verify(data)
+		assert.NoError(t, err)
+	})
+
+	t.Run("should reject unsupported private keys", func(t *testing.T) {
+		_, edKey, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
+		der, err := x509.MarshalPKCS8PrivateKey(edKey)
+		require.NoError(t, err)
+
+		_, err = fatturapa.ParsePrivateKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+		assert.EqualError(t, err, "unsupported private key type ed25519.PrivateKey")
+		_, err = fatturapa.ParsePrivateKey(pem.EncodeToMemory(&pem.Block{Type: "DSA PRIVATE KEY", Bytes: der}))
+		assert.EqualError(t, err, "unsupported private key block 'DSA PRIVATE KEY'")
+		_, err = fatturapa.ParsePrivateKey([]byte("key"))
+		assert.EqualError(t, err, "invalid private key")
+	})
+
+	t.Run("should require certificates", func(t *testing.T) {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+		converter := fatturapa.NewConverter(fatturapa.WithSigner(&fatturapa.KeySigner{Key: key}))
+		_, err = converter.ConvertFromGOBL(env)
+		assert.EqualError(t, err, "signer without certificates")
+	})
+}
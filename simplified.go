@@ -0,0 +1,416 @@
+package fatturapa
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/invopop/gobl"
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/org"
+	"github.com/invopop/gobl/regimes/it"
+	"github.com/invopop/gobl/tax"
+	"github.com/invopop/xmldsig"
+)
+
+// Namespace and schema location used for simplified invoices (FSM10)
+const (
+	namespaceFatturaSemplificata = "http://ivaservizi.agenziaentrate.gov.it/docs/xsd/fatture/v1.0"
+	schemaLocationSemplificata   = "http://ivaservizi.agenziaentrate.gov.it/docs/xsd/fatture/v1.0 https://www.fatturapa.gov.it/export/documenti/fatturapa/v1.0/Schema_VFSM10.xsd"
+)
+
+const formatoTrasmissioneFSM10 = "FSM10"
+
+// tipoDocumentoCreditNoteSimplified is the only simplified document type
+// that requires the details of the invoice being corrected.
+const tipoDocumentoCreditNoteSimplified = "TD08"
+
+// SimplifiedDocument is a pseudo-model for containing the XML document of a
+// simplified invoice (FatturaElettronicaSemplificata) being created.
+type SimplifiedDocument struct {
+	env      *gobl.Envelope `xml:"-"` // Envelope to convert.
+	fileName string         `xml:"-"` // Name of the file without extension.
+	p7m      []byte         `xml:"-"` // CAdES envelope, when signed in that format.
+
+	XMLName        xml.Name `xml:"p:FatturaElettronicaSemplificata"`
+	FPANamespace   string   `xml:"xmlns:p,attr"`
+	DSigNamespace  string   `xml:"xmlns:ds,attr"`
+	XSINamespace   string   `xml:"xmlns:xsi,attr"`
+	Versione       string   `xml:"versione,attr"`
+	SchemaLocation string   `xml:"xsi:schemaLocation,attr"`
+
+	FatturaElettronicaHeader *simplifiedHeader
+	FatturaElettronicaBody   []*simplifiedBody
+
+	Signature *xmldsig.Signature `xml:"ds:Signature,omitempty"`
+}
+
+// simplifiedHeader contains the reduced party data used by simplified
+// invoices.
+type simplifiedHeader struct {
+	DatiTrasmissione       *datiTrasmissione
+	CedentePrestatore      *simplifiedSupplier
+	CessionarioCommittente *simplifiedCustomer
+}
+
+type simplifiedSupplier struct {
+	IdFiscaleIVA  *taxID // nolint:revive
+	CodiceFiscale string `xml:",omitempty"`
+	Denominazione string `xml:",omitempty"`
+	Nome          string `xml:",omitempty"`
+	Cognome       string `xml:",omitempty"`
+	Sede          *address
+	IscrizioneREA *iscrizioneREA `xml:",omitempty"`
+	RegimeFiscale string
+}
+
+type simplifiedCustomer struct {
+	IdentificativiFiscali   *identificativiFiscali
+	AltriDatiIdentificativi *altriDatiIdentificativi `xml:",omitempty"`
+}
+
+type identificativiFiscali struct {
+	IdFiscaleIVA  *taxID `xml:",omitempty"` // nolint:revive
+	CodiceFiscale string `xml:",omitempty"`
+}
+
+type altriDatiIdentificativi struct {
+	Denominazione string `xml:",omitempty"`
+	Nome          string `xml:",omitempty"`
+	Cognome       string `xml:",omitempty"`
+	Sede          *address
+}
+
+// simplifiedBody contains the invoice data of a simplified invoice, where
+// each line includes its own VAT details.
+type simplifiedBody struct {
+	DatiGenerali    *simplifiedDatiGenerali
+	DatiBeniServizi []*simplifiedDatiBeniServizi
+	Allegati        []*allegati `xml:",omitempty"`
+}
+
+type simplifiedDatiGenerali struct {
+	DatiGeneraliDocumento  *simplifiedDatiGeneraliDocumento
+	DatiFatturaRettificata *datiFatturaRettificata `xml:",omitempty"`
+}
+
+type simplifiedDatiGeneraliDocumento struct {
+	TipoDocumento string
+	Divisa        string
+	Data          string
+	Numero        string
+}
+
+// datiFatturaRettificata identifies the invoice being corrected by a
+// simplified credit note.
+type datiFatturaRettificata struct {
+	NumeroFR            string
+	DataFR              string
+	ElementiRettificati string
+}
+
+type simplifiedDatiBeniServizi struct {
+	Descrizione          string
+	Importo              string
+	DatiIVA              *datiIVA
+	Natura               string `xml:",omitempty"`
+	RiferimentoNormativo string `xml:",omitempty"`
+}
+
+type datiIVA struct {
+	Imposta  string `xml:",omitempty"`
+	Aliquota string `xml:",omitempty"`
+}
+
+// ConvertSimplifiedFromGOBL expects the base envelope of an invoice tagged
+// as simplified and provides a new SimplifiedDocument containing the
+// FatturaElettronicaSemplificata (FSM10) XML version.
+func (c *Converter) ConvertSimplifiedFromGOBL(env *gobl.Envelope) (*SimplifiedDocument, error) {
+	return c.ConvertSimplifiedFromGOBLContext(context.Background(), env)
+}
+
+// ConvertSimplifiedFromGOBLContext is like ConvertSimplifiedFromGOBL, using
+// the context provided for the requests made while signing, such as
+// timestamps.
+func (c *Converter) ConvertSimplifiedFromGOBLContext(ctx context.Context, env *gobl.Envelope) (*SimplifiedDocument, error) {
+	invoice, ok := env.Extract().(*bill.Invoice)
+	if !ok {
+		return nil, errors.New("expected an invoice")
+	}
+
+	// Make sure we're dealing with raw data
+	var err error
+	invoice, err = invoice.RemoveIncludedTaxes()
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := c.newSimplifiedHeader(invoice)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := newSimplifiedBody(invoice)
+	if err != nil {
+		return nil, err
+	}
+
+	if body.Allegati, err = newAllegati(c.Config); err != nil {
+		return nil, err
+	}
+
+	d := &SimplifiedDocument{
+		env:                      env,
+		FPANamespace:             namespaceFatturaSemplificata,
+		DSigNamespace:            namespaceDSig,
+		XSINamespace:             namespaceXSI,
+		Versione:                 formatoTrasmissioneFSM10,
+		SchemaLocation:           schemaLocationSemplificata,
+		FatturaElettronicaHeader: header,
+		FatturaElettronicaBody:   []*simplifiedBody{body},
+	}
+
+	if len(c.Config.Attachments) > 0 {
+		if err := checkFileSize(d); err != nil {
+			return nil, err
+		}
+	}
+
+	// Progressives are only issued for documents passing the checks
+	d.fileName, err = c.progressivo(env.Head.UUID.String(), header.DatiTrasmissione, header.CedentePrestatore.IdFiscaleIVA)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Config.signs() {
+		if err := d.sign(ctx, c.Config); err != nil {
+			return nil, err
+		}
+	}
+
+	return d, nil
+}
+
+// FileName provides the name the file must have when sent to the SDI, e.g.
+// "IT01234567890_0000A.xml", or "IT01234567890_0000A.xml.p7m" when signed
+// with CAdES.
+func (d *SimplifiedDocument) FileName() string {
+	if d.p7m != nil {
+		return d.fileName + ".xml.p7m"
+	}
+	return d.fileName + ".xml"
+}
+
+// Buffer returns a byte buffer representation of the complete XML document.
+func (d *SimplifiedDocument) Buffer() (*bytes.Buffer, error) {
+	return d.buffer(xml.Header)
+}
+
+// String converts a struct representation to its string representation
+func (d *SimplifiedDocument) String() (string, error) {
+	buf, err := d.Buffer()
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// Bytes returns the XML document bytes
+func (d *SimplifiedDocument) Bytes() ([]byte, error) {
+	buf, err := d.Buffer()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (d *SimplifiedDocument) buffer(base string) (*bytes.Buffer, error) {
+	return marshalDocument(d, base)
+}
+
+func (c *Converter) newSimplifiedHeader(inv *bill.Invoice) (*simplifiedHeader, error) {
+	dt := c.newDatiTrasmissione(inv)
+	if dt.IdTrasmittente != nil {
+		dt.FormatoTrasmissione = formatoTrasmissioneFSM10
+	}
+
+	sup, err := newSimplifiedSupplier(inv.Supplier)
+	if err != nil {
+		return nil, err
+	}
+
+	cus, err := newSimplifiedCustomer(inv.Customer)
+	if err != nil {
+		return nil, err
+	}
+
+	return &simplifiedHeader{
+		DatiTrasmissione:       dt,
+		CedentePrestatore:      sup,
+		CessionarioCommittente: cus,
+	}, nil
+}
+
+func newSimplifiedSupplier(s *org.Party) (*simplifiedSupplier, error) {
+	if len(s.Addresses) == 0 {
+		return nil, errors.New("simplified invoices require a supplier with an address")
+	}
+
+	a := newAnagrafica(s)
+	ns := &simplifiedSupplier{
+		IdFiscaleIVA: &taxID{
+			IdPaese:  s.TaxID.Country.String(),
+			IdCodice: s.TaxID.Code.String(),
+		},
+		Denominazione: a.Denominazione,
+		Nome:          a.Nome,
+		Cognome:       a.Cognome,
+		IscrizioneREA: newIscrizioneREA(s),
+		RegimeFiscale: "RF01",
+	}
+
+	if v, ok := s.Ext[it.ExtKeySDIFiscalRegime]; ok {
+		ns.RegimeFiscale = v.String()
+	}
+
+	ns.Sede = newAddress(s.Addresses[0])
+
+	return ns, nil
+}
+
+func newSimplifiedCustomer(c *org.Party) (*simplifiedCustomer, error) {
+	if c == nil || c.TaxID == nil {
+		return nil, errors.New("simplified invoices require a customer with a tax ID")
+	}
+
+	nc := &simplifiedCustomer{
+		IdentificativiFiscali: new(identificativiFiscali),
+	}
+
+	if isCodiceFiscale(c.TaxID) {
+		nc.IdentificativiFiscali.CodiceFiscale = c.TaxID.Code.String()
+	} else {
+		nc.IdentificativiFiscali.IdFiscaleIVA = customerFiscaleIVA(c.TaxID)
+	}
+
+	// Additional details are only valid when the address is available
+	if len(c.Addresses) > 0 {
+		a := newAnagrafica(c)
+		nc.AltriDatiIdentificativi = &altriDatiIdentificativi{
+			Denominazione: a.Denominazione,
+			Nome:          a.Nome,
+			Cognome:       a.Cognome,
+			Sede:          newAddress(c.Addresses[0]),
+		}
+	}
+
+	return nc, nil
+}
+
+func newSimplifiedBody(inv *bill.Invoice) (*simplifiedBody, error) {
+	codeTipoDocumento, err := findCodeTipoDocumento(inv)
+	if err != nil {
+		return nil, err
+	}
+
+	switch codeTipoDocumento {
+	case "TD07", "TD08", "TD09":
+		// all good
+	default:
+		return nil, fmt.Errorf("TipoDocumento '%s' is not valid for simplified invoices", codeTipoDocumento)
+	}
+
+	if len(inv.Discounts) > 0 || len(inv.Charges) > 0 {
+		return nil, errors.New("simplified invoices do not support document level discounts or charges")
+	}
+	if len(findRetainedCategories(inv.Totals)) > 0 {
+		return nil, errors.New("simplified invoices do not support retained taxes")
+	}
+
+	dfr, err := newDatiFatturaRettificata(inv, codeTipoDocumento)
+	if err != nil {
+		return nil, err
+	}
+
+	code := inv.Code
+	if inv.Series != "" {
+		code = fmt.Sprintf("%s-%s", inv.Series, inv.Code)
+	}
+
+	return &simplifiedBody{
+		DatiGenerali: &simplifiedDatiGenerali{
+			DatiGeneraliDocumento: &simplifiedDatiGeneraliDocumento{
+				TipoDocumento: codeTipoDocumento,
+				Divisa:        string(inv.Currency),
+				Data:          inv.IssueDate.String(),
+				Numero:        code,
+			},
+			DatiFatturaRettificata: dfr,
+		},
+		DatiBeniServizi: newSimplifiedDatiBeniServizi(inv),
+	}, nil
+}
+
+func newDatiFatturaRettificata(inv *bill.Invoice, codeTipoDocumento string) (*datiFatturaRettificata, error) {
+	if codeTipoDocumento != tipoDocumentoCreditNoteSimplified {
+		return nil, nil
+	}
+
+	if len(inv.Preceding) == 0 {
+		return nil, errors.New("simplified credit notes require a preceding invoice")
+	}
+	p := inv.Preceding[0]
+	if p.IssueDate == nil {
+		return nil, errors.New("simplified credit notes require the preceding invoice issue date")
+	}
+	if p.Reason == "" {
+		return nil, errors.New("simplified credit notes require a reason for the correction")
+	}
+
+	code := p.Code
+	if p.Series != "" {
+		code = fmt.Sprintf("%s-%s", p.Series, p.Code)
+	}
+
+	return &datiFatturaRettificata{
+		NumeroFR:            code,
+		DataFR:              p.IssueDate.String(),
+		ElementiRettificati: p.Reason,
+	}, nil
+}
+
+// newSimplifiedDatiBeniServizi prepares a block for each line. Amounts in
+// simplified invoices include VAT.
+func newSimplifiedDatiBeniServizi(inv *bill.Invoice) []*simplifiedDatiBeniServizi {
+	var dbs []*simplifiedDatiBeniServizi
+
+	for _, line := range inv.Lines {
+		d := &simplifiedDatiBeniServizi{
+			Descrizione: line.Item.Name,
+			DatiIVA:     new(datiIVA),
+		}
+		amount := line.Total
+
+		if vat := line.Taxes.Get(tax.CategoryVAT); vat != nil {
+			if vat.Percent != nil {
+				imposta := vat.Percent.Of(line.Total).Rescale(2)
+				amount = amount.Add(imposta)
+				d.DatiIVA.Imposta = formatAmount(&imposta)
+			}
+			d.DatiIVA.Aliquota = formatPercentage(vat.Percent)
+			if nature, ok := vat.Ext[it.ExtKeySDINature]; ok {
+				// Simplified invoices only support the main nature codes
+				d.Natura, _, _ = strings.Cut(nature.String(), ".")
+				d.RiferimentoNormativo = findRiferimentoNormativo(&tax.RateTotal{Ext: vat.Ext})
+			}
+		}
+		d.Importo = formatAmount(&amount)
+
+		dbs = append(dbs, d)
+	}
+
+	return dbs
+}
@@ -0,0 +1,139 @@
+package fatturapa_test
+
+import (
+	"testing"
+
+	"github.com/invopop/gobl.fatturapa/test"
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cal"
+	"github.com/invopop/gobl/regimes/it"
+	"github.com/invopop/gobl/tax"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSimplifiedDocument(t *testing.T) {
+	t.Run("should contain the simplified invoice data", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simplified.json")
+		doc, err := test.ConvertSimplifiedFromGOBL(env)
+		require.NoError(t, err)
+
+		assert.Equal(t, "FSM10", doc.Versione)
+		assert.NotNil(t, doc.Signature)
+
+		dt := doc.FatturaElettronicaHeader.DatiTrasmissione
+		assert.Equal(t, "FSM10", dt.FormatoTrasmissione)
+
+		cp := doc.FatturaElettronicaHeader.CedentePrestatore
+		assert.Equal(t, "12345678903", cp.IdFiscaleIVA.IdCodice)
+		assert.Equal(t, "MªF. Services", cp.Denominazione)
+		assert.Equal(t, "RF01", cp.RegimeFiscale)
+
+		cc := doc.FatturaElettronicaHeader.CessionarioCommittente
+		assert.Equal(t, "09876543217", cc.IdentificativiFiscali.IdFiscaleIVA.IdCodice)
+		assert.Nil(t, cc.AltriDatiIdentificativi)
+
+		body := doc.FatturaElettronicaBody[0]
+		assert.Equal(t, "TD07", body.DatiGenerali.DatiGeneraliDocumento.TipoDocumento)
+		assert.Equal(t, "SAMPLE-055", body.DatiGenerali.DatiGeneraliDocumento.Numero)
+		assert.Nil(t, body.DatiGenerali.DatiFatturaRettificata)
+
+		require.Len(t, body.DatiBeniServizi, 1)
+		assert.Equal(t, "Random products", body.DatiBeniServizi[0].Descrizione)
+		assert.Equal(t, "1976.40", body.DatiBeniServizi[0].Importo)
+		assert.Equal(t, "356.40", body.DatiBeniServizi[0].DatiIVA.Imposta)
+		assert.Equal(t, "22.00", body.DatiBeniServizi[0].DatiIVA.Aliquota)
+	})
+
+	t.Run("should only include main nature codes", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simplified.json")
+		test.ModifyInvoice(env, func(inv *bill.Invoice) {
+			inv.Lines[0].Taxes[0] = &tax.Combo{
+				Category: tax.CategoryVAT,
+				Rate:     tax.RateExempt,
+				Ext: tax.Extensions{
+					it.ExtKeySDINature: "N2.2",
+				},
+			}
+		})
+
+		doc, err := test.ConvertSimplifiedFromGOBL(env)
+		require.NoError(t, err)
+
+		dbs := doc.FatturaElettronicaBody[0].DatiBeniServizi[0]
+		assert.Equal(t, "N2", dbs.Natura)
+		assert.Equal(t, "0.00", dbs.DatiIVA.Aliquota)
+		assert.Empty(t, dbs.DatiIVA.Imposta)
+	})
+
+	t.Run("should include the corrected invoice in credit notes", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simplified.json")
+		test.ModifyInvoice(env, func(inv *bill.Invoice) {
+			inv.Type = bill.InvoiceTypeCreditNote
+			inv.Preceding = []*bill.Preceding{
+				{
+					Series:    "SAMPLE",
+					Code:      "001",
+					IssueDate: cal.NewDate(2023, 11, 30),
+					Reason:    "Wrong quantity",
+				},
+			}
+		})
+
+		doc, err := test.ConvertSimplifiedFromGOBL(env)
+		require.NoError(t, err)
+
+		dg := doc.FatturaElettronicaBody[0].DatiGenerali
+		assert.Equal(t, "TD08", dg.DatiGeneraliDocumento.TipoDocumento)
+		require.NotNil(t, dg.DatiFatturaRettificata)
+		assert.Equal(t, "SAMPLE-001", dg.DatiFatturaRettificata.NumeroFR)
+		assert.Equal(t, "2023-11-30", dg.DatiFatturaRettificata.DataFR)
+		assert.Equal(t, "Wrong quantity", dg.DatiFatturaRettificata.ElementiRettificati)
+	})
+
+	t.Run("should fail for credit notes without preceding invoice", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simplified.json")
+		test.ModifyInvoice(env, func(inv *bill.Invoice) {
+			inv.Type = bill.InvoiceTypeCreditNote
+		})
+
+		_, err := test.ConvertSimplifiedFromGOBL(env)
+		assert.ErrorContains(t, err, "simplified credit notes require a preceding invoice")
+	})
+
+	t.Run("should fail for invoices that are not simplified", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simple.json")
+
+		_, err := test.ConvertSimplifiedFromGOBL(env)
+		assert.ErrorContains(t, err, "is not valid for simplified invoices")
+	})
+
+	t.Run("should not be converted as an ordinary invoice", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simplified.json")
+
+		_, err := test.ConvertFromGOBL(env)
+		assert.ErrorContains(t, err, "ConvertSimplifiedFromGOBL")
+	})
+
+	t.Run("should fail for suppliers without address", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simplified.json")
+		test.ModifyInvoice(env, func(inv *bill.Invoice) {
+			inv.Supplier.Addresses = nil
+		})
+
+		_, err := test.ConvertSimplifiedFromGOBL(env)
+		assert.EqualError(t, err, "simplified invoices require a supplier with an address")
+	})
+
+	t.Run("should choose the format of the envelope", func(t *testing.T) {
+		c := test.NewConverter()
+		data, name, err := c.ConvertEnvelope(test.LoadTestFile("invoice-simplified.json"))
+		require.NoError(t, err)
+		assert.Contains(t, string(data), "FatturaElettronicaSemplificata")
+		assert.Regexp(t, `^IT01234567890_[0-9A-Za-z]{5}\.xml$`, name)
+
+		data, _, err = c.ConvertEnvelope(test.LoadTestFile("invoice-simple.json"))
+		require.NoError(t, err)
+		assert.Contains(t, string(data), "p:FatturaElettronica ")
+	})
+}
@@ -23,8 +23,8 @@ const (
 )
 
 // NewConverter returns a fatturapa.Converter with the test certificate and
-// transmitter data.
-func NewConverter() *fatturapa.Converter {
+// transmitter data. Additional options may be provided.
+func NewConverter(opts ...fatturapa.Option) *fatturapa.Converter {
 	cert, err := loadCertificate()
 
 	if err != nil {
@@ -36,10 +36,12 @@ func NewConverter() *fatturapa.Converter {
 		TaxID:       "01234567890",
 	}
 
-	converter := fatturapa.NewConverter(
+	opts = append([]fatturapa.Option{
 		fatturapa.WithTransmitterData(transmitter),
 		fatturapa.WithCertificate(cert),
-	)
+	}, opts...)
+
+	converter := fatturapa.NewConverter(opts...)
 
 	return converter
 }
@@ -88,16 +90,11 @@ func TestConversion() error { // nolint:revive
 			return err
 		}
 
-		doc, err := ConvertFromGOBL(env, NewConverter())
+		data, err := convertToBytes(env)
 		if err != nil {
 			return err
 		}
 
-		data, err := doc.Bytes()
-		if err != nil {
-			return fmt.Errorf("extracting document bytes: %w", err)
-		}
-
 		np := strings.TrimSuffix(file, filepath.Ext(file)) + ".xml"
 		err = os.WriteFile(GetDataPath()+"/"+np, data, 0644)
 		if err != nil {
@@ -108,12 +105,37 @@ func TestConversion() error { // nolint:revive
 	return nil
 }
 
+// ConvertSimplifiedFromGOBL takes the GOBL test data of a simplified invoice
+// and converts into XML
+func ConvertSimplifiedFromGOBL(env *gobl.Envelope, converter ...*fatturapa.Converter) (*fatturapa.SimplifiedDocument, error) {
+	var c *fatturapa.Converter
+
+	if len(converter) == 0 {
+		c = NewConverter()
+	} else {
+		c = converter[0]
+	}
+
+	return c.ConvertSimplifiedFromGOBL(env)
+}
+
+func convertToBytes(env *gobl.Envelope) ([]byte, error) {
+	data, _, err := NewConverter().ConvertEnvelope(env)
+	return data, err
+}
+
 // GetDataPath returns the path where test can find data files
 // to be used in tests
 func GetDataPath() string {
 	return getRootFolder() + "/test/data/"
 }
 
+// GetExamplesPath returns the path where tests can find FatturaPA XML
+// example documents
+func GetExamplesPath() string {
+	return getRootFolder() + "/test/examples/"
+}
+
 // ModifyInvoice takes a GOBL envelope and modifies the invoice
 func ModifyInvoice(env *gobl.Envelope, modifyFunc func(*bill.Invoice)) {
 	inv, ok := env.Extract().(*bill.Invoice)
@@ -146,6 +168,17 @@ func LoadTestFile(file string) *gobl.Envelope {
 	return env
 }
 
+// LoadExampleFile loads the contents of a FatturaPA XML document from the
+// test/examples folder
+func LoadExampleFile(file string) []byte {
+	data, err := os.ReadFile(GetExamplesPath() + file)
+	if err != nil {
+		panic(err)
+	}
+
+	return data
+}
+
 func loadCertificate() (*xmldsig.Certificate, error) {
 	certificatesPath := getRootFolder() + "/test/certificates/"
 
@@ -0,0 +1,292 @@
+package test
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// Object identifiers used in timestamp tokens
+var (
+	oidSignedData           = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidTSTInfo              = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}
+	oidAttributeContentType = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidAttributeDigest      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidAttributeSigningCert = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 47}
+	oidSHA256               = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidRSAEncryption        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidTSAPolicy            = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999, 1}
+)
+
+// TSAServer is a local stand-in for a timestamping authority (RFC 3161),
+// signing the timestamps with its own self-signed certificate, which is
+// only valid for timestamping.
+type TSAServer struct {
+	*httptest.Server
+
+	// Username and Password required using basic authentication, if set
+	Username string
+	Password string
+	// Time of the timestamps, or the current time if zero
+	Time time.Time
+	// Delay before responding to each request
+	Delay time.Duration
+
+	key      *rsa.PrivateKey
+	cert     *x509.Certificate
+	requests atomic.Int32
+}
+
+type tsaMessageImprint struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	HashedMessage []byte
+}
+
+type tsaRequest struct {
+	Version        int
+	MessageImprint tsaMessageImprint
+	Nonce          *big.Int `asn1:"optional"`
+	CertReq        bool     `asn1:"optional"`
+}
+
+type tsaInfo struct {
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint tsaMessageImprint
+	SerialNumber   *big.Int
+	GenTime        time.Time `asn1:"generalized"`
+	Nonce          *big.Int  `asn1:"optional"`
+}
+
+type tsaStatus struct {
+	Status int
+}
+
+type tsaContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue
+}
+
+type tsaSignedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	EncapContentInfo tsaContentInfo
+	Certificates     asn1.RawValue
+	SignerInfos      []tsaSignerInfo `asn1:"set"`
+}
+
+type tsaSignerInfo struct {
+	Version            int
+	SID                tsaIssuerAndSerial
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttrs        asn1.RawValue
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+}
+
+type tsaIssuerAndSerial struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type tsaAttribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue
+}
+
+type tsaCertID struct {
+	CertHash []byte
+}
+
+// NewTSAServer starts a local timestamping authority, which must be closed
+// once done.
+func NewTSAServer() *TSAServer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "Test TSA"},
+		NotBefore:    time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:     time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageTimeStamping},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		panic(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		panic(err)
+	}
+	s := &TSAServer{key: key, cert: cert}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// Certificate provides the certificate of the timestamping authority, to be
+// added to the trust store
+func (s *TSAServer) Certificate() *x509.Certificate {
+	return s.cert
+}
+
+// Requests provides the number of timestamps requested
+func (s *TSAServer) Requests() int {
+	return int(s.requests.Load())
+}
+
+func (s *TSAServer) handle(w http.ResponseWriter, r *http.Request) {
+	s.requests.Add(1)
+
+	if s.Username != "" {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != s.Username || pass != s.Password {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req := new(tsaRequest)
+	if _, err := asn1.Unmarshal(body, req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Cancellations are only noticed once the request body has been read
+	if s.Delay > 0 {
+		select {
+		case <-time.After(s.Delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	token, err := s.token(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	status, _ := asn1.Marshal(tsaStatus{Status: 0})
+	resp, _ := asn1.Marshal(asn1.RawValue{
+		Tag:        asn1.TagSequence,
+		IsCompound: true,
+		Bytes:      append(status, token...),
+	})
+
+	w.Header().Set("Content-Type", "application/timestamp-reply")
+	w.Write(resp) // nolint:errcheck
+}
+
+// token prepares the timestamp token, a SignedData with the TSTInfo
+func (s *TSAServer) token(req *tsaRequest) ([]byte, error) {
+	cert := s.cert
+	genTime := s.Time
+	if genTime.IsZero() {
+		genTime = time.Now()
+	}
+	info, err := asn1.Marshal(tsaInfo{
+		Version:        1,
+		Policy:         oidTSAPolicy,
+		MessageImprint: req.MessageImprint,
+		SerialNumber:   big.NewInt(int64(s.Requests())),
+		GenTime:        genTime.UTC().Truncate(time.Second),
+		Nonce:          req.Nonce,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Signed attributes, sorted by their encoding
+	digest := sha256.Sum256(info)
+	certHash := sha256.Sum256(cert.Raw)
+	var attrs [][]byte
+	for _, a := range []struct {
+		oid   asn1.ObjectIdentifier
+		value any
+	}{
+		{oidAttributeContentType, oidTSTInfo},
+		{oidAttributeDigest, digest[:]},
+		{oidAttributeSigningCert, struct{ Certs []tsaCertID }{[]tsaCertID{{certHash[:]}}}},
+	} {
+		value, err := asn1.Marshal(a.value)
+		if err != nil {
+			return nil, err
+		}
+		attr, err := asn1.Marshal(tsaAttribute{
+			Type:   a.oid,
+			Values: asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: value},
+		})
+		if err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, attr)
+	}
+	sort.Slice(attrs, func(i, j int) bool {
+		return bytes.Compare(attrs[i], attrs[j]) < 0
+	})
+	signed := bytes.Join(attrs, nil)
+	set, err := asn1.Marshal(asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: signed})
+	if err != nil {
+		return nil, err
+	}
+	setDigest := sha256.Sum256(set)
+	sigValue, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, setDigest[:])
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := asn1.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+	sd, err := asn1.Marshal(tsaSignedData{
+		Version:          3,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{{Algorithm: oidSHA256}},
+		EncapContentInfo: tsaContentInfo{
+			ContentType: oidTSTInfo,
+			Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: content},
+		},
+		Certificates: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: cert.Raw},
+		SignerInfos: []tsaSignerInfo{{
+			Version: 1,
+			SID: tsaIssuerAndSerial{
+				Issuer:       asn1.RawValue{FullBytes: cert.RawIssuer},
+				SerialNumber: cert.SerialNumber,
+			},
+			DigestAlgorithm:    pkix.AlgorithmIdentifier{Algorithm: oidSHA256},
+			SignedAttrs:        asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: signed},
+			SignatureAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidRSAEncryption, Parameters: asn1.NullRawValue},
+			Signature:          sigValue,
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(tsaContentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: sd},
+	})
+}
@@ -0,0 +1,214 @@
+package fatturapa
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+
+	"github.com/invopop/xmldsig"
+)
+
+// Maximum size of the responses accepted from a TSA
+const maxTimestampResponseSize = 1 << 20
+
+// TimestampProvider obtains timestamp tokens (RFC 3161) for signatures
+type TimestampProvider interface {
+	// Timestamp provides the DER encoded timestamp token for the digest,
+	// calculated with the hash function given.
+	Timestamp(ctx context.Context, hash crypto.Hash, digest []byte) ([]byte, error)
+}
+
+// TSAClient obtains timestamps from a timestamping authority (TSA) over
+// HTTP, using basic authentication when credentials are provided.
+type TSAClient struct {
+	URL      string
+	Username string
+	Password string
+	// Client used to send the requests, http.DefaultClient if nil
+	Client *http.Client
+}
+
+// ASN.1 structures of the timestamp protocol (RFC 3161)
+
+type tsMessageImprint struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	HashedMessage []byte
+}
+
+type tsRequest struct {
+	Version        int
+	MessageImprint tsMessageImprint
+	Nonce          *big.Int `asn1:"optional"`
+	CertReq        bool     `asn1:"optional"`
+}
+
+type tsResponse struct {
+	Status struct {
+		Status       int
+		StatusString asn1.RawValue  `asn1:"optional"`
+		FailInfo     asn1.BitString `asn1:"optional"`
+	}
+	Token asn1.RawValue `asn1:"optional"`
+}
+
+// Statuses of the responses that include a token
+const (
+	tsStatusGranted         = 0
+	tsStatusGrantedWithMods = 1
+)
+
+const tsContentTypeTimestampQuery = "application/timestamp-query"
+
+// Timestamp requests a timestamp token for the digest to the TSA
+func (c *TSAClient) Timestamp(ctx context.Context, hash crypto.Hash, digest []byte) ([]byte, error) {
+	oid, ok := cmsDigestAlgorithms[hash]
+	if !ok {
+		return nil, errors.New("unsupported hash function")
+	}
+	nonce, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, err
+	}
+	req, err := asn1.Marshal(tsRequest{
+		Version: 1,
+		MessageImprint: tsMessageImprint{
+			HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oid},
+			HashedMessage: digest,
+		},
+		Nonce:   nonce,
+		CertReq: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, bytes.NewReader(req))
+	if err != nil {
+		return nil, fmt.Errorf("timestamp request: %w", err)
+	}
+	r.Header.Set("Content-Type", tsContentTypeTimestampQuery)
+	if c.Username != "" {
+		r.SetBasicAuth(c.Username, c.Password)
+	}
+
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(r)
+	if err != nil {
+		return nil, fmt.Errorf("timestamp request: %w", err)
+	}
+	defer res.Body.Close() // nolint:errcheck
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("timestamp response error: %s", res.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(res.Body, maxTimestampResponseSize))
+	if err != nil {
+		return nil, fmt.Errorf("reading timestamp response: %w", err)
+	}
+
+	resp := new(tsResponse)
+	if _, err := asn1.Unmarshal(body, resp); err != nil {
+		return nil, fmt.Errorf("parsing timestamp response: %w", err)
+	}
+	if s := resp.Status.Status; s != tsStatusGranted && s != tsStatusGrantedWithMods {
+		return nil, fmt.Errorf("timestamp rejected with status %d", s)
+	}
+	token := resp.Token.FullBytes
+	if len(token) == 0 {
+		return nil, errors.New("timestamp response without token")
+	}
+
+	info, err := parseTSTInfo(token)
+	if err != nil {
+		return nil, err
+	}
+	if info.Nonce == nil || info.Nonce.Cmp(nonce) != 0 {
+		return nil, errors.New("timestamp nonce mismatch")
+	}
+
+	return token, nil
+}
+
+// timestampProvider provides the TSA to use according to the configuration
+func (c *Config) timestampProvider() TimestampProvider {
+	if c.Timestamps != nil {
+		return c.Timestamps
+	}
+	if c.WithTimestamp {
+		return &TSAClient{URL: xmldsig.TimestampFreeTSA}
+	}
+	return nil
+}
+
+// requestTimestamp obtains a timestamp token for the data from the provider,
+// making sure it is valid.
+func requestTimestamp(ctx context.Context, provider TimestampProvider, data []byte) ([]byte, error) {
+	digest := sha256.Sum256(data)
+	token, err := provider.Timestamp(ctx, crypto.SHA256, digest[:])
+	if err != nil {
+		return nil, fmt.Errorf("timestamp: %w", err)
+	}
+	if _, err := verifyTimestampToken(token, data); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// timestampSignature adds a timestamp of the XAdES signature value to the
+// unsigned properties. The signature must already be part of the document,
+// so that the value is canonicalized with the namespaces in scope.
+func timestampSignature(ctx context.Context, doc signable, sig *xmldsig.Signature, config *Config) error {
+	provider := config.timestampProvider()
+	if provider == nil {
+		return nil
+	}
+
+	buf, err := doc.buffer("")
+	if err != nil {
+		return err
+	}
+	root, err := parseC14N(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	var sv *c14nElement
+	root.walk(func(el *c14nElement) bool {
+		if el.is(namespaceDSig, "SignatureValue") {
+			sv = el
+			return false
+		}
+		return true
+	})
+	if sv == nil {
+		return errors.New("missing SignatureValue")
+	}
+
+	token, err := requestTimestamp(ctx, provider, sv.canonicalize(new(c14nOptions)))
+	if err != nil {
+		return err
+	}
+
+	sig.Object.QualifyingProperties.UnsignedProperties = &xmldsig.UnsignedProperties{
+		SignatureTimestamp: &xmldsig.Timestamp{
+			CanonicalizationMethod: &xmldsig.AlgorithmMethod{
+				Algorithm: c14nInclusive,
+			},
+			EncapsulatedTimeStamp: base64.StdEncoding.EncodeToString(token),
+		},
+	}
+
+	return nil
+}
@@ -0,0 +1,159 @@
+package fatturapa_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"testing"
+	"time"
+
+	fatturapa "github.com/invopop/gobl.fatturapa"
+	"github.com/invopop/gobl.fatturapa/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// staticTimestamps always provides the same token
+type staticTimestamps []byte
+
+func (s staticTimestamps) Timestamp(_ context.Context, _ crypto.Hash, _ []byte) ([]byte, error) {
+	if s == nil {
+		return nil, errors.New("unavailable")
+	}
+	return s, nil
+}
+
+func TestTimestamps(t *testing.T) {
+	env := test.LoadTestFile("invoice-simple.json")
+	tsa := test.NewTSAServer()
+	defer tsa.Close()
+
+	t.Run("should timestamp XAdES signatures", func(t *testing.T) {
+		converter := test.NewConverter(fatturapa.WithTSA(tsa.URL, "", ""))
+		doc, err := test.ConvertFromGOBL(env, converter)
+		require.NoError(t, err)
+
+		qp := doc.Signature.Object.QualifyingProperties
+		require.NotNil(t, qp.UnsignedProperties)
+		assert.NotEmpty(t, qp.UnsignedProperties.SignatureTimestamp.EncapsulatedTimeStamp)
+
+		data, err := doc.Bytes()
+		require.NoError(t, err)
+		v, err := fatturapa.Verify(data)
+		require.NoError(t, err)
+		require.NotNil(t, v.Timestamp)
+		assert.WithinDuration(t, time.Now(), v.Timestamp.Time, time.Minute)
+		assert.Equal(t, tsa.Certificate().Raw, v.Timestamp.Certificate.Raw)
+	})
+
+	t.Run("should timestamp simplified documents", func(t *testing.T) {
+		converter := test.NewConverter(fatturapa.WithTSA(tsa.URL, "", ""))
+		doc, err := test.ConvertSimplifiedFromGOBL(test.LoadTestFile("invoice-simplified.json"), converter)
+		require.NoError(t, err)
+
+		data, err := doc.Bytes()
+		require.NoError(t, err)
+		v, err := fatturapa.Verify(data)
+		require.NoError(t, err)
+		assert.NotNil(t, v.Timestamp)
+	})
+
+	t.Run("should send credentials", func(t *testing.T) {
+		tsa := test.NewTSAServer()
+		defer tsa.Close()
+		tsa.Username = "user"
+		tsa.Password = "secret"
+
+		converter := test.NewConverter(fatturapa.WithTSA(tsa.URL, "user", "wrong"))
+		_, err := test.ConvertFromGOBL(env, converter)
+		assert.EqualError(t, err, "timestamp: timestamp response error: 401 Unauthorized")
+
+		converter = test.NewConverter(fatturapa.WithTSA(tsa.URL, "user", "secret"))
+		_, err = test.ConvertFromGOBL(env, converter)
+		assert.NoError(t, err)
+	})
+
+	t.Run("should honour the context", func(t *testing.T) {
+		tsa := test.NewTSAServer()
+		defer tsa.Close()
+		tsa.Delay = 5 * time.Second
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		converter := test.NewConverter(fatturapa.WithTSA(tsa.URL, "", ""))
+		_, err := converter.ConvertFromGOBLContext(ctx, env)
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	})
+
+	t.Run("should use custom providers", func(t *testing.T) {
+		converter := test.NewConverter(fatturapa.WithTimestampProvider(staticTimestamps(nil)))
+		_, err := test.ConvertFromGOBL(env, converter)
+		assert.EqualError(t, err, "timestamp: unavailable")
+	})
+
+	t.Run("should check the tokens received", func(t *testing.T) {
+		converter := test.NewConverter(fatturapa.WithTSA(tsa.URL, "", ""))
+		doc, err := test.ConvertSimplifiedFromGOBL(test.LoadTestFile("invoice-simplified.json"), converter)
+		require.NoError(t, err)
+		data, err := doc.Bytes()
+		require.NoError(t, err)
+		v, err := fatturapa.Verify(data)
+		require.NoError(t, err)
+
+		// Tokens issued for other signatures are rejected
+		converter = test.NewConverter(fatturapa.WithTimestampProvider(staticTimestamps(v.Timestamp.Token)))
+		_, err = test.ConvertFromGOBL(env, converter)
+		assert.EqualError(t, err, "timestamp: message imprint mismatch")
+	})
+
+	t.Run("should check the trust at the time of the timestamp", func(t *testing.T) {
+		tsa := test.NewTSAServer()
+		defer tsa.Close()
+		tsa.Time = time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+		converter := test.NewConverter(fatturapa.WithTSA(tsa.URL, "", ""))
+		doc, err := test.ConvertFromGOBL(env, converter)
+		require.NoError(t, err)
+		data, err := doc.Bytes()
+		require.NoError(t, err)
+
+		v, err := fatturapa.Verify(data)
+		require.NoError(t, err)
+		pool := x509.NewCertPool()
+		pool.AddCert(v.Certificate)
+		pool.AddCert(tsa.Certificate())
+
+		v, err = fatturapa.Verify(data, fatturapa.WithTrustStore(pool))
+		require.NoError(t, err)
+		assert.Equal(t, tsa.Time, v.Timestamp.Time)
+		assert.True(t, v.Trusted)
+		assert.NoError(t, v.TrustError)
+	})
+
+	t.Run("should not use the time of untrusted timestamps", func(t *testing.T) {
+		tsa := test.NewTSAServer()
+		defer tsa.Close()
+		tsa.Time = time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+		converter := test.NewConverter(fatturapa.WithTSA(tsa.URL, "", ""))
+		doc, err := test.ConvertFromGOBL(env, converter)
+		require.NoError(t, err)
+		data, err := doc.Bytes()
+		require.NoError(t, err)
+
+		v, err := fatturapa.Verify(data)
+		require.NoError(t, err)
+		pool := x509.NewCertPool()
+		pool.AddCert(v.Certificate)
+
+		// The signer's certificate has expired by now
+		v, err = fatturapa.Verify(data, fatturapa.WithTrustStore(pool))
+		require.NoError(t, err)
+		assert.False(t, v.Trusted)
+		require.Error(t, v.TrustError)
+		assert.Contains(t, v.TrustError.Error(), "timestamp not trusted, using the current time")
+		assert.Contains(t, v.TrustError.Error(), "expired")
+	})
+}
@@ -1,7 +1,6 @@
 package fatturapa
 
 import (
-	"github.com/invopop/gobl"
 	"github.com/invopop/gobl/bill"
 	"github.com/invopop/gobl/l10n"
 	"github.com/invopop/gobl/org"
@@ -30,7 +29,7 @@ type datiTrasmissione struct {
 	PECDestinatario     string `xml:",omitempty"`
 }
 
-func (c *Converter) newDatiTrasmissione(inv *bill.Invoice, env *gobl.Envelope) *datiTrasmissione {
+func (c *Converter) newDatiTrasmissione(inv *bill.Invoice) *datiTrasmissione {
 	dt := &datiTrasmissione{
 		CodiceDestinatario: codiceDestinatario(inv.Customer),
 		PECDestinatario:    pecDestinatario(inv.Customer),
@@ -42,7 +41,6 @@ func (c *Converter) newDatiTrasmissione(inv *bill.Invoice, env *gobl.Envelope) *
 			IdPaese:  c.Config.Transmitter.CountryCode,
 			IdCodice: c.Config.Transmitter.TaxID,
 		}
-		dt.ProgressivoInvio = env.Head.UUID.String()[:8]
 		dt.FormatoTrasmissione = formatoTransmissione(inv.Customer)
 	}
 
@@ -87,3 +85,29 @@ func pecDestinatario(cus *org.Party) string {
 	}
 	return ""
 }
+
+func goblInboxes(dt *datiTrasmissione) []*org.Inbox {
+	var inboxes []*org.Inbox
+	if dt == nil {
+		return inboxes
+	}
+
+	switch dt.CodiceDestinatario {
+	case "", defaultCodiceDestinatarioItalianBusiness, defaultCodiceDestinatarioForeignBusiness:
+		// nothing to add
+	default:
+		inboxes = append(inboxes, &org.Inbox{
+			Key:  it.KeyInboxSDICode,
+			Code: dt.CodiceDestinatario,
+		})
+	}
+
+	if dt.PECDestinatario != "" {
+		inboxes = append(inboxes, &org.Inbox{
+			Key:  it.KeyInboxSDIPEC,
+			Code: dt.PECDestinatario,
+		})
+	}
+
+	return inboxes
+}
@@ -0,0 +1,288 @@
+package fatturapa
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cal"
+	"github.com/invopop/gobl/cbc"
+	"github.com/invopop/gobl/num"
+	"github.com/invopop/gobl/org"
+)
+
+// IdentityTypeIncoterms is used in the delivery identities to provide the
+// Incoterms code of the delivery, e.g. "EXW" or "DAP".
+const IdentityTypeIncoterms cbc.Code = "INCOTERMS"
+
+// Delivery meta keys used to describe the transport of the goods in
+// accompanying invoices (fattura accompagnatoria).
+const (
+	MetaKeyTransportMeans   cbc.Key = "transport-means"
+	MetaKeyTransportReason  cbc.Key = "transport-reason"
+	MetaKeyPackages         cbc.Key = "packages"
+	MetaKeyGoodsDescription cbc.Key = "goods-description"
+	MetaKeyWeightUnit       cbc.Key = "weight-unit"
+	MetaKeyGrossWeight      cbc.Key = "gross-weight"
+	MetaKeyNetWeight        cbc.Key = "net-weight"
+	MetaKeyPickupTime       cbc.Key = "pickup-time"
+	MetaKeyTransportStart   cbc.Key = "transport-start"
+	MetaKeyDeliveryTime     cbc.Key = "delivery-time"
+)
+
+// Delivery meta keys used to describe the carrier of the goods. The tax ID
+// includes the country code prefix, i.e. "IT12345678903", and either the
+// name of the organization or the given name and surname of the person must
+// be provided.
+const (
+	MetaKeyCarrierTaxID      cbc.Key = "carrier-tax-id"
+	MetaKeyCarrierFiscalCode cbc.Key = "carrier-fiscal-code"
+	MetaKeyCarrierName       cbc.Key = "carrier-name"
+	MetaKeyCarrierGivenName  cbc.Key = "carrier-given-name"
+	MetaKeyCarrierSurname    cbc.Key = "carrier-surname"
+	MetaKeyDriverLicense     cbc.Key = "driver-license"
+)
+
+var carrierTaxIDRegexp = regexp.MustCompile(`^([A-Z]{2})([0-9A-Za-z]{1,28})$`)
+
+// timeSuffix is added to dates when a date and time is expected
+const timeSuffix = "T00:00:00"
+
+// datiTrasporto contains data about the transport of the goods
+type datiTrasporto struct {
+	DatiAnagraficiVettore *datiAnagraficiVettore `xml:",omitempty"`
+	MezzoTrasporto        string                 `xml:",omitempty"`
+	CausaleTrasporto      string                 `xml:",omitempty"`
+	NumeroColli           string                 `xml:",omitempty"`
+	Descrizione           string                 `xml:",omitempty"`
+	UnitaMisuraPeso       string                 `xml:",omitempty"`
+	PesoLordo             string                 `xml:",omitempty"`
+	PesoNetto             string                 `xml:",omitempty"`
+	DataOraRitiro         string                 `xml:",omitempty"`
+	DataInizioTrasporto   string                 `xml:",omitempty"`
+	TipoResa              string                 `xml:",omitempty"`
+	IndirizzoResa         *address               `xml:",omitempty"`
+	DataOraConsegna       string                 `xml:",omitempty"`
+}
+
+// datiAnagraficiVettore contains the details of the carrier
+type datiAnagraficiVettore struct {
+	IdFiscaleIVA       *taxID // nolint:revive
+	CodiceFiscale      string `xml:",omitempty"`
+	Anagrafica         *anagrafica
+	NumeroLicenzaGuida string `xml:",omitempty"`
+}
+
+// newDatiTrasporto prepares the transport data from the invoice's delivery
+// details. The carrier of the goods is taken from the carrier meta keys, while
+// the receiver's first address is used as the delivery address.
+func newDatiTrasporto(inv *bill.Invoice) (*datiTrasporto, error) {
+	del := inv.Delivery
+	if del == nil {
+		return nil, nil
+	}
+
+	dt := new(datiTrasporto)
+	var meta cbc.Meta
+	if del.Meta != nil {
+		meta = *del.Meta
+	}
+
+	var err error
+	if dt.DatiAnagraficiVettore, err = newDatiAnagraficiVettore(meta); err != nil {
+		return nil, err
+	}
+	if r := del.Receiver; r != nil && len(r.Addresses) > 0 {
+		dt.IndirizzoResa = newAddress(r.Addresses[0])
+	}
+
+	dt.MezzoTrasporto = meta[MetaKeyTransportMeans]
+	dt.CausaleTrasporto = meta[MetaKeyTransportReason]
+	dt.Descrizione = meta[MetaKeyGoodsDescription]
+	dt.UnitaMisuraPeso = meta[MetaKeyWeightUnit]
+
+	if v := meta[MetaKeyPackages]; v != "" {
+		if n, err := strconv.Atoi(v); err != nil || n < 1 || n > 9999 {
+			return nil, fmt.Errorf("delivery %s must be a number between 1 and 9999", MetaKeyPackages)
+		}
+		dt.NumeroColli = v
+	}
+
+	if dt.PesoLordo, err = formatWeight(meta, MetaKeyGrossWeight); err != nil {
+		return nil, err
+	}
+	if dt.PesoNetto, err = formatWeight(meta, MetaKeyNetWeight); err != nil {
+		return nil, err
+	}
+
+	if v := meta[MetaKeyPickupTime]; v != "" {
+		dt.DataOraRitiro = formatDateTime(v)
+	}
+
+	if p := del.Period; p != nil {
+		dt.DataInizioTrasporto = p.Start.String()
+		dt.DataOraConsegna = p.End.String() + timeSuffix
+	}
+	if v, err := formatMetaDate(meta, MetaKeyTransportStart); err != nil {
+		return nil, fmt.Errorf("delivery %w", err)
+	} else if v != "" {
+		dt.DataInizioTrasporto = v
+	}
+	if del.Date != nil {
+		dt.DataOraConsegna = del.Date.String() + timeSuffix
+	}
+	if v := meta[MetaKeyDeliveryTime]; v != "" {
+		dt.DataOraConsegna = formatDateTime(v)
+	}
+
+	for _, id := range del.Identities {
+		if id.Type == IdentityTypeIncoterms {
+			dt.TipoResa = id.Code.String()
+		}
+	}
+
+	return dt, nil
+}
+
+// newDatiAnagraficiVettore prepares the carrier details from the meta keys
+func newDatiAnagraficiVettore(meta cbc.Meta) (*datiAnagraficiVettore, error) {
+	tid := meta[MetaKeyCarrierTaxID]
+	a := &anagrafica{
+		Denominazione: meta[MetaKeyCarrierName],
+		Nome:          meta[MetaKeyCarrierGivenName],
+		Cognome:       meta[MetaKeyCarrierSurname],
+	}
+	if tid == "" && meta[MetaKeyCarrierFiscalCode] == "" && *a == (anagrafica{}) {
+		if meta[MetaKeyDriverLicense] != "" {
+			return nil, fmt.Errorf("delivery %s requires a carrier", MetaKeyDriverLicense)
+		}
+		return nil, nil
+	}
+
+	m := carrierTaxIDRegexp.FindStringSubmatch(tid)
+	if m == nil {
+		return nil, fmt.Errorf("delivery %s must include the country code and tax code of the carrier", MetaKeyCarrierTaxID)
+	}
+	if a.Denominazione == "" && (a.Nome == "" || a.Cognome == "") {
+		return nil, fmt.Errorf("delivery carrier requires %s, or %s and %s", MetaKeyCarrierName, MetaKeyCarrierGivenName, MetaKeyCarrierSurname)
+	}
+	if a.Denominazione != "" {
+		a.Nome = ""
+		a.Cognome = ""
+	}
+
+	return &datiAnagraficiVettore{
+		IdFiscaleIVA: &taxID{
+			IdPaese:  m[1],
+			IdCodice: m[2],
+		},
+		CodiceFiscale:      meta[MetaKeyCarrierFiscalCode],
+		Anagrafica:         a,
+		NumeroLicenzaGuida: meta[MetaKeyDriverLicense],
+	}, nil
+}
+
+func formatWeight(meta cbc.Meta, key cbc.Key) (string, error) {
+	v := meta[key]
+	if v == "" {
+		return "", nil
+	}
+	a, err := num.AmountFromString(v)
+	if err != nil {
+		return "", fmt.Errorf("delivery %s: %w", key, err)
+	}
+	return a.Rescale(2).String(), nil
+}
+
+// formatDateTime ensures a time is included when only a date is available
+func formatDateTime(v string) string {
+	if !strings.Contains(v, "T") {
+		return v + timeSuffix
+	}
+	return v
+}
+
+func goblDelivery(dt *datiTrasporto) (*bill.Delivery, error) {
+	if dt == nil {
+		return nil, nil
+	}
+
+	del := new(bill.Delivery)
+	meta := make(cbc.Meta)
+
+	if v := dt.DatiAnagraficiVettore; v != nil {
+		if v.IdFiscaleIVA != nil {
+			meta[MetaKeyCarrierTaxID] = v.IdFiscaleIVA.IdPaese + v.IdFiscaleIVA.IdCodice
+		}
+		if v.Anagrafica != nil {
+			meta[MetaKeyCarrierName] = v.Anagrafica.Denominazione
+			meta[MetaKeyCarrierGivenName] = v.Anagrafica.Nome
+			meta[MetaKeyCarrierSurname] = v.Anagrafica.Cognome
+		}
+		meta[MetaKeyCarrierFiscalCode] = v.CodiceFiscale
+		meta[MetaKeyDriverLicense] = v.NumeroLicenzaGuida
+	}
+	if dt.IndirizzoResa != nil {
+		del.Receiver = &org.Party{
+			Addresses: []*org.Address{goblAddress(dt.IndirizzoResa)},
+		}
+	}
+
+	for k, v := range map[cbc.Key]string{
+		MetaKeyTransportMeans:   dt.MezzoTrasporto,
+		MetaKeyTransportReason:  dt.CausaleTrasporto,
+		MetaKeyPackages:         dt.NumeroColli,
+		MetaKeyGoodsDescription: dt.Descrizione,
+		MetaKeyWeightUnit:       dt.UnitaMisuraPeso,
+		MetaKeyGrossWeight:      dt.PesoLordo,
+		MetaKeyNetWeight:        dt.PesoNetto,
+		MetaKeyPickupTime:       dt.DataOraRitiro,
+	} {
+		meta[k] = v
+	}
+
+	if dt.TipoResa != "" {
+		del.Identities = []*org.Identity{
+			{Type: IdentityTypeIncoterms, Code: cbc.Code(dt.TipoResa)},
+		}
+	}
+
+	if dt.DataOraConsegna != "" {
+		parts := strings.SplitN(dt.DataOraConsegna, "T", 2)
+		d, err := parseDate(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("DataOraConsegna: %w", err)
+		}
+		del.Date = &d
+		if len(parts) > 1 && "T"+parts[1] != timeSuffix {
+			meta[MetaKeyDeliveryTime] = dt.DataOraConsegna
+		}
+	}
+
+	// The start of the transport is kept as a period when the delivery date
+	// ends it, so that both dates are exported again.
+	if dt.DataInizioTrasporto != "" {
+		d, err := parseDate(dt.DataInizioTrasporto)
+		if err != nil {
+			return nil, fmt.Errorf("DataInizioTrasporto: %w", err)
+		}
+		if del.Date != nil && del.Date.DaysSince(d.Date) >= 0 {
+			del.Period = &cal.Period{Start: d, End: *del.Date}
+		} else {
+			meta[MetaKeyTransportStart] = d.String()
+		}
+	}
+
+	for k, v := range meta {
+		if v == "" {
+			delete(meta, k)
+		}
+	}
+	if len(meta) > 0 {
+		del.Meta = &meta
+	}
+
+	return del, nil
+}
@@ -0,0 +1,154 @@
+package fatturapa
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cbc"
+)
+
+// Item meta keys used to link invoice lines to the transport documents (DDT)
+// they were delivered with. The date key may also be set in the invoice meta
+// to provide the date of the ordering despatch advice. When the date is not
+// provided, the invoice's delivery date will be used.
+const (
+	MetaKeyDespatch     cbc.Key = "despatch"
+	MetaKeyDespatchDate cbc.Key = "despatch-date"
+)
+
+// datiDDT contains data about a transport document (documento di trasporto)
+type datiDDT struct {
+	NumeroDDT              string
+	DataDDT                string
+	RiferimentoNumeroLinea []int `xml:",omitempty"`
+}
+
+// newDatiDDT prepares the transport documents from the invoice's despatch
+// advice and those referenced by the lines.
+func newDatiDDT(inv *bill.Invoice) ([]*datiDDT, error) {
+	var codes []string
+	dates := make(map[string]string)
+	lines := make(map[string][]int)
+
+	deliveryDate, err := formatMetaDate(inv.Meta, MetaKeyDespatchDate)
+	if err != nil {
+		return nil, err
+	}
+	if deliveryDate == "" && inv.Delivery != nil && inv.Delivery.Date != nil {
+		deliveryDate = inv.Delivery.Date.String()
+	}
+
+	if inv.Ordering != nil && inv.Ordering.Despatch != nil {
+		code := documentReferenceCode(inv.Ordering.Despatch)
+		codes = append(codes, code)
+		dates[code] = deliveryDate
+	}
+
+	for _, line := range inv.Lines {
+		if line.Item == nil {
+			continue
+		}
+		code := line.Item.Meta[MetaKeyDespatch]
+		if code == "" {
+			continue
+		}
+		if _, ok := dates[code]; !ok {
+			codes = append(codes, code)
+			dates[code] = deliveryDate
+		}
+		if date := line.Item.Meta[MetaKeyDespatchDate]; date != "" {
+			if _, err := parseDate(date); err != nil {
+				return nil, fmt.Errorf("line %d: %w", line.Index, err)
+			}
+			dates[code] = date
+		}
+		lines[code] = append(lines[code], line.Index)
+	}
+
+	var dd []*datiDDT
+	for _, code := range codes {
+		if dates[code] == "" {
+			return nil, fmt.Errorf("transport document %s requires a date", code)
+		}
+		ln := lines[code]
+		sort.Ints(ln)
+		dd = append(dd, &datiDDT{
+			NumeroDDT:              code,
+			DataDDT:                dates[code],
+			RiferimentoNumeroLinea: ln,
+		})
+	}
+
+	return dd, nil
+}
+
+// hasTransportDocuments determines if the invoice refers to any transport
+// document, as required by deferred invoices.
+func hasTransportDocuments(inv *bill.Invoice) bool {
+	if inv.Ordering != nil && inv.Ordering.Despatch != nil {
+		return true
+	}
+	for _, line := range inv.Lines {
+		if line.Item != nil && line.Item.Meta[MetaKeyDespatch] != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// goblDespatch sets the invoice's despatch advice and line references from
+// the transport documents. Dates are kept in the meta data, as the date of a
+// transport document is not necessarily the delivery date.
+func goblDespatch(inv *bill.Invoice, dd []*datiDDT) error {
+	if len(dd) == 0 {
+		return nil
+	}
+
+	dates := make([]string, len(dd))
+	for i, d := range dd {
+		date, err := parseDate(d.DataDDT)
+		if err != nil {
+			return fmt.Errorf("DataDDT: %w", err)
+		}
+		dates[i] = date.String()
+	}
+
+	if inv.Ordering == nil {
+		inv.Ordering = new(bill.Ordering)
+	}
+	inv.Ordering.Despatch = &bill.DocumentReference{
+		Code: dd[0].NumeroDDT,
+	}
+	if inv.Meta == nil {
+		inv.Meta = make(cbc.Meta)
+	}
+	inv.Meta[MetaKeyDespatchDate] = dates[0]
+
+	for i, d := range dd {
+		for _, n := range d.RiferimentoNumeroLinea {
+			if n < 1 || n > len(inv.Lines) {
+				continue
+			}
+			item := inv.Lines[n-1].Item
+			setItemMeta(item, MetaKeyDespatch, d.NumeroDDT)
+			if dates[i] != dates[0] {
+				setItemMeta(item, MetaKeyDespatchDate, dates[i])
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkDeferredInvoice ensures that deferred invoices (TD24 and TD25) refer to
+// the transport documents of the goods being invoiced.
+func checkDeferredInvoice(inv *bill.Invoice, code string) error {
+	switch code {
+	case "TD24", "TD25":
+		if !hasTransportDocuments(inv) {
+			return fmt.Errorf("%s documents require transport document (DDT) references", code)
+		}
+	}
+	return nil
+}
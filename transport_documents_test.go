@@ -0,0 +1,137 @@
+package fatturapa_test
+
+import (
+	"bytes"
+	"testing"
+
+	fatturapa "github.com/invopop/gobl.fatturapa"
+	"github.com/invopop/gobl.fatturapa/test"
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cal"
+	"github.com/invopop/gobl/cbc"
+	"github.com/invopop/gobl/regimes/it"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDatiDDT(t *testing.T) {
+	deferred := func(inv *bill.Invoice) {
+		inv.Tax = &bill.Tax{Tags: []cbc.Key{it.TagDeferred}}
+		inv.Ordering = &bill.Ordering{
+			Despatch: &bill.DocumentReference{Code: "DDT-1"},
+		}
+		inv.Delivery = &bill.Delivery{
+			Date: cal.NewDate(2023, 3, 1),
+		}
+	}
+
+	t.Run("should be empty without transport documents", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simple.json")
+		doc, err := test.ConvertFromGOBL(env)
+		require.NoError(t, err)
+
+		assert.Empty(t, doc.FatturaElettronicaBody[0].DatiGenerali.DatiDDT)
+	})
+
+	t.Run("should contain the despatch advice", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simple.json")
+		test.ModifyInvoice(env, deferred)
+
+		doc, err := test.ConvertFromGOBL(env)
+		require.NoError(t, err)
+
+		dg := doc.FatturaElettronicaBody[0].DatiGenerali
+		assert.Equal(t, "TD24", dg.DatiGeneraliDocumento.TipoDocumento)
+		require.Len(t, dg.DatiDDT, 1)
+		assert.Equal(t, "DDT-1", dg.DatiDDT[0].NumeroDDT)
+		assert.Equal(t, "2023-03-01", dg.DatiDDT[0].DataDDT)
+		assert.Empty(t, dg.DatiDDT[0].RiferimentoNumeroLinea)
+	})
+
+	t.Run("should link lines to their transport documents", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-irpef.json")
+		test.ModifyInvoice(env, func(inv *bill.Invoice) {
+			deferred(inv)
+			inv.Lines[0].Item.Meta = cbc.Meta{fatturapa.MetaKeyDespatch: "DDT-1"}
+			inv.Lines[1].Item.Meta = cbc.Meta{
+				fatturapa.MetaKeyDespatch:     "DDT-2",
+				fatturapa.MetaKeyDespatchDate: "2023-02-20",
+			}
+		})
+
+		doc, err := test.ConvertFromGOBL(env)
+		require.NoError(t, err)
+
+		dd := doc.FatturaElettronicaBody[0].DatiGenerali.DatiDDT
+		require.Len(t, dd, 2)
+		assert.Equal(t, "DDT-1", dd[0].NumeroDDT)
+		assert.Equal(t, "2023-03-01", dd[0].DataDDT)
+		assert.Equal(t, []int{1}, dd[0].RiferimentoNumeroLinea)
+		assert.Equal(t, "DDT-2", dd[1].NumeroDDT)
+		assert.Equal(t, "2023-02-20", dd[1].DataDDT)
+		assert.Equal(t, []int{2}, dd[1].RiferimentoNumeroLinea)
+	})
+
+	t.Run("should fail for deferred invoices without transport documents", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simple.json")
+		test.ModifyInvoice(env, func(inv *bill.Invoice) {
+			inv.Tax = &bill.Tax{Tags: []cbc.Key{it.TagDeferred, it.TagThirdPeriod}}
+		})
+
+		_, err := test.ConvertFromGOBL(env)
+		assert.EqualError(t, err, "TD25 documents require transport document (DDT) references")
+	})
+
+	t.Run("should prefer the despatch date of the invoice meta", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simple.json")
+		test.ModifyInvoice(env, func(inv *bill.Invoice) {
+			deferred(inv)
+			inv.Meta = cbc.Meta{fatturapa.MetaKeyDespatchDate: "2023-02-25"}
+		})
+
+		doc, err := test.ConvertFromGOBL(env)
+		require.NoError(t, err)
+
+		dd := doc.FatturaElettronicaBody[0].DatiGenerali.DatiDDT
+		require.Len(t, dd, 1)
+		assert.Equal(t, "2023-02-25", dd[0].DataDDT)
+	})
+
+	t.Run("should fail for transport documents without date", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simple.json")
+		test.ModifyInvoice(env, func(inv *bill.Invoice) {
+			deferred(inv)
+			inv.Delivery = nil
+		})
+
+		_, err := test.ConvertFromGOBL(env)
+		assert.EqualError(t, err, "transport document DDT-1 requires a date")
+	})
+
+	t.Run("should be imported as despatch advice", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-irpef.json")
+		test.ModifyInvoice(env, func(inv *bill.Invoice) {
+			deferred(inv)
+			inv.Lines[1].Item.Meta = cbc.Meta{
+				fatturapa.MetaKeyDespatch:     "DDT-2",
+				fatturapa.MetaKeyDespatchDate: "2023-02-20",
+			}
+		})
+
+		c := test.NewConverter()
+		doc, err := test.ConvertFromGOBL(env, c)
+		require.NoError(t, err)
+		data, err := doc.Bytes()
+		require.NoError(t, err)
+
+		out, err := c.ConvertToGOBL(bytes.NewReader(data))
+		require.NoError(t, err)
+		inv := out.Extract().(*bill.Invoice)
+
+		assert.True(t, inv.Tax.ContainsTag(it.TagDeferred))
+		assert.Equal(t, "DDT-1", inv.Ordering.Despatch.Code)
+		assert.Equal(t, "2023-03-01", inv.Meta[fatturapa.MetaKeyDespatchDate])
+		assert.Equal(t, "DDT-2", inv.Lines[1].Item.Meta[fatturapa.MetaKeyDespatch])
+		assert.Equal(t, "2023-02-20", inv.Lines[1].Item.Meta[fatturapa.MetaKeyDespatchDate])
+	})
+}
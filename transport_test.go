@@ -0,0 +1,239 @@
+package fatturapa_test
+
+import (
+	"bytes"
+	"testing"
+
+	fatturapa "github.com/invopop/gobl.fatturapa"
+	"github.com/invopop/gobl.fatturapa/test"
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cal"
+	"github.com/invopop/gobl/cbc"
+	"github.com/invopop/gobl/l10n"
+	"github.com/invopop/gobl/org"
+	"github.com/invopop/gobl/tax"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDatiTrasporto(t *testing.T) {
+	delivery := func(inv *bill.Invoice) {
+		inv.Delivery = &bill.Delivery{
+			Receiver: &org.Party{
+				Name: "Magazzino Centrale",
+				Addresses: []*org.Address{
+					{
+						Street:   "Via Roma",
+						Number:   "10",
+						Locality: "Milano",
+						Region:   "MI",
+						Code:     "20100",
+						Country:  l10n.IT,
+					},
+				},
+			},
+			Identities: []*org.Identity{
+				{Type: fatturapa.IdentityTypeIncoterms, Code: "DAP"},
+			},
+			Period: &cal.Period{
+				Start: *cal.NewDate(2023, 2, 27),
+				End:   *cal.NewDate(2023, 3, 2),
+			},
+			Date: cal.NewDate(2023, 3, 1),
+			Meta: &cbc.Meta{
+				fatturapa.MetaKeyTransportMeans:  "Furgone",
+				fatturapa.MetaKeyTransportReason: "Vendita",
+				fatturapa.MetaKeyPackages:        "3",
+				fatturapa.MetaKeyWeightUnit:      "KG",
+				fatturapa.MetaKeyGrossWeight:     "120.5",
+				fatturapa.MetaKeyNetWeight:       "110",
+				fatturapa.MetaKeyPickupTime:      "2023-02-27T10:30:00",
+				fatturapa.MetaKeyCarrierTaxID:    "IT12345678903",
+				fatturapa.MetaKeyCarrierName:     "Trasporti Veloci S.r.l.",
+				fatturapa.MetaKeyDriverLicense:   "MI1234567X",
+			},
+		}
+	}
+
+	t.Run("should be empty without delivery details", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simple.json")
+		doc, err := test.ConvertFromGOBL(env)
+		require.NoError(t, err)
+
+		assert.Nil(t, doc.FatturaElettronicaBody[0].DatiGenerali.DatiTrasporto)
+	})
+
+	t.Run("should contain the delivery details", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simple.json")
+		test.ModifyInvoice(env, delivery)
+
+		doc, err := test.ConvertFromGOBL(env)
+		require.NoError(t, err)
+		data, err := doc.String()
+		require.NoError(t, err)
+
+		assert.Contains(t, data, "<IdCodice>12345678903</IdCodice>")
+		assert.Contains(t, data, "<Denominazione>Trasporti Veloci S.r.l.</Denominazione>")
+		assert.Contains(t, data, "<NumeroLicenzaGuida>MI1234567X</NumeroLicenzaGuida>")
+		assert.Contains(t, data, "<MezzoTrasporto>Furgone</MezzoTrasporto>")
+		assert.Contains(t, data, "<CausaleTrasporto>Vendita</CausaleTrasporto>")
+		assert.Contains(t, data, "<NumeroColli>3</NumeroColli>")
+		assert.Contains(t, data, "<UnitaMisuraPeso>KG</UnitaMisuraPeso>")
+		assert.Contains(t, data, "<PesoLordo>120.50</PesoLordo>")
+		assert.Contains(t, data, "<PesoNetto>110.00</PesoNetto>")
+		assert.Contains(t, data, "<DataOraRitiro>2023-02-27T10:30:00</DataOraRitiro>")
+		assert.Contains(t, data, "<DataInizioTrasporto>2023-02-27</DataInizioTrasporto>")
+		assert.Contains(t, data, "<TipoResa>DAP</TipoResa>")
+		assert.Contains(t, data, "<IndirizzoResa>")
+		assert.Contains(t, data, "<Comune>Milano</Comune>")
+		assert.Contains(t, data, "<DataOraConsegna>2023-03-01T00:00:00</DataOraConsegna>")
+	})
+
+	t.Run("should use the period end without a delivery date", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simple.json")
+		test.ModifyInvoice(env, func(inv *bill.Invoice) {
+			delivery(inv)
+			inv.Delivery.Date = nil
+		})
+
+		doc, err := test.ConvertFromGOBL(env)
+		require.NoError(t, err)
+
+		dt := doc.FatturaElettronicaBody[0].DatiGenerali.DatiTrasporto
+		require.NotNil(t, dt)
+		assert.Equal(t, "2023-03-02T00:00:00", dt.DataOraConsegna)
+	})
+
+	t.Run("should not use the receiver as carrier", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simple.json")
+		test.ModifyInvoice(env, func(inv *bill.Invoice) {
+			delivery(inv)
+			inv.Delivery.Receiver.TaxID = &tax.Identity{Country: l10n.IT, Code: "12345678903"}
+			inv.Delivery.Meta = nil
+		})
+
+		doc, err := test.ConvertFromGOBL(env)
+		require.NoError(t, err)
+
+		dt := doc.FatturaElettronicaBody[0].DatiGenerali.DatiTrasporto
+		require.NotNil(t, dt)
+		assert.Nil(t, dt.DatiAnagraficiVettore)
+		assert.NotNil(t, dt.IndirizzoResa)
+	})
+
+	t.Run("should validate the carrier", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simple.json")
+		test.ModifyInvoice(env, func(inv *bill.Invoice) {
+			delivery(inv)
+			(*inv.Delivery.Meta)[fatturapa.MetaKeyCarrierTaxID] = "12345678903"
+		})
+		_, err := test.ConvertFromGOBL(env)
+		assert.ErrorContains(t, err, "delivery carrier-tax-id must include the country code")
+
+		env = test.LoadTestFile("invoice-simple.json")
+		test.ModifyInvoice(env, func(inv *bill.Invoice) {
+			delivery(inv)
+			delete(*inv.Delivery.Meta, fatturapa.MetaKeyCarrierName)
+		})
+		_, err = test.ConvertFromGOBL(env)
+		assert.ErrorContains(t, err, "delivery carrier requires carrier-name")
+	})
+
+	t.Run("should validate the number of packages", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simple.json")
+		test.ModifyInvoice(env, func(inv *bill.Invoice) {
+			delivery(inv)
+			(*inv.Delivery.Meta)[fatturapa.MetaKeyPackages] = "many"
+		})
+
+		_, err := test.ConvertFromGOBL(env)
+		assert.ErrorContains(t, err, "delivery packages must be a number between 1 and 9999")
+	})
+
+	t.Run("should validate the weights", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simple.json")
+		test.ModifyInvoice(env, func(inv *bill.Invoice) {
+			delivery(inv)
+			(*inv.Delivery.Meta)[fatturapa.MetaKeyGrossWeight] = "heavy"
+		})
+
+		_, err := test.ConvertFromGOBL(env)
+		assert.ErrorContains(t, err, "delivery gross-weight")
+	})
+
+	t.Run("should be imported as delivery details", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simple.json")
+		test.ModifyInvoice(env, delivery)
+
+		c := test.NewConverter()
+		doc, err := test.ConvertFromGOBL(env, c)
+		require.NoError(t, err)
+		data, err := doc.Bytes()
+		require.NoError(t, err)
+
+		out, err := c.ConvertToGOBL(bytes.NewReader(data))
+		require.NoError(t, err)
+		inv := out.Extract().(*bill.Invoice)
+
+		del := inv.Delivery
+		require.NotNil(t, del)
+		require.NotNil(t, del.Receiver)
+		assert.Nil(t, del.Receiver.TaxID)
+		require.Len(t, del.Receiver.Addresses, 1)
+		assert.Equal(t, "Milano", del.Receiver.Addresses[0].Locality)
+		assert.Equal(t, "2023-03-01", del.Date.String())
+		require.NotNil(t, del.Period)
+		assert.Equal(t, "2023-02-27", del.Period.Start.String())
+		require.Len(t, del.Identities, 1)
+		assert.Equal(t, "DAP", del.Identities[0].Code.String())
+		require.NotNil(t, del.Meta)
+		assert.Equal(t, "Furgone", (*del.Meta)[fatturapa.MetaKeyTransportMeans])
+		assert.Equal(t, "3", (*del.Meta)[fatturapa.MetaKeyPackages])
+		assert.Equal(t, "120.50", (*del.Meta)[fatturapa.MetaKeyGrossWeight])
+		assert.Equal(t, "MI1234567X", (*del.Meta)[fatturapa.MetaKeyDriverLicense])
+		assert.Equal(t, "IT12345678903", (*del.Meta)[fatturapa.MetaKeyCarrierTaxID])
+		assert.Equal(t, "Trasporti Veloci S.r.l.", (*del.Meta)[fatturapa.MetaKeyCarrierName])
+	})
+
+	t.Run("should round trip the transport data", func(t *testing.T) {
+		for name, modify := range map[string]func(*bill.Delivery){
+			"with period": func(*bill.Delivery) {},
+			"with start only": func(del *bill.Delivery) {
+				del.Period = nil
+				del.Date = nil
+				(*del.Meta)[fatturapa.MetaKeyTransportStart] = "2023-02-27"
+			},
+			"with delivery time": func(del *bill.Delivery) {
+				(*del.Meta)[fatturapa.MetaKeyDeliveryTime] = "2023-03-01T16:45:00"
+			},
+		} {
+			t.Run(name, func(t *testing.T) {
+				env := test.LoadTestFile("invoice-simple.json")
+				test.ModifyInvoice(env, func(inv *bill.Invoice) {
+					delivery(inv)
+					modify(inv.Delivery)
+				})
+
+				c := test.NewConverter()
+				doc, err := test.ConvertFromGOBL(env, c)
+				require.NoError(t, err)
+				data, err := doc.Bytes()
+				require.NoError(t, err)
+
+				out, err := c.ConvertToGOBL(bytes.NewReader(data))
+				require.NoError(t, err)
+				inv := out.Extract().(*bill.Invoice)
+				test.ModifyInvoice(env, func(orig *bill.Invoice) {
+					orig.Delivery = inv.Delivery
+				})
+
+				doc2, err := test.ConvertFromGOBL(env, c)
+				require.NoError(t, err)
+				assert.Equal(t,
+					doc.FatturaElettronicaBody[0].DatiGenerali.DatiTrasporto,
+					doc2.FatturaElettronicaBody[0].DatiGenerali.DatiTrasporto,
+				)
+			})
+		}
+	})
+}
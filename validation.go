@@ -0,0 +1,107 @@
+package fatturapa
+
+import (
+	_ "embed" // for the schemas
+	"fmt"
+	"strings"
+	"sync"
+)
+
+var (
+	//go:embed schema/fatturapav1_2_2.xsd
+	schemaFatturaPA []byte
+
+	//go:embed "schema/ST Fatturazione elettronica - Schema VFSM10_Schema_VFSM10.xsd"
+	schemaFatturaSemplificata []byte
+)
+
+var (
+	loadSchemasOnce sync.Once
+	schemas         map[string]*xsdSchema
+	errSchemas      error
+)
+
+// ValidationError describes a violation of the FatturaPA schema, along with
+// the XPath of the element or attribute concerned.
+type ValidationError struct {
+	Path    string
+	Message string
+}
+
+// Error provides the path and message of the violation.
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+// ValidationErrors contains all the violations found in a document.
+type ValidationErrors []*ValidationError
+
+// Error joins the messages of all the violations.
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Validate checks the document against the FatturaPA XSD, returning
+// ValidationErrors with the details of each violation found.
+func (d *Document) Validate() error {
+	data, err := d.Bytes()
+	if err != nil {
+		return err
+	}
+	return ValidateXML(data)
+}
+
+// Validate checks the document against the FatturaPA simplified invoice XSD,
+// returning ValidationErrors with the details of each violation found.
+func (d *SimplifiedDocument) Validate() error {
+	data, err := d.Bytes()
+	if err != nil {
+		return err
+	}
+	return ValidateXML(data)
+}
+
+// ValidateXML checks the given FatturaPA XML document against the schema
+// corresponding to its root element, either FatturaElettronica or
+// FatturaElettronicaSemplificata. ValidationErrors are returned with the
+// details of each violation found.
+func ValidateXML(data []byte) error {
+	loadSchemasOnce.Do(loadSchemas)
+	if errSchemas != nil {
+		return errSchemas
+	}
+
+	root, err := parseXMLNode(data)
+	if err != nil {
+		return err
+	}
+
+	s, ok := schemas[root.name.Space]
+	if !ok {
+		return fmt.Errorf("unsupported namespace '%s'", root.name.Space)
+	}
+
+	v := &xsdValidator{schema: s}
+	v.validateRoot(root)
+	if len(v.errs) > 0 {
+		return v.errs
+	}
+
+	return nil
+}
+
+func loadSchemas() {
+	schemas = make(map[string]*xsdSchema)
+	for _, data := range [][]byte{schemaFatturaPA, schemaFatturaSemplificata} {
+		s, err := parseXSD(data)
+		if err != nil {
+			errSchemas = fmt.Errorf("loading schema: %w", err)
+			return
+		}
+		schemas[s.namespace] = s
+	}
+}
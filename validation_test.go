@@ -0,0 +1,135 @@
+package fatturapa_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	fatturapa "github.com/invopop/gobl.fatturapa"
+	"github.com/invopop/gobl.fatturapa/test"
+	"github.com/invopop/gobl/bill"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func loadValidationErrors(t *testing.T, err error) fatturapa.ValidationErrors {
+	t.Helper()
+	require.Error(t, err)
+	errs, ok := err.(fatturapa.ValidationErrors)
+	require.True(t, ok, "expected validation errors, got: %v", err)
+	return errs
+}
+
+func modifyExample(t *testing.T, name string, replacements ...string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(test.GetExamplesPath() + name)
+	require.NoError(t, err)
+	out := string(data)
+	for i := 0; i < len(replacements); i += 2 {
+		require.Contains(t, out, replacements[i])
+		out = strings.Replace(out, replacements[i], replacements[i+1], 1)
+	}
+	return []byte(out)
+}
+
+func TestValidate(t *testing.T) {
+	t.Run("should accept converted documents", func(t *testing.T) {
+		for _, name := range []string{"invoice-simple.json", "invoice-hotel.json", "invoice-simple-with-pec.json"} {
+			doc, err := test.ConvertFromGOBL(test.LoadTestFile(name))
+			require.NoError(t, err)
+			assert.NoError(t, doc.Validate(), name)
+		}
+	})
+
+	t.Run("should accept simplified documents", func(t *testing.T) {
+		doc, err := test.ConvertSimplifiedFromGOBL(test.LoadTestFile("invoice-simplified.json"))
+		require.NoError(t, err)
+		assert.NoError(t, doc.Validate())
+	})
+
+	t.Run("should report invalid values", func(t *testing.T) {
+		env := test.LoadTestFile("invoice-simple.json")
+		test.ModifyInvoice(env, func(inv *bill.Invoice) {
+			inv.Lines[0].Item.Name = strings.Repeat("x", 1001)
+		})
+		doc, err := test.ConvertFromGOBL(env)
+		require.NoError(t, err)
+
+		errs := loadValidationErrors(t, doc.Validate())
+		require.Len(t, errs, 1)
+		assert.Equal(t, "/FatturaElettronica/FatturaElettronicaBody/DatiBeniServizi/DettaglioLinee[1]/Descrizione", errs[0].Path)
+		assert.Contains(t, errs[0].Message, "does not match pattern")
+	})
+}
+
+func TestValidateXML(t *testing.T) {
+	body := "/FatturaElettronica/FatturaElettronicaBody"
+
+	t.Run("should accept valid examples", func(t *testing.T) {
+		for _, name := range []string{"bare-minimum.xml", "public-single-line.xml", "invoice_simplified_sample.xml"} {
+			data, err := os.ReadFile(test.GetExamplesPath() + name)
+			require.NoError(t, err)
+			assert.NoError(t, fatturapa.ValidateXML(data), name)
+		}
+	})
+
+	t.Run("should check the order of elements", func(t *testing.T) {
+		data := modifyExample(t, "private-multiple-lines.xml")
+
+		errs := loadValidationErrors(t, fatturapa.ValidateXML(data))
+		require.Len(t, errs, 1)
+		assert.Equal(t, "/FatturaElettronica/FatturaElettronicaHeader/DatiTrasmissione/ContattiTrasmittente: unexpected element", errs[0].Error())
+	})
+
+	t.Run("should check missing elements", func(t *testing.T) {
+		data := modifyExample(t, "bare-minimum.xml", "<Divisa>EUR</Divisa>", "")
+
+		errs := loadValidationErrors(t, fatturapa.ValidateXML(data))
+		require.Len(t, errs, 1)
+		assert.Equal(t, body+"/DatiGenerali/DatiGeneraliDocumento/Divisa: missing element", errs[0].Error())
+	})
+
+	t.Run("should check cardinality", func(t *testing.T) {
+		data := modifyExample(t, "bare-minimum.xml", "<Divisa>EUR</Divisa>", "<Divisa>EUR</Divisa><Divisa>EUR</Divisa>")
+
+		errs := loadValidationErrors(t, fatturapa.ValidateXML(data))
+		require.Len(t, errs, 1)
+		assert.Equal(t, body+"/DatiGenerali/DatiGeneraliDocumento/Divisa[2]: unexpected element", errs[0].Error())
+	})
+
+	t.Run("should check choices", func(t *testing.T) {
+		data := modifyExample(t, "bare-minimum.xml", "<Denominazione>ALPHA SRL</Denominazione>", "")
+
+		errs := loadValidationErrors(t, fatturapa.ValidateXML(data))
+		require.Len(t, errs, 1)
+		assert.Equal(t, "/FatturaElettronica/FatturaElettronicaHeader/CedentePrestatore/DatiAnagrafici/Anagrafica: missing one of Denominazione, Nome, Cognome", errs[0].Error())
+	})
+
+	t.Run("should check patterns, enumerations and lengths", func(t *testing.T) {
+		data := modifyExample(t, "bare-minimum.xml",
+			"<IdCodice>01234567890</IdCodice>", "<IdCodice>"+strings.Repeat("0", 29)+"</IdCodice>",
+			"<TipoDocumento>TD01</TipoDocumento>", "<TipoDocumento>TD99</TipoDocumento>",
+			"<Quantita>5.00</Quantita>", "<Quantita>5</Quantita>",
+		)
+
+		errs := loadValidationErrors(t, fatturapa.ValidateXML(data))
+		require.Len(t, errs, 3)
+		assert.Equal(t, "/FatturaElettronica/FatturaElettronicaHeader/DatiTrasmissione/IdTrasmittente/IdCodice: value '"+strings.Repeat("0", 29)+"' must be at most 28 characters long", errs[0].Error())
+		assert.Equal(t, body+"/DatiGenerali/DatiGeneraliDocumento/TipoDocumento: value 'TD99' is not allowed", errs[1].Error())
+		assert.Equal(t, body+"/DatiBeniServizi/DettaglioLinee/Quantita: value '5' does not match pattern '[0-9]{1,12}\\.[0-9]{2,8}'", errs[2].Error())
+	})
+
+	t.Run("should check attributes", func(t *testing.T) {
+		data := modifyExample(t, "bare-minimum.xml", `versione="FPA12"`, `versione="FPX12" foo="bar"`)
+
+		errs := loadValidationErrors(t, fatturapa.ValidateXML(data))
+		require.Len(t, errs, 2)
+		assert.Equal(t, "/FatturaElettronica/@versione: value 'FPX12' is not allowed", errs[0].Error())
+		assert.Equal(t, "/FatturaElettronica/@foo: unexpected attribute", errs[1].Error())
+	})
+
+	t.Run("should reject unknown documents", func(t *testing.T) {
+		err := fatturapa.ValidateXML([]byte(`<Unknown/>`))
+		assert.EqualError(t, err, "unsupported namespace ''")
+	})
+}
@@ -0,0 +1,599 @@
+package fatturapa
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	_ "crypto/sha1" // hashes used by signatures
+	_ "crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/invopop/xmldsig"
+)
+
+// Algorithms supported when verifying XAdES signatures
+const (
+	transformEnveloped = "http://www.w3.org/2000/09/xmldsig#enveloped-signature"
+	transformXPath     = "http://www.w3.org/TR/1999/REC-xpath-19991116"
+	transformXPath2    = "http://www.w3.org/2002/06/xmldsig-filter2"
+)
+
+var xmlDigestMethods = map[string]crypto.Hash{
+	"http://www.w3.org/2000/09/xmldsig#sha1":        crypto.SHA1,
+	"http://www.w3.org/2001/04/xmldsig-more#sha224": crypto.SHA224,
+	"http://www.w3.org/2001/04/xmlenc#sha256":       crypto.SHA256,
+	"http://www.w3.org/2001/04/xmldsig-more#sha384": crypto.SHA384,
+	"http://www.w3.org/2001/04/xmlenc#sha512":       crypto.SHA512,
+}
+
+var xmlSignatureMethods = map[string]crypto.Hash{
+	"http://www.w3.org/2000/09/xmldsig#rsa-sha1":          crypto.SHA1,
+	"http://www.w3.org/2001/04/xmldsig-more#rsa-sha256":   crypto.SHA256,
+	"http://www.w3.org/2001/04/xmldsig-more#rsa-sha384":   crypto.SHA384,
+	"http://www.w3.org/2001/04/xmldsig-more#rsa-sha512":   crypto.SHA512,
+	"http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha256": crypto.SHA256,
+	"http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha384": crypto.SHA384,
+	"http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha512": crypto.SHA512,
+}
+
+// Verification contains the details of a valid signature
+type Verification struct {
+	// Format of the signature
+	Format SignatureFormat
+	// Data is the XML document signed, extracted from the envelope when
+	// using CAdES.
+	Data []byte
+	// Certificate used to sign the document
+	Certificate *x509.Certificate
+	// Certificates included in the signature, starting with the signer's
+	Certificates []*x509.Certificate
+	// SigningTime claimed by the signer, if any
+	SigningTime time.Time
+	// Timestamp added to the signature, if any
+	Timestamp *TimestampToken
+	// Trusted is set when the certificate chains up to the trust store
+	Trusted bool
+	// TrustError explains why the certificate is not trusted, or why the
+	// time of the timestamp could not be used to check it
+	TrustError error
+}
+
+// TimestampToken describes a timestamp (RFC 3161) added to a signature
+type TimestampToken struct {
+	// Time the timestamp was generated at
+	Time time.Time
+	// Certificate of the timestamping authority
+	Certificate *x509.Certificate
+	// Token is the DER encoded timestamp token
+	Token []byte
+
+	// certificates included in the token, used as intermediates
+	certificates []*x509.Certificate
+}
+
+// verifyConfig contains the configuration used to verify signatures
+type verifyConfig struct {
+	roots *x509.CertPool
+	time  time.Time
+}
+
+// VerifyOption is a function that can be passed to Verify to configure it
+type VerifyOption func(*verifyConfig)
+
+// WithTrustStore will check the signer's certificate chains up to one of the
+// certificates in the pool provided
+func WithTrustStore(pool *x509.CertPool) VerifyOption {
+	return func(c *verifyConfig) {
+		c.roots = pool
+	}
+}
+
+// WithVerificationTime will check the validity of the signer's certificate
+// at the given time, instead of the timestamp or current time
+func WithVerificationTime(t time.Time) VerifyOption {
+	return func(c *verifyConfig) {
+		c.time = t
+	}
+}
+
+// Verify checks the signature of a FatturaPA document, either an XML with an
+// enveloped XAdES signature or a CAdES .p7m envelope, which may be encoded in
+// base64. An error is returned if the signature is not valid. The signer's
+// certificate is checked against the trust store, if configured, at the
+// time of the timestamp or otherwise the current time, with the result
+// reported in the Verification. The time of the timestamp is only used when
+// the certificate of the timestamping authority is trusted for timestamping.
+func Verify(data []byte, opts ...VerifyOption) (*Verification, error) {
+	config := new(verifyConfig)
+	for _, opt := range opts {
+		opt(config)
+	}
+
+	if len(data) == 0 || data[0] != 0x30 {
+		// Only text documents may be surrounded by whitespace, as DER
+		// envelopes can end with any byte.
+		data = bytes.TrimSpace(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")))
+	}
+	if len(data) == 0 {
+		return nil, errors.New("empty document")
+	}
+
+	var v *Verification
+	var err error
+	switch {
+	case data[0] == '<':
+		v, err = verifyXAdES(data)
+	case data[0] == 0x30:
+		v, err = verifyCAdES(data)
+	default:
+		der, derr := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(string(data)), ""))
+		if derr != nil || len(der) == 0 || der[0] != 0x30 {
+			return nil, errors.New("unrecognised signed document")
+		}
+		v, err = verifyCAdES(der)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	v.checkTrust(config)
+
+	return v, nil
+}
+
+// checkTrust determines if the signer's certificate is valid according to
+// the trust store
+func (v *Verification) checkTrust(config *verifyConfig) {
+	if config.roots == nil {
+		v.TrustError = errors.New("no trust store configured")
+		return
+	}
+
+	at := config.time
+	var tsErr error
+	if at.IsZero() {
+		at = time.Now()
+		if v.Timestamp != nil {
+			if tsErr = v.Timestamp.checkTrust(config.roots); tsErr == nil {
+				at = v.Timestamp.Time
+			} else {
+				tsErr = fmt.Errorf("timestamp not trusted, using the current time: %w", tsErr)
+			}
+		}
+	}
+
+	_, err := v.Certificate.Verify(x509.VerifyOptions{
+		Roots:         config.roots,
+		Intermediates: certPool(v.Certificates[1:]),
+		CurrentTime:   at,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	v.Trusted = err == nil
+	v.TrustError = errors.Join(tsErr, err)
+}
+
+// checkTrust ensures the certificate of the timestamping authority chains up
+// to the trust store and may be used for timestamps at the time of the token
+func (ts *TimestampToken) checkTrust(roots *x509.CertPool) error {
+	_, err := ts.Certificate.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: certPool(ts.certificates),
+		CurrentTime:   ts.Time,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageTimeStamping},
+	})
+	return err
+}
+
+func certPool(certs []*x509.Certificate) *x509.CertPool {
+	pool := x509.NewCertPool()
+	for _, c := range certs {
+		pool.AddCert(c)
+	}
+	return pool
+}
+
+// LoadTrustStore loads the certificates in PEM or DER format found in the
+// path provided, which may be a file or a directory, into a pool to be used
+// as trust store.
+func LoadTrustStore(path string) (*x509.CertPool, error) {
+	files := []string{path}
+	if fi, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("loading trust store: %w", err)
+	} else if fi.IsDir() {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, fmt.Errorf("loading trust store: %w", err)
+		}
+		files = files[:0]
+		for _, e := range entries {
+			if !e.IsDir() {
+				files = append(files, filepath.Join(path, e.Name()))
+			}
+		}
+	}
+
+	pool := x509.NewCertPool()
+	for _, name := range files {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return nil, fmt.Errorf("loading trust store: %w", err)
+		}
+		certs, err := parseCertificates(data)
+		if err != nil {
+			return nil, fmt.Errorf("loading trust store %s: %w", name, err)
+		}
+		for _, c := range certs {
+			pool.AddCert(c)
+		}
+	}
+
+	return pool, nil
+}
+
+// parseCertificates parses the certificates in PEM or DER format
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	if !bytes.Contains(data, []byte("-----BEGIN")) {
+		return x509.ParseCertificates(data)
+	}
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, c)
+	}
+	return certs, nil
+}
+
+// verifyXAdES checks the enveloped signature of an XML document, along
+// with the digests of all its references and the XAdES signed properties.
+func verifyXAdES(data []byte) (*Verification, error) {
+	root, err := parseC14N(data)
+	if err != nil {
+		return nil, err
+	}
+	if id := root.duplicateID(); id != "" {
+		return nil, fmt.Errorf("duplicate Id '%s'", id)
+	}
+
+	var sig *c14nElement
+	root.walk(func(el *c14nElement) bool {
+		if el.is(namespaceDSig, "Signature") {
+			sig = el
+			return false
+		}
+		return true
+	})
+	if sig == nil {
+		return nil, errors.New("document is not signed")
+	}
+
+	v := &Verification{Format: XAdES, Data: data}
+
+	for _, el := range sig.childElements() {
+		if !el.is(namespaceDSig, "KeyInfo") {
+			continue
+		}
+		for _, x := range el.childElements() {
+			if !x.is(namespaceDSig, "X509Data") {
+				continue
+			}
+			for _, c := range x.childElements() {
+				if !c.is(namespaceDSig, "X509Certificate") {
+					continue
+				}
+				cert, err := x509.ParseCertificate(decodeBase64(c.text()))
+				if err != nil {
+					return nil, fmt.Errorf("parsing certificate: %w", err)
+				}
+				v.Certificates = append(v.Certificates, cert)
+			}
+		}
+	}
+	if len(v.Certificates) == 0 {
+		return nil, errors.New("missing signer certificate")
+	}
+	v.Certificate = v.Certificates[0]
+
+	si := sig.child(namespaceDSig, "SignedInfo")
+	if si == nil {
+		return nil, errors.New("missing SignedInfo")
+	}
+	c14n, err := c14nMethod(si.child(namespaceDSig, "CanonicalizationMethod"))
+	if err != nil {
+		return nil, err
+	}
+	method := ""
+	if sm := si.child(namespaceDSig, "SignatureMethod"); sm != nil {
+		method = sm.attr("Algorithm")
+	}
+	hash, ok := xmlSignatureMethods[method]
+	if !ok {
+		return nil, fmt.Errorf("unsupported signature method '%s'", method)
+	}
+	sv := sig.child(namespaceDSig, "SignatureValue")
+	if sv == nil {
+		return nil, errors.New("missing SignatureValue")
+	}
+	if err := checkSignature(v.Certificate, hash, si.canonicalize(c14n), decodeBase64(sv.text()), true); err != nil {
+		return nil, err
+	}
+
+	// All the references must match, and cover both the document and the
+	// signed properties.
+	var sp *c14nElement
+	for _, obj := range sig.childElements() {
+		if obj.is(namespaceDSig, "Object") && sp == nil {
+			sp = obj.find(xmldsig.NamespaceXAdES, "QualifyingProperties", "SignedProperties")
+		}
+	}
+	if sp == nil {
+		return nil, errors.New("missing SignedProperties")
+	}
+	var document, properties bool
+	for _, ref := range si.childElements() {
+		if !ref.is(namespaceDSig, "Reference") {
+			continue
+		}
+		uri := ref.attr("URI")
+		if err := checkReference(root, sig, ref); err != nil {
+			return nil, fmt.Errorf("reference '%s': %w", uri, err)
+		}
+		switch {
+		case uri == "" || (uri == "#"+root.attr("Id") && root.attr("Id") != ""):
+			document = true
+		case strings.HasPrefix(uri, "#") && root.byID(uri[1:]) == sp:
+			properties = true
+		}
+	}
+	if !document {
+		return nil, errors.New("signature does not cover the document")
+	}
+	if !properties {
+		return nil, errors.New("signature does not cover the SignedProperties")
+	}
+
+	ssp := sp.child(xmldsig.NamespaceXAdES, "SignedSignatureProperties")
+	if ssp == nil {
+		return nil, errors.New("missing SignedSignatureProperties")
+	}
+	if err := checkSigningCertificate(ssp, v.Certificate); err != nil {
+		return nil, err
+	}
+	if st := ssp.child(xmldsig.NamespaceXAdES, "SigningTime"); st != nil {
+		v.SigningTime, err = parseXMLTime(st.text())
+		if err != nil {
+			return nil, fmt.Errorf("parsing signing time: %w", err)
+		}
+	}
+
+	// xmldsig writes the timestamp as SignatureTimestamp instead of the
+	// SignatureTimeStamp element defined by XAdES, so accept both.
+	usp := sp.parent.find(xmldsig.NamespaceXAdES, "UnsignedProperties", "UnsignedSignatureProperties")
+	var ts *c14nElement
+	if usp != nil {
+		ts = usp.child(xmldsig.NamespaceXAdES, "SignatureTimeStamp")
+		if ts == nil {
+			ts = usp.child(xmldsig.NamespaceXAdES, "SignatureTimestamp")
+		}
+	}
+	if ts != nil {
+		c14n, err := c14nMethod(ts.child(namespaceDSig, "CanonicalizationMethod"))
+		if err != nil {
+			return nil, err
+		}
+		token := ts.child(xmldsig.NamespaceXAdES, "EncapsulatedTimeStamp")
+		if token == nil {
+			return nil, errors.New("missing EncapsulatedTimeStamp")
+		}
+		v.Timestamp, err = verifyTimestampToken(decodeBase64(token.text()), sv.canonicalize(c14n))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return v, nil
+}
+
+// c14nMethod provides the canonicalization options for the method element,
+// using inclusive canonicalization by default.
+func c14nMethod(el *c14nElement) (*c14nOptions, error) {
+	if el == nil {
+		return new(c14nOptions), nil
+	}
+	opts := new(c14nOptions)
+	switch alg := el.attr("Algorithm"); alg {
+	case c14nInclusive:
+	case c14nInclusiveComments:
+		opts.comments = true
+	case c14nExclusive, c14nExclusiveWithComments:
+		opts.exclusive = true
+		opts.comments = alg == c14nExclusiveWithComments
+		if in := el.child(c14nExclusive, "InclusiveNamespaces"); in != nil {
+			opts.inclusive = make(map[string]bool)
+			for _, p := range strings.Fields(in.attr("PrefixList")) {
+				if p == "#default" {
+					p = ""
+				}
+				opts.inclusive[p] = true
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unsupported canonicalization method '%s'", alg)
+	}
+	return opts, nil
+}
+
+// checkReference calculates the digest of the data referenced after applying
+// the transforms, and compares it to the expected value.
+func checkReference(root, sig, ref *c14nElement) error {
+	uri := ref.attr("URI")
+	node := root
+	if uri != "" {
+		if !strings.HasPrefix(uri, "#") {
+			return errors.New("unsupported reference")
+		}
+		if node = root.byID(uri[1:]); node == nil {
+			return errors.New("element not found")
+		}
+	}
+
+	opts := new(c14nOptions)
+	var excluded []func(*c14nElement) bool
+	if t := ref.child(namespaceDSig, "Transforms"); t != nil {
+		for _, tr := range t.childElements() {
+			if !tr.is(namespaceDSig, "Transform") {
+				continue
+			}
+			switch alg := tr.attr("Algorithm"); alg {
+			case transformEnveloped:
+				excluded = append(excluded, func(el *c14nElement) bool {
+					return el == sig
+				})
+			case transformXPath, transformXPath2:
+				// Only the expressions used to remove signatures are supported
+				expr := strings.Join(strings.Fields(tr.text()), "")
+				if expr != "not(ancestor-or-self::ds:Signature)" && expr != "/descendant::ds:Signature" {
+					return fmt.Errorf("unsupported xpath '%s'", expr)
+				}
+				excluded = append(excluded, func(el *c14nElement) bool {
+					return el.is(namespaceDSig, "Signature")
+				})
+			default:
+				m, err := c14nMethod(tr)
+				if err != nil {
+					return fmt.Errorf("unsupported transform '%s'", alg)
+				}
+				opts = m
+			}
+		}
+	}
+	opts.exclude = func(el *c14nElement) bool {
+		for _, fn := range excluded {
+			if fn(el) {
+				return true
+			}
+		}
+		return false
+	}
+	if uri == "" {
+		opts.comments = false
+	}
+
+	method := ""
+	if dm := ref.child(namespaceDSig, "DigestMethod"); dm != nil {
+		method = dm.attr("Algorithm")
+	}
+	hash, ok := xmlDigestMethods[method]
+	if !ok {
+		return fmt.Errorf("unsupported digest method '%s'", method)
+	}
+	dv := ref.child(namespaceDSig, "DigestValue")
+	if dv == nil {
+		return errors.New("missing DigestValue")
+	}
+
+	h := hash.New()
+	h.Write(node.canonicalize(opts)) // nolint:errcheck
+	if !bytes.Equal(h.Sum(nil), decodeBase64(dv.text())) {
+		return errors.New("digest mismatch")
+	}
+	return nil
+}
+
+// checkSigningCertificate ensures the certificate used to sign matches the
+// one referenced in the signed properties.
+func checkSigningCertificate(ssp *c14nElement, cert *x509.Certificate) error {
+	sc := ssp.child(xmldsig.NamespaceXAdES, "SigningCertificate")
+	if sc == nil {
+		sc = ssp.child(xmldsig.NamespaceXAdES, "SigningCertificateV2")
+	}
+	if sc == nil {
+		return errors.New("missing SigningCertificate")
+	}
+	for _, c := range sc.childElements() {
+		cd := c.child(xmldsig.NamespaceXAdES, "CertDigest")
+		if cd == nil {
+			continue
+		}
+		method := ""
+		if dm := cd.child(namespaceDSig, "DigestMethod"); dm != nil {
+			method = dm.attr("Algorithm")
+		}
+		hash, ok := xmlDigestMethods[method]
+		dv := cd.child(namespaceDSig, "DigestValue")
+		if !ok || dv == nil {
+			continue
+		}
+		h := hash.New()
+		h.Write(cert.Raw) // nolint:errcheck
+		if bytes.Equal(h.Sum(nil), decodeBase64(dv.text())) {
+			return nil
+		}
+	}
+	return errors.New("signing certificate does not match")
+}
+
+// checkSignature verifies the signature of the data with the certificate's
+// public key. ECDSA signatures in XML are the concatenation of r and s, while
+// in CMS they are ASN.1 encoded.
+func checkSignature(cert *x509.Certificate, hash crypto.Hash, data, sig []byte, xmlECDSA bool) error {
+	h := hash.New()
+	h.Write(data) // nolint:errcheck
+	digest := h.Sum(nil)
+
+	var valid bool
+	switch pub := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		valid = rsa.VerifyPKCS1v15(pub, hash, digest, sig) == nil
+	case *ecdsa.PublicKey:
+		if xmlECDSA {
+			n := len(sig) / 2
+			r := new(big.Int).SetBytes(sig[:n])
+			s := new(big.Int).SetBytes(sig[n:])
+			valid = ecdsa.Verify(pub, digest, r, s)
+		} else {
+			valid = ecdsa.VerifyASN1(pub, digest, sig)
+		}
+	default:
+		return errors.New("unsupported public key")
+	}
+	if !valid {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+// decodeBase64 decodes the base64 content of an element, ignoring whitespace
+func decodeBase64(s string) []byte {
+	data, _ := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(s), ""))
+	return data
+}
+
+// parseXMLTime parses an xs:dateTime, which may not include the time zone
+func parseXMLTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02T15:04:05", s)
+}
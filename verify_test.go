@@ -0,0 +1,264 @@
+package fatturapa_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	fatturapa "github.com/invopop/gobl.fatturapa"
+	"github.com/invopop/gobl.fatturapa/test"
+	"github.com/invopop/gobl/bill"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func signedXML(t *testing.T) []byte {
+	t.Helper()
+	doc, err := test.ConvertFromGOBL(test.LoadTestFile("invoice-simple.json"))
+	require.NoError(t, err)
+	data, err := doc.Bytes()
+	require.NoError(t, err)
+	return data
+}
+
+func signedP7M(t *testing.T) []byte {
+	t.Helper()
+	converter := test.NewConverter(fatturapa.WithSignatureFormat(fatturapa.CAdES))
+	doc, err := test.ConvertFromGOBL(test.LoadTestFile("invoice-simple.json"), converter)
+	require.NoError(t, err)
+	data, err := doc.P7M()
+	require.NoError(t, err)
+	return data
+}
+
+func TestVerifyXAdES(t *testing.T) {
+	t.Run("should verify signed documents", func(t *testing.T) {
+		data := signedXML(t)
+		v, err := fatturapa.Verify(data)
+		require.NoError(t, err)
+		assert.Equal(t, fatturapa.XAdES, v.Format)
+		assert.Equal(t, data, v.Data)
+		assert.Equal(t, "EIDAS CERTIFICADO PRUEBAS - 99999999R", v.Certificate.Subject.CommonName)
+		assert.False(t, v.SigningTime.IsZero())
+		assert.Nil(t, v.Timestamp)
+		assert.False(t, v.Trusted)
+		assert.EqualError(t, v.TrustError, "no trust store configured")
+
+		doc, err := test.ConvertFromGOBL(test.LoadTestFile("invoice-hotel.json"))
+		require.NoError(t, err)
+		data, err = doc.Bytes()
+		require.NoError(t, err)
+		_, err = fatturapa.Verify(data)
+		assert.NoError(t, err)
+
+		sdoc, err := test.ConvertSimplifiedFromGOBL(test.LoadTestFile("invoice-simplified.json"))
+		require.NoError(t, err)
+		data, err = sdoc.Bytes()
+		require.NoError(t, err)
+		_, err = fatturapa.Verify(data)
+		assert.NoError(t, err)
+	})
+
+	t.Run("should verify documents signed by other tools", func(t *testing.T) {
+		// Signed outside of this package: canonicalized with xmllint, and
+		// signed with openssl, using exclusive canonicalization and
+		// SigningCertificateV2.
+		data, err := os.ReadFile(test.GetExamplesPath() + "bare-minimum-xades.xml")
+		require.NoError(t, err)
+		v, err := fatturapa.Verify(data)
+		require.NoError(t, err)
+		assert.Equal(t, fatturapa.XAdES, v.Format)
+		assert.Equal(t, "XAdES Fixture", v.Certificate.Subject.CommonName)
+		assert.Equal(t, time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC), v.SigningTime.UTC())
+
+		data = bytes.Replace(data, []byte("<Numero>123"), []byte("<Numero>124"), 1)
+		_, err = fatturapa.Verify(data)
+		assert.EqualError(t, err, "reference '': digest mismatch")
+	})
+
+	t.Run("should sign with SHA-256 digests using external signers", func(t *testing.T) {
+		converter := fatturapa.NewConverter(fatturapa.WithSigner(newRemoteSigner(t)))
+		doc, err := test.ConvertFromGOBL(test.LoadTestFile("invoice-simple.json"), converter)
+		require.NoError(t, err)
+		data, err := doc.Bytes()
+		require.NoError(t, err)
+		assert.Contains(t, string(data), `<ds:DigestMethod Algorithm="http://www.w3.org/2001/04/xmlenc#sha256">`)
+		assert.NotContains(t, string(data), "sha512")
+	})
+
+	t.Run("should detect changes to the document", func(t *testing.T) {
+		data := signedXML(t)
+		data = bytes.Replace(data, []byte("<Numero>"), []byte("<Numero>1"), 1)
+		_, err := fatturapa.Verify(data)
+		assert.EqualError(t, err, "reference '': digest mismatch")
+	})
+
+	t.Run("should detect changes to the signed properties", func(t *testing.T) {
+		data := signedXML(t)
+		data = bytes.Replace(data, []byte("<xades:SigningTime>20"), []byte("<xades:SigningTime>19"), 1)
+		_, err := fatturapa.Verify(data)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "-SignedProperties': digest mismatch")
+	})
+
+	t.Run("should reject duplicate Ids", func(t *testing.T) {
+		// A genuine copy of the signed properties in the key info, which is
+		// not covered by the digest of the document, with forged ones in
+		// the object.
+		data := signedXML(t)
+		start := bytes.Index(data, []byte("<xades:SignedProperties"))
+		end := bytes.Index(data, []byte("</xades:SignedProperties>")) + len("</xades:SignedProperties>")
+		genuine := append([]byte{}, data[start:end]...)
+		data = bytes.Replace(data, []byte("<xades:SigningTime>20"), []byte("<xades:SigningTime>19"), 1)
+		data = bytes.Replace(data, []byte("</ds:KeyInfo>"), append(genuine, []byte("</ds:KeyInfo>")...), 1)
+		_, err := fatturapa.Verify(data)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "duplicate Id 'Signature-")
+	})
+
+	t.Run("should detect changes to the signature", func(t *testing.T) {
+		data := signedXML(t)
+		i := bytes.Index(data, []byte("SignatureValue\">")) + len("SignatureValue\">")
+		data[i] ^= 0x01
+		_, err := fatturapa.Verify(data)
+		assert.EqualError(t, err, "invalid signature")
+	})
+
+	t.Run("should require a signature", func(t *testing.T) {
+		data, err := os.ReadFile(test.GetExamplesPath() + "bare-minimum.xml")
+		require.NoError(t, err)
+		_, err = fatturapa.Verify(data)
+		assert.EqualError(t, err, "document is not signed")
+	})
+}
+
+func TestVerifyCAdES(t *testing.T) {
+	t.Run("should verify envelopes", func(t *testing.T) {
+		data := signedP7M(t)
+		v, err := fatturapa.Verify(data)
+		require.NoError(t, err)
+		assert.Equal(t, fatturapa.CAdES, v.Format)
+		assert.True(t, bytes.HasPrefix(v.Data, []byte("<?xml")))
+		assert.Equal(t, "EIDAS CERTIFICADO PRUEBAS - 99999999R", v.Certificate.Subject.CommonName)
+		assert.WithinDuration(t, time.Now(), v.SigningTime, time.Minute)
+		assert.Nil(t, v.Timestamp)
+	})
+
+	t.Run("should verify envelopes ending in whitespace bytes", func(t *testing.T) {
+		converter := test.NewConverter(fatturapa.WithSignatureFormat(fatturapa.CAdES))
+		for i := 0; ; i++ {
+			require.Less(t, i, 2000, "no envelope ending in whitespace")
+			env := test.LoadTestFile("invoice-simple.json")
+			test.ModifyInvoice(env, func(inv *bill.Invoice) {
+				inv.Code = fmt.Sprintf("%d", i)
+			})
+			doc, err := test.ConvertFromGOBL(env, converter)
+			require.NoError(t, err)
+			data, err := doc.P7M()
+			require.NoError(t, err)
+			if strings.IndexByte(" \t\n\v\f\r", data[len(data)-1]) < 0 {
+				continue
+			}
+			_, err = fatturapa.Verify(data)
+			assert.NoError(t, err)
+			break
+		}
+	})
+
+	t.Run("should verify base64 envelopes", func(t *testing.T) {
+		data := []byte(base64.StdEncoding.EncodeToString(signedP7M(t)))
+		v, err := fatturapa.Verify(data)
+		require.NoError(t, err)
+		assert.Equal(t, fatturapa.CAdES, v.Format)
+	})
+
+	t.Run("should verify envelopes made by other tools", func(t *testing.T) {
+		// BER encoded, with indefinite lengths
+		data, err := os.ReadFile(test.GetExamplesPath() + "bare-minimum.xml.p7m")
+		require.NoError(t, err)
+		v, err := fatturapa.Verify(data)
+		require.NoError(t, err)
+
+		xml, err := os.ReadFile(test.GetExamplesPath() + "bare-minimum.xml")
+		require.NoError(t, err)
+		assert.Equal(t, xml, v.Data)
+		assert.False(t, v.SigningTime.IsZero())
+	})
+
+	t.Run("should detect changes to the document", func(t *testing.T) {
+		data := signedP7M(t)
+		i := bytes.Index(data, []byte("<Numero>"))
+		data[i+1] = 'n'
+		_, err := fatturapa.Verify(data)
+		assert.EqualError(t, err, "message digest mismatch")
+	})
+
+	t.Run("should detect changes to the signature", func(t *testing.T) {
+		data := signedP7M(t)
+		data[len(data)-1] ^= 0x01
+		_, err := fatturapa.Verify(data)
+		assert.EqualError(t, err, "invalid signature")
+	})
+
+	t.Run("should reject deeply nested envelopes", func(t *testing.T) {
+		data := bytes.Repeat([]byte{0x30, 0x80}, 1<<20)
+		_, err := fatturapa.Verify(data)
+		assert.EqualError(t, err, "parsing envelope: too deeply nested")
+	})
+
+	t.Run("should reject unknown documents", func(t *testing.T) {
+		_, err := fatturapa.Verify([]byte("random data"))
+		assert.EqualError(t, err, "unrecognised signed document")
+	})
+}
+
+func TestVerifyTrust(t *testing.T) {
+	data := signedP7M(t)
+	v, err := fatturapa.Verify(data)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	pemData := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: v.Certificate.Raw})
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "signer.pem"), pemData, 0644))
+	pool, err := fatturapa.LoadTrustStore(dir)
+	require.NoError(t, err)
+
+	t.Run("should trust certificates in the trust store", func(t *testing.T) {
+		at := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+		v, err := fatturapa.Verify(data, fatturapa.WithTrustStore(pool), fatturapa.WithVerificationTime(at))
+		require.NoError(t, err)
+		assert.True(t, v.Trusted)
+		assert.NoError(t, v.TrustError)
+
+		v, err = fatturapa.Verify(signedXML(t), fatturapa.WithTrustStore(pool), fatturapa.WithVerificationTime(at))
+		require.NoError(t, err)
+		assert.True(t, v.Trusted)
+	})
+
+	t.Run("should check the validity period", func(t *testing.T) {
+		v, err := fatturapa.Verify(data, fatturapa.WithTrustStore(pool))
+		require.NoError(t, err)
+		assert.False(t, v.Trusted)
+		assert.True(t, strings.Contains(v.TrustError.Error(), "expired"))
+	})
+
+	t.Run("should not trust other certificates", func(t *testing.T) {
+		data, err := os.ReadFile(test.GetExamplesPath() + "bare-minimum.xml.p7m")
+		require.NoError(t, err)
+		empty := filepath.Join(t.TempDir(), "roots.pem")
+		require.NoError(t, os.WriteFile(empty, nil, 0644))
+		pool, err := fatturapa.LoadTrustStore(empty)
+		require.NoError(t, err)
+
+		v, err := fatturapa.Verify(data, fatturapa.WithTrustStore(pool))
+		require.NoError(t, err)
+		assert.False(t, v.Trusted)
+		assert.Error(t, v.TrustError)
+	})
+}
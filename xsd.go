@@ -0,0 +1,671 @@
+package fatturapa
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// The XSD support implemented here covers the subset of XML Schema used by the
+// FatturaPA schemas: named complex types containing sequences and choices of
+// elements, attributes, and simple types restricting the built-in types with
+// patterns, enumerations, length and range facets.
+
+const (
+	xsdUnbounded = -1
+
+	xsdParticleElement  = "element"
+	xsdParticleSequence = "sequence"
+	xsdParticleChoice   = "choice"
+)
+
+// xmlNode is a generic representation of an XML element, used both for the
+// schemas and the documents being validated.
+type xmlNode struct {
+	name     xml.Name
+	attrs    []xml.Attr
+	children []*xmlNode
+	text     string
+}
+
+func (n *xmlNode) attr(name string) string {
+	for _, a := range n.attrs {
+		if a.Name.Local == name && a.Name.Space == "" {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+func parseXMLNode(data []byte) (*xmlNode, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	dec := xml.NewDecoder(bytes.NewReader(data))
+
+	var root *xmlNode
+	var stack []*xmlNode
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			if root != nil && len(stack) == 0 {
+				return root, nil
+			}
+			return nil, fmt.Errorf("parsing xml: %w", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			n := &xmlNode{name: t.Name, attrs: t.Attr}
+			if len(stack) > 0 {
+				p := stack[len(stack)-1]
+				p.children = append(p.children, n)
+			} else if root == nil {
+				root = n
+			} else {
+				return nil, fmt.Errorf("parsing xml: unexpected element %s", t.Name.Local)
+			}
+			stack = append(stack, n)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].text += string(t)
+			}
+		}
+	}
+}
+
+// xsdSchema contains the global elements and types defined by a schema.
+type xsdSchema struct {
+	namespace    string
+	elements     map[string]*xsdParticle
+	complexTypes map[string]*xsdComplexType
+	simpleTypes  map[string]*xsdSimpleType
+}
+
+type xsdComplexType struct {
+	content    *xsdParticle
+	attributes []*xsdAttribute
+}
+
+type xsdAttribute struct {
+	name     string
+	typ      string
+	required bool
+}
+
+// xsdParticle is either an element or a group of particles, with the number
+// of occurrences allowed.
+type xsdParticle struct {
+	kind      string
+	name      string
+	typ       string
+	minOccurs int
+	maxOccurs int
+	items     []*xsdParticle
+}
+
+type xsdPattern struct {
+	source string
+	re     *regexp.Regexp
+}
+
+type xsdSimpleType struct {
+	base         string
+	whiteSpace   string
+	patterns     []*xsdPattern
+	enumerations []string
+	length       int
+	minLength    int
+	maxLength    int
+	minInclusive string
+	maxInclusive string
+}
+
+func parseXSD(data []byte) (*xsdSchema, error) {
+	root, err := parseXMLNode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &xsdSchema{
+		namespace:    root.attr("targetNamespace"),
+		elements:     make(map[string]*xsdParticle),
+		complexTypes: make(map[string]*xsdComplexType),
+		simpleTypes:  make(map[string]*xsdSimpleType),
+	}
+	for _, n := range root.children {
+		name := n.attr("name")
+		switch n.name.Local {
+		case "element":
+			p, err := parseXSDParticle(n)
+			if err != nil {
+				return nil, err
+			}
+			s.elements[name] = p
+		case "complexType":
+			ct, err := parseXSDComplexType(n)
+			if err != nil {
+				return nil, fmt.Errorf("type %s: %w", name, err)
+			}
+			s.complexTypes[name] = ct
+		case "simpleType":
+			st, err := parseXSDSimpleType(n)
+			if err != nil {
+				return nil, fmt.Errorf("type %s: %w", name, err)
+			}
+			s.simpleTypes[name] = st
+		}
+	}
+
+	return s, nil
+}
+
+func parseXSDComplexType(n *xmlNode) (*xsdComplexType, error) {
+	ct := new(xsdComplexType)
+	for _, c := range n.children {
+		switch c.name.Local {
+		case xsdParticleSequence, xsdParticleChoice:
+			p, err := parseXSDParticle(c)
+			if err != nil {
+				return nil, err
+			}
+			ct.content = p
+		case "attribute":
+			ct.attributes = append(ct.attributes, &xsdAttribute{
+				name:     c.attr("name"),
+				typ:      c.attr("type"),
+				required: c.attr("use") == "required",
+			})
+		}
+	}
+	return ct, nil
+}
+
+func parseXSDParticle(n *xmlNode) (*xsdParticle, error) {
+	p := &xsdParticle{
+		kind:      n.name.Local,
+		name:      n.attr("name"),
+		typ:       n.attr("type"),
+		minOccurs: 1,
+		maxOccurs: 1,
+	}
+	if ref := n.attr("ref"); ref != "" {
+		p.name = ref
+		if i := strings.Index(ref, ":"); i >= 0 {
+			p.name = ref[i+1:]
+		}
+		p.typ = ref
+	}
+
+	var err error
+	if v := n.attr("minOccurs"); v != "" {
+		if p.minOccurs, err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("invalid minOccurs '%s'", v)
+		}
+	}
+	if v := n.attr("maxOccurs"); v == "unbounded" {
+		p.maxOccurs = xsdUnbounded
+	} else if v != "" {
+		if p.maxOccurs, err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("invalid maxOccurs '%s'", v)
+		}
+	}
+
+	if p.kind == xsdParticleElement {
+		return p, nil
+	}
+	for _, c := range n.children {
+		switch c.name.Local {
+		case xsdParticleElement, xsdParticleSequence, xsdParticleChoice:
+			item, err := parseXSDParticle(c)
+			if err != nil {
+				return nil, err
+			}
+			p.items = append(p.items, item)
+		}
+	}
+	return p, nil
+}
+
+func parseXSDSimpleType(n *xmlNode) (*xsdSimpleType, error) {
+	st := new(xsdSimpleType)
+	for _, r := range n.children {
+		if r.name.Local != "restriction" {
+			continue
+		}
+		st.base = r.attr("base")
+		for _, f := range r.children {
+			v := f.attr("value")
+			var err error
+			switch f.name.Local {
+			case "pattern":
+				// Patterns that cannot be translated make the schema fail to
+				// load, rather than skipping the check silently.
+				re, perr := compileXSDPattern(v)
+				if perr != nil {
+					return nil, fmt.Errorf("invalid pattern '%s': %w", v, perr)
+				}
+				st.patterns = append(st.patterns, &xsdPattern{source: v, re: re})
+			case "enumeration":
+				st.enumerations = append(st.enumerations, v)
+			case "whiteSpace":
+				st.whiteSpace = v
+			case "length":
+				st.length, err = strconv.Atoi(v)
+			case "minLength":
+				st.minLength, err = strconv.Atoi(v)
+			case "maxLength":
+				st.maxLength, err = strconv.Atoi(v)
+			case "minInclusive":
+				st.minInclusive = v
+			case "maxInclusive":
+				st.maxInclusive = v
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s '%s': %w", f.name.Local, v, err)
+			}
+		}
+	}
+	return st, nil
+}
+
+// XSD block escapes and the ranges of characters they represent, as
+// these are not supported by Go.
+var xsdBlockEscapes = map[string]string{
+	`\p{IsBasicLatin}`:        `\x{0000}-\x{007F}`,
+	`\p{IsLatin-1Supplement}`: `\x{0080}-\x{00FF}`,
+}
+
+// compileXSDPattern converts an XSD regular expression into its Go
+// equivalent. XSD patterns always match the complete value.
+func compileXSDPattern(p string) (*regexp.Regexp, error) {
+	var sb strings.Builder
+	inClass := false
+	for i := 0; i < len(p); {
+		if p[i] == '\\' {
+			replaced := false
+			for esc, r := range xsdBlockEscapes {
+				if strings.HasPrefix(p[i:], esc) {
+					if inClass {
+						sb.WriteString(r)
+					} else {
+						sb.WriteString("[" + r + "]")
+					}
+					i += len(esc)
+					replaced = true
+					break
+				}
+			}
+			if !replaced {
+				end := i + 2
+				if end > len(p) {
+					end = len(p)
+				}
+				sb.WriteString(p[i:end])
+				i = end
+			}
+			continue
+		}
+		switch p[i] {
+		case '[':
+			inClass = true
+		case ']':
+			inClass = false
+		}
+		sb.WriteByte(p[i])
+		i++
+	}
+	return regexp.Compile("^(?:" + sb.String() + ")$")
+}
+
+// starts determines if the particle may begin with the given element.
+func (p *xsdParticle) starts(name string) bool {
+	switch p.kind {
+	case xsdParticleElement:
+		return p.name == name
+	case xsdParticleSequence:
+		for _, item := range p.items {
+			if item.starts(name) {
+				return true
+			}
+			if !item.emptiable() {
+				return false
+			}
+		}
+	case xsdParticleChoice:
+		for _, item := range p.items {
+			if item.starts(name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// emptiable determines if the particle may be omitted entirely.
+func (p *xsdParticle) emptiable() bool {
+	if p.minOccurs == 0 {
+		return true
+	}
+	switch p.kind {
+	case xsdParticleSequence:
+		for _, item := range p.items {
+			if !item.emptiable() {
+				return false
+			}
+		}
+		return true
+	case xsdParticleChoice:
+		for _, item := range p.items {
+			if item.emptiable() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (p *xsdParticle) names() []string {
+	if p.kind == xsdParticleElement {
+		return []string{p.name}
+	}
+	var names []string
+	for _, item := range p.items {
+		names = append(names, item.names()...)
+	}
+	return names
+}
+
+// xsdValidator walks a document checking each element against its type,
+// collecting the violations found.
+type xsdValidator struct {
+	schema *xsdSchema
+	errs   ValidationErrors
+}
+
+func (v *xsdValidator) addError(path, format string, args ...any) {
+	v.errs = append(v.errs, &ValidationError{
+		Path:    path,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (v *xsdValidator) validateRoot(n *xmlNode) {
+	path := "/" + n.name.Local
+	el, ok := v.schema.elements[n.name.Local]
+	if !ok || n.name.Space != v.schema.namespace {
+		v.addError(path, "unexpected root element in namespace '%s'", n.name.Space)
+		return
+	}
+	v.validateElement(el, n, path)
+}
+
+func (v *xsdValidator) validateElement(el *xsdParticle, n *xmlNode, path string) {
+	if strings.HasPrefix(el.typ, "ds:") {
+		// Signatures are checked when verified
+		return
+	}
+	if ct, ok := v.schema.complexTypes[el.typ]; ok {
+		v.validateComplex(ct, n, path)
+		return
+	}
+	if len(n.children) > 0 {
+		v.addError(path+"/"+n.children[0].name.Local, "unexpected element")
+		return
+	}
+	v.validateValue(el.typ, n.text, path)
+}
+
+func (v *xsdValidator) validateComplex(ct *xsdComplexType, n *xmlNode, path string) {
+	v.validateAttributes(ct, n, path)
+
+	if strings.TrimSpace(n.text) != "" {
+		v.addError(path, "unexpected text content")
+	}
+
+	var matched []*xsdParticle
+	pos := 0
+	if ct.content != nil {
+		pos = v.matchParticle(ct.content, n.children, pos, path, &matched)
+	}
+	if pos < len(n.children) {
+		v.addError(childPath(path, n.children, pos), "unexpected element")
+	}
+
+	for i, el := range matched {
+		if el != nil {
+			v.validateElement(el, n.children[i], childPath(path, n.children, i))
+		}
+	}
+}
+
+// childPath provides the XPath of the child at the given position, including
+// its index when there are several siblings with the same name.
+func childPath(path string, children []*xmlNode, pos int) string {
+	name := children[pos].name.Local
+	count, index := 0, 0
+	for i, c := range children {
+		if c.name.Local == name {
+			count++
+			if i <= pos {
+				index++
+			}
+		}
+	}
+	if count > 1 {
+		return fmt.Sprintf("%s/%s[%d]", path, name, index)
+	}
+	return path + "/" + name
+}
+
+// matchParticle consumes the children matching the particle from the given
+// position, reporting missing elements, and returns the position after them.
+// The FatturaPA schemas are deterministic, so looking at the next child is
+// enough to decide how to proceed.
+func (v *xsdValidator) matchParticle(p *xsdParticle, children []*xmlNode, pos int, path string, matched *[]*xsdParticle) int {
+	count := 0
+	for p.maxOccurs == xsdUnbounded || count < p.maxOccurs {
+		if pos >= len(children) || !p.starts(children[pos].name.Local) {
+			break
+		}
+		pos = v.matchOnce(p, children, pos, path, matched)
+		count++
+	}
+
+	if p.kind == xsdParticleElement && count > 0 {
+		// Skip the occurrences exceeding the maximum
+		for pos < len(children) && children[pos].name.Local == p.name {
+			v.addError(childPath(path, children, pos), "unexpected element")
+			*matched = append(*matched, nil)
+			pos++
+		}
+	}
+
+	if count < p.minOccurs {
+		if p.kind == xsdParticleElement {
+			v.addError(path+"/"+p.name, "missing element")
+			return pos
+		}
+		// Report the elements missing from the group
+		for ; count < p.minOccurs; count++ {
+			pos = v.matchOnce(p, children, pos, path, matched)
+		}
+	}
+
+	return pos
+}
+
+func (v *xsdValidator) matchOnce(p *xsdParticle, children []*xmlNode, pos int, path string, matched *[]*xsdParticle) int {
+	switch p.kind {
+	case xsdParticleElement:
+		*matched = append(*matched, p)
+		return pos + 1
+	case xsdParticleSequence:
+		for _, item := range p.items {
+			pos = v.matchParticle(item, children, pos, path, matched)
+		}
+	case xsdParticleChoice:
+		for _, item := range p.items {
+			if pos < len(children) && item.starts(children[pos].name.Local) {
+				return v.matchParticle(item, children, pos, path, matched)
+			}
+		}
+		if !p.emptiable() {
+			v.addError(path, "missing one of %s", strings.Join(p.names(), ", "))
+		}
+	}
+	return pos
+}
+
+func (v *xsdValidator) validateAttributes(ct *xsdComplexType, n *xmlNode, path string) {
+	known := make(map[string]bool)
+	for _, a := range ct.attributes {
+		known[a.name] = true
+		ap := path + "/@" + a.name
+		found := false
+		for _, na := range n.attrs {
+			if na.Name.Space == "" && na.Name.Local == a.name {
+				found = true
+				v.validateValue(a.typ, na.Value, ap)
+			}
+		}
+		if !found && a.required {
+			v.addError(ap, "missing attribute")
+		}
+	}
+	for _, na := range n.attrs {
+		if na.Name.Space == "" && na.Name.Local != "xmlns" && !known[na.Name.Local] {
+			v.addError(path+"/@"+na.Name.Local, "unexpected attribute")
+		}
+	}
+}
+
+func (v *xsdValidator) validateValue(typ, value, path string) {
+	st, ok := v.schema.simpleTypes[typ]
+	if !ok {
+		if err := checkXSDBuiltin(typ, value); err != nil {
+			v.addError(path, "%s", err)
+		}
+		return
+	}
+
+	ws := st.whiteSpace
+	if ws == "" {
+		switch st.base {
+		case "xs:normalizedString":
+			ws = "replace"
+		case "xs:string":
+			ws = "preserve"
+		default:
+			ws = "collapse"
+		}
+	}
+	switch ws {
+	case "replace":
+		value = strings.NewReplacer("\t", " ", "\n", " ", "\r", " ").Replace(value)
+	case "collapse":
+		value = strings.Join(strings.Fields(value), " ")
+	}
+
+	if err := checkXSDBuiltin(st.base, value); err != nil {
+		v.addError(path, "%s", err)
+		return
+	}
+
+	if len(st.enumerations) > 0 && !containsString(st.enumerations, value) {
+		v.addError(path, "value '%s' is not allowed", value)
+		return
+	}
+
+	if len(st.patterns) > 0 {
+		ok := false
+		for _, p := range st.patterns {
+			if p.re.MatchString(value) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			v.addError(path, "value '%s' does not match pattern '%s'", value, st.patterns[0].source)
+			return
+		}
+	}
+
+	l := utf8.RuneCountInString(value)
+	switch {
+	case st.length > 0 && l != st.length:
+		v.addError(path, "value '%s' must be %d characters long", value, st.length)
+		return
+	case st.minLength > 0 && l < st.minLength:
+		v.addError(path, "value '%s' must be at least %d characters long", value, st.minLength)
+		return
+	case st.maxLength > 0 && l > st.maxLength:
+		v.addError(path, "value '%s' must be at most %d characters long", value, st.maxLength)
+		return
+	}
+
+	if st.minInclusive != "" && compareXSDValues(st.base, value, st.minInclusive) < 0 {
+		v.addError(path, "value '%s' must be at least %s", value, st.minInclusive)
+	} else if st.maxInclusive != "" && compareXSDValues(st.base, value, st.maxInclusive) > 0 {
+		v.addError(path, "value '%s' must be at most %s", value, st.maxInclusive)
+	}
+}
+
+// compareXSDValues compares two values of the given built-in type, which have
+// already been checked. Dates are compared in their ISO 8601 representation.
+func compareXSDValues(typ, a, b string) int {
+	if typ == "xs:date" {
+		return strings.Compare(a, b)
+	}
+	fa, _ := strconv.ParseFloat(a, 64)
+	fb, _ := strconv.ParseFloat(b, 64)
+	switch {
+	case fa < fb:
+		return -1
+	case fa > fb:
+		return 1
+	}
+	return 0
+}
+
+var (
+	xsdDecimalRegexp = regexp.MustCompile(`^[+-]?(\d+(\.\d*)?|\.\d+)$`)
+	xsdIntegerRegexp = regexp.MustCompile(`^[+-]?\d+$`)
+)
+
+// checkXSDBuiltin ensures the value is valid for the built-in type. String
+// types and those not supported are not checked.
+func checkXSDBuiltin(typ, value string) error {
+	switch typ {
+	case "xs:date":
+		if _, err := time.Parse("2006-01-02", strings.TrimSpace(value)); err != nil {
+			return fmt.Errorf("invalid date '%s'", value)
+		}
+	case "xs:dateTime":
+		value = strings.TrimSpace(value)
+		if _, err := time.Parse(time.RFC3339, value); err != nil {
+			if _, err := time.Parse("2006-01-02T15:04:05", value); err != nil {
+				return fmt.Errorf("invalid date and time '%s'", value)
+			}
+		}
+	case "xs:decimal":
+		if !xsdDecimalRegexp.MatchString(strings.TrimSpace(value)) {
+			return fmt.Errorf("invalid decimal '%s'", value)
+		}
+	case "xs:integer":
+		if !xsdIntegerRegexp.MatchString(strings.TrimSpace(value)) {
+			return fmt.Errorf("invalid integer '%s'", value)
+		}
+	case "xs:base64Binary":
+		value = strings.Join(strings.Fields(value), "")
+		if _, err := base64.StdEncoding.DecodeString(value); err != nil {
+			return fmt.Errorf("invalid base64 data")
+		}
+	}
+	return nil
+}